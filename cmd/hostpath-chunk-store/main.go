@@ -0,0 +1,72 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// hostpath-chunk-store checks the integrity of the chunk store of the
+// hostPath snapshots and optionally prunes the chunks no snapshot refers to
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/hostpath"
+)
+
+var (
+	depot  = flag.String("depot", getEnv(hostpath.DepotENVK, "/tmp/"), "Directory holding the hostPath snapshots.")
+	prune  = flag.Bool("prune", false, "Remove the chunks not referenced by any snapshot.")
+	minAge = flag.Duration("min-age", time.Hour, "Only prune chunks older than this, it protects the chunks of snapshots being written.")
+)
+
+func main() {
+	flag.Parse()
+
+	report, err := hostpath.VerifyChunkStore(*depot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to verify the chunk store of %s: %v\n", *depot, err)
+		os.Exit(2)
+	}
+	fmt.Printf("snapshots: %d, chunks: %d, unreferenced chunks: %d\n", report.Snapshots, report.Chunks, len(report.Unreferenced))
+	for _, hash := range report.Corrupted {
+		fmt.Printf("corrupted chunk: %s\n", hash)
+	}
+	for manifest, hashes := range report.Missing {
+		for _, hash := range hashes {
+			fmt.Printf("missing chunk of %s: %s\n", manifest, hash)
+		}
+	}
+
+	if *prune {
+		pruned, err := hostpath.PruneChunkStore(*depot, *minAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to prune the chunk store of %s: %v\n", *depot, err)
+			os.Exit(2)
+		}
+		fmt.Printf("pruned chunks: %d\n", pruned)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// hostpath-snapshot-rewrap moves the encrypted hostPath snapshots stored on
+// a node to the active key of their encryption secret. It runs on the node
+// the snapshots are stored on, once every snapshot is moved the previous
+// keys can be removed from the secret.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/client"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/hostpath"
+)
+
+var (
+	kubeconfig = flag.String("kubeconfig", "", "Path to a kube config. Only required if out-of-cluster.")
+	secret     = flag.String("secret", "", "Encryption secret of the snapshots to rewrap, as namespace/name.")
+	node       = flag.String("node", volume.NodeName(), "Node the snapshots are stored on.")
+	dryRun     = flag.Bool("dry-run", false, "Report the snapshots to rewrap without changing them.")
+)
+
+func main() {
+	flag.Parse()
+	parts := strings.Split(*secret, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		fmt.Fprintf(os.Stderr, "Invalid -secret %q, expected namespace/name\n", *secret)
+		os.Exit(2)
+	}
+
+	config, err := buildConfig(*kubeconfig)
+	if err != nil {
+		fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fatalf("Failed to create client: %v", err)
+	}
+	snapshotClient, _, err := client.NewClient(config)
+	if err != nil {
+		fatalf("Failed to make CRD client: %v", err)
+	}
+
+	s, err := clientset.CoreV1().Secrets(parts[0]).Get(context.TODO(), parts[1], metav1.GetOptions{})
+	if err != nil {
+		fatalf("Failed to get secret %s: %v", *secret, err)
+	}
+	keys, err := hostpath.ParseEncryptionKeys(s)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	var list crdv1.VolumeSnapshotDataList
+	err = snapshotClient.Get().
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Do(context.TODO()).Into(&list)
+	if err != nil {
+		fatalf("Failed to list VolumeSnapshotData: %v", err)
+	}
+
+	var rewrapped, failed int
+	for i := range list.Items {
+		data := &list.Items[i]
+		src := data.Spec.HostPath
+		if src == nil || src.EncryptionSecret != *secret {
+			continue
+		}
+		if src.NodeName != "" && src.NodeName != *node {
+			continue
+		}
+		if _, err := os.Stat(src.Path); os.IsNotExist(err) {
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("%s: %s, key %s\n", data.Metadata.Name, src.Path, src.EncryptionKeyID)
+			continue
+		}
+		previous, err := hostpath.RewrapSnapshot(src.Path, keys)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rewrap %s: %v\n", data.Metadata.Name, err)
+			failed++
+			continue
+		}
+		// the manifest may already be rewrapped by an interrupted run
+		if src.EncryptionKeyID != keys.Active {
+			src.EncryptionKeyID = keys.Active
+			err = snapshotClient.Put().
+				Name(data.Metadata.Name).
+				Resource(crdv1.VolumeSnapshotDataResourcePlural).
+				Body(data).
+				Do(context.TODO()).Error()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to update %s: %v\n", data.Metadata.Name, err)
+				failed++
+				continue
+			}
+		}
+		if previous != keys.Active {
+			fmt.Printf("%s: rewrapped from key %s to key %s\n", data.Metadata.Name, previous, keys.Active)
+			rewrapped++
+		}
+	}
+	fmt.Printf("rewrapped snapshots: %d, failed: %d\n", rewrapped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func buildConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(2)
+}
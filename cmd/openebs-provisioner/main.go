@@ -20,13 +20,20 @@ import (
 	"context"
 	"flag"
 	"os"
+	"strconv"
 	"strings"
 
 	"syscall"
 
 	"github.com/golang/glog"
+	crdclient "github.com/openebs/openebs-k8s-provisioner/pkg/client"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/provisioner"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/server"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/tracing"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/openebs"
+	mayav1alpha1 "github.com/openebs/openebs-k8s-provisioner/pkg/volume/v1alpha1"
 	mayav1 "github.com/openebs/openebs-k8s-provisioner/types/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -67,24 +74,92 @@ func main() {
 		glog.Fatalf("Failed to create client: %v", err)
 	}
 
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		ServiceName: "openebs-provisioner",
+		Exporter:    mayav1.TracingExporterENV(),
+		Endpoint:    mayav1.TracingEndpointENV(),
+	})
+	if err != nil {
+		glog.Fatalf("Failed to set up the tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// The final snapshots of the deleted volumes are recorded through the
+	// snapshot API, they are not supported if it is not available
+	var snapshotData provisioner.SnapshotDataClient
+	var finalSnapshotter provisioner.FinalSnapshotter
+	snapshotClient, _, err := crdclient.NewClient(config)
+	if err != nil {
+		glog.Warningf("Final snapshots are not supported, failed to create the snapshot client: %v", err)
+	} else {
+		snapshotData = provisioner.NewSnapshotDataClient(snapshotClient)
+		finalSnapshotter = openebs.NewFinalSnapshotter()
+	}
+
 	// Create the provisioner: it implements the Provisioner interface expected by
 	// the controller
-	openEBSProvisioner, err := provisioner.NewOpenEBSCASProvisioner(clientset)
+	openEBSProvisioner, err := provisioner.NewOpenEBSCASProvisioner(clientset, snapshotData, finalSnapshotter)
 	if err != nil {
 		glog.Fatalf("Error creating Openebs provisioner: %v", err)
 	}
 
+	// The informers of the provision controller are shared with the
+	// readiness checks
+	informerFactory := informers.NewSharedInformerFactory(clientset, controller.DefaultResyncPeriod)
+	claims := informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	volumes := informerFactory.Core().V1().PersistentVolumes().Informer()
+	classes := informerFactory.Storage().V1().StorageClasses().Informer()
+
 	// Start the provision controller which will dynamically provision OpenEBS PVs
+	leaderElection := isLeaderElectionEnabled()
 	pc := controller.NewProvisionController(
 		clientset,
 		provisionerName,
 		openEBSProvisioner,
-		controller.LeaderElection(isLeaderElectionEnabled()),
+		controller.LeaderElection(leaderElection),
+		controller.ClaimsInformer(claims),
+		controller.VolumesInformer(volumes),
+		controller.ClassesInformer(classes),
 	)
+	stopCh := make(chan struct{})
+	informerFactory.Start(stopCh)
+
+	if address := mayav1.HTTPAddressENV(); address != "" {
+		srv := server.New(server.Config{
+			Address:     address,
+			EnablePprof: isPprofEnabled(),
+		})
+		srv.AddReadyCheck("maya-apiserver", mayav1alpha1.CASVolume{}.Reachable)
+		srv.AddReadyCheck("informers", server.SyncedCheck(claims.HasSynced, volumes.HasSynced, classes.HasSynced))
+		if leaderElection {
+			srv.AddReadyCheck("leader", server.LeaderCheck(clientset, provisionerName))
+		}
+		go func() {
+			if err := srv.Run(stopCh); err != nil {
+				glog.Fatalf("Failed to serve HTTP: %v", err)
+			}
+		}()
+	}
+
 	// Run starts all of controller's control loops
 	pc.Run(context.Background())
 }
 
+// isPprofEnabled returns true if the ENV OPENEBS_IO_ENABLE_PPROF enables
+// the pprof endpoints, they are disabled by default
+func isPprofEnabled() bool {
+	value := mayav1.EnablePprofENV()
+	if value == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		glog.Warningf("Invalid %s %q, pprof disabled: %v", mayav1.EnablePprofENVK, value, err)
+		return false
+	}
+	return enabled
+}
+
 // isLeaderElectionEnabled returns true/false based on the ENV
 // LEADER_ELECTION_ENABLED set via provisioner deployment.
 // Defaults to true, means leaderElection enabled by default.
@@ -17,27 +17,46 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	v1 "k8s.io/api/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/client"
 
+	"github.com/openebs/openebs-k8s-provisioner/pkg/controller/garbagecollector"
 	snapshotcontroller "github.com/openebs/openebs-k8s-provisioner/pkg/controller/snapshot-controller"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/controller/snapshotter"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/server"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/tracing"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
 
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/csi"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/gluster"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/hostpath"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/lvm"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/openebs"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/remote"
+	mayav1alpha1 "github.com/openebs/openebs-k8s-provisioner/pkg/volume/v1alpha1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/zfs"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 )
 
@@ -49,18 +68,82 @@ var (
 	kubeconfig      = flag.String("kubeconfig", "", "Path to a kube config. Only required if out-of-cluster.")
 	cloudProvider   = flag.String("cloudprovider", "", "")
 	cloudConfigFile = flag.String("cloudconfig", "", "Path to a Cloud config. Only required if cloudprovider is set.")
-	volumePlugins   = make(map[string]volume.Plugin)
+	httpAddress     = flag.String("http-address", "", "Address to serve the prometheus metrics, the health, readiness and debug endpoints on, e.g. :9500. They are not served if empty.")
+	metricsAddress  = flag.String("metrics-address", "", "Deprecated: use -http-address, used if -http-address is empty.")
+	enablePprof     = flag.Bool("enable-pprof", false, "Serve the pprof endpoints under /debug/pprof/ on -http-address.")
+	gcInterval      = flag.Duration("gc-interval", 0, "Interval between two runs of the garbage collector of orphaned cas volumes and snapshots. The garbage collector is disabled if 0.")
+	gcGracePeriod   = flag.Duration("gc-grace-period", 24*time.Hour, "Time a cas volume or snapshot has to stay orphaned before it is deleted. It is counted in memory and starts again when the controller restarts.")
+	gcDryRun        = flag.Bool("gc-dry-run", false, "Report orphaned cas volumes and snapshots and expired final snapshots without deleting them.")
+	expiryInterval  = flag.Duration("final-snapshot-expiry-interval", 10*time.Minute, "Interval between two runs of the deletion of the expired final snapshots along with their cas volume and snapshot.")
+	pluginDir       = flag.String("plugin-dir", "", "Directory of the sockets of the out-of-process volume plugins. No plugin is discovered if empty.")
+	pluginMapping   = flag.String("provisioner-plugins", crdv1.DefaultProvisionerPlugins, "Comma separated provisioner=plugin pairs selecting the volume plugin of the PVs by their provisioner or CSI driver name. A trailing * matches a prefix, an empty plugin selects the plugin from the volume source. The plugins are selected from the volume source of all PVs if empty.")
+	waitDelay       = flag.Duration("snapshot-wait-initial-delay", snapshotter.DefaultWaitConfig.InitialDelay, "Initial delay between two checks of a snapshot being created.")
+	waitFactor      = flag.Float64("snapshot-wait-factor", snapshotter.DefaultWaitConfig.Factor, "Factor the delay between two checks of a snapshot being created is multiplied by after each check.")
+	waitMaxDelay    = flag.Duration("snapshot-wait-max-delay", snapshotter.DefaultWaitConfig.MaxDelay, "Maximum delay between two checks of a snapshot being created, the delay is not capped if 0.")
+	waitTimeout     = flag.Duration("snapshot-wait-timeout", snapshotter.DefaultWaitConfig.Timeout, "Time since the creation of a snapshot after which it fails with the Timeout reason if it is not ready. Snapshots do not time out if 0.")
+	waitPlugins     = flag.String("snapshot-wait-plugins", "", "Comma separated plugin=timeout[:initial delay[:factor[:max delay]]] entries overriding the snapshot wait of the volume plugins, e.g. aws_ebs=4h:10s.")
+	drainTimeout    = flag.Duration("shutdown-timeout", 25*time.Second, "Time the running snapshot operations have to finish on SIGTERM or SIGINT, the interrupted ones resume after the restart.")
+	tracingExporter = flag.String("tracing-exporter", tracing.ExporterNone, "Exporter of the OpenTelemetry traces, otlp or stdout. The snapshot operations are not traced if empty.")
+	tracingEndpoint = flag.String("tracing-endpoint", "", "URL of the OTLP/HTTP collector of the traces, e.g. http://otel-collector:4318. OTEL_EXPORTER_OTLP_ENDPOINT is used if empty.")
+	volumePlugins   = make(map[string]volume.PluginV2)
 )
 
 func main() {
 	flag.Parse()
 	flag.Set("logtostderr", "true")
+	plugins, err := crdv1.ParseProvisionerPlugins(*pluginMapping)
+	if err != nil {
+		glog.Fatalf("Invalid -provisioner-plugins: %v", err)
+	}
+	crdv1.SetProvisionerPlugins(plugins)
+	waitConfigs, err := snapshotter.ParseWaitConfigs(*waitPlugins, snapshotter.WaitConfig{
+		InitialDelay: *waitDelay,
+		Factor:       *waitFactor,
+		MaxDelay:     *waitMaxDelay,
+		Timeout:      *waitTimeout,
+	})
+	if err != nil {
+		glog.Fatalf("Invalid -snapshot-wait-plugins: %v", err)
+	}
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		ServiceName: "snapshot-controller",
+		Exporter:    *tracingExporter,
+		Endpoint:    *tracingEndpoint,
+	})
+	if err != nil {
+		glog.Fatalf("Failed to set up the tracing: %v", err)
+	}
 	// Create the client config. Use kubeconfig if given, otherwise assume in-cluster.
 	config, err := buildConfig(*kubeconfig)
 	if err != nil {
 		panic(err)
 	}
 
+	address := *httpAddress
+	if address == "" {
+		address = *metricsAddress
+	}
+	stopCh := make(chan struct{})
+	var srv *server.Server
+	if address != "" {
+		srv = server.New(server.Config{
+			Address:     address,
+			EnablePprof: *enablePprof,
+		})
+		// promhttp.Handler serves the metrics of the default registry
+		srv.Handle("/metrics", promhttp.Handler())
+		garbagecollector.RegisterMetrics(prometheus.DefaultRegisterer)
+		srv.SetReady("crd", errors.New("waiting for the snapshot resources"))
+		if os.Getenv("MAPI_ADDR") != "" {
+			srv.AddReadyCheck("maya-apiserver", mayav1alpha1.CASVolume{}.Reachable)
+		}
+		go func() {
+			if err := srv.Run(stopCh); err != nil {
+				glog.Fatalf("Failed to serve HTTP: %v", err)
+			}
+		}()
+	}
+
 	clientset, err := kubernetes.NewForConfig(config)
 	aeclientset, err := apiextensionsclient.NewForConfig(config)
 	if err != nil {
@@ -86,19 +169,58 @@ func main() {
 		panic(err)
 	}
 	// build volume plugins map
-	buildVolumePlugins()
+	buildVolumePlugins(clientset)
 
 	// start controller on instances of our CRD
 	glog.Infof("starting snapshot controller")
-	ssController := snapshotcontroller.NewSnapshotController(snapshotClient, snapshotScheme, clientset, &volumePlugins, defaultSyncDuration)
-	stopCh := make(chan struct{})
+	ssController := snapshotcontroller.NewSnapshotController(snapshotClient, snapshotScheme, clientset, &volumePlugins, defaultSyncDuration, waitConfigs)
+	if srv != nil {
+		srv.SetReady("crd", nil)
+		srv.AddReadyCheck("informers", server.SyncedCheck(ssController.HasSynced))
+		srv.AddState("snapshot-controller", func() interface{} { return ssController.State() })
+	}
 
 	go ssController.Run(stopCh)
 
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(glog.Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events(v1.NamespaceAll)})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "openebs-garbage-collector"})
+
+	// the final snapshots expire whether or not the orphans are collected
+	expirer := garbagecollector.NewFinalSnapshotExpirer(
+		garbagecollector.NewSnapshotDataLister(snapshotClient),
+		garbagecollector.NewMayaBackend(),
+		recorder,
+		garbagecollector.ExpirerConfig{
+			Interval:                 *expiryInterval,
+			DryRun:                   *gcDryRun,
+			GarbageCollectorDisabled: *gcInterval <= 0,
+		})
+	go expirer.Run(stopCh)
+
+	if *gcInterval > 0 {
+		gc := garbagecollector.NewGarbageCollector(clientset,
+			garbagecollector.NewSnapshotDataLister(snapshotClient),
+			garbagecollector.NewMayaBackend(),
+			garbagecollector.Config{
+				Interval:    *gcInterval,
+				GracePeriod: *gcGracePeriod,
+				DryRun:      *gcDryRun,
+			})
+		go gc.Run(stopCh)
+	}
+
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	glog.Infof("Received %v, shutting down", sig)
+	ssController.Drain(*drainTimeout)
 	close(stopCh)
+	// flush the spans of the drained operations
+	if err := shutdownTracing(context.Background()); err != nil {
+		glog.Errorf("Failed to flush the traces: %v", err)
+	}
 
 }
 
@@ -109,9 +231,16 @@ func buildConfig(kubeconfig string) (*rest.Config, error) {
 	return rest.InClusterConfig()
 }
 
-func buildVolumePlugins() {
-	volumePlugins[gluster.GetPluginName()] = gluster.RegisterPlugin()
+func buildVolumePlugins(client kubernetes.Interface) {
+	volumePlugins[csi.GetPluginName()] = csi.RegisterPlugin()
+	volumePlugins[gluster.GetPluginName()] = volume.AdaptPlugin(gluster.RegisterPlugin())
 	volumePlugins[hostpath.GetPluginName()] = hostpath.RegisterPlugin()
+	volumePlugins[lvm.GetPluginName()] = volume.AdaptPlugin(lvm.RegisterPlugin())
 	volumePlugins[openebs.GetPluginName()] = openebs.RegisterPlugin()
+	volumePlugins[zfs.GetPluginName()] = volume.AdaptPlugin(zfs.RegisterPlugin())
+	remote.RegisterPlugins(*pluginDir, volumePlugins)
 
+	for _, plugin := range volumePlugins {
+		volume.SetKubeClient(plugin, client)
+	}
 }
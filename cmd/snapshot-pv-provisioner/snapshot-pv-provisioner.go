@@ -27,14 +27,24 @@ import (
 	"github.com/golang/glog"
 	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
 	crdclient "github.com/openebs/openebs-k8s-provisioner/pkg/client"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/server"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/tracing"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/csi"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/gluster"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/hostpath"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/lvm"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/openebs"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/remote"
+	mayav1alpha1 "github.com/openebs/openebs-k8s-provisioner/pkg/volume/v1alpha1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/zfs"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/v7/controller"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
@@ -42,7 +52,7 @@ import (
 )
 
 const (
-	provisionerName  = "volumesnapshot.external-storage.k8s.io/snapshot-promoter"
+	provisionerName  = crdv1.SnapshotPromoterName
 	provisionerIDAnn = "snapshotProvisionerIdentity"
 	// LeaderElectionKey represents ENV for disable/enable leaderElection for
 	// snapshot-provisioner
@@ -69,31 +79,56 @@ func newSnapshotProvisioner(client kubernetes.Interface, crdclient *rest.RESTCli
 
 var _ controller.Provisioner = &snapshotProvisioner{}
 
-func (p *snapshotProvisioner) snapshotRestore(snapshotName string, snapshotData crdv1.VolumeSnapshotData, options controller.ProvisionOptions) (*v1.PersistentVolumeSource, map[string]string, error) {
+func (p *snapshotProvisioner) snapshotRestore(ctx context.Context, snapshotName string, snapshotData crdv1.VolumeSnapshotData, options controller.ProvisionOptions) (*volume.RestoreResult, error) {
 	// validate the PV supports snapshot and restore
 	spec := &snapshotData.Spec
 	volumeType := crdv1.GetSupportedVolumeFromSnapshotDataSpec(spec)
 	if len(volumeType) == 0 {
-		return nil, nil, fmt.Errorf("unsupported volume type found in SnapshotData %#v", *spec)
+		return nil, fmt.Errorf("unsupported volume type found in SnapshotData %#v", *spec)
 	}
 	plugin, ok := volumePlugins[volumeType]
 	if !ok {
-		return nil, nil, fmt.Errorf("%s is not supported volume for %#v", volumeType, *spec)
+		return nil, fmt.Errorf("%s is not supported volume for %#v", volumeType, *spec)
+	}
+
+	if !plugin.Capabilities().SnapshotRestore {
+		return nil, volume.NewUnsupportedError("%s can not restore snapshots", volumeType)
 	}
 
 	// restore snapshot
-	pvSrc, labels, err := plugin.SnapshotRestore(&snapshotData, options.PVC, options.PVName, options.StorageClass.Parameters)
+	ctx, done := volume.StartOperation(ctx, volumeType, volume.OperationRestore)
+	result, err := plugin.SnapshotRestore(ctx, &snapshotData, options.PVC, options.PVName, options.StorageClass.Parameters)
+	done(err)
 	if err != nil {
 		glog.Warningf("failed to snapshot %#v, err: %v", spec, err)
-	} else {
-		glog.Infof("snapshot %#v to snap %#v", spec, pvSrc)
+		return nil, err
 	}
+	glog.Infof("snapshot %#v to snap %#v", spec, result.Source)
+
+	return &result, nil
+}
 
-	return pvSrc, labels, err
+// restoreNodeAffinity returns the node affinity of the PV restored from the
+// snapshot, if the volume plugin restricts the nodes it is reachable from
+func restoreNodeAffinity(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (*v1.VolumeNodeAffinity, error) {
+	plugin, ok := volumePlugins[crdv1.GetSupportedVolumeFromSnapshotDataSpec(&snapshotData.Spec)]
+	if !ok {
+		return nil, nil
+	}
+	return volume.RestoreNodeAffinity(ctx, plugin, snapshotData)
 }
 
 // Provision creates a storage asset and returns a PV object representing it.
 func (p *snapshotProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	ctx, span := tracing.Start(ctx, "Provision",
+		attribute.String("pv", options.PVName),
+		attribute.String("pvc", options.PVC.Namespace+"/"+options.PVC.Name))
+	pv, state, err := p.provision(ctx, options)
+	tracing.End(span, err)
+	return pv, state, err
+}
+
+func (p *snapshotProvisioner) provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
 	if options.PVC.Spec.Selector != nil {
 		return nil, controller.ProvisioningFinished, fmt.Errorf("claim Selector is not supported")
 	}
@@ -127,8 +162,17 @@ func (p *snapshotProvisioner) Provision(ctx context.Context, options controller.
 	}
 	glog.V(3).Infof("restore from VolumeSnapshotData %s", snapshot.Spec.SnapshotDataName)
 
-	pvSrc, labels, err := p.snapshotRestore(snapshot.Spec.SnapshotDataName, snapshotData, options)
-	if err != nil || pvSrc == nil {
+	// resolved before the restore, a failure leaves no volume behind
+	nodeAffinity, err := restoreNodeAffinity(ctx, &snapshotData)
+	if err != nil {
+		return nil, controller.ProvisioningNoChange, fmt.Errorf("failed to get the node affinity of the PV restored from snapshot %s: %v", snapshotName, err)
+	}
+
+	result, err := p.snapshotRestore(ctx, snapshot.Spec.SnapshotDataName, snapshotData, options)
+	if volume.IsUnsupported(err) {
+		return nil, controller.ProvisioningFinished, fmt.Errorf("failed to create a PV from snapshot %s: %v", snapshotName, err)
+	}
+	if err != nil || result == nil {
 		return nil, controller.ProvisioningInBackground, fmt.Errorf("failed to create a PV from snapshot %s: %v", snapshotName, err)
 	}
 	pv := &v1.PersistentVolume{
@@ -136,6 +180,9 @@ func (p *snapshotProvisioner) Provision(ctx context.Context, options controller.
 			Name: options.PVName,
 			Annotations: map[string]string{
 				provisionerIDAnn: p.identity,
+				// the restored PVs are snapshotted by the plugin they are
+				// restored by
+				crdv1.PluginAnnotation: crdv1.GetSupportedVolumeFromSnapshotDataSpec(&snapshotData.Spec),
 			},
 		},
 		Spec: v1.PersistentVolumeSpec{
@@ -144,15 +191,20 @@ func (p *snapshotProvisioner) Provision(ctx context.Context, options controller.
 			Capacity: v1.ResourceList{
 				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
 			},
-			PersistentVolumeSource: *pvSrc,
+			PersistentVolumeSource: result.Source,
 		},
 	}
 
-	if len(labels) != 0 {
+	pv.Spec.NodeAffinity = result.NodeAffinity
+	if pv.Spec.NodeAffinity == nil {
+		pv.Spec.NodeAffinity = nodeAffinity
+	}
+
+	if len(result.Labels) != 0 {
 		if pv.Labels == nil {
 			pv.Labels = make(map[string]string)
 		}
-		for k, v := range labels {
+		for k, v := range result.Labels {
 			pv.Labels[k] = v
 		}
 	}
@@ -164,8 +216,15 @@ func (p *snapshotProvisioner) Provision(ctx context.Context, options controller.
 
 // Delete removes the storage asset that was created by Provision represented
 // by the given PV.
-func (p *snapshotProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume) error {
-	ann, ok := volume.Annotations[provisionerIDAnn]
+func (p *snapshotProvisioner) Delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	ctx, span := tracing.Start(ctx, "Delete", attribute.String("pv", pv.Name))
+	err := p.delete(ctx, pv)
+	tracing.End(span, err)
+	return err
+}
+
+func (p *snapshotProvisioner) delete(ctx context.Context, pv *v1.PersistentVolume) error {
+	ann, ok := pv.Annotations[provisionerIDAnn]
 	if !ok {
 		return errors.New("identity annotation not found on PV")
 	}
@@ -173,17 +232,29 @@ func (p *snapshotProvisioner) Delete(ctx context.Context, volume *v1.PersistentV
 		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
 	}
 
-	volumeType := crdv1.GetSupportedVolumeFromPVSpec(&volume.Spec)
+	volumeType := crdv1.GetSupportedVolumeFromPV(pv)
 	if len(volumeType) == 0 {
-		return fmt.Errorf("unsupported volume type found in PV %#v", *volume)
+		return fmt.Errorf("unsupported volume type found in PV %#v", *pv)
 	}
 	plugin, ok := volumePlugins[volumeType]
 	if !ok {
-		return fmt.Errorf("%s is not supported volume for %#v", volumeType, *volume)
+		return fmt.Errorf("%s is not supported volume for %#v", volumeType, *pv)
+	}
+
+	if !plugin.Capabilities().VolumeDelete {
+		glog.Infof("%s can not delete volumes, leaving the volume of PV %s", volumeType, pv.Name)
+		return nil
 	}
 
 	// delete PV
-	return plugin.VolumeDelete(volume)
+	ctx, done := volume.StartOperation(ctx, volumeType, volume.OperationVolumeDelete)
+	err := plugin.VolumeDelete(ctx, pv)
+	done(err)
+	if volume.IsNotFound(err) {
+		glog.Infof("volume of PV %s not found, it is deleted already", pv.Name)
+		return nil
+	}
+	return err
 }
 
 var (
@@ -192,15 +263,36 @@ var (
 	id              = flag.String("id", "", "Unique provisioner identity")
 	cloudProvider   = flag.String("cloudprovider", "", "")
 	cloudConfigFile = flag.String("cloudconfig", "", "Path to a Cloud config. Only required if cloudprovider is set.")
-	volumePlugins   = make(map[string]volume.Plugin)
+	pluginDir       = flag.String("plugin-dir", "", "Directory of the sockets of the out-of-process volume plugins. No plugin is discovered if empty.")
+	pluginMapping   = flag.String("provisioner-plugins", crdv1.DefaultProvisionerPlugins, "Comma separated provisioner=plugin pairs selecting the volume plugin of the PVs by their provisioner or CSI driver name. A trailing * matches a prefix, an empty plugin selects the plugin from the volume source. The plugins are selected from the volume source of all PVs if empty.")
+	httpAddress     = flag.String("http-address", "", "Address to serve the prometheus metrics, the health, readiness and debug endpoints on, e.g. :9500. They are not served if empty.")
+	metricsAddress  = flag.String("metrics-address", "", "Address to serve the prometheus metrics on, used if -http-address is empty.")
+	enablePprof     = flag.Bool("enable-pprof", false, "Serve the pprof endpoints under /debug/pprof/ on -http-address.")
+	tracingExporter = flag.String("tracing-exporter", tracing.ExporterNone, "Exporter of the OpenTelemetry traces, otlp or stdout. The operations are not traced if empty.")
+	tracingEndpoint = flag.String("tracing-endpoint", "", "URL of the OTLP/HTTP collector of the traces, e.g. http://otel-collector:4318. OTEL_EXPORTER_OTLP_ENDPOINT is used if empty.")
+	volumePlugins   = make(map[string]volume.PluginV2)
 )
 
 func main() {
 	flag.Parse()
 	flag.Set("logtostderr", "true")
+	plugins, err := crdv1.ParseProvisionerPlugins(*pluginMapping)
+	if err != nil {
+		glog.Fatalf("Invalid -provisioner-plugins: %v", err)
+	}
+	crdv1.SetProvisionerPlugins(plugins)
+
+	shutdownTracing, err := tracing.Setup(tracing.Config{
+		ServiceName: "snapshot-pv-provisioner",
+		Exporter:    *tracingExporter,
+		Endpoint:    *tracingEndpoint,
+	})
+	if err != nil {
+		glog.Fatalf("Failed to set up the tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	var config *rest.Config
-	var err error
 	if *master != "" || *kubeconfig != "" {
 		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
 	} else {
@@ -219,7 +311,7 @@ func main() {
 	}
 
 	// build volume plugins map
-	buildVolumePlugins()
+	buildVolumePlugins(clientset)
 
 	// make a crd client to list VolumeSnapshot
 	snapshotClient, _, err := crdclient.NewClient(config)
@@ -231,23 +323,68 @@ func main() {
 	// the controller
 	snapshotProvisioner := newSnapshotProvisioner(clientset, snapshotClient, prID)
 
+	// The informers of the provision controller are shared with the
+	// readiness checks
+	informerFactory := informers.NewSharedInformerFactory(clientset, controller.DefaultResyncPeriod)
+	claims := informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	volumes := informerFactory.Core().V1().PersistentVolumes().Informer()
+	classes := informerFactory.Storage().V1().StorageClasses().Informer()
+
 	// Start the provision controller which will dynamically provision snapshot
 	// PVs
+	leaderElection := isLeaderElectionEnabled()
 	pc := controller.NewProvisionController(
 		clientset,
 		provisionerName,
 		snapshotProvisioner,
-		controller.LeaderElection(isLeaderElectionEnabled()),
+		controller.LeaderElection(leaderElection),
+		controller.ClaimsInformer(claims),
+		controller.VolumesInformer(volumes),
+		controller.ClassesInformer(classes),
 	)
+	stopCh := make(chan struct{})
+	informerFactory.Start(stopCh)
+
+	address := *httpAddress
+	if address == "" {
+		address = *metricsAddress
+	}
+	if address != "" {
+		srv := server.New(server.Config{
+			Address:     address,
+			EnablePprof: *enablePprof,
+		})
+		srv.Handle("/metrics", promhttp.Handler())
+		// maya-apiserver restores the snapshots of the OpenEBS volumes only
+		if os.Getenv("MAPI_ADDR") != "" {
+			srv.AddReadyCheck("maya-apiserver", mayav1alpha1.CASVolume{}.Reachable)
+		}
+		srv.AddReadyCheck("informers", server.SyncedCheck(claims.HasSynced, volumes.HasSynced, classes.HasSynced))
+		if leaderElection {
+			srv.AddReadyCheck("leader", server.LeaderCheck(clientset, provisionerName))
+		}
+		go func() {
+			if err := srv.Run(stopCh); err != nil {
+				glog.Fatalf("Failed to serve HTTP: %v", err)
+			}
+		}()
+	}
 	glog.Infof("starting PV provisioner %s", provisionerName)
 	pc.Run(context.Background())
 }
 
-func buildVolumePlugins() {
-	volumePlugins[gluster.GetPluginName()] = gluster.RegisterPlugin()
+func buildVolumePlugins(client kubernetes.Interface) {
+	volumePlugins[csi.GetPluginName()] = csi.RegisterPlugin()
+	volumePlugins[gluster.GetPluginName()] = volume.AdaptPlugin(gluster.RegisterPlugin())
 	volumePlugins[hostpath.GetPluginName()] = hostpath.RegisterPlugin()
+	volumePlugins[lvm.GetPluginName()] = volume.AdaptPlugin(lvm.RegisterPlugin())
 	volumePlugins[openebs.GetPluginName()] = openebs.RegisterPlugin()
+	volumePlugins[zfs.GetPluginName()] = volume.AdaptPlugin(zfs.RegisterPlugin())
+	remote.RegisterPlugins(*pluginDir, volumePlugins)
 
+	for _, plugin := range volumePlugins {
+		volume.SetKubeClient(plugin, client)
+	}
 }
 
 // isLeaderElectionEnabled returns true/false based on the ENV
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// snapshot-reference-plugin serves the reference out-of-process volume
+// plugin. The snapshot controller and the snapshot provisioner discover it
+// when its socket is in their -plugin-dir.
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/remote"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume/remote/reference"
+)
+
+const version = "0.1.0"
+
+var (
+	socket      = flag.String("socket", "/var/lib/openebs/snapshot-plugins/reference.sock", "Unix socket to serve the plugin on.")
+	name        = flag.String("name", "reference", "Name the plugin is registered with.")
+	snapshotDir = flag.String("snapshot-dir", "/var/openebs/snapshots", "Directory the snapshots are copied to.")
+	restoreDir  = flag.String("restore-dir", "/var/openebs/restored", "Directory the snapshots are restored to.")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	for _, dir := range []string{*snapshotDir, *restoreDir} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			glog.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	server := remote.NewServer(*name, version, reference.NewPlugin(*name, *snapshotDir, *restoreDir))
+	stopCh := make(chan struct{})
+	go wait.Until(func() {
+		if err := reference.Check(*snapshotDir, *restoreDir); err != nil {
+			glog.Errorf("Plugin is not serving: %v", err)
+			server.SetServing(false)
+			return
+		}
+		server.SetServing(true)
+	}, 30*time.Second, stopCh)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		close(stopCh)
+		server.Stop()
+	}()
+
+	if err := server.ListenAndServe(*socket); err != nil {
+		glog.Fatalf("%v", err)
+	}
+}
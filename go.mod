@@ -3,16 +3,24 @@ module github.com/openebs/openebs-k8s-provisioner
 go 1.16
 
 require (
+	github.com/container-storage-interface/spec v1.3.0
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
-	github.com/google/go-cmp v0.5.5 // indirect
+	github.com/klauspost/compress v1.11.13
 	github.com/miekg/dns v1.1.35 // indirect
 	github.com/pborman/uuid v1.2.0
-	github.com/prometheus/client_golang v1.8.0 // indirect
+	github.com/prometheus/client_golang v1.8.0
+	github.com/prometheus/client_model v0.2.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad // indirect
-	golang.org/x/sys v0.0.0-20210216224549-f992740a1bac // indirect
+	golang.org/x/sys v0.0.0-20210423185535-09eb48e85fd7
 	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1 // indirect
 	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba // indirect
+	google.golang.org/grpc v1.41.0
 	k8s.io/api v0.20.3
 	k8s.io/apiextensions-apiserver v0.0.0
 	k8s.io/apimachinery v0.20.3
@@ -20,6 +28,7 @@ require (
 	k8s.io/kubernetes v1.20.3
 	k8s.io/utils v0.0.0-20201110183641-67b214c5f920
 	sigs.k8s.io/sig-storage-lib-external-provisioner/v7 v7.0.1
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetSnapshotExpiry returns the time after which the object expires
+// according to its SnapshotExpiryAnnotation, a duration is relative to the
+// creation of the object. It returns false when the annotation is not set.
+func GetSnapshotExpiry(meta *metav1.ObjectMeta) (time.Time, bool, error) {
+	value, ok := meta.Annotations[SnapshotExpiryAnnotation]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	if expiry, err := time.Parse(time.RFC3339, value); err == nil {
+		return expiry, true, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid %s %q: neither an RFC 3339 time nor a duration", SnapshotExpiryAnnotation, value)
+	}
+	if ttl < 0 {
+		return time.Time{}, false, fmt.Errorf("invalid %s %q: negative duration", SnapshotExpiryAnnotation, value)
+	}
+	return meta.CreationTimestamp.Add(ttl), true, nil
+}
+
+// IsLegalHold returns true if the object is held by its LegalHoldAnnotation.
+// A value that does not parse as a boolean holds the object too, an
+// unintended hold is better than an unintended deletion.
+func IsLegalHold(meta *metav1.ObjectMeta) bool {
+	value, ok := meta.Annotations[LegalHoldAnnotation]
+	if !ok {
+		return false
+	}
+	held, err := strconv.ParseBool(value)
+	return err != nil || held
+}
+
+// HasFinalizer returns true if the object has the finalizer
+func HasFinalizer(meta *metav1.ObjectMeta, finalizer string) bool {
+	for _, f := range meta.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFinalizer adds the finalizer to the object, or removes it from the
+// object, it returns true if the finalizers of the object changed
+func SetFinalizer(meta *metav1.ObjectMeta, finalizer string, set bool) bool {
+	if HasFinalizer(meta, finalizer) == set {
+		return false
+	}
+	if set {
+		meta.Finalizers = append(meta.Finalizers, finalizer)
+		return true
+	}
+	var finalizers []string
+	for _, f := range meta.Finalizers {
+		if f != finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	meta.Finalizers = finalizers
+	return true
+}
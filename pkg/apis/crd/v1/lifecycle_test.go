@@ -0,0 +1,85 @@
+package v1
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSnapshotExpiry(t *testing.T) {
+	created := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		annotations map[string]string
+		expiry      time.Time
+		ok          bool
+		wantErr     bool
+	}{
+		"no expiry": {},
+		"absolute time": {
+			annotations: map[string]string{SnapshotExpiryAnnotation: "2018-07-01T00:00:00Z"},
+			expiry:      time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC),
+			ok:          true,
+		},
+		"duration": {
+			annotations: map[string]string{SnapshotExpiryAnnotation: "24h"},
+			expiry:      created.Add(24 * time.Hour),
+			ok:          true,
+		},
+		"negative duration": {
+			annotations: map[string]string{SnapshotExpiryAnnotation: "-1h"},
+			wantErr:     true,
+		},
+		"invalid": {
+			annotations: map[string]string{SnapshotExpiryAnnotation: "next week"},
+			wantErr:     true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			meta := &metav1.ObjectMeta{Annotations: test.annotations, CreationTimestamp: metav1.NewTime(created)}
+			expiry, ok, err := GetSnapshotExpiry(meta)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if ok != test.ok || !expiry.Equal(test.expiry) {
+				t.Errorf("Expected expiry %v (%v), got %v (%v)", test.expiry, test.ok, expiry, ok)
+			}
+		})
+	}
+}
+
+func TestIsLegalHold(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		held        bool
+	}{
+		"not set": {},
+		"true":    {annotations: map[string]string{LegalHoldAnnotation: "true"}, held: true},
+		"false":   {annotations: map[string]string{LegalHoldAnnotation: "false"}},
+		"case id": {annotations: map[string]string{LegalHoldAnnotation: "CASE-1234"}, held: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if held := IsLegalHold(&metav1.ObjectMeta{Annotations: test.annotations}); held != test.held {
+				t.Errorf("Expected held %v, got %v", test.held, held)
+			}
+		})
+	}
+}
+
+func TestSetFinalizer(t *testing.T) {
+	meta := &metav1.ObjectMeta{Finalizers: []string{"other"}}
+	if !SetFinalizer(meta, LegalHoldFinalizer, true) || !HasFinalizer(meta, LegalHoldFinalizer) {
+		t.Fatalf("Expected the finalizer to be added, got %v", meta.Finalizers)
+	}
+	if SetFinalizer(meta, LegalHoldFinalizer, true) {
+		t.Errorf("Expected no change adding the finalizer twice")
+	}
+	if !SetFinalizer(meta, LegalHoldFinalizer, false) || HasFinalizer(meta, LegalHoldFinalizer) {
+		t.Fatalf("Expected the finalizer to be removed, got %v", meta.Finalizers)
+	}
+	if len(meta.Finalizers) != 1 || meta.Finalizers[0] != "other" {
+		t.Errorf("Expected the other finalizers to be kept, got %v", meta.Finalizers)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ProvisionedByAnnotation names the provisioner of a dynamically
+	// provisioned PV
+	ProvisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+	// OpenEBSProvisionerIdentityAnnotation is set by the openebs provisioner
+	// on the PVs it provisions
+	OpenEBSProvisionerIdentityAnnotation = "openEBSProvisionerIdentity"
+	// OpenEBSProvisionerName is the name of the openebs provisioner
+	OpenEBSProvisionerName = "openebs.io/provisioner-iscsi"
+	// SnapshotPromoterName is the name of the provisioner restoring PVs
+	// from snapshots
+	SnapshotPromoterName = "volumesnapshot.external-storage.k8s.io/snapshot-promoter"
+
+	// DefaultProvisionerPlugins is the default mapping of the provisioners
+	// to the volume plugins, see ParseProvisionerPlugins. The PVs restored
+	// from snapshots are annotated with their plugin, the older ones are
+	// told apart by their volume source. The PVs of the CSI drivers not in
+	// the mapping fall back to the csi plugin.
+	DefaultProvisionerPlugins = OpenEBSProvisionerName + "=openebs," +
+		"kubernetes.io/aws-ebs=aws_ebs," +
+		"kubernetes.io/gce-pd=gce-pd," +
+		"kubernetes.io/cinder=cinder," +
+		"kubernetes.io/glusterfs=glusterfs," +
+		"kubernetes.io/host-path=hostPath," +
+		"local-volume-provisioner-*=," +
+		SnapshotPromoterName + "="
+)
+
+// provisionerPlugins maps the provisioner and CSI driver names to the volume
+// plugins, the PV source type selects the plugin if it is empty
+var provisionerPlugins = mustParseProvisionerPlugins(DefaultProvisionerPlugins)
+
+// ParseProvisionerPlugins parses a comma separated list of
+// provisioner=plugin pairs. The provisioner is the provisioned-by annotation
+// of the PVs or their CSI driver name, a trailing * matches every provisioner
+// with the prefix. An empty plugin selects the plugin from the volume source
+// of the PVs.
+func ParseProvisionerPlugins(mapping string) (map[string]string, error) {
+	plugins := make(map[string]string)
+	for _, pair := range strings.Split(mapping, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid provisioner plugin %q, expected provisioner=plugin", pair)
+		}
+		plugins[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return plugins, nil
+}
+
+func mustParseProvisionerPlugins(mapping string) map[string]string {
+	plugins, err := ParseProvisionerPlugins(mapping)
+	if err != nil {
+		panic(err)
+	}
+	return plugins
+}
+
+// SetProvisionerPlugins sets the mapping of the provisioners to the volume
+// plugins used by GetSupportedVolumeFromPV. The plugins are selected from
+// the volume source of the PVs if the mapping is empty.
+func SetProvisionerPlugins(plugins map[string]string) {
+	provisionerPlugins = plugins
+}
+
+// provisionerOfPV returns the name the plugin of the PV is looked up by,
+// empty if the PV does not tell its provisioner
+func provisionerOfPV(pv *core_v1.PersistentVolume) string {
+	if provisioner := pv.Annotations[ProvisionedByAnnotation]; provisioner != "" {
+		return provisioner
+	}
+	if _, ok := pv.Annotations[OpenEBSProvisionerIdentityAnnotation]; ok {
+		return OpenEBSProvisionerName
+	}
+	if pv.Spec.CSI != nil {
+		return pv.Spec.CSI.Driver
+	}
+	return ""
+}
+
+// pluginOfProvisioner looks the provisioner up in the mapping, the exact
+// names take precedence over the longest matching prefix
+func pluginOfProvisioner(provisioner string) (string, bool) {
+	if plugin, ok := provisionerPlugins[provisioner]; ok {
+		return plugin, true
+	}
+	plugin, match, found := "", "", false
+	for name, p := range provisionerPlugins {
+		prefix := strings.TrimSuffix(name, "*")
+		if prefix != name && strings.HasPrefix(provisioner, prefix) && (!found || len(prefix) > len(match)) {
+			plugin, match, found = p, prefix, true
+		}
+	}
+	return plugin, found
+}
@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSupportedVolumeFromPV(t *testing.T) {
+	iscsi := core_v1.PersistentVolumeSource{ISCSI: &core_v1.ISCSIPersistentVolumeSource{}}
+	hostPath := core_v1.PersistentVolumeSource{HostPath: &core_v1.HostPathVolumeSource{}}
+	local := core_v1.PersistentVolumeSource{Local: &core_v1.LocalVolumeSource{}}
+	csi := core_v1.PersistentVolumeSource{CSI: &core_v1.CSIPersistentVolumeSource{Driver: "csi.example.com"}}
+
+	tests := map[string]struct {
+		mapping     string
+		annotations map[string]string
+		source      core_v1.PersistentVolumeSource
+		plugin      string
+	}{
+		"openebs provisioned": {
+			annotations: map[string]string{ProvisionedByAnnotation: OpenEBSProvisionerName},
+			source:      iscsi,
+			plugin:      "openebs",
+		},
+		"openebs identity": {
+			annotations: map[string]string{OpenEBSProvisionerIdentityAnnotation: "node1"},
+			source:      iscsi,
+			plugin:      "openebs",
+		},
+		"iscsi of another array": {
+			annotations: map[string]string{ProvisionedByAnnotation: "array.example.com/iscsi"},
+			source:      iscsi,
+		},
+		"static iscsi": {
+			source: iscsi,
+		},
+		"static hostPath": {
+			source: hostPath,
+			plugin: "hostPath",
+		},
+		"local provisioner prefix": {
+			annotations: map[string]string{ProvisionedByAnnotation: "local-volume-provisioner-node1-1234"},
+			source:      local,
+			plugin:      "lvm",
+		},
+		"restored": {
+			annotations: map[string]string{ProvisionedByAnnotation: SnapshotPromoterName},
+			source:      iscsi,
+			plugin:      "openebs",
+		},
+		"explicit plugin": {
+			annotations: map[string]string{ProvisionedByAnnotation: "array.example.com/iscsi", PluginAnnotation: "array"},
+			source:      iscsi,
+			plugin:      "array",
+		},
+		"unmapped csi driver": {
+			source: csi,
+			plugin: "csi",
+		},
+		"unmapped csi provisioner": {
+			annotations: map[string]string{ProvisionedByAnnotation: "csi.example.com"},
+			source:      csi,
+			plugin:      "csi",
+		},
+		"csi driver mapped to another plugin": {
+			mapping: "csi.example.com=array",
+			source:  csi,
+			plugin:  "array",
+		},
+		"mapped csi driver": {
+			mapping: "csi.example.com=csi",
+			source:  csi,
+			plugin:  "csi",
+		},
+		"no mapping": {
+			mapping:     ",",
+			annotations: map[string]string{ProvisionedByAnnotation: "array.example.com/iscsi"},
+			source:      iscsi,
+			plugin:      "openebs",
+		},
+	}
+	defer SetProvisionerPlugins(provisionerPlugins)
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mapping := DefaultProvisionerPlugins
+			if test.mapping != "" {
+				mapping = test.mapping
+			}
+			plugins, err := ParseProvisionerPlugins(mapping)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			SetProvisionerPlugins(plugins)
+			pv := &core_v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv", Annotations: test.annotations},
+				Spec:       core_v1.PersistentVolumeSpec{PersistentVolumeSource: test.source},
+			}
+			if plugin := GetSupportedVolumeFromPV(pv); plugin != test.plugin {
+				t.Errorf("Expected plugin %q, got %q", test.plugin, plugin)
+			}
+		})
+	}
+}
+
+func TestParseProvisionerPlugins(t *testing.T) {
+	if _, err := ParseProvisionerPlugins("openebs"); err == nil {
+		t.Errorf("Expected an error for a pair without a plugin")
+	}
+	plugins, err := ParseProvisionerPlugins(" a=x , b= ")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(plugins) != 2 || plugins["a"] != "x" || plugins["b"] != "" {
+		t.Errorf("Unexpected plugins %v", plugins)
+	}
+}
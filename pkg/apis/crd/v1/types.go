@@ -39,6 +39,11 @@ type VolumeSnapshotStatus struct {
 
 	// Represent the latest available observations about the volume snapshot
 	Conditions []VolumeSnapshotCondition `json:"conditions" protobuf:"bytes,2,rep,name=conditions"`
+
+	// LegalHold is true while the snapshot is held by LegalHoldAnnotation
+	// and can not be deleted
+	// +optional
+	LegalHold bool `json:"legalHold,omitempty" protobuf:"varint,3,opt,name=legalHold"`
 }
 
 // VolumeSnapshotConditionType is the type of VolumeSnapshot conditions
@@ -58,6 +63,15 @@ const (
 	VolumeSnapshotConditionError VolumeSnapshotConditionType = "Error"
 )
 
+const (
+	// VolumeSnapshotReasonUnsupported is the reason of the Error condition
+	// of the snapshots of PVs no volume plugin supports
+	VolumeSnapshotReasonUnsupported = "Unsupported"
+	// VolumeSnapshotReasonTimeout is the reason of the Error condition of
+	// the snapshots not ready before their wait timeout
+	VolumeSnapshotReasonTimeout = "Timeout"
+)
+
 // VolumeSnapshotCondition describes the state of a volume snapshot  at a certain point.
 type VolumeSnapshotCondition struct {
 	// Type of replication controller condition.
@@ -122,6 +136,11 @@ type VolumeSnapshotDataStatus struct {
 
 	// Representes the lates available observations about the volume snapshot
 	Conditions []VolumeSnapshotDataCondition `json:"conditions" protobuf:"bytes,2,rep,name=conditions"`
+
+	// LegalHold is true while the VolumeSnapshot, or the VolumeSnapshotData
+	// itself, is held by LegalHoldAnnotation
+	// +optional
+	LegalHold bool `json:"legalHold,omitempty" protobuf:"varint,3,opt,name=legalHold"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -202,12 +221,29 @@ type VolumeSnapshotDataSpec struct {
 type HostPathVolumeSnapshotSource struct {
 	// Path represents a tar file that stores the HostPath volume source
 	Path string `json:"snapshot"`
+	// NodeName is the node the tar file is stored on
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// EncryptionSecret is the secret holding the keys the tar file is
+	// encrypted with, as namespace/name
+	// +optional
+	EncryptionSecret string `json:"encryptionSecret,omitempty"`
+	// EncryptionKeyID is the ID of the key the tar file is encrypted with
+	// +optional
+	EncryptionKeyID string `json:"encryptionKeyID,omitempty"`
 }
 
 // GlusterVolumeSnapshotSource is Gluster volume snapshot source
 type GlusterVolumeSnapshotSource struct {
 	// UniqueID represents a snapshot resource.
 	SnapshotID string `json:"snapshotId"`
+	// EndpointsName is the endpoints of the volume the snapshot is taken
+	// from
+	// +optional
+	EndpointsName string `json:"endpointsName,omitempty"`
+	// EndpointsNamespace is the namespace of the endpoints
+	// +optional
+	EndpointsNamespace string `json:"endpointsNamespace,omitempty"`
 }
 
 // AWSElasticBlockStoreVolumeSnapshotSource is AWS EBS volume snapshot source
@@ -232,6 +268,78 @@ type OpenEBSVolumeSnapshotSource struct {
 	SnapshotID string `json:"snapshotId"`
 	// Capacity will holds the size of the snapshot
 	Capacity string `json:"capacity"`
+	// CASType is the cas type of the snapshotted volume, it selects the
+	// engine of the snapshot
+	// +optional
+	CASType string `json:"casType,omitempty"`
+	// VolumeName is the name of the snapshotted cas volume
+	// +optional
+	VolumeName string `json:"volumeName,omitempty"`
+	// Namespace is the namespace of the claim of the snapshotted volume,
+	// the snapshot is deleted in it once the PV is gone
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// DeleteVolume is set on the final snapshots, their volume is kept for
+	// the snapshot only and is deleted along with it
+	// +optional
+	DeleteVolume bool `json:"deleteVolume,omitempty"`
+}
+
+// ZFSVolumeSnapshotSource is ZFS volume snapshot source
+type ZFSVolumeSnapshotSource struct {
+	// Snapshot is the full name of the ZFS snapshot, i.e. dataset@name
+	Snapshot string `json:"snapshot"`
+	// NodeName is the node the pool of the snapshot is imported on
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// Local is set if the snapshot is taken of a local volume, the volumes
+	// restored from it are local volumes too
+	// +optional
+	Local bool `json:"local,omitempty"`
+}
+
+// LVMVolumeSnapshotSource is LVM thin volume snapshot source
+type LVMVolumeSnapshotSource struct {
+	// Snapshot is the thin snapshot logical volume as vg/lv
+	Snapshot string `json:"snapshot"`
+	// NodeName is the node the volume group of the snapshot is on
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// FSType is the file system on the snapshot, empty for raw block
+	// volumes
+	// +optional
+	FSType string `json:"fsType,omitempty"`
+}
+
+// CSIVolumeSnapshotSource is CSI volume snapshot source
+type CSIVolumeSnapshotSource struct {
+	// Driver is the name of the CSI driver holding the snapshot
+	Driver string `json:"driver"`
+	// SnapshotHandle is the ID of the snapshot returned by the driver
+	SnapshotHandle string `json:"snapshotHandle"`
+	// SourceVolumeHandle is the ID of the volume the snapshot is taken of
+	// +optional
+	SourceVolumeHandle string `json:"sourceVolumeHandle,omitempty"`
+	// RestoreSize is the minimum size in bytes of a volume restored from the
+	// snapshot, 0 if unknown
+	// +optional
+	RestoreSize int64 `json:"restoreSize,omitempty"`
+	// SnapshotName is the name the snapshot was created with, CreateSnapshot
+	// returns the snapshot again when called with it
+	// +optional
+	SnapshotName string `json:"snapshotName,omitempty"`
+}
+
+// PluginVolumeSnapshotSource is the snapshot source of an out-of-process
+// volume plugin
+type PluginVolumeSnapshotSource struct {
+	// Plugin is the name the plugin holding the snapshot advertises
+	Plugin string `json:"plugin"`
+	// SnapshotID is the ID of the snapshot returned by the plugin
+	SnapshotID string `json:"snapshotId"`
+	// Attributes are kept for the plugin, they are opaque to the controller
+	// +optional
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 // GCEPersistentDiskSnapshotSource is GCE PD volume snapshot source
@@ -266,6 +374,138 @@ type VolumeSnapshotDataSource struct {
 	// OpenEBSVolumeSnapshotSource represents OpenEBS snapshot resource
 	// +optional
 	OpenEBSSnapshot *OpenEBSVolumeSnapshotSource `json:"openebsVolume,omitempty"`
+	// ZFSSnapshot represents a ZFS snapshot of a local or hostPath volume
+	// +optional
+	ZFSSnapshot *ZFSVolumeSnapshotSource `json:"zfsSnapshot,omitempty"`
+	// LVMSnapshot represents an LVM thin snapshot of a local volume
+	// +optional
+	LVMSnapshot *LVMVolumeSnapshotSource `json:"lvmSnapshot,omitempty"`
+	// CSISnapshot represents a snapshot of a CSI volume
+	// +optional
+	CSISnapshot *CSIVolumeSnapshotSource `json:"csiSnapshot,omitempty"`
+	// PluginSnapshot represents a snapshot of an out-of-process volume plugin
+	// +optional
+	PluginSnapshot *PluginVolumeSnapshotSource `json:"pluginSnapshot,omitempty"`
+}
+
+const (
+	// ZFSVolumeLabel set to "true" marks the local and hostPath PVs backed
+	// by a ZFS dataset, they are snapshotted by the zfs plugin
+	ZFSVolumeLabel = "zfs.snapshot.openebs.io/volume"
+	// ZFSDatasetAnnotation on a local or hostPath PV names the ZFS dataset
+	// backing it, the PV is snapshotted by the zfs plugin
+	ZFSDatasetAnnotation = "zfs.snapshot.openebs.io/dataset"
+	// PluginAnnotation on a PV names the out-of-process volume plugin
+	// snapshotting it. The plugins set it as a label on the PVs they restore.
+	PluginAnnotation = "snapshot.openebs.io/plugin"
+)
+
+const (
+	// FinalSnapshotAnnotation set to "true" on a PV, or as a parameter of
+	// its storage class, makes the provisioner take a final snapshot of the
+	// volume before the volume is deleted
+	FinalSnapshotAnnotation = "snapshot.openebs.io/final-snapshot"
+	// FinalSnapshotRetentionAnnotation on a PV, or as a parameter of its
+	// storage class, is the time the final snapshot is kept, e.g. 720h
+	FinalSnapshotRetentionAnnotation = "snapshot.openebs.io/final-snapshot-retention"
+	// FinalSnapshotLabel marks the VolumeSnapshotData of the final snapshots
+	FinalSnapshotLabel = "snapshot.openebs.io/final-snapshot"
+	// FinalSnapshotExpiryAnnotation on the VolumeSnapshotData of a final
+	// snapshot is the RFC 3339 time after which the snapshot and its volume
+	// are deleted, unless the VolumeSnapshotData is bound to a VolumeSnapshot
+	FinalSnapshotExpiryAnnotation = "snapshot.openebs.io/expires-at"
+	// FinalSnapshotClaimAnnotation on the VolumeSnapshotData of a final
+	// snapshot is the namespace/name of the PVC of the deleted volume
+	FinalSnapshotClaimAnnotation = "snapshot.openebs.io/claim"
+	// FinalSnapshotStorageClassAnnotation on the VolumeSnapshotData of a
+	// final snapshot is the storage class of the deleted volume
+	FinalSnapshotStorageClassAnnotation = "snapshot.openebs.io/storage-class"
+)
+
+const (
+	// SnapshotExpiryAnnotation on a VolumeSnapshot is either the RFC 3339
+	// time or the duration since the creation of the VolumeSnapshot, e.g.
+	// 720h, after which the snapshot controller deletes the VolumeSnapshot
+	SnapshotExpiryAnnotation = "snapshot.openebs.io/expiry"
+	// LegalHoldAnnotation set on a VolumeSnapshot, to any value but "false",
+	// keeps the VolumeSnapshot, its VolumeSnapshotData and the snapshot in
+	// the backend from being deleted until the annotation is removed
+	LegalHoldAnnotation = "snapshot.openebs.io/legal-hold"
+	// LegalHoldFinalizer is the finalizer the snapshot controller sets on
+	// the VolumeSnapshots and VolumeSnapshotData under legal hold
+	LegalHoldFinalizer = "snapshot.openebs.io/legal-hold"
+	// SnapshotWaitTimeoutAnnotation on a VolumeSnapshot is the duration
+	// since the creation of the VolumeSnapshot after which it fails with the
+	// Timeout reason if it is not ready, e.g. 30m. It overrides the wait
+	// timeout of the volume plugin.
+	SnapshotWaitTimeoutAnnotation = "snapshot.openebs.io/wait-timeout"
+)
+
+const (
+	// SnapshotProgressAnnotation on a VolumeSnapshot, or a VolumeSnapshotData
+	// being deleted, is the last step of the snapshot operation the snapshot
+	// controller went through, it resumes the operation from there after a
+	// restart
+	SnapshotProgressAnnotation = "snapshot.openebs.io/progress"
+	// SnapshotSourceAnnotation on a VolumeSnapshot is the JSON of the
+	// VolumeSnapshotDataSource of the snapshot taken in the backend, until
+	// the VolumeSnapshotData of the snapshot is bound to the VolumeSnapshot
+	SnapshotSourceAnnotation = "snapshot.openebs.io/snapshot-source"
+
+	// SnapshotProgressTaking means the backend is taking the snapshot
+	SnapshotProgressTaking = "Taking"
+	// SnapshotProgressTaken means the backend took the snapshot, its source
+	// is in SnapshotSourceAnnotation
+	SnapshotProgressTaken = "Taken"
+	// SnapshotProgressBound means the VolumeSnapshotData of the snapshot is
+	// bound to the VolumeSnapshot
+	SnapshotProgressBound = "Bound"
+	// SnapshotProgressDeleting on a VolumeSnapshotData means the backend is
+	// deleting the snapshot
+	SnapshotProgressDeleting = "Deleting"
+)
+
+// GetSupportedVolumeFromPV gets supported volume from PV, it takes the
+// labels and annotations of the PV into account for the volume types that
+// can not be told apart by the PV spec. The PVs are mapped to the plugins by
+// their provisioner, see SetProvisionerPlugins, it returns an empty string
+// for the PVs of the provisioners not in the mapping. The CSI PVs of the
+// drivers not in the mapping are snapshotted by the csi plugin.
+func GetSupportedVolumeFromPV(pv *core_v1.PersistentVolume) string {
+	if plugin := pv.Annotations[PluginAnnotation]; plugin != "" {
+		return plugin
+	}
+	if plugin := pv.Labels[PluginAnnotation]; plugin != "" {
+		return plugin
+	}
+	if pv.Spec.Local != nil || pv.Spec.HostPath != nil {
+		if _, ok := pv.Annotations[ZFSDatasetAnnotation]; ok || pv.Labels[ZFSVolumeLabel] == "true" {
+			return "zfs"
+		}
+	}
+	if len(provisionerPlugins) == 0 {
+		return GetSupportedVolumeFromPVSpec(&pv.Spec)
+	}
+	provisioner := provisionerOfPV(pv)
+	if provisioner == "" {
+		// a statically provisioned iSCSI PV can be of any array
+		if pv.Spec.ISCSI != nil {
+			return ""
+		}
+		return GetSupportedVolumeFromPVSpec(&pv.Spec)
+	}
+	plugin, ok := pluginOfProvisioner(provisioner)
+	if !ok {
+		// the CSI volumes are snapshotted through their driver
+		if pv.Spec.CSI != nil {
+			return "csi"
+		}
+		return ""
+	}
+	if plugin == "" {
+		return GetSupportedVolumeFromPVSpec(&pv.Spec)
+	}
+	return plugin
 }
 
 // GetSupportedVolumeFromPVSpec gets supported volume from PV spec
@@ -288,6 +528,12 @@ func GetSupportedVolumeFromPVSpec(spec *core_v1.PersistentVolumeSpec) string {
 	if spec.ISCSI != nil {
 		return "openebs"
 	}
+	if spec.Local != nil {
+		return "lvm"
+	}
+	if spec.CSI != nil {
+		return "csi"
+	}
 	return ""
 }
 
@@ -311,6 +557,18 @@ func GetSupportedVolumeFromSnapshotDataSpec(spec *VolumeSnapshotDataSpec) string
 	if spec.OpenEBSSnapshot != nil {
 		return "openebs"
 	}
+	if spec.ZFSSnapshot != nil {
+		return "zfs"
+	}
+	if spec.LVMSnapshot != nil {
+		return "lvm"
+	}
+	if spec.CSISnapshot != nil {
+		return "csi"
+	}
+	if spec.PluginSnapshot != nil {
+		return spec.PluginSnapshot.Plugin
+	}
 	return ""
 }
 
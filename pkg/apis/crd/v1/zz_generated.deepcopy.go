@@ -56,6 +56,22 @@ func (in *CinderVolumeSnapshotSource) DeepCopy() *CinderVolumeSnapshotSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CSIVolumeSnapshotSource) DeepCopyInto(out *CSIVolumeSnapshotSource) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CSIVolumeSnapshotSource.
+func (in *CSIVolumeSnapshotSource) DeepCopy() *CSIVolumeSnapshotSource {
+	if in == nil {
+		return nil
+	}
+	out := new(CSIVolumeSnapshotSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GCEPersistentDiskSnapshotSource) DeepCopyInto(out *GCEPersistentDiskSnapshotSource) {
 	*out = *in
@@ -104,6 +120,45 @@ func (in *HostPathVolumeSnapshotSource) DeepCopy() *HostPathVolumeSnapshotSource
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LVMVolumeSnapshotSource) DeepCopyInto(out *LVMVolumeSnapshotSource) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LVMVolumeSnapshotSource.
+func (in *LVMVolumeSnapshotSource) DeepCopy() *LVMVolumeSnapshotSource {
+	if in == nil {
+		return nil
+	}
+	out := new(LVMVolumeSnapshotSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginVolumeSnapshotSource) DeepCopyInto(out *PluginVolumeSnapshotSource) {
+	*out = *in
+	if in.Attributes != nil {
+		in, out := &in.Attributes, &out.Attributes
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginVolumeSnapshotSource.
+func (in *PluginVolumeSnapshotSource) DeepCopy() *PluginVolumeSnapshotSource {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginVolumeSnapshotSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VolumeSnapshot) DeepCopyInto(out *VolumeSnapshot) {
 	*out = *in
@@ -329,6 +384,42 @@ func (in *VolumeSnapshotDataSource) DeepCopyInto(out *VolumeSnapshotDataSource)
 			**out = **in
 		}
 	}
+	if in.ZFSSnapshot != nil {
+		in, out := &in.ZFSSnapshot, &out.ZFSSnapshot
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ZFSVolumeSnapshotSource)
+			**out = **in
+		}
+	}
+	if in.LVMSnapshot != nil {
+		in, out := &in.LVMSnapshot, &out.LVMSnapshot
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(LVMVolumeSnapshotSource)
+			**out = **in
+		}
+	}
+	if in.CSISnapshot != nil {
+		in, out := &in.CSISnapshot, &out.CSISnapshot
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(CSIVolumeSnapshotSource)
+			**out = **in
+		}
+	}
+	if in.PluginSnapshot != nil {
+		in, out := &in.PluginSnapshot, &out.PluginSnapshot
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(PluginVolumeSnapshotSource)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
@@ -474,3 +565,19 @@ func (in *VolumeSnapshotStatus) DeepCopy() *VolumeSnapshotStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZFSVolumeSnapshotSource) DeepCopyInto(out *ZFSVolumeSnapshotSource) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZFSVolumeSnapshotSource.
+func (in *ZFSVolumeSnapshotSource) DeepCopy() *ZFSVolumeSnapshotSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ZFSVolumeSnapshotSource)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// Config holds a single configuration of a CAS entity. A list of configs is
+// set as yaml against the CASConfigKey annotation.
+//
+// Example - Below is a sample CASVolume that overrides the replica count
+// of the volume.
+//
+// ```yaml
+// kind: CASVolume
+// apiVersion: v1alpha1
+// metadata:
+//   name: jiva-cas-vol
+//   annotations:
+//     cas.openebs.io/config: |
+//       - name: ReplicaCount
+//         value: "1"
+// ```
+type Config struct {
+	// Name of the config
+	Name string `json:"name"`
+	// Value of the config
+	Value string `json:"value"`
+}
+
+// CASConfigName is a typed string to represent the names of the configs
+// that can be set against a CAS volume
+type CASConfigName string
+
+const (
+	// ReplicaCountConfig is the number of replicas of the volume
+	ReplicaCountConfig CASConfigName = "ReplicaCount"
+
+	// TargetResourceLimitsConfig is the resource limits of the volume target
+	TargetResourceLimitsConfig CASConfigName = "TargetResourceLimits"
+
+	// TargetResourceRequestsConfig is the resource requests of the volume target
+	TargetResourceRequestsConfig CASConfigName = "TargetResourceRequests"
+
+	// AuxResourceLimitsConfig is the resource limits of the target sidecars
+	AuxResourceLimitsConfig CASConfigName = "AuxResourceLimits"
+
+	// AuxResourceRequestsConfig is the resource requests of the target sidecars
+	AuxResourceRequestsConfig CASConfigName = "AuxResourceRequests"
+
+	// ReplicaResourceLimitsConfig is the resource limits of the volume replicas
+	ReplicaResourceLimitsConfig CASConfigName = "ReplicaResourceLimits"
+
+	// ReplicaResourceRequestsConfig is the resource requests of the volume replicas
+	ReplicaResourceRequestsConfig CASConfigName = "ReplicaResourceRequests"
+
+	// StoragePoolConfig is the jiva storage pool the replicas are placed on
+	StoragePoolConfig CASConfigName = "StoragePool"
+
+	// StoragePoolClaimConfig is the cstor storage pool claim the replicas
+	// are placed on
+	StoragePoolClaimConfig CASConfigName = "StoragePoolClaim"
+
+	// FSTypeConfig is the file system of the volume
+	FSTypeConfig CASConfigName = "FSType"
+)
@@ -70,6 +70,14 @@ const (
 	// StorageClassHeaderKey is the key to fetch name of StorageClass
 	// This key is present only in get request headers
 	StorageClassHeaderKey CASKey = "storageclass"
+
+	// SelectedNodeKey is the key to fetch name of the node selected by the
+	// scheduler for the pod consuming the volume
+	SelectedNodeKey CASKey = "openebs.io/selected-node"
+
+	// AllowedTopologiesKey is the key to fetch the allowed topologies of the
+	// StorageClass. Its value is the json encoded list of topology terms.
+	AllowedTopologiesKey CASKey = "openebs.io/allowed-topologies"
 )
 
 // CASVolumeKey is a typed string to represent CAS Volume related annotations'
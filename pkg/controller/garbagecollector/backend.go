@@ -0,0 +1,105 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollector
+
+import (
+	"context"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	mvol_v1alpha1 "github.com/openebs/openebs-k8s-provisioner/pkg/volume/v1alpha1"
+	"k8s.io/client-go/rest"
+)
+
+// Backend lists and deletes the volumes and snapshots of maya-apiserver, they
+// are listed by namespace
+type Backend interface {
+	ListVolumes(namespace string) ([]v1alpha1.CASVolume, error)
+	ListSnapshots(namespace string) ([]v1alpha1.CASSnapshot, error)
+	DeleteVolume(volume *v1alpha1.CASVolume) error
+	DeleteSnapshot(snapshot *v1alpha1.CASSnapshot) error
+}
+
+// SnapshotDataLister lists the VolumeSnapshotData objects and deletes the
+// expired final snapshots
+type SnapshotDataLister interface {
+	ListSnapshotData() ([]crdv1.VolumeSnapshotData, error)
+	DeleteSnapshotData(name string) error
+}
+
+type mayaBackend struct {
+	mvol_v1alpha1.CASVolume
+}
+
+// NewMayaBackend returns a Backend talking to the maya-apiserver set in
+// the MAPI_ADDR environment variable
+func NewMayaBackend() Backend {
+	return &mayaBackend{}
+}
+
+func (b *mayaBackend) ListVolumes(namespace string) ([]v1alpha1.CASVolume, error) {
+	var list v1alpha1.CASVolumeList
+	if err := b.CASVolume.ListVolumes(namespace, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (b *mayaBackend) ListSnapshots(namespace string) ([]v1alpha1.CASSnapshot, error) {
+	var list v1alpha1.CASSnapshotList
+	if err := b.CASVolume.ListSnapshots(namespace, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (b *mayaBackend) DeleteVolume(volume *v1alpha1.CASVolume) error {
+	return b.CASVolume.DeleteVolume(volume.Name, volume.Namespace)
+}
+
+func (b *mayaBackend) DeleteSnapshot(snapshot *v1alpha1.CASSnapshot) error {
+	_, err := b.CASVolume.DeleteSnapshot(snapshot.Spec.CasType, snapshot.Spec.VolumeName, snapshot.Name, snapshot.Namespace)
+	return err
+}
+
+type snapshotDataLister struct {
+	restClient *rest.RESTClient
+}
+
+// NewSnapshotDataLister returns a SnapshotDataLister using the rest client
+// of the snapshot API group
+func NewSnapshotDataLister(restClient *rest.RESTClient) SnapshotDataLister {
+	return &snapshotDataLister{restClient: restClient}
+}
+
+func (l *snapshotDataLister) ListSnapshotData() ([]crdv1.VolumeSnapshotData, error) {
+	var list crdv1.VolumeSnapshotDataList
+	err := l.restClient.Get().
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Do(context.TODO()).Into(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (l *snapshotDataLister) DeleteSnapshotData(name string) error {
+	return l.restClient.Delete().
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Name(name).
+		Do(context.TODO()).Error()
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// Event reasons
+	snapshotExpired      = "FinalSnapshotExpired"
+	snapshotExpiryFailed = "FinalSnapshotExpiryFailed"
+)
+
+// ExpirerConfig holds the settings of the final snapshot expirer
+type ExpirerConfig struct {
+	// Interval between two runs
+	Interval time.Duration
+	// DryRun reports expired final snapshots without deleting them
+	DryRun bool
+	// GarbageCollectorDisabled is set when the orphans are not collected,
+	// the expirer is then the only one deleting anything
+	GarbageCollectorDisabled bool
+}
+
+type finalSnapshotExpirer struct {
+	snapshotData SnapshotDataLister
+	backend      Backend
+	recorder     record.EventRecorder
+	config       ExpirerConfig
+
+	warned bool
+	now    func() time.Time
+}
+
+// NewFinalSnapshotExpirer returns a loop deleting the expired final
+// snapshots along with their cas snapshot and volume. It runs whether or not
+// the garbage collector is enabled, the volumes kept for the final snapshots
+// are not orphans.
+func NewFinalSnapshotExpirer(
+	snapshotData SnapshotDataLister,
+	backend Backend,
+	recorder record.EventRecorder,
+	config ExpirerConfig) GarbageCollector {
+	return &finalSnapshotExpirer{
+		snapshotData: snapshotData,
+		backend:      backend,
+		recorder:     recorder,
+		config:       config,
+		now:          time.Now,
+	}
+}
+
+func (e *finalSnapshotExpirer) Run(stopCh <-chan struct{}) {
+	glog.Infof("Starting final snapshot expirer, interval %v, dry run %v", e.config.Interval, e.config.DryRun)
+	wait.Until(e.run, e.config.Interval, stopCh)
+}
+
+func (e *finalSnapshotExpirer) run() {
+	if err := e.expire(); err != nil {
+		runErrors.Inc()
+		glog.Errorf("Final snapshot expiry skipped: %v", err)
+	}
+}
+
+func (e *finalSnapshotExpirer) expire() error {
+	snapshotData, err := e.snapshotData.ListSnapshotData()
+	if err != nil {
+		return fmt.Errorf("failed to list VolumeSnapshotData: %v", err)
+	}
+	finalSnapshots := 0
+	for i := range snapshotData {
+		data := &snapshotData[i]
+		if !isFinalSnapshot(data) {
+			continue
+		}
+		finalSnapshots++
+		if e.isExpired(data) {
+			e.deleteExpired(data)
+		}
+	}
+	if finalSnapshots > 0 && e.config.GarbageCollectorDisabled && !e.warned {
+		e.warned = true
+		glog.Warningf("Found %d final snapshots while the garbage collector is disabled, the expired ones are deleted every %v "+
+			"but the cas volumes and snapshots left behind by failed deletions are not collected, see -gc-interval",
+			finalSnapshots, e.config.Interval)
+	}
+	return nil
+}
+
+// isFinalSnapshot returns true if the VolumeSnapshotData records the final
+// snapshot of a deleted PV
+func isFinalSnapshot(data *crdv1.VolumeSnapshotData) bool {
+	return data.Metadata.Labels[crdv1.FinalSnapshotLabel] == "true" &&
+		data.Spec.OpenEBSSnapshot != nil && data.Spec.PersistentVolumeRef != nil
+}
+
+// isExpired returns true if the final snapshot is past its expiry and is not
+// bound to a VolumeSnapshot, a bound one belongs to its VolumeSnapshot and is
+// deleted along with its volume when the VolumeSnapshot is. A final snapshot
+// under legal hold does not expire.
+func (e *finalSnapshotExpirer) isExpired(data *crdv1.VolumeSnapshotData) bool {
+	if data.Spec.VolumeSnapshotRef != nil {
+		return false
+	}
+	if crdv1.IsLegalHold(&data.Metadata) || crdv1.HasFinalizer(&data.Metadata, crdv1.LegalHoldFinalizer) {
+		glog.V(4).Infof("Final snapshot %s is under legal hold, it is kept", data.Metadata.Name)
+		return false
+	}
+	value := data.Metadata.Annotations[crdv1.FinalSnapshotExpiryAnnotation]
+	expiry, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		glog.Warningf("Final snapshot %s has an invalid %s %q, it is kept: %v", data.Metadata.Name, crdv1.FinalSnapshotExpiryAnnotation, value, err)
+		return false
+	}
+	return !e.now().Before(expiry)
+}
+
+// deleteExpired deletes the cas snapshot and the cas volume of the final
+// snapshot before its VolumeSnapshotData, a failed run is retried as a whole
+// by the next one
+func (e *finalSnapshotExpirer) deleteExpired(data *crdv1.VolumeSnapshotData) {
+	ref := &v1.ObjectReference{
+		APIVersion: crdv1.SchemeGroupVersion.String(),
+		Kind:       "VolumeSnapshotData",
+		Name:       data.Metadata.Name,
+	}
+	volumeName := data.Spec.PersistentVolumeRef.Name
+	if e.config.DryRun {
+		glog.Infof("Dry run: not deleting expired final snapshot %s", data.Metadata.Name)
+		return
+	}
+	if err := e.deleteCASResources(data); err != nil {
+		glog.Errorf("Failed to delete expired final snapshot %s: %v", data.Metadata.Name, err)
+		e.recorder.Eventf(ref, v1.EventTypeWarning, snapshotExpiryFailed, "Failed to delete expired final snapshot of volume %s: %v", volumeName, err)
+		return
+	}
+	if err := e.snapshotData.DeleteSnapshotData(data.Metadata.Name); err != nil {
+		glog.Errorf("Failed to delete expired final snapshot %s: %v", data.Metadata.Name, err)
+		return
+	}
+	glog.Infof("Deleted expired final snapshot %s of volume %s", data.Metadata.Name, volumeName)
+	e.recorder.Eventf(ref, v1.EventTypeNormal, snapshotExpired, "Deleted final snapshot of volume %s expired at %s",
+		volumeName, data.Metadata.Annotations[crdv1.FinalSnapshotExpiryAnnotation])
+}
+
+// deleteCASResources deletes the cas snapshot and volume of the final
+// snapshot that still exist, in the namespace of the claim it was taken for
+func (e *finalSnapshotExpirer) deleteCASResources(data *crdv1.VolumeSnapshotData) error {
+	claim := data.Metadata.Annotations[crdv1.FinalSnapshotClaimAnnotation]
+	parts := strings.SplitN(claim, "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid %s %q", crdv1.FinalSnapshotClaimAnnotation, claim)
+	}
+	namespace := parts[0]
+	volumeName := data.Spec.PersistentVolumeRef.Name
+
+	snapshots, err := e.backend.ListSnapshots(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to list cas snapshots of namespace %s: %v", namespace, err)
+	}
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+		if snapshot.Name != data.Spec.OpenEBSSnapshot.SnapshotID {
+			continue
+		}
+		if snapshot.Spec.CasType == "" {
+			snapshot.Spec.CasType = data.Spec.OpenEBSSnapshot.CASType
+		}
+		if snapshot.Spec.VolumeName == "" {
+			snapshot.Spec.VolumeName = volumeName
+		}
+		if err := e.backend.DeleteSnapshot(snapshot); err != nil {
+			return fmt.Errorf("failed to delete cas snapshot %s: %v", snapshot.Name, err)
+		}
+	}
+
+	volumes, err := e.backend.ListVolumes(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to list cas volumes of namespace %s: %v", namespace, err)
+	}
+	for i := range volumes {
+		volume := &volumes[i]
+		if volume.Name != volumeName {
+			continue
+		}
+		if err := e.backend.DeleteVolume(volume); err != nil {
+			return fmt.Errorf("failed to delete cas volume %s: %v", volume.Name, err)
+		}
+	}
+	return nil
+}
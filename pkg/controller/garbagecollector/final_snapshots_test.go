@@ -0,0 +1,110 @@
+package garbagecollector
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestExpireFinalSnapshots(t *testing.T) {
+	now := time.Now()
+	held := newFinalSnapshotData("pvc-held", now.Add(-time.Hour), false)
+	held.Metadata.Annotations[crdv1.LegalHoldAnnotation] = "true"
+	noClaim := newFinalSnapshotData("pvc-no-claim", now.Add(-time.Hour), false)
+	delete(noClaim.Metadata.Annotations, crdv1.FinalSnapshotClaimAnnotation)
+	newItems := func() []crdv1.VolumeSnapshotData {
+		return []crdv1.VolumeSnapshotData{
+			newFinalSnapshotData("pvc-kept", now.Add(time.Hour), false),
+			newFinalSnapshotData("pvc-expired", now.Add(-time.Hour), false),
+			newFinalSnapshotData("pvc-bound", now.Add(-time.Hour), true),
+			held,
+			noClaim,
+		}
+	}
+	newBackend := func() *fakeBackend {
+		backend := &fakeBackend{}
+		for _, name := range []string{"pvc-kept", "pvc-expired", "pvc-bound", "pvc-held", "pvc-no-claim"} {
+			backend.volumes = append(backend.volumes, newCASVolume(name, "claim-"+name))
+			backend.snapshots = append(backend.snapshots, newCASSnapshot("final-"+name))
+		}
+		return backend
+	}
+
+	cases := map[string]struct {
+		config              ExpirerConfig
+		listErr             error
+		expectDeleted       []string
+		expectDeletedCAS    []string
+		expectSnapshotsLeft int
+	}{
+		"Expired": {
+			expectDeleted:       []string{"final-pvc-expired"},
+			expectDeletedCAS:    []string{"snapshot/final-pvc-expired", "volume/pvc-expired"},
+			expectSnapshotsLeft: 4,
+		},
+		"Expired with the garbage collector disabled": {
+			config:              ExpirerConfig{GarbageCollectorDisabled: true},
+			expectDeleted:       []string{"final-pvc-expired"},
+			expectDeletedCAS:    []string{"snapshot/final-pvc-expired", "volume/pvc-expired"},
+			expectSnapshotsLeft: 4,
+		},
+		"Dry run": {
+			config:              ExpirerConfig{DryRun: true},
+			expectSnapshotsLeft: 5,
+		},
+		// the VolumeSnapshotData is kept to retry the deletion of its cas
+		// snapshot and volume
+		"Listing failed": {
+			listErr:             errors.New("maya-apiserver unavailable"),
+			expectSnapshotsLeft: 5,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			snapshotData := &fakeSnapshotDataLister{items: newItems()}
+			backend := newBackend()
+			backend.listErr = tc.listErr
+			e := NewFinalSnapshotExpirer(snapshotData, backend, record.NewFakeRecorder(10), tc.config).(*finalSnapshotExpirer)
+			e.now = func() time.Time { return now }
+
+			if err := e.expire(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(snapshotData.deleted, tc.expectDeleted) {
+				t.Errorf("Expected deleted final snapshots %v, got %v", tc.expectDeleted, snapshotData.deleted)
+			}
+			sort.Strings(backend.deleted)
+			if !reflect.DeepEqual(backend.deleted, tc.expectDeletedCAS) {
+				t.Errorf("Expected deleted %v, got %v", tc.expectDeletedCAS, backend.deleted)
+			}
+			if len(snapshotData.items) != tc.expectSnapshotsLeft {
+				t.Errorf("Expected %d final snapshots left, got %d", tc.expectSnapshotsLeft, len(snapshotData.items))
+			}
+		})
+	}
+}
+
+func TestExpireFinalSnapshotsOfGoneVolume(t *testing.T) {
+	now := time.Now()
+	snapshotData := &fakeSnapshotDataLister{items: []crdv1.VolumeSnapshotData{
+		newFinalSnapshotData("pvc-gone", now.Add(-time.Hour), false),
+	}}
+	// a previous run deleted the cas volume but failed to delete the
+	// VolumeSnapshotData
+	backend := &fakeBackend{volumes: []v1alpha1.CASVolume{newCASVolume("pvc-other", "claim-other")}}
+	e := NewFinalSnapshotExpirer(snapshotData, backend, record.NewFakeRecorder(10), ExpirerConfig{}).(*finalSnapshotExpirer)
+	e.now = func() time.Time { return now }
+
+	if err := e.expire(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(snapshotData.deleted, []string{"final-pvc-gone"}) || len(backend.deleted) != 0 {
+		t.Errorf("Expected only the final snapshot to be deleted, got %v %v", snapshotData.deleted, backend.deleted)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package garbagecollector implements a periodic loop that finds the volumes
+// and snapshots of maya-apiserver that are no longer referenced by any
+// PersistentVolume or VolumeSnapshotData and deletes them, and another one
+// deleting the final snapshots once they expire.
+package garbagecollector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	volumeKind   = "volume"
+	snapshotKind = "snapshot"
+)
+
+var (
+	// ownerAnnotations are the annotations set on the PVs created by the
+	// openebs provisioners, a cas volume is in use as long as a PV carrying
+	// one of them exists with the same name
+	ownerAnnotations = []string{"openEBSProvisionerIdentity", "snapshotProvisionerIdentity"}
+)
+
+// GarbageCollector runs a periodic loop deleting the orphaned volumes and
+// snapshots of maya-apiserver
+type GarbageCollector interface {
+	// Run starts the loop, it returns once stopCh is closed
+	Run(stopCh <-chan struct{})
+}
+
+// Config holds the settings of the garbage collector
+type Config struct {
+	// Interval between two runs
+	Interval time.Duration
+	// GracePeriod an orphan has to stay orphaned before it is deleted
+	GracePeriod time.Duration
+	// DryRun reports orphans without deleting them
+	DryRun bool
+}
+
+type garbageCollector struct {
+	kubeClient   kubernetes.Interface
+	snapshotData SnapshotDataLister
+	backend      Backend
+	config       Config
+
+	// orphans holds the time at which each orphan was first found, keyed by
+	// kind/namespace/name. An entry is dropped as soon as the resource is
+	// either referenced again or gone. It is kept in memory only, the grace
+	// period of the orphans starts again when the process restarts.
+	orphans map[string]time.Time
+	now     func() time.Time
+}
+
+// NewGarbageCollector is the constructor of GarbageCollector. The orphans
+// are reported in the logs only, there is no object in the cluster their
+// events could be recorded on.
+func NewGarbageCollector(
+	kubeClient kubernetes.Interface,
+	snapshotData SnapshotDataLister,
+	backend Backend,
+	config Config) GarbageCollector {
+	return &garbageCollector{
+		kubeClient:   kubeClient,
+		snapshotData: snapshotData,
+		backend:      backend,
+		config:       config,
+		orphans:      make(map[string]time.Time),
+		now:          time.Now,
+	}
+}
+
+func (gc *garbageCollector) Run(stopCh <-chan struct{}) {
+	glog.Infof("Starting garbage collector, interval %v, grace period %v, dry run %v",
+		gc.config.Interval, gc.config.GracePeriod, gc.config.DryRun)
+	wait.Until(gc.run, gc.config.Interval, stopCh)
+}
+
+func (gc *garbageCollector) run() {
+	if err := gc.collect(); err != nil {
+		runErrors.Inc()
+		glog.Errorf("Garbage collection skipped: %v", err)
+	}
+}
+
+// collect lists everything first and gives up on any error, a partial view
+// of the cluster would make referenced resources look orphaned
+func (gc *garbageCollector) collect() error {
+	pvs, err := gc.kubeClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list PVs: %v", err)
+	}
+	claims, err := gc.kubeClient.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list PVCs: %v", err)
+	}
+	snapshotData, err := gc.snapshotData.ListSnapshotData()
+	if err != nil {
+		return fmt.Errorf("failed to list VolumeSnapshotData: %v", err)
+	}
+	volumes, snapshots, err := gc.listCASResources()
+	if err != nil {
+		return err
+	}
+
+	referencedVolumes := make(map[string]bool)
+	for _, pv := range pvs.Items {
+		for _, annotation := range ownerAnnotations {
+			if _, ok := pv.Annotations[annotation]; ok {
+				referencedVolumes[pv.Name] = true
+			}
+		}
+	}
+	pendingClaims := make(map[string]bool)
+	for _, claim := range claims.Items {
+		if claim.Status.Phase == v1.ClaimPending {
+			pendingClaims[claim.Namespace+"/"+claim.Name] = true
+		}
+	}
+	referencedSnapshots := make(map[string]bool)
+	for i := range snapshotData {
+		data := &snapshotData[i]
+		if data.Spec.OpenEBSSnapshot != nil {
+			referencedSnapshots[data.Spec.OpenEBSSnapshot.SnapshotID] = true
+		}
+		// the volume of a final snapshot is kept until the snapshot is
+		// deleted, a clone is restored from the volume
+		if isFinalSnapshot(data) {
+			referencedVolumes[data.Spec.PersistentVolumeRef.Name] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	volumeOrphans := 0
+	for i := range volumes {
+		volume := &volumes[i]
+		if referencedVolumes[volume.Name] || isBeingProvisioned(volume, pendingClaims) {
+			continue
+		}
+		volumeOrphans++
+		seen[orphanKey(volumeKind, volume.Namespace, volume.Name)] = true
+		gc.handleOrphan(volumeKind, volume.Namespace, volume.Name, func() error {
+			return gc.backend.DeleteVolume(volume)
+		})
+	}
+	snapshotOrphans := 0
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+		if referencedSnapshots[snapshot.Name] {
+			continue
+		}
+		snapshotOrphans++
+		seen[orphanKey(snapshotKind, snapshot.Namespace, snapshot.Name)] = true
+		gc.handleOrphan(snapshotKind, snapshot.Namespace, snapshot.Name, func() error {
+			return gc.backend.DeleteSnapshot(snapshot)
+		})
+	}
+
+	for key := range gc.orphans {
+		if !seen[key] {
+			delete(gc.orphans, key)
+		}
+	}
+	orphans.WithLabelValues(volumeKind).Set(float64(volumeOrphans))
+	orphans.WithLabelValues(snapshotKind).Set(float64(snapshotOrphans))
+	return nil
+}
+
+// listCASResources lists the cas volumes and snapshots of every namespace,
+// namespace by namespace since maya-apiserver does not tell whether it lists
+// them across the namespaces. The volumes of the deleted namespaces are not
+// listed.
+func (gc *garbageCollector) listCASResources() ([]v1alpha1.CASVolume, []v1alpha1.CASSnapshot, error) {
+	namespaces, err := gc.kubeClient.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list namespaces: %v", err)
+	}
+	var volumes []v1alpha1.CASVolume
+	var snapshots []v1alpha1.CASSnapshot
+	for _, namespace := range namespaces.Items {
+		namespaceVolumes, err := gc.backend.ListVolumes(namespace.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list cas volumes of namespace %s: %v", namespace.Name, err)
+		}
+		namespaceSnapshots, err := gc.backend.ListSnapshots(namespace.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list cas snapshots of namespace %s: %v", namespace.Name, err)
+		}
+		volumes = append(volumes, namespaceVolumes...)
+		snapshots = append(snapshots, namespaceSnapshots...)
+	}
+	return volumes, snapshots, nil
+}
+
+// isBeingProvisioned returns true if the claim the volume was created for is
+// still pending, the PV of such a volume may not have been created yet
+func isBeingProvisioned(volume *v1alpha1.CASVolume, pendingClaims map[string]bool) bool {
+	namespace := volume.Labels[string(v1alpha1.NamespaceKey)]
+	claim := volume.Labels[string(v1alpha1.PersistentVolumeClaimKey)]
+	return claim != "" && pendingClaims[namespace+"/"+claim]
+}
+
+func orphanKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// handleOrphan records when the orphan was first found and deletes it once
+// the grace period has passed
+func (gc *garbageCollector) handleOrphan(kind, namespace, name string, deleteFunc func() error) {
+	key := orphanKey(kind, namespace, name)
+	firstSeen, ok := gc.orphans[key]
+	if !ok {
+		firstSeen = gc.now()
+		gc.orphans[key] = firstSeen
+		glog.Warningf("Found orphaned %s %s/%s, it is not referenced by any object, it will be deleted after %v",
+			kind, namespace, name, gc.config.GracePeriod)
+	}
+	if gc.now().Sub(firstSeen) < gc.config.GracePeriod {
+		return
+	}
+	if gc.config.DryRun {
+		glog.Infof("Dry run: not deleting orphaned %s %s/%s", kind, namespace, name)
+		return
+	}
+
+	if err := deleteFunc(); err != nil {
+		deleteErrors.WithLabelValues(kind).Inc()
+		glog.Errorf("Failed to delete orphaned %s %s/%s: %v", kind, namespace, name, err)
+		return
+	}
+	deletedOrphans.WithLabelValues(kind).Inc()
+	delete(gc.orphans, key)
+	glog.Infof("Deleted orphaned %s %s/%s", kind, namespace, name)
+}
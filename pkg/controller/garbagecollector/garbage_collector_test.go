@@ -0,0 +1,290 @@
+package garbagecollector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeBackend struct {
+	volumes   []v1alpha1.CASVolume
+	snapshots []v1alpha1.CASSnapshot
+	listErr   error
+	deleted   []string
+}
+
+func (b *fakeBackend) ListVolumes(namespace string) ([]v1alpha1.CASVolume, error) {
+	var volumes []v1alpha1.CASVolume
+	for _, volume := range b.volumes {
+		if volume.Namespace == namespace {
+			volumes = append(volumes, volume)
+		}
+	}
+	return volumes, b.listErr
+}
+
+func (b *fakeBackend) ListSnapshots(namespace string) ([]v1alpha1.CASSnapshot, error) {
+	var snapshots []v1alpha1.CASSnapshot
+	for _, snapshot := range b.snapshots {
+		if snapshot.Namespace == namespace {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, b.listErr
+}
+
+func (b *fakeBackend) DeleteVolume(volume *v1alpha1.CASVolume) error {
+	b.deleted = append(b.deleted, "volume/"+volume.Name)
+	return nil
+}
+
+func (b *fakeBackend) DeleteSnapshot(snapshot *v1alpha1.CASSnapshot) error {
+	b.deleted = append(b.deleted, "snapshot/"+snapshot.Name)
+	return nil
+}
+
+type fakeSnapshotDataLister struct {
+	items   []crdv1.VolumeSnapshotData
+	deleted []string
+}
+
+func (l *fakeSnapshotDataLister) ListSnapshotData() ([]crdv1.VolumeSnapshotData, error) {
+	return l.items, nil
+}
+
+func (l *fakeSnapshotDataLister) DeleteSnapshotData(name string) error {
+	l.deleted = append(l.deleted, name)
+	var items []crdv1.VolumeSnapshotData
+	for _, item := range l.items {
+		if item.Metadata.Name != name {
+			items = append(items, item)
+		}
+	}
+	l.items = items
+	return nil
+}
+
+var defaultNamespace = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+
+func newCASVolume(name, claim string) v1alpha1.CASVolume {
+	volume := v1alpha1.CASVolume{}
+	volume.Name = name
+	volume.Namespace = "default"
+	volume.Labels = map[string]string{
+		string(v1alpha1.NamespaceKey):             "default",
+		string(v1alpha1.PersistentVolumeClaimKey): claim,
+	}
+	return volume
+}
+
+func newCASSnapshot(name string) v1alpha1.CASSnapshot {
+	snapshot := v1alpha1.CASSnapshot{}
+	snapshot.Name = name
+	snapshot.Namespace = "default"
+	return snapshot
+}
+
+func TestCollect(t *testing.T) {
+	objects := []runtime.Object{
+		defaultNamespace,
+		&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-bound",
+			Annotations: map[string]string{"openEBSProvisionerIdentity": "node1"},
+		}},
+		&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-restored",
+			Annotations: map[string]string{"snapshotProvisionerIdentity": "node1"},
+		}},
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "claim-pending", Namespace: "default"},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending},
+		},
+	}
+	snapshotData := &fakeSnapshotDataLister{items: []crdv1.VolumeSnapshotData{
+		{Spec: crdv1.VolumeSnapshotDataSpec{VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+			OpenEBSSnapshot: &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "snap-referenced"},
+		}}},
+	}}
+
+	cases := map[string]struct {
+		dryRun        bool
+		elapsed       time.Duration
+		listErr       error
+		expectDeleted []string
+		expectOrphans int
+	}{
+		"Within grace period": {false, time.Hour, nil, nil, 2},
+		"After grace period":  {false, 2 * time.Hour, nil, []string{"snapshot/snap-orphan", "volume/pvc-orphan"}, 0},
+		"Dry run":             {true, 2 * time.Hour, nil, nil, 2},
+		"Listing fails":       {false, 2 * time.Hour, fmt.Errorf("connection refused"), nil, 0},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			backend := &fakeBackend{
+				volumes: []v1alpha1.CASVolume{
+					newCASVolume("pvc-bound", "claim-bound"),
+					newCASVolume("pvc-restored", "claim-restored"),
+					newCASVolume("pvc-pending", "claim-pending"),
+					newCASVolume("pvc-orphan", "claim-deleted"),
+				},
+				snapshots: []v1alpha1.CASSnapshot{
+					newCASSnapshot("snap-referenced"),
+					newCASSnapshot("snap-orphan"),
+				},
+				listErr: tc.listErr,
+			}
+			now := time.Now()
+			gc := NewGarbageCollector(fake.NewSimpleClientset(objects...), snapshotData, backend,
+				Config{GracePeriod: 90 * time.Minute, DryRun: tc.dryRun}).(*garbageCollector)
+			gc.now = func() time.Time { return now }
+
+			err := gc.collect()
+			if (err != nil) != (tc.listErr != nil) {
+				t.Fatalf("Expected error %v, got %v", tc.listErr, err)
+			}
+			now = now.Add(tc.elapsed)
+			gc.collect()
+
+			sort.Strings(backend.deleted)
+			if !reflect.DeepEqual(backend.deleted, tc.expectDeleted) {
+				t.Errorf("Expected deleted %v, got %v", tc.expectDeleted, backend.deleted)
+			}
+			if len(gc.orphans) != tc.expectOrphans {
+				t.Errorf("Expected %d tracked orphans, got %v", tc.expectOrphans, gc.orphans)
+			}
+		})
+	}
+}
+
+func newFinalSnapshotData(volume string, expiry time.Time, bound bool) crdv1.VolumeSnapshotData {
+	data := crdv1.VolumeSnapshotData{
+		Metadata: metav1.ObjectMeta{
+			Name:   "final-" + volume,
+			Labels: map[string]string{crdv1.FinalSnapshotLabel: "true"},
+			Annotations: map[string]string{
+				crdv1.FinalSnapshotExpiryAnnotation: expiry.UTC().Format(time.RFC3339),
+				crdv1.FinalSnapshotClaimAnnotation:  "default/claim-" + volume,
+			},
+		},
+		Spec: crdv1.VolumeSnapshotDataSpec{
+			VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+				OpenEBSSnapshot: &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "final-" + volume},
+			},
+			PersistentVolumeRef: &v1.ObjectReference{Name: volume},
+		},
+	}
+	if bound {
+		data.Spec.VolumeSnapshotRef = &v1.ObjectReference{Name: "default/restore"}
+	}
+	return data
+}
+
+func TestCollectKeepsFinalSnapshots(t *testing.T) {
+	now := time.Now()
+	snapshotData := &fakeSnapshotDataLister{items: []crdv1.VolumeSnapshotData{
+		newFinalSnapshotData("pvc-kept", now.Add(time.Hour), false),
+		newFinalSnapshotData("pvc-expired", now.Add(-time.Hour), false),
+	}}
+	backend := &fakeBackend{
+		volumes: []v1alpha1.CASVolume{
+			newCASVolume("pvc-kept", "claim-kept"),
+			newCASVolume("pvc-expired", "claim-expired"),
+		},
+		snapshots: []v1alpha1.CASSnapshot{
+			newCASSnapshot("final-pvc-kept"),
+			newCASSnapshot("final-pvc-expired"),
+		},
+	}
+	gc := NewGarbageCollector(fake.NewSimpleClientset(defaultNamespace), snapshotData, backend, Config{}).(*garbageCollector)
+	gc.now = func() time.Time { return now }
+
+	// the final snapshots are deleted by the expirer, their volumes and
+	// snapshots are not orphans even once expired
+	if err := gc.collect(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(snapshotData.deleted) != 0 || len(backend.deleted) != 0 || len(gc.orphans) != 0 {
+		t.Errorf("Expected the final snapshots to be kept, got deleted %v %v, orphans %v",
+			snapshotData.deleted, backend.deleted, gc.orphans)
+	}
+}
+
+func TestCollectListsEveryNamespace(t *testing.T) {
+	other := newCASVolume("pvc-other", "claim-deleted")
+	other.Namespace = "other"
+	otherSnapshot := newCASSnapshot("snap-other")
+	otherSnapshot.Namespace = "other"
+	backend := &fakeBackend{
+		volumes:   []v1alpha1.CASVolume{newCASVolume("pvc-orphan", "claim-deleted"), other},
+		snapshots: []v1alpha1.CASSnapshot{otherSnapshot},
+	}
+	kubeClient := fake.NewSimpleClientset(defaultNamespace, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}})
+	gc := NewGarbageCollector(kubeClient, &fakeSnapshotDataLister{}, backend, Config{}).(*garbageCollector)
+
+	if err := gc.collect(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sort.Strings(backend.deleted)
+	expectDeleted := []string{"snapshot/snap-other", "volume/pvc-orphan", "volume/pvc-other"}
+	if !reflect.DeepEqual(backend.deleted, expectDeleted) {
+		t.Errorf("Expected deleted %v, got %v", expectDeleted, backend.deleted)
+	}
+}
+
+func TestMayaBackendListsByNamespace(t *testing.T) {
+	var namespaces []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespaces = append(namespaces, r.URL.Path+" "+r.Header.Get("namespace"))
+		fmt.Fprint(w, `{"items":[{"metadata":{"name":"pvc-1","namespace":"team-a"}}]}`)
+	}))
+	defer ts.Close()
+	os.Setenv("MAPI_ADDR", ts.URL)
+	defer os.Unsetenv("MAPI_ADDR")
+
+	backend := NewMayaBackend()
+	volumes, err := backend.ListVolumes("team-a")
+	if err != nil || len(volumes) != 1 || volumes[0].Name != "pvc-1" {
+		t.Errorf("Unexpected volumes %v: %v", volumes, err)
+	}
+	if _, err := backend.ListSnapshots("team-a"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expect := []string{"/latest/volumes/ team-a", "/latest/snapshots/ team-a"}
+	if !reflect.DeepEqual(namespaces, expect) {
+		t.Errorf("Expected requests %v, got %v", expect, namespaces)
+	}
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	RegisterMetrics(registry)
+	backend := &fakeBackend{volumes: []v1alpha1.CASVolume{newCASVolume("pvc-orphan", "claim-deleted")}}
+	gc := NewGarbageCollector(fake.NewSimpleClientset(defaultNamespace), &fakeSnapshotDataLister{}, backend, Config{}).(*garbageCollector)
+	if err := gc.collect(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "openebs_garbage_collector_orphans" {
+			return
+		}
+	}
+	t.Errorf("Expected the orphans metric to be registered, got %v", families)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package garbagecollector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "openebs"
+	metricsSubsystem = "garbage_collector"
+
+	// kindLabel is the label of the metrics holding the kind of the
+	// orphaned resource, i.e. volume or snapshot
+	kindLabel = "kind"
+)
+
+var (
+	orphans = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "orphans",
+		Help:      "Number of orphaned resources found in maya-apiserver by the last run.",
+	}, []string{kindLabel})
+
+	deletedOrphans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "deleted_orphans_total",
+		Help:      "Number of orphaned resources deleted from maya-apiserver.",
+	}, []string{kindLabel})
+
+	deleteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "delete_errors_total",
+		Help:      "Number of failed deletions of orphaned resources.",
+	}, []string{kindLabel})
+
+	runErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "run_errors_total",
+		Help:      "Number of runs skipped because listing resources failed.",
+	})
+)
+
+// RegisterMetrics registers the metrics of the garbage collector and of the
+// final snapshot expirer on the registerer, the one whose metrics are served
+func RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(orphans, deletedOrphans, deleteErrors, runErrors)
+}
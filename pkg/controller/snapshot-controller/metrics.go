@@ -0,0 +1,55 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+)
+
+const (
+	metricsNamespace = "openebs"
+	metricsSubsystem = "snapshot_controller"
+)
+
+var (
+	cachedSnapshots = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_snapshots",
+		Help:      "Number of VolumeSnapshots in the desired and actual states of world, by cache, i.e. desired or actual.",
+	}, []string{"cache"})
+)
+
+func init() {
+	prometheus.MustRegister(cachedSnapshots)
+}
+
+// updateMetrics sets the metrics of the caches and of the VolumeSnapshots
+func (c *snapshotController) updateMetrics() {
+	cachedSnapshots.WithLabelValues("desired").Set(float64(len(c.desiredStateOfWorld.GetSnapshots())))
+	cachedSnapshots.WithLabelValues("actual").Set(float64(len(c.actualStateOfWorld.GetSnapshots())))
+
+	var snapshots []*crdv1.VolumeSnapshot
+	for _, obj := range c.snapshotStore.List() {
+		if snapshot, ok := obj.(*crdv1.VolumeSnapshot); ok {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	c.snapshotter.ObserveSnapshots(snapshots)
+}
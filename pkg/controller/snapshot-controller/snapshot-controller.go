@@ -23,6 +23,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
@@ -50,11 +51,35 @@ const (
 	// time the DesiredStateOfWorldPopulator loop waits between list snapshots
 	// calls.
 	desiredStateOfWorldPopulatorListSnapshotsRetryDuration time.Duration = 3 * time.Minute
+
+	// snapshotExpiryLoopPeriod is the amount of time between two searches
+	// for the VolumeSnapshots past their expiry
+	snapshotExpiryLoopPeriod time.Duration = 1 * time.Minute
+
+	// metricsLoopPeriod is the amount of time between two updates of the
+	// metrics of the VolumeSnapshots
+	metricsLoopPeriod time.Duration = 30 * time.Second
 )
 
 // SnapshotController is a controller that handles snapshot operations
 type SnapshotController interface {
 	Run(stopCh <-chan struct{})
+	// Drain stops starting new snapshot operations and waits up to timeout
+	// for the running ones to finish, it returns false on timeout
+	Drain(timeout time.Duration) bool
+	// HasSynced returns true once the VolumeSnapshots are listed
+	HasSynced() bool
+	// State returns the state of the controller, for debugging
+	State() State
+}
+
+// State is the state of the controller: the snapshots of the desired and
+// actual states of world by unique snapshot name, and the start time of the
+// running snapshot operations by operation name
+type State struct {
+	DesiredStateOfWorld map[string]*crdv1.VolumeSnapshot `json:"desiredStateOfWorld"`
+	ActualStateOfWorld  map[string]*crdv1.VolumeSnapshot `json:"actualStateOfWorld"`
+	RunningOperations   map[string]time.Time             `json:"runningOperations"`
 }
 
 type snapshotController struct {
@@ -95,8 +120,9 @@ type snapshotController struct {
 func NewSnapshotController(client *rest.RESTClient,
 	scheme *runtime.Scheme,
 	clientset kubernetes.Interface,
-	volumePlugins *map[string]volume.Plugin,
-	syncDuration time.Duration) SnapshotController {
+	volumePlugins *map[string]volume.PluginV2,
+	syncDuration time.Duration,
+	waitConfigs snapshotter.WaitConfigs) SnapshotController {
 
 	sc := &snapshotController{
 		snapshotClient: client,
@@ -141,7 +167,8 @@ func NewSnapshotController(client *rest.RESTClient,
 		scheme,
 		clientset,
 		sc.actualStateOfWorld,
-		volumePlugins)
+		volumePlugins,
+		waitConfigs)
 
 	sc.reconciler = reconciler.NewReconciler(
 		reconcilerLoopPeriod,
@@ -171,9 +198,64 @@ func (c *snapshotController) Run(ctx <-chan struct{}) {
 		return
 	}
 
+	go c.snapshotter.Run(ctx)
 	go c.reconciler.Run(ctx)
 	go c.desiredStateOfWorldPopulator.Run(ctx)
+	go wait.Until(c.expireSnapshots, snapshotExpiryLoopPeriod, ctx)
+	go wait.Until(c.updateMetrics, metricsLoopPeriod, ctx)
+
+}
+
+// Drain stops starting new snapshot operations and waits up to timeout for
+// the running ones to finish
+func (c *snapshotController) Drain(timeout time.Duration) bool {
+	glog.Infof("Draining snapshot controller")
+	return c.snapshotter.Drain(timeout)
+}
+
+// HasSynced returns true once the VolumeSnapshots are listed
+func (c *snapshotController) HasSynced() bool {
+	return c.snapshotController.HasSynced()
+}
+
+// State returns the state of the controller
+func (c *snapshotController) State() State {
+	return State{
+		DesiredStateOfWorld: c.desiredStateOfWorld.GetSnapshots(),
+		ActualStateOfWorld:  c.actualStateOfWorld.GetSnapshots(),
+		RunningOperations:   c.snapshotter.RunningOperations(),
+	}
+}
 
+// expireSnapshots deletes the VolumeSnapshots past their expiry, their
+// VolumeSnapshotData and backend snapshots are then deleted like the ones of
+// any deleted VolumeSnapshot
+func (c *snapshotController) expireSnapshots() {
+	now := time.Now()
+	for _, obj := range c.snapshotStore.List() {
+		snapshot, ok := obj.(*crdv1.VolumeSnapshot)
+		if !ok {
+			continue
+		}
+		if isExpired(snapshot, now) {
+			glog.Infof("[CONTROLLER] Snapshot %s/%s expired", snapshot.Metadata.Namespace, snapshot.Metadata.Name)
+			c.snapshotter.ExpireVolumeSnapshot(snapshot)
+		}
+	}
+}
+
+// isExpired returns true if the snapshot is past its expiry. A snapshot
+// under legal hold does not expire until the hold is removed.
+func isExpired(snapshot *crdv1.VolumeSnapshot, now time.Time) bool {
+	if snapshot.Metadata.DeletionTimestamp != nil || crdv1.IsLegalHold(&snapshot.Metadata) {
+		return false
+	}
+	expiry, ok, err := crdv1.GetSnapshotExpiry(&snapshot.Metadata)
+	if err != nil {
+		glog.Warningf("Snapshot %s/%s is kept: %v", snapshot.Metadata.Namespace, snapshot.Metadata.Name, err)
+		return false
+	}
+	return ok && !now.Before(expiry)
 }
 
 func (c *snapshotController) onSnapshotAdd(obj interface{}) {
@@ -188,6 +270,7 @@ func (c *snapshotController) onSnapshotAdd(obj interface{}) {
 
 	glog.Infof("[CONTROLLER] OnAdd %s, Snapshot %#v", snapshot.Metadata.SelfLink, snapshot)
 	c.desiredStateOfWorld.AddSnapshot(snapshot)
+	c.snapshotter.SyncLegalHold(snapshot)
 }
 
 func (c *snapshotController) onSnapshotUpdate(oldObj, newObj interface{}) {
@@ -198,6 +281,12 @@ func (c *snapshotController) onSnapshotUpdate(oldObj, newObj interface{}) {
 	if oldSnapshot.Spec.SnapshotDataName != newSnapshot.Spec.SnapshotDataName {
 		c.desiredStateOfWorld.AddSnapshot(newSnapshot)
 	}
+	// a held snapshot being deleted stays in the DesiredStateOfWorld until
+	// the hold is removed and the finalizer with it
+	c.snapshotter.SyncLegalHold(newSnapshot)
+	if newSnapshot.Metadata.DeletionTimestamp != nil {
+		c.snapshotter.CancelVolumeSnapshot(newSnapshot)
+	}
 }
 
 func (c *snapshotController) onSnapshotDelete(obj interface{}) {
@@ -220,5 +309,6 @@ func (c *snapshotController) onSnapshotDelete(obj interface{}) {
 	snapshot := deletedSnapshot.DeepCopy()
 	glog.Infof("[CONTROLLER] OnDelete %s, snapshot name: %s/%s\n", snapshot.Metadata.SelfLink, snapshot.Metadata.Namespace, snapshot.Metadata.Name)
 	c.desiredStateOfWorld.DeleteSnapshot(cache.MakeSnapshotName(snapshot))
+	c.snapshotter.CancelVolumeSnapshot(snapshot)
 
 }
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+)
+
+func TestIsExpired(t *testing.T) {
+	now := time.Now()
+	deleted := metav1.NewTime(now)
+	tests := map[string]struct {
+		annotations map[string]string
+		deletion    *metav1.Time
+		expired     bool
+	}{
+		"no expiry": {},
+		"not yet expired": {
+			annotations: map[string]string{crdv1.SnapshotExpiryAnnotation: "2h"},
+		},
+		"expired duration": {
+			annotations: map[string]string{crdv1.SnapshotExpiryAnnotation: "30m"},
+			expired:     true,
+		},
+		"expired time": {
+			annotations: map[string]string{crdv1.SnapshotExpiryAnnotation: now.Add(-time.Minute).UTC().Format(time.RFC3339)},
+			expired:     true,
+		},
+		"invalid expiry": {
+			annotations: map[string]string{crdv1.SnapshotExpiryAnnotation: "soon"},
+		},
+		"legal hold": {
+			annotations: map[string]string{crdv1.SnapshotExpiryAnnotation: "30m", crdv1.LegalHoldAnnotation: "true"},
+		},
+		"being deleted": {
+			annotations: map[string]string{crdv1.SnapshotExpiryAnnotation: "30m"},
+			deletion:    &deleted,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			snapshot := &crdv1.VolumeSnapshot{Metadata: metav1.ObjectMeta{
+				Annotations:       test.annotations,
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Hour)),
+				DeletionTimestamp: test.deletion,
+			}}
+			if expired := isExpired(snapshot, now); expired != test.expired {
+				t.Errorf("Expected expired %v, got %v", test.expired, expired)
+			}
+		})
+	}
+}
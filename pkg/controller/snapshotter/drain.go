@@ -0,0 +1,214 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshotter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/kubernetes/pkg/util/goroutinemap"
+	"k8s.io/kubernetes/pkg/util/goroutinemap/exponentialbackoff"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/tracing"
+)
+
+// errDraining is returned by startOperation once the snapshotter drains
+var errDraining = errors.New("the snapshotter is shutting down")
+
+// startOperation runs the operation through runningOperation unless the
+// snapshotter is draining, and logs why it was not started
+func (vs *volumeSnapshotter) startOperation(operationName string, operation func(ctx context.Context) error) {
+	vs.drainLock.RLock()
+	err := errDraining
+	if !vs.draining {
+		err = vs.runningOperation.Run(operationName, vs.trackOperation(operationName, operation))
+	}
+	vs.drainLock.RUnlock()
+
+	if err != nil {
+		switch {
+		case err == errDraining:
+			glog.V(4).Infof("operation %q not started: %v", operationName, err)
+		case goroutinemap.IsAlreadyExists(err):
+			glog.V(4).Infof("operation %q is already running, skipping", operationName)
+		case exponentialbackoff.IsExponentialBackoff(err):
+			glog.V(4).Infof("operation %q postponed due to exponential backoff", operationName)
+			operationBackoffs.WithLabelValues(operationKind(operationName)).Inc()
+		default:
+			glog.Errorf("Failed to schedule the operation %q: %v", operationName, err)
+		}
+	}
+}
+
+// trackOperation wraps the operation to record it in the running operations
+// while it runs, to count it as a retry if its last run failed, and to run it
+// in a span whose context is passed to the operation
+func (vs *volumeSnapshotter) trackOperation(operationName string, operation func(ctx context.Context) error) func() error {
+	return func() error {
+		vs.operationsLock.Lock()
+		vs.operations[operationName] = time.Now()
+		if vs.failed[operationName] {
+			operationRetries.WithLabelValues(operationKind(operationName)).Inc()
+		}
+		vs.operationsLock.Unlock()
+
+		ctx, span := tracing.Start(context.Background(), "snapshot."+operationKind(operationName),
+			attribute.String("operation", operationName))
+		err := operation(ctx)
+		tracing.End(span, err)
+
+		vs.operationsLock.Lock()
+		delete(vs.operations, operationName)
+		if err != nil {
+			vs.failed[operationName] = true
+		} else {
+			delete(vs.failed, operationName)
+		}
+		vs.operationsLock.Unlock()
+		return err
+	}
+}
+
+// RunningOperations returns the start time of the running operations by
+// operation name
+func (vs *volumeSnapshotter) RunningOperations() map[string]time.Time {
+	vs.operationsLock.Lock()
+	defer vs.operationsLock.Unlock()
+	operations := make(map[string]time.Time, len(vs.operations))
+	for name, start := range vs.operations {
+		operations[name] = start
+	}
+	return operations
+}
+
+// Drain stops starting new operations, cancels the waits for the snapshots
+// to become ready, and waits up to timeout for the running operations to
+// finish. It returns false if some operations are still running after the
+// timeout, the progress markers of their snapshots let them resume after a
+// restart.
+func (vs *volumeSnapshotter) Drain(timeout time.Duration) bool {
+	vs.drainLock.Lock()
+	if !vs.draining {
+		vs.draining = true
+		// a single waiter, runningOperation signals only one
+		vs.drained = make(chan struct{})
+		go func() {
+			vs.runningOperation.WaitForCompletion()
+			close(vs.drained)
+		}()
+	}
+	vs.drainLock.Unlock()
+	// a wait is resumed from the Pending status of the snapshot
+	vs.cancel()
+
+	select {
+	case <-vs.drained:
+		glog.Infof("All the snapshot operations finished")
+		return true
+	case <-time.After(timeout):
+		glog.Warningf("Snapshot operations still running after %v, they are resumed after the restart", timeout)
+		return false
+	}
+}
+
+// setSnapshotProgress records the progress of the creation of the snapshot
+// in its annotations, source is the snapshot taken in the backend if any
+func (vs *volumeSnapshotter) setSnapshotProgress(snapshot *crdv1.VolumeSnapshot, progress string, source *crdv1.VolumeSnapshotDataSource) error {
+	var snapshotObj crdv1.VolumeSnapshot
+	err := vs.restClient.Get().
+		Name(snapshot.Metadata.Name).
+		Resource(crdv1.VolumeSnapshotResourcePlural).
+		Namespace(snapshot.Metadata.Namespace).
+		Do(context.TODO()).Into(&snapshotObj)
+	if err != nil {
+		return fmt.Errorf("Error retrieving VolumeSnapshot %s from API server: %v", snapshot.Metadata.Name, err)
+	}
+	snapshotCopy := snapshotObj.DeepCopy()
+	if err := setProgressAnnotations(&snapshotCopy.Metadata.Annotations, progress, source); err != nil {
+		return err
+	}
+	var result crdv1.VolumeSnapshot
+	return vs.restClient.Put().
+		Name(snapshot.Metadata.Name).
+		Resource(crdv1.VolumeSnapshotResourcePlural).
+		Namespace(snapshot.Metadata.Namespace).
+		Body(snapshotCopy).
+		Do(context.TODO()).Into(&result)
+}
+
+// setProgressAnnotations sets the progress annotations, the source
+// annotation is removed if source is nil
+func setProgressAnnotations(annotations *map[string]string, progress string, source *crdv1.VolumeSnapshotDataSource) error {
+	if *annotations == nil {
+		*annotations = map[string]string{}
+	}
+	(*annotations)[crdv1.SnapshotProgressAnnotation] = progress
+	if source == nil {
+		delete(*annotations, crdv1.SnapshotSourceAnnotation)
+		return nil
+	}
+	data, err := json.Marshal(source)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot source: %v", err)
+	}
+	(*annotations)[crdv1.SnapshotSourceAnnotation] = string(data)
+	return nil
+}
+
+// takenSnapshotSource returns the source of the snapshot the backend took
+// before the creation of the snapshot was interrupted, nil if there is none
+func takenSnapshotSource(snapshot *crdv1.VolumeSnapshot) *crdv1.VolumeSnapshotDataSource {
+	if snapshot.Metadata.Annotations[crdv1.SnapshotProgressAnnotation] != crdv1.SnapshotProgressTaken {
+		return nil
+	}
+	var source crdv1.VolumeSnapshotDataSource
+	if err := json.Unmarshal([]byte(snapshot.Metadata.Annotations[crdv1.SnapshotSourceAnnotation]), &source); err != nil {
+		glog.Warningf("Ignoring invalid %s of snapshot %s: %v", crdv1.SnapshotSourceAnnotation, snapshot.Metadata.Name, err)
+		return nil
+	}
+	return &source
+}
+
+// resumeDeletions deletes the VolumeSnapshotData, and their snapshots, whose
+// deletion was interrupted
+func (vs *volumeSnapshotter) resumeDeletions() {
+	var snapshotDataList crdv1.VolumeSnapshotDataList
+	err := vs.restClient.Get().
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Do(context.TODO()).Into(&snapshotDataList)
+	if err != nil {
+		glog.Errorf("Error retrieving the VolumeSnapshotData objects from API server: %v", err)
+		return
+	}
+	for i := range snapshotDataList.Items {
+		snapshotDataObj := &snapshotDataList.Items[i]
+		if snapshotDataObj.Metadata.Annotations[crdv1.SnapshotProgressAnnotation] != crdv1.SnapshotProgressDeleting {
+			continue
+		}
+		snapshotDataName := snapshotDataObj.Metadata.Name
+		glog.Infof("Resuming the deletion of VolumeSnapshotData %s", snapshotDataName)
+		vs.startOperation(snapshotOpDeletePrefix+snapshotDataName, func(ctx context.Context) error {
+			return vs.deleteSnapshotData(ctx, snapshotDataObj)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package snapshotter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/controller/cache"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+func newTestSnapshotter(t *testing.T, server *fakeAPIServer, plugin volume.Plugin) *volumeSnapshotter {
+	return newTestSnapshotterV2(t, server, volume.AdaptPlugin(plugin))
+}
+
+func newTestSnapshotterV2(t *testing.T, server *fakeAPIServer, plugin volume.PluginV2) *volumeSnapshotter {
+	scheme, client, err := fakeSchemeAndClient(server.roundTrip)
+	if err != nil {
+		t.Fatalf("Failed to create test client: %v", err)
+	}
+	plugins := map[string]volume.PluginV2{"hostPath": plugin}
+	return NewVolumeSnapshotter(client, scheme, fake.NewSimpleClientset(fakePV()), cache.NewActualStateOfWorld(), &plugins,
+		WaitConfigs{Default: DefaultWaitConfig}).(*volumeSnapshotter)
+}
+
+func TestDrain(t *testing.T) {
+	vs := newTestSnapshotter(t, &fakeAPIServer{}, &TestPlugin{})
+
+	release := make(chan struct{})
+	vs.startOperation("running", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	if vs.Drain(10 * time.Millisecond) {
+		t.Errorf("Expected the drain to time out while an operation runs")
+	}
+	if _, ok := vs.RunningOperations()["running"]; !ok {
+		t.Errorf("Expected the operation to be listed as running, got %v", vs.RunningOperations())
+	}
+
+	started := false
+	vs.startOperation("new", func(ctx context.Context) error {
+		started = true
+		return nil
+	})
+	close(release)
+	if !vs.Drain(5 * time.Second) {
+		t.Errorf("Expected the drain to finish once the operation finishes")
+	}
+	if started {
+		t.Errorf("Expected no operation to start while draining")
+	}
+	if operations := vs.RunningOperations(); len(operations) != 0 {
+		t.Errorf("Expected no running operation, got %v", operations)
+	}
+}
+
+func Test_updateSnapshotIfExistsResumesTakenSnapshot(t *testing.T) {
+	tp := &TestPluginV2{SupportedCalls: volume.AllCapabilities}
+	snapshot := fakeNewVolumeSnapshot()
+	snapshot.Metadata.UID = "uid-1"
+	snapshot.Metadata.Labels = map[string]string{snapshotMetadataTimeStamp: "1", pvNameLabel: "fake-pv-1"}
+	source := &crdv1.VolumeSnapshotDataSource{HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: "/fake/file"}}
+	if err := setProgressAnnotations(&snapshot.Metadata.Annotations, crdv1.SnapshotProgressTaken, source); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	snapshotData := fakeVolumeSnapshotDataList().Items[0]
+	snapshotData.Spec.VolumeSnapshotRef = nil
+	server := &fakeAPIServer{snapshot: snapshot.DeepCopy(), snapshotData: &snapshotData}
+	vs := newTestSnapshotterV2(t, server, tp)
+
+	status, _, err := vs.updateSnapshotIfExists(context.Background(), cache.MakeSnapshotName(snapshot), snapshot)
+	if err != nil || status != statusPending {
+		t.Fatalf("Expected the taken snapshot to be resumed as pending, got %s: %v", status, err)
+	}
+	if tp.FindCallCount != 0 || tp.CreateCallCount != 0 {
+		t.Errorf("Expected the taken snapshot to be used, got %d finds and %d creates", tp.FindCallCount, tp.CreateCallCount)
+	}
+	annotations := server.snapshot.Metadata.Annotations
+	if annotations[crdv1.SnapshotProgressAnnotation] != crdv1.SnapshotProgressBound {
+		t.Errorf("Expected progress %s, got %q", crdv1.SnapshotProgressBound, annotations[crdv1.SnapshotProgressAnnotation])
+	}
+	if _, ok := annotations[crdv1.SnapshotSourceAnnotation]; ok {
+		t.Errorf("Expected the source annotation to be removed once bound")
+	}
+}
+
+func Test_resumeDeletions(t *testing.T) {
+	tp := &TestPlugin{ShouldFail: true}
+	snapshotData := fakeVolumeSnapshotDataList().Items[0]
+	server := &fakeAPIServer{snapshot: fakeNewVolumeSnapshot(), snapshotData: &snapshotData}
+	vs := newTestSnapshotter(t, server, tp)
+
+	// the backend fails the deletion, the VolumeSnapshotData stays marked
+	if err := vs.deleteSnapshotData(context.Background(), snapshotData.DeepCopy()); err == nil {
+		t.Fatalf("Expected the deletion to fail")
+	}
+	if progress := server.snapshotData.Metadata.Annotations[crdv1.SnapshotProgressAnnotation]; progress != crdv1.SnapshotProgressDeleting {
+		t.Fatalf("Expected progress %s, got %q", crdv1.SnapshotProgressDeleting, progress)
+	}
+
+	// after a restart
+	tp.ShouldFail = false
+	vs = newTestSnapshotter(t, server, tp)
+	vs.resumeDeletions()
+	vs.runningOperation.WaitForCompletion()
+	if tp.DeleteCallCount != 2 {
+		t.Errorf("Expected the backend snapshot deletion to be resumed, got %d SnapshotDelete calls", tp.DeleteCallCount)
+	}
+	if len(server.deletes) != 1 {
+		t.Errorf("Expected the VolumeSnapshotData to be deleted, got %v", server.deletes)
+	}
+}
+
+func Test_updateVolumeSnapshotMetadataProgress(t *testing.T) {
+	snapshot := fakeNewVolumeSnapshot()
+	snapshot.Metadata.CreationTimestamp = metav1.Now()
+	server := &fakeAPIServer{snapshot: snapshot.DeepCopy()}
+	vs := newTestSnapshotter(t, server, &TestPlugin{})
+
+	if _, err := vs.updateVolumeSnapshotMetadata(snapshot, "fake-pv-1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if progress := server.snapshot.Metadata.Annotations[crdv1.SnapshotProgressAnnotation]; progress != crdv1.SnapshotProgressTaking {
+		t.Errorf("Expected progress %s, got %q", crdv1.SnapshotProgressTaking, progress)
+	}
+}
+
+func Test_trackOperationTracesPluginCalls(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	vs := newTestSnapshotter(t, &fakeAPIServer{}, &TestPlugin{})
+	snapDataList := fakeVolumeSnapshotDataList()
+	pv := fakePV()
+	pv.Name = snapDataList.Items[0].Spec.PersistentVolumeRef.Name
+	vs.coreClient.CoreV1().PersistentVolumes().Create(context.TODO(), pv, metav1.CreateOptions{})
+
+	operationName := snapshotOpDeletePrefix + "default/new-snapshot-test-1"
+	err := vs.trackOperation(operationName, func(ctx context.Context) error {
+		return vs.deleteSnapshot(ctx, &snapDataList.Items[0].Spec)
+	})()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected the plugin and the operation spans, got %d spans", len(spans))
+	}
+	plugin, operation := spans[0], spans[1]
+	if plugin.Name != "plugin."+volume.OperationDelete || operation.Name != "snapshot.delete" {
+		t.Errorf("Expected spans plugin.delete and snapshot.delete, got %s and %s", plugin.Name, operation.Name)
+	}
+	if plugin.Parent.SpanID() != operation.SpanContext.SpanID() {
+		t.Errorf("Expected the plugin span to be a child of the operation span")
+	}
+}
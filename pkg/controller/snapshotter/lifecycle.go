@@ -0,0 +1,161 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/controller/cache"
+)
+
+const (
+	snapshotOpLegalHoldPrefix string = "legal-hold"
+	snapshotOpExpirePrefix    string = "expire"
+)
+
+// legalHoldInSync returns true if the finalizer and the status of the
+// snapshot match its legal hold annotation. The VolumeSnapshotData of a held
+// snapshot may still lag behind, a held snapshot is never in sync.
+func legalHoldInSync(snapshot *crdv1.VolumeSnapshot) bool {
+	if crdv1.IsLegalHold(&snapshot.Metadata) {
+		return false
+	}
+	return !crdv1.HasFinalizer(&snapshot.Metadata, crdv1.LegalHoldFinalizer) && !snapshot.Status.LegalHold
+}
+
+// SyncLegalHold sets, or clears, the legal hold finalizer and status of the
+// snapshot and its VolumeSnapshotData according to the legal hold
+// annotation of the snapshot
+func (vs *volumeSnapshotter) SyncLegalHold(snapshot *crdv1.VolumeSnapshot) {
+	if legalHoldInSync(snapshot) {
+		return
+	}
+	snapshotName := cache.MakeSnapshotName(snapshot)
+	operationName := snapshotOpLegalHoldPrefix + snapshotName
+	glog.V(4).Infof("Snapshotter is about to sync the legal hold of volume snapshot operation named %s", operationName)
+
+	vs.startOperation(operationName, vs.getLegalHoldSyncFunc(snapshotName, snapshot))
+}
+
+func (vs *volumeSnapshotter) getLegalHoldSyncFunc(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) func(ctx context.Context) error {
+	// Sync the legal hold of a snapshot
+	// 1. Get a fresh copy of the Snapshot, the annotation may have changed
+	// 2. Update the SnapshotData of the Snapshot, if any
+	// 3. Update the Snapshot, a released Snapshot loses its finalizer last
+	//    so its deletion does not race with the release of its SnapshotData
+	return func(ctx context.Context) error {
+		var snapshotObj crdv1.VolumeSnapshot
+		err := vs.restClient.Get().
+			Name(snapshot.Metadata.Name).
+			Resource(crdv1.VolumeSnapshotResourcePlural).
+			Namespace(snapshot.Metadata.Namespace).
+			Do(context.TODO()).Into(&snapshotObj)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error retrieving VolumeSnapshot %s from API server: %v", uniqueSnapshotName, err)
+		}
+		held := crdv1.IsLegalHold(&snapshotObj.Metadata)
+
+		if snapshotDataName := snapshotObj.Spec.SnapshotDataName; snapshotDataName != "" {
+			err = vs.setSnapshotDataLegalHold(snapshotDataName, held)
+			if err != nil {
+				return fmt.Errorf("Failed to sync the legal hold of VolumeSnapshotData %s: %v", snapshotDataName, err)
+			}
+		}
+
+		snapshotCopy := snapshotObj.DeepCopy()
+		changed := crdv1.SetFinalizer(&snapshotCopy.Metadata, crdv1.LegalHoldFinalizer, held)
+		if snapshotCopy.Status.LegalHold != held {
+			snapshotCopy.Status.LegalHold = held
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		var result crdv1.VolumeSnapshot
+		err = vs.restClient.Put().
+			Name(snapshot.Metadata.Name).
+			Resource(crdv1.VolumeSnapshotResourcePlural).
+			Namespace(snapshot.Metadata.Namespace).
+			Body(snapshotCopy).
+			Do(context.TODO()).Into(&result)
+		if err != nil {
+			return fmt.Errorf("Error updating snapshot object %s on the API server: %v", uniqueSnapshotName, err)
+		}
+		glog.Infof("Legal hold of VolumeSnapshot %s set to %v", uniqueSnapshotName, held)
+		return nil
+	}
+}
+
+// setSnapshotDataLegalHold sets, or clears, the legal hold finalizer and
+// status of the VolumeSnapshotData. A VolumeSnapshotData annotated with its
+// own legal hold keeps it when its VolumeSnapshot is released.
+func (vs *volumeSnapshotter) setSnapshotDataLegalHold(snapshotDataName string, held bool) error {
+	var snapshotDataObj crdv1.VolumeSnapshotData
+	err := vs.restClient.Get().
+		Name(snapshotDataName).
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Do(context.TODO()).Into(&snapshotDataObj)
+	if err != nil {
+		return err
+	}
+	held = held || crdv1.IsLegalHold(&snapshotDataObj.Metadata)
+
+	changed := crdv1.SetFinalizer(&snapshotDataObj.Metadata, crdv1.LegalHoldFinalizer, held)
+	if snapshotDataObj.Status.LegalHold != held {
+		snapshotDataObj.Status.LegalHold = held
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	var result crdv1.VolumeSnapshotData
+	return vs.restClient.Put().
+		Name(snapshotDataName).
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Body(&snapshotDataObj).
+		Do(context.TODO()).Into(&result)
+}
+
+// ExpireVolumeSnapshot deletes the expired snapshot from the API server, the
+// controller then deletes its VolumeSnapshotData and the backend snapshot
+// like for any deleted snapshot
+func (vs *volumeSnapshotter) ExpireVolumeSnapshot(snapshot *crdv1.VolumeSnapshot) {
+	snapshotName := cache.MakeSnapshotName(snapshot)
+	operationName := snapshotOpExpirePrefix + snapshotName
+	glog.V(4).Infof("Snapshotter is about to expire volume snapshot operation named %s", operationName)
+
+	vs.startOperation(operationName, func(ctx context.Context) error {
+		err := vs.restClient.Delete().
+			Name(snapshot.Metadata.Name).
+			Resource(crdv1.VolumeSnapshotResourcePlural).
+			Namespace(snapshot.Metadata.Namespace).
+			Do(context.TODO()).Error()
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("Failed to delete expired VolumeSnapshot %s from API server: %v", snapshotName, err)
+		}
+		glog.Infof("Expired VolumeSnapshot %s deleted", snapshotName)
+		return nil
+	})
+}
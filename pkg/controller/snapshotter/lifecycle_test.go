@@ -0,0 +1,125 @@
+package snapshotter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/controller/cache"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+// fakeAPIServer serves a VolumeSnapshot and a VolumeSnapshotData and
+// records the objects put back and deleted
+type fakeAPIServer struct {
+	snapshot     *crdv1.VolumeSnapshot
+	snapshotData *crdv1.VolumeSnapshotData
+	puts         []string
+	deletes      []string
+}
+
+func (s *fakeAPIServer) roundTrip(req *http.Request) (*http.Response, error) {
+	var obj runtime.Object = s.snapshot
+	if strings.Contains(req.URL.Path, crdv1.VolumeSnapshotDataResourcePlural) {
+		obj = s.snapshotData
+	}
+	switch {
+	case req.Method == http.MethodPut:
+		if err := json.NewDecoder(req.Body).Decode(obj); err != nil {
+			return nil, err
+		}
+		s.puts = append(s.puts, req.URL.Path)
+	case req.Method == http.MethodDelete:
+		s.deletes = append(s.deletes, req.URL.Path)
+		obj = &metav1.Status{Status: metav1.StatusSuccess}
+	case strings.HasSuffix(req.URL.Path, "/"+crdv1.VolumeSnapshotDataResourcePlural):
+		obj = &crdv1.VolumeSnapshotDataList{Items: []crdv1.VolumeSnapshotData{*s.snapshotData}}
+	}
+	header := http.Header{}
+	header.Set("Content-Type", runtime.ContentTypeJSON)
+	return &http.Response{StatusCode: http.StatusOK, Header: header, Body: objBody(obj)}, nil
+}
+
+func newHeldSnapshot(annotations map[string]string, finalizers []string) *crdv1.VolumeSnapshot {
+	snapshot := fakeNewVolumeSnapshot()
+	snapshot.Metadata.Annotations = annotations
+	snapshot.Metadata.Finalizers = finalizers
+	snapshot.Spec.SnapshotDataName = "snapshotdata-test-1"
+	return snapshot
+}
+
+func Test_getLegalHoldSyncFunc(t *testing.T) {
+	hold := map[string]string{crdv1.LegalHoldAnnotation: "true"}
+	finalizers := []string{crdv1.LegalHoldFinalizer}
+	tests := map[string]struct {
+		snapshot *crdv1.VolumeSnapshot
+		// wasHeld is the legal hold status before the sync
+		wasHeld bool
+		held    bool
+		puts    int
+	}{
+		"hold set":      {snapshot: newHeldSnapshot(hold, nil), held: true, puts: 2},
+		"hold removed":  {snapshot: newHeldSnapshot(nil, finalizers), wasHeld: true, puts: 2},
+		"hold in place": {snapshot: newHeldSnapshot(hold, finalizers), wasHeld: true, held: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			snapshotData := fakeVolumeSnapshotDataList().Items[0]
+			if test.wasHeld {
+				snapshotData.Metadata.Finalizers = finalizers
+				snapshotData.Status.LegalHold = true
+				test.snapshot.Status.LegalHold = true
+			}
+			server := &fakeAPIServer{snapshot: test.snapshot, snapshotData: &snapshotData}
+			scheme, client, err := fakeSchemeAndClient(server.roundTrip)
+			if err != nil {
+				t.Fatalf("Failed to create test client: %v", err)
+			}
+			plugins := map[string]volume.PluginV2{}
+			vs := NewVolumeSnapshotter(client, scheme, fake.NewSimpleClientset(), cache.NewActualStateOfWorld(), &plugins, WaitConfigs{Default: DefaultWaitConfig}).(*volumeSnapshotter)
+
+			if err := vs.getLegalHoldSyncFunc("default/new-snapshot-test-1", test.snapshot.DeepCopy())(context.Background()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(server.puts) != test.puts {
+				t.Errorf("Expected %d updates, got %v", test.puts, server.puts)
+			}
+			for _, meta := range []*metav1.ObjectMeta{&server.snapshot.Metadata, &server.snapshotData.Metadata} {
+				if crdv1.HasFinalizer(meta, crdv1.LegalHoldFinalizer) != test.held {
+					t.Errorf("Expected %s to have the finalizer %v, got %v", meta.Name, test.held, meta.Finalizers)
+				}
+			}
+			if server.snapshot.Status.LegalHold != test.held || server.snapshotData.Status.LegalHold != test.held {
+				t.Errorf("Expected legal hold status %v, got %v and %v", test.held, server.snapshot.Status.LegalHold, server.snapshotData.Status.LegalHold)
+			}
+		})
+	}
+}
+
+func Test_getSnapshotDeleteFuncLegalHold(t *testing.T) {
+	tp := &TestPlugin{}
+	snapshotData := fakeVolumeSnapshotDataList().Items[0]
+	snapshotData.Metadata.Finalizers = []string{crdv1.LegalHoldFinalizer}
+	server := &fakeAPIServer{snapshot: fakeNewVolumeSnapshot(), snapshotData: &snapshotData}
+	scheme, client, err := fakeSchemeAndClient(server.roundTrip)
+	if err != nil {
+		t.Fatalf("Failed to create test client: %v", err)
+	}
+	plugins := map[string]volume.PluginV2{"hostPath": volume.AdaptPlugin(tp)}
+	vs := NewVolumeSnapshotter(client, scheme, fake.NewSimpleClientset(fakePV()), cache.NewActualStateOfWorld(), &plugins, WaitConfigs{Default: DefaultWaitConfig}).(*volumeSnapshotter)
+
+	snapshot := newHeldSnapshot(nil, nil)
+	if err := vs.getSnapshotDeleteFunc("default/new-snapshot-test-1", snapshot)(context.Background()); err == nil {
+		t.Errorf("Expected the deletion of a held snapshot to fail")
+	}
+	if tp.DeleteCallCount != 0 {
+		t.Errorf("Expected the backend snapshot to be kept, got %d SnapshotDelete calls", tp.DeleteCallCount)
+	}
+}
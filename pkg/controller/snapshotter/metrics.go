@@ -0,0 +1,114 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshotter
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+)
+
+const (
+	metricsNamespace = "openebs"
+	metricsSubsystem = "snapshot_controller"
+)
+
+var (
+	snapshotsByStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "snapshots",
+		Help:      "Number of VolumeSnapshots by status, i.e. new, pending, ready or error.",
+	}, []string{"status"})
+
+	oldestPendingSnapshotAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "oldest_pending_snapshot_age_seconds",
+		Help:      "Age of the oldest pending VolumeSnapshot, 0 if none is pending.",
+	})
+
+	operationBackoffs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "operation_backoffs_total",
+		Help:      "Number of snapshot operations postponed because they failed recently, by operation.",
+	}, []string{"operation"})
+
+	operationRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "operation_retries_total",
+		Help:      "Number of snapshot operations started again after they failed, by operation.",
+	}, []string{"operation"})
+
+	// operationPrefixes are the prefixes of the operation names, the
+	// operation label of the metrics
+	operationPrefixes = []string{
+		snapshotOpCreatePrefix,
+		snapshotOpDeletePrefix,
+		snapshotOpPromotePrefix,
+		snapshotOpLegalHoldPrefix,
+		snapshotOpExpirePrefix,
+	}
+)
+
+func init() {
+	prometheus.MustRegister(snapshotsByStatus, oldestPendingSnapshotAge, operationBackoffs, operationRetries)
+}
+
+// operationKind returns the kind of the operation of the name, i.e. the
+// prefix of the name
+func operationKind(operationName string) string {
+	for _, prefix := range operationPrefixes {
+		if strings.HasPrefix(operationName, prefix) {
+			return prefix
+		}
+	}
+	return "unknown"
+}
+
+// ObserveSnapshots sets the metrics of the VolumeSnapshots: their number by
+// status and the age of the oldest pending one
+func (vs *volumeSnapshotter) ObserveSnapshots(snapshots []*crdv1.VolumeSnapshot) {
+	counts := map[string]int{statusNew: 0, statusPending: 0, statusReady: 0, statusError: 0}
+	var oldestPending time.Time
+	for _, snapshot := range snapshots {
+		// a snapshot without conditions is new, getSimplifiedSnapshotStatus
+		// logs it as an error
+		status := statusNew
+		if len(snapshot.Status.Conditions) != 0 {
+			status = vs.getSimplifiedSnapshotStatus(snapshot.Status.Conditions)
+		}
+		counts[status]++
+		created := snapshot.Metadata.CreationTimestamp.Time
+		if status == statusPending && (oldestPending.IsZero() || created.Before(oldestPending)) {
+			oldestPending = created
+		}
+	}
+	for status, count := range counts {
+		snapshotsByStatus.WithLabelValues(status).Set(float64(count))
+	}
+	if oldestPending.IsZero() {
+		oldestPendingSnapshotAge.Set(0)
+		return
+	}
+	oldestPendingSnapshotAge.Set(time.Since(oldestPending).Seconds())
+}
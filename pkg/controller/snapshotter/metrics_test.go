@@ -0,0 +1,85 @@
+package snapshotter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+)
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	var metric dto.Metric
+	if err := gauge.Write(&metric); err != nil {
+		t.Fatalf("Failed to read the metric: %v", err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func newStatusSnapshot(age time.Duration, conditionType crdv1.VolumeSnapshotConditionType) *crdv1.VolumeSnapshot {
+	snapshot := &crdv1.VolumeSnapshot{
+		Metadata: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(time.Now().Add(-age))},
+	}
+	if conditionType != "" {
+		snapshot.Status.Conditions = []crdv1.VolumeSnapshotCondition{{Type: conditionType, Status: v1.ConditionTrue}}
+	}
+	return snapshot
+}
+
+func TestObserveSnapshots(t *testing.T) {
+	tests := map[string]struct {
+		snapshots     []*crdv1.VolumeSnapshot
+		expectCounts  map[string]float64
+		expectMinAge  time.Duration
+		expectNoOlder time.Duration
+	}{
+		"no snapshot": {
+			expectCounts: map[string]float64{statusNew: 0, statusPending: 0, statusReady: 0, statusError: 0},
+		},
+		"snapshots of every status": {
+			snapshots: []*crdv1.VolumeSnapshot{
+				newStatusSnapshot(time.Hour, ""),
+				newStatusSnapshot(10*time.Minute, crdv1.VolumeSnapshotConditionPending),
+				newStatusSnapshot(time.Minute, crdv1.VolumeSnapshotConditionPending),
+				newStatusSnapshot(2*time.Hour, crdv1.VolumeSnapshotConditionReady),
+				newStatusSnapshot(3*time.Hour, crdv1.VolumeSnapshotConditionError),
+			},
+			expectCounts:  map[string]float64{statusNew: 1, statusPending: 2, statusReady: 1, statusError: 1},
+			expectMinAge:  10 * time.Minute,
+			expectNoOlder: 11 * time.Minute,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			vs := &volumeSnapshotter{}
+			vs.ObserveSnapshots(test.snapshots)
+			for status, count := range test.expectCounts {
+				if value := gaugeValue(t, snapshotsByStatus.WithLabelValues(status)); value != count {
+					t.Errorf("Expected %v %s snapshots, got %v", count, status, value)
+				}
+			}
+			age := time.Duration(gaugeValue(t, oldestPendingSnapshotAge) * float64(time.Second))
+			if age < test.expectMinAge || age > test.expectNoOlder {
+				t.Errorf("Expected the oldest pending snapshot age within [%v, %v], got %v", test.expectMinAge, test.expectNoOlder, age)
+			}
+		})
+	}
+}
+
+func Test_operationKind(t *testing.T) {
+	tests := map[string]string{
+		snapshotOpCreatePrefix + "default/snapshot-uid" + "claim": snapshotOpCreatePrefix,
+		snapshotOpDeletePrefix + "snapshotdata-1":                 snapshotOpDeletePrefix,
+		snapshotOpLegalHoldPrefix + "default/snapshot-uid":        snapshotOpLegalHoldPrefix,
+		"resize": "unknown",
+	}
+	for name, expected := range tests {
+		if kind := operationKind(name); kind != expected {
+			t.Errorf("Expected kind %s of operation %s, got %s", expected, name, kind)
+		}
+	}
+}
@@ -19,14 +19,18 @@ package snapshotter
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"go.opentelemetry.io/otel/trace"
 
 	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/controller/cache"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/uuid"
@@ -34,7 +38,6 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/kubernetes/pkg/util/goroutinemap"
-	"k8s.io/kubernetes/pkg/util/goroutinemap/exponentialbackoff"
 )
 
 const (
@@ -44,13 +47,18 @@ const (
 	pvNameLabel                      = "pvName"
 	defaultExponentialBackOffOnError = true
 
-	// volumeSnapshot* is configuration of exponential backoff for
-	// waiting for snapshot operation to complete. Starting with 2
-	// seconds, multiplying by 1.5 with each step and taking 20 steps at maximum.
-	// It will time out after 20 steps (6650 seconds).
+	// volumeSnapshot* is the default configuration of exponential backoff
+	// for waiting for snapshot operation to complete, see DefaultWaitConfig.
+	// Starting with 2 seconds, multiplying by 1.5 with each step up to 5
+	// minutes between two steps. It will time out 2 hours after the creation
+	// of the snapshot.
 	volumeSnapshotInitialDelay = 2 * time.Second
 	volumeSnapshotFactor       = 1.5
-	volumeSnapshotSteps        = 20
+	volumeSnapshotMaxDelay     = 5 * time.Minute
+	volumeSnapshotTimeout      = 2 * time.Hour
+	// volumeSnapshotSteps bounds the retries of the creation of the
+	// VolumeSnapshotData
+	volumeSnapshotSteps = 20
 )
 
 // VolumeSnapshotter does the "heavy lifting": it spawns goroutines that talk to the
@@ -61,6 +69,16 @@ type VolumeSnapshotter interface {
 	CreateVolumeSnapshot(snapshot *crdv1.VolumeSnapshot)
 	DeleteVolumeSnapshot(snapshot *crdv1.VolumeSnapshot)
 	PromoteVolumeSnapshotToPV(snapshot *crdv1.VolumeSnapshot)
+	SyncLegalHold(snapshot *crdv1.VolumeSnapshot)
+	ExpireVolumeSnapshot(snapshot *crdv1.VolumeSnapshot)
+	CancelVolumeSnapshot(snapshot *crdv1.VolumeSnapshot)
+	Run(stopCh <-chan struct{})
+	Drain(timeout time.Duration) bool
+	// RunningOperations returns the start time of the running operations by
+	// operation name
+	RunningOperations() map[string]time.Time
+	// ObserveSnapshots sets the metrics of the VolumeSnapshots
+	ObserveSnapshots(snapshots []*crdv1.VolumeSnapshot)
 	//UpdateVolumeSnapshot(snapshotName string, status *[]crdv1.VolumeSnapshotCondition) (*crdv1.VolumeSnapshot, error)
 	//UpdateVolumeSnapshotData(snapshotDataName string, status *[]crdv1.VolumeSnapshotDataCondition) error
 }
@@ -71,7 +89,28 @@ type volumeSnapshotter struct {
 	scheme             *runtime.Scheme
 	actualStateOfWorld cache.ActualStateOfWorld
 	runningOperation   goroutinemap.GoRoutineMap
-	volumePlugins      *map[string]volume.Plugin
+	volumePlugins      *map[string]volume.PluginV2
+	waitConfigs        WaitConfigs
+
+	// ctx is canceled when the snapshotter stops, waits holds the cancel
+	// functions of the running waits for snapshots by snapshot name
+	ctx       context.Context
+	cancel    context.CancelFunc
+	waitsLock sync.Mutex
+	waits     map[string]context.CancelFunc
+
+	// draining is set once the snapshotter stops starting new operations,
+	// drained is closed once the running operations finish
+	drainLock sync.RWMutex
+	draining  bool
+	drained   chan struct{}
+
+	// operations holds the start time of the running operations by
+	// operation name, failed the names of the operations whose last run
+	// failed
+	operationsLock sync.Mutex
+	operations     map[string]time.Time
+	failed         map[string]bool
 }
 
 const (
@@ -103,7 +142,9 @@ func NewVolumeSnapshotter(
 	scheme *runtime.Scheme,
 	clientset kubernetes.Interface,
 	asw cache.ActualStateOfWorld,
-	volumePlugins *map[string]volume.Plugin) VolumeSnapshotter {
+	volumePlugins *map[string]volume.PluginV2,
+	waitConfigs WaitConfigs) VolumeSnapshotter {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &volumeSnapshotter{
 		restClient:         restClient,
 		coreClient:         clientset,
@@ -111,6 +152,12 @@ func NewVolumeSnapshotter(
 		actualStateOfWorld: asw,
 		runningOperation:   goroutinemap.NewGoRoutineMap(defaultExponentialBackOffOnError),
 		volumePlugins:      volumePlugins,
+		waitConfigs:        waitConfigs,
+		ctx:                ctx,
+		cancel:             cancel,
+		waits:              map[string]context.CancelFunc{},
+		operations:         map[string]time.Time{},
+		failed:             map[string]bool{},
 	}
 }
 
@@ -199,7 +246,7 @@ func (vs *volumeSnapshotter) getSnapshotDataFromSnapshot(snapshot *crdv1.VolumeS
 
 // Query status of the snapshot from plugin and update the status of VolumeSnapshot and VolumeSnapshotData
 // if needed. Finish waiting when the snapshot becomes available/ready or error.
-func (vs *volumeSnapshotter) waitForSnapshot(uniqueSnapshotName string, snapshotObj *crdv1.VolumeSnapshot, snapshotDataObj *crdv1.VolumeSnapshotData) error {
+func (vs *volumeSnapshotter) waitForSnapshot(ctx context.Context, uniqueSnapshotName string, snapshotObj *crdv1.VolumeSnapshot, snapshotDataObj *crdv1.VolumeSnapshotData) error {
 	glog.Infof("In waitForSnapshot: snapshot %s snapshot data %s", uniqueSnapshotName, snapshotObj.Spec.SnapshotDataName)
 	if snapshotDataObj == nil {
 		return fmt.Errorf("Failed to update VolumeSnapshot for snapshot %s: no VolumeSnapshotData", uniqueSnapshotName)
@@ -215,24 +262,44 @@ func (vs *volumeSnapshotter) waitForSnapshot(uniqueSnapshotName string, snapshot
 		return fmt.Errorf("%s is not supported volume for %#v", volumeType, spec)
 	}
 
-	backoff := wait.Backoff{
-		Duration: volumeSnapshotInitialDelay,
-		Factor:   volumeSnapshotFactor,
-		Steps:    volumeSnapshotSteps,
+	// A plugin that can not describe its snapshots returns their final
+	// conditions from SnapshotCreate
+	if !plugin.Capabilities().DescribeSnapshot {
+		if vs.getSimplifiedSnapshotStatus(snapshotObj.Status.Conditions) != statusReady {
+			return fmt.Errorf("snapshot %s is not ready and %s can not describe it", uniqueSnapshotName, volumeType)
+		}
+		glog.Infof("waitForSnapshot: Snapshot %s created successfully. Adding it to Actual State of World.", uniqueSnapshotName)
+		vs.actualStateOfWorld.AddSnapshot(snapshotObj)
+		return nil
 	}
+
+	config := vs.waitConfig(volumeType, snapshotObj)
+	waitCtx, canceled, done := vs.startWait(uniqueSnapshotName, snapshotObj, config.Timeout)
+	defer done()
+	// The wait is canceled with the controller, not the operation, but its
+	// describe calls are spans of the operation
+	waitCtx = trace.ContextWithSpan(waitCtx, trace.SpanFromContext(ctx))
 	// Wait until the snapshot is successfully created by the plugin or an error occurs that
 	// fails the snapshot creation.
-	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
-		conditions, _, err := plugin.DescribeSnapshot(snapshotDataObj)
+	err := pollWithBackoff(waitCtx, config, func() (bool, error) {
+		describeCtx, describeDone := volume.StartOperation(waitCtx, volumeType, volume.OperationDescribe)
+		result, err := plugin.DescribeSnapshot(describeCtx, snapshotDataObj)
+		describeDone(err)
+		if volume.IsNotFound(err) {
+			return true, fmt.Errorf("snapshot %s not found: %v", uniqueSnapshotName, err)
+		}
 		if err != nil {
 			glog.Warningf("failed to get snapshot %v, err: %v", uniqueSnapshotName, err)
 			//continue waiting
 			return false, nil
 		}
+		if len(result.Conditions) == 0 {
+			glog.Warningf("no condition returned for snapshot %v", uniqueSnapshotName)
+			return false, nil
+		}
 
-		newstatus := vs.getSimplifiedSnapshotStatus(*conditions)
-		condition := *conditions
-		lastCondition := condition[len(condition)-1]
+		newstatus := vs.getSimplifiedSnapshotStatus(result.Conditions)
+		lastCondition := result.Conditions[len(result.Conditions)-1]
 		newSnapshot, err := vs.UpdateVolumeSnapshotStatus(snapshotObj, &lastCondition)
 		if err != nil {
 			glog.Errorf("Error updating volume snapshot %s: %v", uniqueSnapshotName, err)
@@ -250,39 +317,75 @@ func (vs *volumeSnapshotter) waitForSnapshot(uniqueSnapshotName string, snapshot
 		return false, nil
 	})
 
-	return err
+	switch {
+	case err == nil || waitCtx.Err() == nil:
+		return err
+	case canceled():
+		// the snapshot is deleted, or the controller stops. The snapshot of
+		// a deleted VolumeSnapshot is deleted like any taken snapshot.
+		if vs.ctx.Err() == nil {
+			vs.actualStateOfWorld.AddSnapshot(snapshotObj)
+		}
+		return fmt.Errorf("wait for snapshot %s canceled", uniqueSnapshotName)
+	}
+	message := fmt.Sprintf("snapshot %s is not ready after the %v wait timeout of the %s volume plugin", uniqueSnapshotName, config.Timeout, volumeType)
+	glog.Errorf("waitForSnapshot: %s", message)
+	condition := &crdv1.VolumeSnapshotCondition{
+		Type:               crdv1.VolumeSnapshotConditionError,
+		Status:             v1.ConditionTrue,
+		Reason:             crdv1.VolumeSnapshotReasonTimeout,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+	if _, updateErr := vs.UpdateVolumeSnapshotStatus(snapshotObj, condition); updateErr != nil {
+		glog.Errorf("waitForSnapshot: Error updating the status of volume snapshot %s: %v", uniqueSnapshotName, updateErr)
+	}
+	return fmt.Errorf("%s", message)
 }
 
 // This is the function responsible for determining the correct volume plugin to use,
 // asking it to make a snapshot and assigning it some name that it returns to the caller.
 func (vs *volumeSnapshotter) takeSnapshot(
+	ctx context.Context,
 	snapshot *crdv1.VolumeSnapshot,
 	pv *v1.PersistentVolume,
 	tags *map[string]string,
 ) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
 	spec := &pv.Spec
-	volumeType := crdv1.GetSupportedVolumeFromPVSpec(spec)
-	if len(volumeType) == 0 {
-		return nil, nil, fmt.Errorf("unsupported volume type found in PV %#v", spec)
-	}
-	plugin, ok := (*vs.volumePlugins)[volumeType]
-	if !ok {
-		return nil, nil, fmt.Errorf("%s is not supported volume for %#v", volumeType, spec)
+	plugin, err := vs.getPluginForPV(pv)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	snapDataSource, snapConditions, err := plugin.SnapshotCreate(snapshot, pv, tags)
+	ctx, done := volume.StartOperation(ctx, crdv1.GetSupportedVolumeFromPV(pv), volume.OperationCreate)
+	result, err := plugin.SnapshotCreate(ctx, snapshot, pv, *tags)
+	done(err)
 	if err != nil {
 		glog.Warningf("failed to snapshot %#v, err: %v", spec, err)
-	} else {
-		glog.Infof("snapshot created: %v. Conditions: %#v", snapDataSource, snapConditions)
-		return snapDataSource, snapConditions, nil
+		return nil, nil, nil
 	}
-	return nil, nil, nil
+	glog.Infof("snapshot created: %v. Conditions: %#v", result.Source, result.Conditions)
+	snapDataSource, snapConditions := snapshotResultRefs(result)
+	return snapDataSource, snapConditions, nil
+}
+
+// snapshotResultRefs returns the source and the conditions of the result,
+// nil if they are empty
+func snapshotResultRefs(result volume.SnapshotResult) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition) {
+	var snapDataSource *crdv1.VolumeSnapshotDataSource
+	if !reflect.DeepEqual(result.Source, crdv1.VolumeSnapshotDataSource{}) {
+		snapDataSource = &result.Source
+	}
+	var snapConditions *[]crdv1.VolumeSnapshotCondition
+	if len(result.Conditions) != 0 {
+		snapConditions = &result.Conditions
+	}
+	return snapDataSource, snapConditions
 }
 
 // This is the function responsible for determining the correct volume plugin to use,
 // asking it to make a snapshot and assigning it some name that it returns to the caller.
-func (vs *volumeSnapshotter) deleteSnapshot(spec *crdv1.VolumeSnapshotDataSpec) error {
+func (vs *volumeSnapshotter) deleteSnapshot(ctx context.Context, spec *crdv1.VolumeSnapshotDataSpec) error {
 	volumeType := crdv1.GetSupportedVolumeFromSnapshotDataSpec(spec)
 	if len(volumeType) == 0 {
 		return fmt.Errorf("unsupported volume type found in VolumeSnapshotData %#v", spec)
@@ -292,11 +395,20 @@ func (vs *volumeSnapshotter) deleteSnapshot(spec *crdv1.VolumeSnapshotDataSpec)
 		return fmt.Errorf("%s is not supported volume for %#v", volumeType, spec)
 	}
 	source := spec.VolumeSnapshotDataSource
+	// The PV may be gone, e.g. the one of a final snapshot, the plugin is
+	// then passed none
 	pv, err := vs.getPVFromName(spec.PersistentVolumeRef.Name)
 	if err != nil {
 		glog.Warningf("failed to retrieve PV %s from the API server: %q", spec.PersistentVolumeRef.Name, err)
+		pv = nil
+	}
+	ctx, done := volume.StartOperation(ctx, volumeType, volume.OperationDelete)
+	err = plugin.SnapshotDelete(ctx, &source, pv)
+	done(err)
+	if volume.IsNotFound(err) {
+		glog.Infof("snapshot %#v not found, it is deleted already", source)
+		return nil
 	}
-	err = plugin.SnapshotDelete(&source, pv)
 	if err != nil {
 		return fmt.Errorf("failed to delete snapshot %#v, err: %v", source, err)
 	}
@@ -345,25 +457,22 @@ func (vs *volumeSnapshotter) findVolumeSnapshotMetadata(snapshot *crdv1.VolumeSn
 	return &tags
 }
 
-func (vs *volumeSnapshotter) getPlugin(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) (*volume.Plugin, error) {
-	pv, err := vs.getPVFromVolumeSnapshot(uniqueSnapshotName, snapshot)
-	if err != nil {
-		return nil, err
-	}
-	spec := &pv.Spec
-	volumeType := crdv1.GetSupportedVolumeFromPVSpec(spec)
+// getPluginForPV returns the volume plugin snapshotting the PV, the error is
+// an unsupported error if there is none
+func (vs *volumeSnapshotter) getPluginForPV(pv *v1.PersistentVolume) (volume.PluginV2, error) {
+	volumeType := crdv1.GetSupportedVolumeFromPV(pv)
 	if len(volumeType) == 0 {
-		return nil, fmt.Errorf("Unsupported volume type found in PV %#v", spec)
+		return nil, volume.NewUnsupportedError("no volume plugin supports PV %s provisioned by %q", pv.Name, pv.Annotations[crdv1.ProvisionedByAnnotation])
 	}
 	plugin, ok := (*vs.volumePlugins)[volumeType]
 	if !ok {
-		return nil, fmt.Errorf("%s is not supported volume for %#v", volumeType, spec)
+		return nil, volume.NewUnsupportedError("%s volume plugin of PV %s is not registered", volumeType, pv.Name)
 	}
-	return &plugin, nil
+	return plugin, nil
 }
 
 // Exame the given snapshot in detail and then return the status
-func (vs *volumeSnapshotter) updateSnapshotIfExists(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) (string, *crdv1.VolumeSnapshot, error) {
+func (vs *volumeSnapshotter) updateSnapshotIfExists(ctx context.Context, uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) (string, *crdv1.VolumeSnapshot, error) {
 	snapshotName := snapshot.Metadata.Name
 	var snapshotDataObj *crdv1.VolumeSnapshotData
 	var snapshotDataSource *crdv1.VolumeSnapshotDataSource
@@ -386,10 +495,22 @@ func (vs *volumeSnapshotter) updateSnapshotIfExists(uniqueSnapshotName string, s
 		}
 		return statusPending, snapshotObj, nil
 	}
-	// Find snapshot through cloud provider by existing tags, and create VolumeSnapshotData if such snapshot is found
-	snapshotDataSource, conditions, err = vs.findSnapshotByTags(snapshotName, snapshot)
-	if err != nil {
-		return statusNew, snapshot, nil
+	// Use the snapshot the backend took before the creation was interrupted, or
+	// find snapshot through cloud provider by existing tags, and create VolumeSnapshotData if such snapshot is found
+	if snapshotDataSource = takenSnapshotSource(snapshot); snapshotDataSource != nil {
+		glog.Infof("updateSnapshotIfExists: resuming the creation of snapshot %s taken by the backend", uniqueSnapshotName)
+	} else {
+		snapshotDataSource, conditions, err = vs.findSnapshotByTags(ctx, snapshotName, snapshot)
+		if volume.IsTransient(err) {
+			// the snapshot may exist, do not take it twice
+			return statusError, snapshot, err
+		}
+		if err != nil {
+			if snapshot.Metadata.Annotations[crdv1.SnapshotProgressAnnotation] == crdv1.SnapshotProgressTaking {
+				glog.Warningf("updateSnapshotIfExists: the creation of snapshot %s was interrupted while the backend was taking it, it is taken again", uniqueSnapshotName)
+			}
+			return statusNew, snapshot, nil
+		}
 	}
 	// Snapshot is found. Create VolumeSnapshotData, bind VolumeSnapshotData to VolumeSnapshot, and update VolumeSnapshot status
 	glog.Infof("updateSnapshotIfExists: create VolumeSnapshotData object for VolumeSnapshot %s.", uniqueSnapshotName)
@@ -411,15 +532,15 @@ func (vs *volumeSnapshotter) updateSnapshotIfExists(uniqueSnapshotName string, s
 
 // Below are the closures meant to build the functions for the GoRoutineMap operations.
 // syncSnapshot is the main controller method to decide what to do to create a snapshot.
-func (vs *volumeSnapshotter) syncSnapshot(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) func() error {
-	return func() error {
+func (vs *volumeSnapshotter) syncSnapshot(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
 		snapshotObj := snapshot
 		status := vs.getSimplifiedSnapshotStatus(snapshot.Status.Conditions)
 		var err error
 		// When the condition is new, it is still possible that snapshot is already triggered but has not yet updated the condition.
 		// Check the metadata and avaiable VolumeSnapshotData objects and update the snapshot accordingly
 		if status == statusNew {
-			status, snapshotObj, err = vs.updateSnapshotIfExists(uniqueSnapshotName, snapshot)
+			status, snapshotObj, err = vs.updateSnapshotIfExists(ctx, uniqueSnapshotName, snapshot)
 			if err != nil {
 				glog.Errorf("updateSnapshotIfExists has error %v", err)
 			}
@@ -440,7 +561,7 @@ func (vs *volumeSnapshotter) syncSnapshot(uniqueSnapshotName string, snapshot *c
 			if err != nil {
 				return fmt.Errorf("Failed to find snapshot %v", err)
 			}
-			err = vs.waitForSnapshot(uniqueSnapshotName, snapshotObj, snapshotDataObj)
+			err = vs.waitForSnapshot(ctx, uniqueSnapshotName, snapshotObj, snapshotDataObj)
 			if err != nil {
 				return fmt.Errorf("Failed to check snapshot state %s with error %v", uniqueSnapshotName, err)
 			}
@@ -448,32 +569,44 @@ func (vs *volumeSnapshotter) syncSnapshot(uniqueSnapshotName string, snapshot *c
 			return nil
 		case statusNew:
 			glog.Infof("syncSnapshot: Creating snapshot %s ...", uniqueSnapshotName)
-			err = vs.createSnapshot(uniqueSnapshotName, snapshotObj)
+			err = vs.createSnapshot(ctx, uniqueSnapshotName, snapshotObj)
 			return err
 		}
 		return fmt.Errorf("Error occurred when creating snapshot %s, unknown status %s", uniqueSnapshotName, status)
 	}
 }
 
-func (vs *volumeSnapshotter) findSnapshotByTags(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+func (vs *volumeSnapshotter) findSnapshotByTags(ctx context.Context, uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
 	glog.Infof("findSnapshot: snapshot %s", uniqueSnapshotName)
-	var snapshotDataSource *crdv1.VolumeSnapshotDataSource
-	var conditions *[]crdv1.VolumeSnapshotCondition
 	tags := vs.findVolumeSnapshotMetadata(snapshot)
 	if tags != nil {
-		plugin, err := vs.getPlugin(uniqueSnapshotName, snapshot)
+		pv, err := vs.getPVFromVolumeSnapshot(uniqueSnapshotName, snapshot)
+		if err != nil {
+			return nil, nil, err
+		}
+		plugin, err := vs.getPluginForPV(pv)
 		if err != nil {
 			glog.Errorf("Failed to get volume plugin. %v", err)
 			return nil, nil, fmt.Errorf("Failed to get volume plugin to create snapshot %s", uniqueSnapshotName)
 		}
+		if !plugin.Capabilities().FindSnapshot {
+			glog.V(4).Infof("findSnapshot: volume plugin can not find snapshot %s by tags, skipping", uniqueSnapshotName)
+			return nil, nil, volume.NewUnsupportedError("volume plugin can not find snapshot %s by tags", uniqueSnapshotName)
+		}
 		// Check whether the real snapshot is already created by the plugin
 		glog.Infof("findSnapshot: find snapshot %s by tags %v.", uniqueSnapshotName, tags)
-		snapshotDataSource, conditions, err = (*plugin).FindSnapshot(tags)
-		if err == nil {
-			glog.Infof("findSnapshot: found snapshot %s.", uniqueSnapshotName)
-			return snapshotDataSource, conditions, nil
+		ctx, done := volume.StartOperation(ctx, crdv1.GetSupportedVolumeFromPV(pv), volume.OperationFind)
+		result, err := plugin.FindSnapshot(ctx, *tags)
+		done(err)
+		if err != nil {
+			return nil, nil, err
 		}
-		return nil, nil, err
+		snapshotDataSource, conditions := snapshotResultRefs(result)
+		if snapshotDataSource == nil {
+			return nil, nil, volume.NewNotFoundError("volume plugin found no source for snapshot %s", uniqueSnapshotName)
+		}
+		glog.Infof("findSnapshot: found snapshot %s.", uniqueSnapshotName)
+		return snapshotDataSource, conditions, nil
 	}
 	return nil, nil, fmt.Errorf("No metadata found in snapshot %s", uniqueSnapshotName)
 }
@@ -484,7 +617,7 @@ func (vs *volumeSnapshotter) findSnapshotByTags(uniqueSnapshotName string, snaps
 // 3. Create the VolumeSnapshotData object with the snapshot id information returned from step 2.
 // 4. Bind the VolumeSnapshot and VolumeSnapshotData object
 // 5. Query the snapshot status through cloud provider and update the status until snapshot is ready or fails.
-func (vs *volumeSnapshotter) createSnapshot(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) error {
+func (vs *volumeSnapshotter) createSnapshot(ctx context.Context, uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) error {
 	var snapshotDataSource *crdv1.VolumeSnapshotDataSource
 	var snapStatus *[]crdv1.VolumeSnapshotCondition
 	var err error
@@ -494,6 +627,20 @@ func (vs *volumeSnapshotter) createSnapshot(uniqueSnapshotName string, snapshot
 	if err != nil {
 		return err
 	}
+	if _, err = vs.getPluginForPV(pv); err != nil {
+		glog.Errorf("createSnapshot: Can not snapshot %s: %v", uniqueSnapshotName, err)
+		condition := &crdv1.VolumeSnapshotCondition{
+			Type:               crdv1.VolumeSnapshotConditionError,
+			Status:             v1.ConditionTrue,
+			Reason:             crdv1.VolumeSnapshotReasonUnsupported,
+			Message:            err.Error(),
+			LastTransitionTime: metav1.Now(),
+		}
+		if _, updateErr := vs.UpdateVolumeSnapshotStatus(snapshot, condition); updateErr != nil {
+			glog.Errorf("createSnapshot: Error updating the status of volume snapshot %s: %v", uniqueSnapshotName, updateErr)
+		}
+		return fmt.Errorf("Failed to take snapshot of the volume %s: %v", pv.Name, err)
+	}
 
 	glog.Infof("createSnapshot: Creating metadata for snapshot %s.", uniqueSnapshotName)
 	tags, err = vs.updateVolumeSnapshotMetadata(snapshot, pv.Name)
@@ -501,10 +648,13 @@ func (vs *volumeSnapshotter) createSnapshot(uniqueSnapshotName string, snapshot
 		return fmt.Errorf("Failed to update metadata for volume snapshot %s: %q", uniqueSnapshotName, err)
 	}
 
-	snapshotDataSource, snapStatus, err = vs.takeSnapshot(snapshot, pv, tags)
+	snapshotDataSource, snapStatus, err = vs.takeSnapshot(ctx, snapshot, pv, tags)
 	if err != nil || snapshotDataSource == nil {
 		return fmt.Errorf("Failed to take snapshot of the volume %s: %q", pv.Name, err)
 	}
+	if err = vs.setSnapshotProgress(snapshot, crdv1.SnapshotProgressTaken, snapshotDataSource); err != nil {
+		glog.Warningf("createSnapshot: Failed to record the progress of snapshot %s: %v", uniqueSnapshotName, err)
+	}
 
 	glog.Infof("createSnapshot: create VolumeSnapshotData object for VolumeSnapshot %s.", uniqueSnapshotName)
 	snapshotDataObj, err := vs.createVolumeSnapshotData(uniqueSnapshotName, pv.Name, snapshotDataSource, snapStatus)
@@ -520,7 +670,7 @@ func (vs *volumeSnapshotter) createSnapshot(uniqueSnapshotName string, snapshot
 	}
 
 	// Waiting for snapshot to be ready
-	err = vs.waitForSnapshot(uniqueSnapshotName, snapshotObj, snapshotDataObj)
+	err = vs.waitForSnapshot(ctx, uniqueSnapshotName, snapshotObj, snapshotDataObj)
 	if err != nil {
 		return fmt.Errorf("Failed to create snapshot %s with error %v", uniqueSnapshotName, err)
 	}
@@ -593,51 +743,82 @@ func (vs *volumeSnapshotter) createVolumeSnapshotData(uniqueSnapshotName, pvName
 	return &result, nil
 }
 
-func (vs *volumeSnapshotter) getSnapshotDeleteFunc(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) func() error {
+func (vs *volumeSnapshotter) getSnapshotDeleteFunc(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) func(ctx context.Context) error {
 	// Delete a snapshot
 	// 1. Find the SnapshotData corresponding to Snapshot
 	//   1a: Not found => finish (it's been deleted already)
+	//   1b: Under legal hold => retry later
 	// 2. Ask the backend to remove the snapshot device
 	// 3. Delete the SnapshotData object
 	// 4. Remove the Snapshot from ActualStateOfWorld
 	// 5. Finish
-	return func() error {
+	return func(ctx context.Context) error {
 		// TODO: get VolumeSnapshotDataSource from associated VolumeSnapshotData
 		// then call volume delete snapshot method to delete the ot
 		snapshotDataObj, err := vs.getSnapshotDataFromSnapshot(snapshot)
 		if err != nil {
 			return fmt.Errorf("Error getting VolumeSnapshotData for VolumeSnapshot %s with error %v", uniqueSnapshotName, err)
 		}
+		if crdv1.HasFinalizer(&snapshotDataObj.Metadata, crdv1.LegalHoldFinalizer) || crdv1.IsLegalHold(&snapshotDataObj.Metadata) {
+			return fmt.Errorf("VolumeSnapshotData %s of VolumeSnapshot %s is under legal hold", snapshotDataObj.Metadata.Name, uniqueSnapshotName)
+		}
 
-		err = vs.deleteSnapshot(&snapshotDataObj.Spec)
+		err = vs.deleteSnapshotData(ctx, snapshotDataObj)
 		if err != nil {
 			return fmt.Errorf("Failed to delete snapshot %s: %q", uniqueSnapshotName, err)
 		}
 
-		snapshotDataName := snapshotDataObj.Metadata.Name
-		var result metav1.Status
-		err = vs.restClient.Delete().
+		vs.actualStateOfWorld.DeleteSnapshot(uniqueSnapshotName)
+
+		return nil
+	}
+}
+
+// deleteSnapshotData deletes the snapshot of the VolumeSnapshotData in the
+// backend and then the VolumeSnapshotData. The VolumeSnapshotData is marked
+// first so that the deletion resumes after a restart.
+func (vs *volumeSnapshotter) deleteSnapshotData(ctx context.Context, snapshotDataObj *crdv1.VolumeSnapshotData) error {
+	snapshotDataName := snapshotDataObj.Metadata.Name
+	if snapshotDataObj.Metadata.Annotations[crdv1.SnapshotProgressAnnotation] != crdv1.SnapshotProgressDeleting {
+		snapshotDataCopy := snapshotDataObj.DeepCopy()
+		if err := setProgressAnnotations(&snapshotDataCopy.Metadata.Annotations, crdv1.SnapshotProgressDeleting, nil); err != nil {
+			return err
+		}
+		var result crdv1.VolumeSnapshotData
+		err := vs.restClient.Put().
 			Name(snapshotDataName).
 			Resource(crdv1.VolumeSnapshotDataResourcePlural).
+			Body(snapshotDataCopy).
 			Do(context.TODO()).Into(&result)
 		if err != nil {
-			return fmt.Errorf("Failed to delete VolumeSnapshotData %s from API server: %q", snapshotDataName, err)
+			return fmt.Errorf("Failed to mark VolumeSnapshotData %s as being deleted: %v", snapshotDataName, err)
 		}
+	}
 
-		vs.actualStateOfWorld.DeleteSnapshot(uniqueSnapshotName)
+	err := vs.deleteSnapshot(ctx, &snapshotDataObj.Spec)
+	if err != nil {
+		return err
+	}
 
-		return nil
+	var result metav1.Status
+	err = vs.restClient.Delete().
+		Name(snapshotDataName).
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Do(context.TODO()).Into(&result)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("Failed to delete VolumeSnapshotData %s from API server: %q", snapshotDataName, err)
 	}
+	return nil
 }
 
-func (vs *volumeSnapshotter) getSnapshotPromoteFunc(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) func() error {
+func (vs *volumeSnapshotter) getSnapshotPromoteFunc(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot) func(ctx context.Context) error {
 	// Promote snapshot to a PVC
 	// 1. We have a PVC referencing a Snapshot object
 	// 2. Find the SnapshotData corresponding to tha Snapshot
 	// 3. Ask the backend to give us a device (PV) made from the snapshot device
 	// 4. Bind it to the PVC
 	// 5. Finish
-	return func() error { return nil }
+	return func(ctx context.Context) error { return nil }
 }
 
 func (vs *volumeSnapshotter) CreateVolumeSnapshot(snapshot *crdv1.VolumeSnapshot) {
@@ -645,18 +826,7 @@ func (vs *volumeSnapshotter) CreateVolumeSnapshot(snapshot *crdv1.VolumeSnapshot
 	operationName := snapshotOpCreatePrefix + snapshotName + snapshot.Spec.PersistentVolumeClaimName
 	//glog.Infof("Snapshotter is about to create volume snapshot operation named %s, spec %#v", operationName, snapshot.Spec)
 
-	err := vs.runningOperation.Run(operationName, vs.syncSnapshot(snapshotName, snapshot))
-
-	if err != nil {
-		switch {
-		case goroutinemap.IsAlreadyExists(err):
-			glog.V(4).Infof("operation %q is already running, skipping", operationName)
-		case exponentialbackoff.IsExponentialBackoff(err):
-			glog.V(4).Infof("operation %q postponed due to exponential backoff", operationName)
-		default:
-			glog.Errorf("Failed to schedule the operation %q: %v", operationName, err)
-		}
-	}
+	vs.startOperation(operationName, vs.syncSnapshot(snapshotName, snapshot))
 }
 
 func (vs *volumeSnapshotter) DeleteVolumeSnapshot(snapshot *crdv1.VolumeSnapshot) {
@@ -664,18 +834,7 @@ func (vs *volumeSnapshotter) DeleteVolumeSnapshot(snapshot *crdv1.VolumeSnapshot
 	operationName := snapshotOpDeletePrefix + snapshotName + snapshot.Spec.PersistentVolumeClaimName
 	glog.V(4).Infof("Snapshotter is about to delete volume snapshot operation named %s", operationName)
 
-	err := vs.runningOperation.Run(operationName, vs.getSnapshotDeleteFunc(snapshotName, snapshot))
-
-	if err != nil {
-		switch {
-		case goroutinemap.IsAlreadyExists(err):
-			glog.V(4).Infof("operation %q is already running, skipping", operationName)
-		case exponentialbackoff.IsExponentialBackoff(err):
-			glog.V(4).Infof("operation %q postponed due to exponential backoff", operationName)
-		default:
-			glog.Errorf("Failed to schedule the operation %q: %v", operationName, err)
-		}
-	}
+	vs.startOperation(operationName, vs.getSnapshotDeleteFunc(snapshotName, snapshot))
 }
 
 func (vs *volumeSnapshotter) PromoteVolumeSnapshotToPV(snapshot *crdv1.VolumeSnapshot) {
@@ -683,18 +842,7 @@ func (vs *volumeSnapshotter) PromoteVolumeSnapshotToPV(snapshot *crdv1.VolumeSna
 	operationName := snapshotOpPromotePrefix + snapshotName + snapshot.Spec.PersistentVolumeClaimName
 	glog.Infof("Snapshotter is about to create volume snapshot operation named %s", operationName)
 
-	err := vs.runningOperation.Run(operationName, vs.getSnapshotPromoteFunc(snapshotName, snapshot))
-
-	if err != nil {
-		switch {
-		case goroutinemap.IsAlreadyExists(err):
-			glog.V(4).Infof("operation %q is already running, skipping", operationName)
-		case exponentialbackoff.IsExponentialBackoff(err):
-			glog.V(4).Infof("operation %q postponed due to exponential backoff", operationName)
-		default:
-			glog.Errorf("Failed to schedule the operation %q: %v", operationName, err)
-		}
-	}
+	vs.startOperation(operationName, vs.getSnapshotPromoteFunc(snapshotName, snapshot))
 }
 
 // Update VolumeSnapshot object with current timestamp and associated PersistentVolume name in object's metadata
@@ -719,6 +867,11 @@ func (vs *volumeSnapshotter) updateVolumeSnapshotMetadata(snapshot *crdv1.Volume
 	}
 	snapshotCopy.Metadata.Labels[snapshotMetadataTimeStamp] = fmt.Sprintf("%d", time.Now().UnixNano())
 	snapshotCopy.Metadata.Labels[snapshotMetadataPVName] = pvName
+	// the backend may take the snapshot from now on, an interrupted creation
+	// looks for it before taking another one
+	if err := setProgressAnnotations(&snapshotCopy.Metadata.Annotations, crdv1.SnapshotProgressTaking, nil); err != nil {
+		return nil, err
+	}
 	glog.Infof("updateVolumeSnapshotMetadata: Metadata UID: %s Metadata Name: %s Metadata Namespace: %s Setting tags in Metadata Labels: %#v.",
 		snapshotCopy.Metadata.UID, snapshotCopy.Metadata.Name, snapshotCopy.Metadata.Namespace, snapshotCopy.Metadata.Labels)
 
@@ -875,6 +1028,9 @@ func (vs *volumeSnapshotter) bindandUpdateVolumeSnapshot(snapshot *crdv1.VolumeS
 	if status != nil {
 		snapshotCopy.Status.Conditions = *status
 	}
+	if err := setProgressAnnotations(&snapshotCopy.Metadata.Annotations, crdv1.SnapshotProgressBound, nil); err != nil {
+		return nil, err
+	}
 	glog.Infof("bindVolumeSnapshotDataToVolumeSnapshot: Updating VolumeSnapshot object [%#v]", snapshotCopy)
 	// TODO: Make diff of the two objects and then use restClient.Patch to update it
 	var result crdv1.VolumeSnapshot
@@ -85,6 +85,47 @@ func (tp *TestPlugin) VolumeDelete(pv *v1.PersistentVolume) error {
 	return nil
 }
 
+// TestPluginV2 is a PluginV2 finding the snapshot /fake/file, it supports
+// the calls of its SupportedCalls
+type TestPluginV2 struct {
+	SupportedCalls  volume.Capabilities
+	CreateCallCount int
+	FindCallCount   int
+}
+
+func (tp *TestPluginV2) Init(cloudprovider.Interface) {
+}
+
+func (tp *TestPluginV2) Capabilities() volume.Capabilities {
+	return tp.SupportedCalls
+}
+
+func (tp *TestPluginV2) SnapshotCreate(context.Context, *crdv1.VolumeSnapshot, *v1.PersistentVolume, map[string]string) (volume.SnapshotResult, error) {
+	tp.CreateCallCount = tp.CreateCallCount + 1
+	return volume.SnapshotResult{}, nil
+}
+
+func (tp *TestPluginV2) SnapshotDelete(context.Context, *crdv1.VolumeSnapshotDataSource, *v1.PersistentVolume) error {
+	return nil
+}
+
+func (tp *TestPluginV2) SnapshotRestore(context.Context, *crdv1.VolumeSnapshotData, *v1.PersistentVolumeClaim, string, map[string]string) (volume.RestoreResult, error) {
+	return volume.RestoreResult{}, nil
+}
+
+func (tp *TestPluginV2) DescribeSnapshot(context.Context, *crdv1.VolumeSnapshotData) (volume.DescribeResult, error) {
+	return volume.DescribeResult{Completed: true}, nil
+}
+
+func (tp *TestPluginV2) FindSnapshot(context.Context, map[string]string) (volume.SnapshotResult, error) {
+	tp.FindCallCount = tp.FindCallCount + 1
+	return volume.SnapshotResult{Source: crdv1.VolumeSnapshotDataSource{HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: "/fake/file"}}}, nil
+}
+
+func (tp *TestPluginV2) VolumeDelete(context.Context, *v1.PersistentVolume) error {
+	return nil
+}
+
 // Helper functions
 type roundTripperFunc func(*http.Request) (*http.Response, error)
 
@@ -274,13 +315,13 @@ func Test_NewVolumeSnapshotter(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset()
 	asw := cache.NewActualStateOfWorld()
-	plugins := map[string]volume.Plugin{"hostPath": tp}
+	plugins := map[string]volume.PluginV2{"hostPath": volume.AdaptPlugin(tp)}
 	scheme, client, err := fakeSchemeAndClient(dummyRoundTripper)
 	if err != nil {
 		t.Errorf("Failed to create test client: %v", err)
 	}
 
-	vs := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins)
+	vs := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins, WaitConfigs{Default: DefaultWaitConfig})
 	if vs == nil {
 		t.Errorf("Test failed: could not create volume snapshotter")
 	}
@@ -291,7 +332,7 @@ func Test_getSnapshotDataFromSnapshotName(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset()
 	asw := cache.NewActualStateOfWorld()
-	plugins := map[string]volume.Plugin{"hostPath": tp}
+	plugins := map[string]volume.PluginV2{"hostPath": volume.AdaptPlugin(tp)}
 	scheme, client, err := fakeSchemeAndClient(func(req *http.Request) (*http.Response, error) {
 		header := http.Header{}
 		header.Set("Content-Type", runtime.ContentTypeJSON)
@@ -305,7 +346,7 @@ func Test_getSnapshotDataFromSnapshotName(t *testing.T) {
 		t.Errorf("Failed to create test client: %v", err)
 	}
 
-	vsObj := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins)
+	vsObj := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins, WaitConfigs{Default: DefaultWaitConfig})
 	if vsObj == nil {
 		t.Errorf("Test failed: could not create volume snapshotter")
 	}
@@ -326,13 +367,13 @@ func Test_takeSnapshot(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset()
 	asw := cache.NewActualStateOfWorld()
-	plugins := map[string]volume.Plugin{"hostPath": tp}
+	plugins := map[string]volume.PluginV2{"hostPath": volume.AdaptPlugin(tp)}
 	scheme, client, err := fakeSchemeAndClient(dummyRoundTripper)
 	if err != nil {
 		t.Errorf("Failed to create test client: %v", err)
 	}
 
-	vsObj := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins)
+	vsObj := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins, WaitConfigs{Default: DefaultWaitConfig})
 	if vsObj == nil {
 		t.Errorf("Test failed: could not create volume snapshotter")
 	}
@@ -344,12 +385,12 @@ func Test_takeSnapshot(t *testing.T) {
 		"tag2": "tag value 2",
 	}
 	snapshot := fakeNewVolumeSnapshot()
-	_, _, err = vs.takeSnapshot(snapshot, pv, &tags)
+	_, _, err = vs.takeSnapshot(context.Background(), snapshot, pv, &tags)
 	if err != nil {
 		t.Errorf("Test failed, unexpected error: %v", err)
 	}
 	tp.ShouldFail = true
-	_, _, err = vs.takeSnapshot(snapshot, pv, &tags)
+	_, _, err = vs.takeSnapshot(context.Background(), snapshot, pv, &tags)
 	if err != nil {
 		t.Errorf("Test failed, unexpected error: %v", err)
 	}
@@ -357,6 +398,18 @@ func Test_takeSnapshot(t *testing.T) {
 	if tp.CreateCallCount != 2 {
 		t.Errorf("Test failed, expected 2 CreateSnapshot calls in plugin, got %d", tp.CreateCallCount)
 	}
+
+	// the iSCSI PVs of other provisioners are not snapshotted by openebs
+	iscsiPV := fakePV()
+	iscsiPV.Annotations = map[string]string{crdv1.ProvisionedByAnnotation: "array.example.com/iscsi"}
+	iscsiPV.Spec.PersistentVolumeSource = v1.PersistentVolumeSource{ISCSI: &v1.ISCSIPersistentVolumeSource{}}
+	_, _, err = vs.takeSnapshot(context.Background(), snapshot, iscsiPV, &tags)
+	if !volume.IsUnsupported(err) {
+		t.Errorf("Test failed, expected an unsupported error, got %v", err)
+	}
+	if tp.CreateCallCount != 2 {
+		t.Errorf("Test failed, expected no CreateSnapshot call for an unsupported PV, got %d", tp.CreateCallCount-2)
+	}
 }
 
 func Test_deleteSnapshot(t *testing.T) {
@@ -364,13 +417,13 @@ func Test_deleteSnapshot(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset()
 	asw := cache.NewActualStateOfWorld()
-	plugins := map[string]volume.Plugin{"hostPath": tp}
+	plugins := map[string]volume.PluginV2{"hostPath": volume.AdaptPlugin(tp)}
 	scheme, client, err := fakeSchemeAndClient(dummyRoundTripper)
 	if err != nil {
 		t.Errorf("Failed to create test client: %v", err)
 	}
 
-	vsObj := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins)
+	vsObj := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins, WaitConfigs{Default: DefaultWaitConfig})
 	if vsObj == nil {
 		t.Errorf("Test failed: could not create volume snapshotter")
 	}
@@ -381,12 +434,12 @@ func Test_deleteSnapshot(t *testing.T) {
 	pv := fakePV()
 	pv.Name = snapDataList.Items[0].Spec.PersistentVolumeRef.Name
 	vs.coreClient.CoreV1().PersistentVolumes().Create(context.TODO(), pv, metav1.CreateOptions{})
-	err = vs.deleteSnapshot(&snapDataList.Items[0].Spec)
+	err = vs.deleteSnapshot(context.Background(), &snapDataList.Items[0].Spec)
 	if err != nil {
 		t.Errorf("Test failed, unexpected error: %v", err)
 	}
 	tp.ShouldFail = true
-	err = vs.deleteSnapshot(&snapDataList.Items[0].Spec)
+	err = vs.deleteSnapshot(context.Background(), &snapDataList.Items[0].Spec)
 	if err == nil {
 		t.Errorf("Test failed, expected error got nil")
 	}
@@ -401,7 +454,7 @@ func Test_createSnapshotData(t *testing.T) {
 
 	clientset := fake.NewSimpleClientset(fakePVC(), fakePV())
 	asw := cache.NewActualStateOfWorld()
-	plugins := map[string]volume.Plugin{"hostPath": tp}
+	plugins := map[string]volume.PluginV2{"hostPath": volume.AdaptPlugin(tp)}
 	scheme, client, err := fakeSchemeAndClient(func(req *http.Request) (*http.Response, error) {
 		header := http.Header{}
 		header.Set("Content-Type", runtime.ContentTypeJSON)
@@ -416,7 +469,7 @@ func Test_createSnapshotData(t *testing.T) {
 		t.Errorf("Failed to create test client: %v", err)
 	}
 
-	vsObj := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins)
+	vsObj := NewVolumeSnapshotter(client, scheme, clientset, asw, &plugins, WaitConfigs{Default: DefaultWaitConfig})
 	if vsObj == nil {
 		t.Errorf("Test failed: could not create volume snapshotter")
 	}
@@ -443,3 +496,39 @@ func Test_createSnapshotData(t *testing.T) {
 		t.Errorf("Test failed: faailed to create VolumeSnapshotData")
 	}
 }
+
+func Test_findSnapshotByTags(t *testing.T) {
+	noFind := volume.AllCapabilities
+	noFind.FindSnapshot = false
+	tests := map[string]struct {
+		capabilities volume.Capabilities
+		canFind      bool
+		findCalls    int
+	}{
+		"find supported":   {capabilities: volume.AllCapabilities, canFind: true, findCalls: 1},
+		"find unsupported": {capabilities: noFind},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			plugin := &TestPluginV2{SupportedCalls: test.capabilities}
+			clientset := fake.NewSimpleClientset(fakePVC(), fakePV())
+			plugins := map[string]volume.PluginV2{"hostPath": plugin}
+			scheme, client, err := fakeSchemeAndClient(dummyRoundTripper)
+			if err != nil {
+				t.Fatalf("Failed to create test client: %v", err)
+			}
+			vs := NewVolumeSnapshotter(client, scheme, clientset, cache.NewActualStateOfWorld(), &plugins, WaitConfigs{Default: DefaultWaitConfig}).(*volumeSnapshotter)
+
+			source, _, err := vs.findSnapshotByTags(context.Background(), "default/new-snapshot-test-1", fakeNewVolumeSnapshot())
+			if test.canFind && (err != nil || source == nil || source.HostPath == nil) {
+				t.Errorf("Expected snapshot to be found, got %v", err)
+			}
+			if !test.canFind && !volume.IsUnsupported(err) {
+				t.Errorf("Expected unsupported error, got %v", err)
+			}
+			if plugin.FindCallCount != test.findCalls {
+				t.Errorf("Expected %d FindSnapshot calls, got %d", test.findCalls, plugin.FindCallCount)
+			}
+		})
+	}
+}
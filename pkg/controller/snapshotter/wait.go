@@ -0,0 +1,208 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/controller/cache"
+)
+
+// WaitConfig is the backoff of the wait for a snapshot to become ready
+type WaitConfig struct {
+	// InitialDelay between the first two descriptions of the snapshot
+	InitialDelay time.Duration
+	// Factor the delay is multiplied by after each description
+	Factor float64
+	// MaxDelay caps the delay between two descriptions, 0 for no cap
+	MaxDelay time.Duration
+	// Timeout since the creation of the snapshot after which the snapshot
+	// fails with the Timeout reason, 0 for no timeout
+	Timeout time.Duration
+}
+
+// DefaultWaitConfig is the WaitConfig of the plugins without their own
+var DefaultWaitConfig = WaitConfig{
+	InitialDelay: volumeSnapshotInitialDelay,
+	Factor:       volumeSnapshotFactor,
+	MaxDelay:     volumeSnapshotMaxDelay,
+	Timeout:      volumeSnapshotTimeout,
+}
+
+// WaitConfigs are the WaitConfig of the volume plugins
+type WaitConfigs struct {
+	// Default applies to the plugins not in Plugins
+	Default WaitConfig
+	// Plugins holds the WaitConfig of the plugins by plugin name
+	Plugins map[string]WaitConfig
+}
+
+// ParseWaitConfigs parses the comma separated
+// plugin=timeout[:initial delay[:factor[:max delay]]] entries of value, the
+// omitted settings are taken from defaults, e.g. "openebs=10m,aws_ebs=4h:10s"
+func ParseWaitConfigs(value string, defaults WaitConfig) (WaitConfigs, error) {
+	configs := WaitConfigs{Default: defaults, Plugins: map[string]WaitConfig{}}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return configs, fmt.Errorf("invalid snapshot wait %q, expected plugin=timeout[:initial delay[:factor[:max delay]]]", entry)
+		}
+		config, err := parseWaitConfig(parts[1], defaults)
+		if err != nil {
+			return configs, fmt.Errorf("invalid snapshot wait of plugin %s: %v", parts[0], err)
+		}
+		configs.Plugins[parts[0]] = config
+	}
+	return configs, nil
+}
+
+func parseWaitConfig(value string, config WaitConfig) (WaitConfig, error) {
+	var err error
+	fields := strings.Split(value, ":")
+	if len(fields) > 4 {
+		return config, fmt.Errorf("too many settings in %q", value)
+	}
+	for i, field := range fields {
+		switch i {
+		case 0:
+			config.Timeout, err = time.ParseDuration(field)
+		case 1:
+			config.InitialDelay, err = time.ParseDuration(field)
+		case 2:
+			config.Factor, err = strconv.ParseFloat(field, 64)
+		case 3:
+			config.MaxDelay, err = time.ParseDuration(field)
+		}
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, config.validate()
+}
+
+func (config WaitConfig) validate() error {
+	if config.InitialDelay <= 0 {
+		return fmt.Errorf("initial delay %v is not positive", config.InitialDelay)
+	}
+	if config.Factor < 1 {
+		return fmt.Errorf("factor %v is less than 1", config.Factor)
+	}
+	if config.MaxDelay < 0 || config.Timeout < 0 {
+		return fmt.Errorf("negative max delay %v or timeout %v", config.MaxDelay, config.Timeout)
+	}
+	return nil
+}
+
+// waitConfig returns the WaitConfig of the snapshot taken by the plugin, the
+// wait timeout annotation of the snapshot overrides the one of the plugin
+func (vs *volumeSnapshotter) waitConfig(volumeType string, snapshot *crdv1.VolumeSnapshot) WaitConfig {
+	config, ok := vs.waitConfigs.Plugins[volumeType]
+	if !ok {
+		config = vs.waitConfigs.Default
+	}
+	if value, ok := snapshot.Metadata.Annotations[crdv1.SnapshotWaitTimeoutAnnotation]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			glog.Warningf("Ignoring invalid %s %q of snapshot %s", crdv1.SnapshotWaitTimeoutAnnotation, value, cache.MakeSnapshotName(snapshot))
+		} else {
+			config.Timeout = timeout
+		}
+	}
+	return config
+}
+
+// startWait returns the context of the wait for the snapshot, it is
+// canceled by CancelVolumeSnapshot, when the snapshotter stops or when the
+// timeout of the snapshot expires. done releases the context.
+func (vs *volumeSnapshotter) startWait(uniqueSnapshotName string, snapshot *crdv1.VolumeSnapshot, timeout time.Duration) (ctx context.Context, canceled func() bool, done func()) {
+	waitCtx, cancelWait := context.WithCancel(vs.ctx)
+	vs.waitsLock.Lock()
+	vs.waits[uniqueSnapshotName] = cancelWait
+	vs.waitsLock.Unlock()
+
+	ctx, cancel := waitCtx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		start := snapshot.Metadata.CreationTimestamp.Time
+		if start.IsZero() {
+			start = time.Now()
+		}
+		ctx, cancel = context.WithDeadline(waitCtx, start.Add(timeout))
+	}
+	canceled = func() bool {
+		return waitCtx.Err() != nil
+	}
+	done = func() {
+		cancel()
+		cancelWait()
+		vs.waitsLock.Lock()
+		delete(vs.waits, uniqueSnapshotName)
+		vs.waitsLock.Unlock()
+	}
+	return ctx, canceled, done
+}
+
+// CancelVolumeSnapshot stops the wait for the snapshot to become ready
+func (vs *volumeSnapshotter) CancelVolumeSnapshot(snapshot *crdv1.VolumeSnapshot) {
+	snapshotName := cache.MakeSnapshotName(snapshot)
+	vs.waitsLock.Lock()
+	defer vs.waitsLock.Unlock()
+	if cancel, ok := vs.waits[snapshotName]; ok {
+		glog.Infof("Canceling the wait for snapshot %s", snapshotName)
+		cancel()
+	}
+}
+
+// Run resumes the interrupted deletions of snapshots and blocks until stopCh
+// is closed, it then cancels all the waits for the snapshots
+func (vs *volumeSnapshotter) Run(stopCh <-chan struct{}) {
+	vs.resumeDeletions()
+	<-stopCh
+	glog.Infof("Canceling the waits for the snapshots")
+	vs.cancel()
+}
+
+// pollWithBackoff runs condition until it returns true or an error, waiting
+// after each run for the delay of config, until ctx is done
+func pollWithBackoff(ctx context.Context, config WaitConfig, condition wait.ConditionFunc) error {
+	delay := config.InitialDelay
+	for {
+		if ok, err := condition(); err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = time.Duration(float64(delay) * config.Factor)
+		if config.MaxDelay > 0 && delay > config.MaxDelay {
+			delay = config.MaxDelay
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package snapshotter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/controller/cache"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+func TestParseWaitConfigs(t *testing.T) {
+	defaults := DefaultWaitConfig
+	tests := map[string]struct {
+		value   string
+		plugins map[string]WaitConfig
+		wantErr bool
+	}{
+		"empty": {plugins: map[string]WaitConfig{}},
+		"timeouts": {
+			value: "openebs=10m, aws_ebs=4h",
+			plugins: map[string]WaitConfig{
+				"openebs": {InitialDelay: defaults.InitialDelay, Factor: defaults.Factor, MaxDelay: defaults.MaxDelay, Timeout: 10 * time.Minute},
+				"aws_ebs": {InitialDelay: defaults.InitialDelay, Factor: defaults.Factor, MaxDelay: defaults.MaxDelay, Timeout: 4 * time.Hour},
+			},
+		},
+		"full backoff": {
+			value:   "gce-pd=1h:10s:2:1m",
+			plugins: map[string]WaitConfig{"gce-pd": {InitialDelay: 10 * time.Second, Factor: 2, MaxDelay: time.Minute, Timeout: time.Hour}},
+		},
+		"no plugin":       {value: "=10m", wantErr: true},
+		"invalid timeout": {value: "openebs=soon", wantErr: true},
+		"factor below 1":  {value: "openebs=10m:1s:0.5", wantErr: true},
+		"too many fields": {value: "openebs=10m:1s:2:1m:1", wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			configs, err := ParseWaitConfigs(test.value, defaults)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Expected error %v, got %v", test.wantErr, err)
+			}
+			if test.wantErr {
+				return
+			}
+			if configs.Default != defaults || !reflect.DeepEqual(configs.Plugins, test.plugins) {
+				t.Errorf("Expected %v, got %v", test.plugins, configs)
+			}
+		})
+	}
+}
+
+func newWaitingSnapshotter(t *testing.T, server *fakeAPIServer) *volumeSnapshotter {
+	scheme, client, err := fakeSchemeAndClient(server.roundTrip)
+	if err != nil {
+		t.Fatalf("Failed to create test client: %v", err)
+	}
+	// TestPlugin describes its snapshots without conditions, they never
+	// become ready
+	plugins := map[string]volume.PluginV2{"hostPath": volume.AdaptPlugin(&TestPlugin{})}
+	config := WaitConfig{InitialDelay: 5 * time.Millisecond, Factor: 1.5, MaxDelay: 20 * time.Millisecond, Timeout: time.Hour}
+	return NewVolumeSnapshotter(client, scheme, fake.NewSimpleClientset(), cache.NewActualStateOfWorld(), &plugins,
+		WaitConfigs{Default: config}).(*volumeSnapshotter)
+}
+
+func Test_waitForSnapshotTimeout(t *testing.T) {
+	snapshotData := fakeVolumeSnapshotDataList().Items[0]
+	snapshot := newHeldSnapshot(map[string]string{crdv1.SnapshotWaitTimeoutAnnotation: "50ms"}, nil)
+	server := &fakeAPIServer{snapshot: snapshot.DeepCopy(), snapshotData: &snapshotData}
+	vs := newWaitingSnapshotter(t, server)
+
+	if err := vs.waitForSnapshot(context.Background(), cache.MakeSnapshotName(snapshot), snapshot, &snapshotData); err == nil {
+		t.Fatalf("Expected the wait to time out")
+	}
+	conditions := server.snapshot.Status.Conditions
+	if len(conditions) == 0 {
+		t.Fatalf("Expected an Error condition, got none")
+	}
+	last := conditions[len(conditions)-1]
+	if last.Type != crdv1.VolumeSnapshotConditionError || last.Reason != crdv1.VolumeSnapshotReasonTimeout || last.Message == "" {
+		t.Errorf("Expected an Error condition with the Timeout reason, got %#v", last)
+	}
+}
+
+func Test_waitForSnapshotCanceled(t *testing.T) {
+	tests := map[string]struct {
+		cancel  func(vs *volumeSnapshotter, snapshot *crdv1.VolumeSnapshot)
+		deleted bool
+	}{
+		"snapshot deleted": {
+			cancel:  func(vs *volumeSnapshotter, snapshot *crdv1.VolumeSnapshot) { vs.CancelVolumeSnapshot(snapshot) },
+			deleted: true,
+		},
+		"controller stopped": {
+			cancel: func(vs *volumeSnapshotter, snapshot *crdv1.VolumeSnapshot) {
+				stopCh := make(chan struct{})
+				close(stopCh)
+				vs.Run(stopCh)
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			snapshotData := fakeVolumeSnapshotDataList().Items[0]
+			snapshot := newHeldSnapshot(nil, nil)
+			server := &fakeAPIServer{snapshot: snapshot.DeepCopy(), snapshotData: &snapshotData}
+			vs := newWaitingSnapshotter(t, server)
+
+			name := cache.MakeSnapshotName(snapshot)
+			errCh := make(chan error)
+			go func() {
+				errCh <- vs.waitForSnapshot(context.Background(), name, snapshot, &snapshotData)
+			}()
+			for waiting := false; !waiting; {
+				time.Sleep(time.Millisecond)
+				vs.waitsLock.Lock()
+				_, waiting = vs.waits[name]
+				vs.waitsLock.Unlock()
+			}
+			test.cancel(vs, snapshot)
+
+			select {
+			case err := <-errCh:
+				if err == nil {
+					t.Errorf("Expected the wait to be canceled")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Wait not canceled")
+			}
+			if len(server.snapshot.Status.Conditions) != 0 {
+				t.Errorf("Expected no condition on a canceled wait, got %#v", server.snapshot.Status.Conditions)
+			}
+			if exists := vs.actualStateOfWorld.SnapshotExists(name); exists != test.deleted {
+				t.Errorf("Expected the snapshot in the actual state of world %v, got %v", test.deleted, exists)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// CASConfigAnnotationPrefix is the prefix of the PVC annotations that
+	// override a single cas config of the volume,
+	// e.g. cas.config.openebs.io/ReplicaCount: "1"
+	CASConfigAnnotationPrefix = "cas.config.openebs.io/"
+)
+
+// supportedCASConfigs is the allow-list of cas configs that can be set
+// through StorageClass parameters or PVC annotations along with the
+// validation of their values
+var supportedCASConfigs = map[v1alpha1.CASConfigName]func(string) error{
+	v1alpha1.ReplicaCountConfig:            validateReplicaCount,
+	v1alpha1.TargetResourceLimitsConfig:    validateResourceList,
+	v1alpha1.TargetResourceRequestsConfig:  validateResourceList,
+	v1alpha1.AuxResourceLimitsConfig:       validateResourceList,
+	v1alpha1.AuxResourceRequestsConfig:     validateResourceList,
+	v1alpha1.ReplicaResourceLimitsConfig:   validateResourceList,
+	v1alpha1.ReplicaResourceRequestsConfig: validateResourceList,
+	v1alpha1.StoragePoolConfig:             validateName,
+	v1alpha1.StoragePoolClaimConfig:        validateName,
+	v1alpha1.FSTypeConfig:                  validateFSType,
+}
+
+// getCASConfig merges the StorageClass parameters and the cas config
+// annotations of the PVC into a list of configs. PVC annotations take
+// precedence over StorageClass parameters. An error is returned for any
+// unknown or malformed config, the final snapshot parameters are skipped.
+func getCASConfig(parameters map[string]string, pvc *v1.PersistentVolumeClaim) ([]v1alpha1.Config, error) {
+	merged := make(map[string]string)
+	for name, value := range parameters {
+		merged[name] = value
+	}
+	for _, name := range finalSnapshotParameters {
+		delete(merged, name)
+	}
+	for key, value := range pvc.Annotations {
+		if !strings.HasPrefix(key, CASConfigAnnotationPrefix) {
+			continue
+		}
+		merged[strings.TrimPrefix(key, CASConfigAnnotationPrefix)] = value
+	}
+
+	var errs []string
+	config := []v1alpha1.Config{}
+	for name, value := range merged {
+		validate, ok := supportedCASConfigs[v1alpha1.CASConfigName(name)]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown config %q", name))
+			continue
+		}
+		if err := validate(value); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %q of config %q: %v", value, name, err))
+			continue
+		}
+		config = append(config, v1alpha1.Config{Name: name, Value: value})
+	}
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("invalid cas config: %s", strings.Join(errs, ", "))
+	}
+	sort.Slice(config, func(i, j int) bool { return config[i].Name < config[j].Name })
+	return config, nil
+}
+
+// setCASVolumeConfig sets the configs as yaml against the CASConfigKey
+// annotation of the cas volume and returns the yaml
+func setCASVolumeConfig(casVolume *v1alpha1.CASVolume, config []v1alpha1.Config) (string, error) {
+	if len(config) == 0 {
+		return "", nil
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	if casVolume.Annotations == nil {
+		casVolume.Annotations = make(map[string]string)
+	}
+	casVolume.Annotations[string(v1alpha1.CASConfigKey)] = string(data)
+	return string(data), nil
+}
+
+func validateReplicaCount(value string) error {
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if count < 1 {
+		return fmt.Errorf("must be greater than zero")
+	}
+	return nil
+}
+
+// validateResourceList validates a yaml map of resource names to quantities
+// e.g. "memory: 1Gi\ncpu: 200m"
+func validateResourceList(value string) error {
+	var list map[v1.ResourceName]string
+	if err := yaml.Unmarshal([]byte(value), &list); err != nil {
+		return err
+	}
+	for name, quantity := range list {
+		if name != v1.ResourceCPU && name != v1.ResourceMemory && name != v1.ResourceEphemeralStorage {
+			return fmt.Errorf("unsupported resource %q", name)
+		}
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			return fmt.Errorf("resource %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func validateName(value string) error {
+	if errs := validation.IsDNS1123Subdomain(value); len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func validateFSType(value string) error {
+	switch value {
+	case "ext4", "xfs":
+		return nil
+	}
+	return fmt.Errorf("supported values are ext4 and xfs")
+}
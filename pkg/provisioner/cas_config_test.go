@@ -0,0 +1,60 @@
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetCASConfig(t *testing.T) {
+	cases := map[string]struct {
+		parameters   map[string]string
+		annotations  map[string]string
+		expectErr    bool
+		expectConfig []v1alpha1.Config
+	}{
+		"No overrides": {nil, nil, false, []v1alpha1.Config{}},
+		"StorageClass parameters": {
+			map[string]string{"ReplicaCount": "3", "StoragePoolClaim": "cstor-sparse-pool"},
+			nil, false,
+			[]v1alpha1.Config{{Name: "ReplicaCount", Value: "3"}, {Name: "StoragePoolClaim", Value: "cstor-sparse-pool"}},
+		},
+		"PVC annotation overrides StorageClass parameter": {
+			map[string]string{"ReplicaCount": "3"},
+			map[string]string{CASConfigAnnotationPrefix + "ReplicaCount": "1", "unrelated.io/annotation": "x"},
+			false,
+			[]v1alpha1.Config{{Name: "ReplicaCount", Value: "1"}},
+		},
+		"Resource limits": {
+			nil,
+			map[string]string{CASConfigAnnotationPrefix + "TargetResourceLimits": "memory: 1Gi\ncpu: 200m"},
+			false,
+			[]v1alpha1.Config{{Name: "TargetResourceLimits", Value: "memory: 1Gi\ncpu: 200m"}},
+		},
+		"Final snapshot parameters": {
+			map[string]string{crdv1.FinalSnapshotAnnotation: "true", crdv1.FinalSnapshotRetentionAnnotation: "24h"},
+			nil, false, []v1alpha1.Config{},
+		},
+		"Unknown StorageClass parameter": {map[string]string{"replicas": "3"}, nil, true, nil},
+		"Unknown PVC annotation":         {nil, map[string]string{CASConfigAnnotationPrefix + "Foo": "bar"}, true, nil},
+		"Malformed replica count":        {map[string]string{"ReplicaCount": "zero"}, nil, true, nil},
+		"Malformed resource limits":      {map[string]string{"ReplicaResourceLimits": "memory: lots"}, nil, true, nil},
+		"Unsupported file system":        {map[string]string{"FSType": "btrfs"}, nil, true, nil},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			config, err := getCASConfig(tc.parameters, pvc)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if !reflect.DeepEqual(config, tc.expectConfig) {
+				t.Errorf("Expected %v, got %v", tc.expectConfig, config)
+			}
+		})
+	}
+}
@@ -23,13 +23,19 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/tracing"
 	mv1alpha1 "github.com/openebs/openebs-k8s-provisioner/pkg/volume/v1alpha1"
+	"go.opentelemetry.io/otel/attribute"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/sig-storage-lib-external-provisioner/v7/controller"
 )
 
@@ -40,10 +46,21 @@ type openEBSCASProvisioner struct {
 	// Identity of this openEBSProvisioner, set to node's name. Used to identify
 	// "this" provisioner's PVs.
 	identity string
+
+	// eventRecorder is used to record events against the PVCs
+	eventRecorder record.EventRecorder
+
+	// snapshotData creates the VolumeSnapshotData of the final snapshots
+	// taken by finalSnapshotter, final snapshots are not supported if
+	// either is nil
+	snapshotData     SnapshotDataClient
+	finalSnapshotter FinalSnapshotter
+	now              func() time.Time
 }
 
-// NewOpenEBSProvisioner creates a new openebs provisioner
-func NewOpenEBSCASProvisioner(client kubernetes.Interface) (controller.Provisioner, error) {
+// NewOpenEBSProvisioner creates a new openebs provisioner, snapshotData and
+// finalSnapshotter may be nil if the snapshot API is not available
+func NewOpenEBSCASProvisioner(client kubernetes.Interface, snapshotData SnapshotDataClient, finalSnapshotter FinalSnapshotter) (controller.Provisioner, error) {
 	nodeName := os.Getenv("NODE_NAME")
 	if nodeName == "" {
 		return nil, fmt.Errorf("Env variable 'NODE_NAME' is not set")
@@ -61,9 +78,17 @@ func NewOpenEBSCASProvisioner(client kubernetes.Interface) (controller.Provision
 	//Set maya-apiserver IP address along with default port
 	os.Setenv("MAPI_ADDR", mayaServiceURI)
 
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(glog.Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+
 	return &openEBSCASProvisioner{
-		identity: nodeName,
-		endpoint: mayaServiceURI,
+		identity:         nodeName,
+		endpoint:         mayaServiceURI,
+		eventRecorder:    broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: provisionerComponent}),
+		snapshotData:     snapshotData,
+		finalSnapshotter: finalSnapshotter,
+		now:              time.Now,
 	}, nil
 }
 
@@ -72,9 +97,30 @@ var _ controller.BlockProvisioner = &openEBSCASProvisioner{}
 
 // Provision creates a storage asset and returns a PV object representing it.
 func (p *openEBSCASProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	attributes := []attribute.KeyValue{attribute.String("pv", options.PVName)}
+	if options.PVC != nil {
+		attributes = append(attributes, attribute.String("pvc", options.PVC.Namespace+"/"+options.PVC.Name))
+	}
+	ctx, span := tracing.Start(ctx, "Provision", attributes...)
+	pv, state, err := p.provision(ctx, options)
+	tracing.End(span, err)
+	return pv, state, err
+}
+
+func (p *openEBSCASProvisioner) provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+
+	// Reject requests that can never be satisfied before anything is created
+	// in maya-apiserver, the error is ignored so the library stops retrying
+	if err := validateProvisionOptions(options); err != nil {
+		glog.Errorf("Invalid provision request for volume %q: %v", options.PVName, err)
+		if options.PVC != nil {
+			p.eventRecorder.Event(options.PVC, v1.EventTypeWarning, "ValidationFailed", err.Error())
+		}
+		return nil, controller.ProvisioningFinished, &controller.IgnoredError{Reason: err.Error()}
+	}
 
 	//Issue a request to Maya API Server to create a volume
-	var openebsCASVol mv1alpha1.CASVolume
+	openebsCASVol := mv1alpha1.CASVolume{}.WithContext(ctx)
 	casVolume := v1alpha1.CASVolume{}
 
 	volSize := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
@@ -85,25 +131,42 @@ func (p *openEBSCASProvisioner) Provision(ctx context.Context, options controlle
 	// creating a map b/c have to initialize the map using the make function before
 	// adding any elements to avoid nil map assignment error
 	mapLabels := make(map[string]string)
-
-	if className == nil {
-		glog.Errorf("Volume has no storage class specified")
-	} else {
-		mapLabels[string(v1alpha1.StorageClassKey)] = *className
-		casVolume.Labels = mapLabels
-	}
+	mapLabels[string(v1alpha1.StorageClassKey)] = *className
+	casVolume.Labels = mapLabels
 
 	casVolume.Labels[string(v1alpha1.NamespaceKey)] = options.PVC.Namespace
 	casVolume.Namespace = options.PVC.Namespace
 	casVolume.Labels[string(v1alpha1.PersistentVolumeClaimKey)] = options.PVC.ObjectMeta.Name
 	casVolume.Name = options.PVName
 
+	// Resolve the placement of the volume before talking to maya-apiserver,
+	// if the selected node can not host it let the scheduler pick another one
+	topology, err := getVolumeTopology(options.SelectedNode, options.StorageClass.AllowedTopologies)
+	if err != nil {
+		glog.Errorf("Failed to resolve topology for volume %q: %v", options.PVName, err)
+		return nil, controller.ProvisioningReschedule, err
+	}
+	err = setCASVolumeTopology(&casVolume, topology)
+	if err != nil {
+		return nil, controller.ProvisioningFinished, err
+	}
+
+	// Per volume overrides of the cas config, already validated above
+	casConfig, err := getCASConfig(options.StorageClass.Parameters, options.PVC)
+	if err != nil {
+		return nil, controller.ProvisioningFinished, err
+	}
+	casConfigYAML, err := setCASVolumeConfig(&casVolume, casConfig)
+	if err != nil {
+		return nil, controller.ProvisioningFinished, err
+	}
+
 	// Check if volume already exists
 	// if present then return the read values
 	// if unexpected error then return the error
 	// if absent then create volume
 	glog.V(2).Infof("Checking if volume %q already exists", options.PVName)
-	err := openebsCASVol.ReadVolume(options.PVName, options.PVC.Namespace, *className, &casVolume)
+	err = openebsCASVol.ReadVolume(options.PVName, options.PVC.Namespace, *className, &casVolume)
 	if err == nil {
 		glog.V(2).Infof("Volume %q already present", options.PVName)
 	} else if err.Error() != http.StatusText(404) {
@@ -126,10 +189,17 @@ func (p *openEBSCASProvisioner) Provision(ctx context.Context, options controlle
 		glog.V(2).Infof("VolumeInfo: created volume metadata : %#v", casVolume)
 	}
 
-	for _, accessMode := range options.PVC.Spec.AccessModes {
-		if accessMode != v1.ReadWriteOnce {
-			return nil, controller.ProvisioningFinished, fmt.Errorf("Only support ReadWriteOnce access mode")
+	// Do not hand out a PV until the target of the volume is reachable,
+	// a pending volume is picked up again when the claim is requeued
+	state, err := waitForCASVolume(ctx, options.PVName, options.PVC.Namespace, *className, &casVolume)
+	if err != nil {
+		if _, ok := err.(*casVolumeFailedError); ok {
+			glog.Errorf("%v", err)
+			p.eventRecorder.Event(options.PVC, v1.EventTypeWarning, "VolumeFailed", err.Error())
+			return nil, controller.ProvisioningFinished, &controller.IgnoredError{Reason: err.Error()}
 		}
+		glog.V(2).Infof("Volume %q is not yet ready: %v", options.PVName, err)
+		return nil, state, err
 	}
 
 	//if !util.AccessModesContainedInAll(p.GetAccessModes(), options.PVC.Spec.AccessModes) {
@@ -142,6 +212,14 @@ func (p *openEBSCASProvisioner) Provision(ctx context.Context, options controlle
 	volAnnotations = Setlink(volAnnotations, options.PVName)
 	volAnnotations["openEBSProvisionerIdentity"] = p.identity
 	volAnnotations[string(v1alpha1.CASTypeKey)] = casVolume.Spec.CasType
+	if casConfigYAML != "" {
+		volAnnotations[string(v1alpha1.CASConfigKey)] = casConfigYAML
+	}
+	for _, key := range finalSnapshotParameters {
+		if value, ok := options.StorageClass.Parameters[key]; ok {
+			volAnnotations[key] = value
+		}
+	}
 	fstype := casVolume.Spec.FSType
 
 	labels := make(map[string]string)
@@ -168,7 +246,8 @@ func (p *openEBSCASProvisioner) Provision(ctx context.Context, options controlle
 			Capacity: v1.ResourceList{
 				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
 			},
-			VolumeMode: volumeMode,
+			VolumeMode:   volumeMode,
+			NodeAffinity: getNodeAffinity(topology),
 			PersistentVolumeSource: v1.PersistentVolumeSource{
 				ISCSI: &v1.ISCSIPersistentVolumeSource{
 					TargetPortal: casVolume.Spec.TargetPortal,
@@ -196,8 +275,15 @@ func (p *openEBSCASProvisioner) SupportsBlock(ctx context.Context) bool {
 // Delete removes the storage asset that was created by Provision represented
 // by the given PV.
 func (p *openEBSCASProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume) error {
+	ctx, span := tracing.Start(ctx, "Delete", attribute.String("pv", volume.Name))
+	err := p.delete(ctx, volume)
+	tracing.End(span, err)
+	return err
+}
+
+func (p *openEBSCASProvisioner) delete(ctx context.Context, volume *v1.PersistentVolume) error {
 
-	var openebsCASVol mv1alpha1.CASVolume
+	openebsCASVol := mv1alpha1.CASVolume{}.WithContext(ctx)
 	_, ok := volume.Annotations["openEBSProvisionerIdentity"]
 	if !ok {
 		return errors.New("identity annotation not found on PV")
@@ -213,6 +299,16 @@ func (p *openEBSCASProvisioner) Delete(ctx context.Context, volume *v1.Persisten
 		}
 	*/
 
+	// The volume is kept along with its final snapshot, the snapshot
+	// controller deletes both once the snapshot expires
+	if finalSnapshotEnabled(volume) {
+		if err := p.takeFinalSnapshot(ctx, volume); err != nil {
+			glog.Errorf("Failed to take the final snapshot of volume %s, error: %v", volume.Name, err)
+			return err
+		}
+		return nil
+	}
+
 	// Issue a delete request to Maya API Server
 	err := openebsCASVol.DeleteVolume(volume.Name, volume.Spec.ClaimRef.Namespace)
 	if err != nil {
@@ -0,0 +1,113 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	mv1alpha1 "github.com/openebs/openebs-k8s-provisioner/pkg/volume/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v7/controller"
+)
+
+const (
+	// casVolumeInitialDelay is the delay before the volume is read again
+	// while it is not yet available
+	casVolumeInitialDelay = 500 * time.Millisecond
+	// casVolumeFactor is the backoff factor between two reads
+	casVolumeFactor = 2
+	// casVolumeSteps is the number of reads done within one call of
+	// Provision, the library requeues the claim with its own rate limited
+	// backoff once these are exhausted
+	casVolumeSteps = 5
+)
+
+// casVolumeBackoff is the backoff used while waiting for a volume to
+// become available
+var casVolumeBackoff = wait.Backoff{
+	Duration: casVolumeInitialDelay,
+	Factor:   casVolumeFactor,
+	Steps:    casVolumeSteps,
+}
+
+// casVolumeFailedError is returned when maya-apiserver reports the volume
+// as failed, provisioning is not retried
+type casVolumeFailedError struct {
+	name    string
+	reason  string
+	message string
+}
+
+func (e *casVolumeFailedError) Error() string {
+	return fmt.Sprintf("volume %q failed: %s: %s", e.name, e.reason, e.message)
+}
+
+// getCASVolumeState maps the status reported by maya-apiserver to the
+// provisioning state of the claim:
+//   - Available with a target portal: ProvisioningFinished
+//   - Failed: ProvisioningFinished with a casVolumeFailedError
+//   - anything else: ProvisioningInBackground
+func getCASVolumeState(casVolume *v1alpha1.CASVolume) (controller.ProvisioningState, error) {
+	switch casVolume.Status.Phase {
+	case v1alpha1.VolumeFailed:
+		return controller.ProvisioningFinished, &casVolumeFailedError{
+			name:    casVolume.Name,
+			reason:  casVolume.Status.Reason,
+			message: casVolume.Status.Message,
+		}
+	case v1alpha1.VolumeAvailable:
+		if casVolume.Spec.TargetPortal != "" {
+			return controller.ProvisioningFinished, nil
+		}
+		return controller.ProvisioningInBackground, fmt.Errorf("volume %q is available but has no target portal yet", casVolume.Name)
+	}
+	return controller.ProvisioningInBackground, fmt.Errorf("volume %q is not yet available, phase %q", casVolume.Name, casVolume.Status.Phase)
+}
+
+// waitForCASVolume reads the volume with backoff until it is either
+// available or failed. When it is still pending once the backoff is
+// exhausted ProvisioningInBackground is returned so that the claim is
+// requeued. Since every call starts by reading the volume, a claim is
+// resumed from wherever it was left, including after a restart.
+func waitForCASVolume(ctx context.Context, name, namespace, storageClass string, casVolume *v1alpha1.CASVolume) (controller.ProvisioningState, error) {
+	openebsCASVol := mv1alpha1.CASVolume{}.WithContext(ctx)
+	state, stateErr := getCASVolumeState(casVolume)
+	if state == controller.ProvisioningFinished {
+		return state, stateErr
+	}
+	err := wait.ExponentialBackoff(casVolumeBackoff, func() (bool, error) {
+		glog.V(4).Infof("Waiting for volume %q: %v", name, stateErr)
+		if err := openebsCASVol.ReadVolume(name, namespace, storageClass, casVolume); err != nil {
+			// let the next step retry the read
+			glog.Errorf("Failed to read volume %q: %v", name, err)
+			return false, nil
+		}
+		state, stateErr = getCASVolumeState(casVolume)
+		return state == controller.ProvisioningFinished, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return controller.ProvisioningInBackground, stateErr
+	}
+	if err != nil {
+		return controller.ProvisioningInBackground, err
+	}
+	return state, stateErr
+}
@@ -0,0 +1,114 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v7/controller"
+)
+
+func TestGetCASVolumeState(t *testing.T) {
+	cases := map[string]struct {
+		phase        v1alpha1.VolumePhase
+		targetPortal string
+		expectState  controller.ProvisioningState
+		expectErr    bool
+		expectFailed bool
+	}{
+		"Pending":                  {v1alpha1.VolumePending, "", controller.ProvisioningInBackground, true, false},
+		"No phase reported":        {"", "", controller.ProvisioningInBackground, true, false},
+		"Available without portal": {v1alpha1.VolumeAvailable, "", controller.ProvisioningInBackground, true, false},
+		"Available":                {v1alpha1.VolumeAvailable, "10.0.0.1:3260", controller.ProvisioningFinished, false, false},
+		"Failed":                   {v1alpha1.VolumeFailed, "", controller.ProvisioningFinished, true, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			casVolume := &v1alpha1.CASVolume{}
+			casVolume.Name = "pvc-1"
+			casVolume.Spec.TargetPortal = tc.targetPortal
+			casVolume.Status = v1alpha1.CASVolumeStatus{Phase: tc.phase, Reason: "PoolNotFound", Message: "no pool"}
+			state, err := getCASVolumeState(casVolume)
+			if state != tc.expectState {
+				t.Errorf("Expected state %v, got %v", tc.expectState, state)
+			}
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if _, ok := err.(*casVolumeFailedError); ok != tc.expectFailed {
+				t.Errorf("Expected failed error %v, got %v", tc.expectFailed, err)
+			}
+		})
+	}
+}
+
+func TestWaitForCASVolume(t *testing.T) {
+	available := v1alpha1.CASVolume{}
+	available.Status.Phase = v1alpha1.VolumeAvailable
+	available.Spec.TargetPortal = "10.0.0.1:3260"
+	pending := v1alpha1.CASVolume{}
+	pending.Status.Phase = v1alpha1.VolumePending
+	failed := v1alpha1.CASVolume{}
+	failed.Status = v1alpha1.CASVolumeStatus{Phase: v1alpha1.VolumeFailed, Reason: "PoolNotFound", Message: "no pool"}
+
+	cases := map[string]struct {
+		// responses are served in turn, the last one is repeated
+		responses    []v1alpha1.CASVolume
+		expectState  controller.ProvisioningState
+		expectErr    bool
+		expectFailed bool
+		expectReads  int
+	}{
+		"Available after a few reads":  {[]v1alpha1.CASVolume{pending, pending, available}, controller.ProvisioningFinished, false, false, 3},
+		"Failed":                       {[]v1alpha1.CASVolume{pending, failed}, controller.ProvisioningFinished, true, true, 2},
+		"Still pending after backoff":  {[]v1alpha1.CASVolume{pending}, controller.ProvisioningInBackground, true, false, casVolumeSteps},
+		"Available after read failure": {[]v1alpha1.CASVolume{{}, available}, controller.ProvisioningFinished, false, false, 2},
+	}
+	defer func(backoff wait.Backoff) { casVolumeBackoff = backoff }(casVolumeBackoff)
+	casVolumeBackoff.Duration = time.Millisecond
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			reads := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				response := tc.responses[len(tc.responses)-1]
+				if reads < len(tc.responses) {
+					response = tc.responses[reads]
+				}
+				reads++
+				// an empty volume stands for a failed read
+				if response.Status.Phase == "" && response.Spec.TargetPortal == "" {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				json.NewEncoder(w).Encode(response)
+			}))
+			defer ts.Close()
+			os.Setenv("MAPI_ADDR", ts.URL)
+			defer os.Unsetenv("MAPI_ADDR")
+
+			casVolume := &v1alpha1.CASVolume{}
+			casVolume.Name = "pvc-1"
+			casVolume.Status.Phase = v1alpha1.VolumePending
+			state, err := waitForCASVolume(context.Background(), "pvc-1", "default", "sc", casVolume)
+			if state != tc.expectState {
+				t.Errorf("Expected state %v, got %v", tc.expectState, state)
+			}
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if _, ok := err.(*casVolumeFailedError); ok != tc.expectFailed {
+				t.Errorf("Expected failed error %v, got %v", tc.expectFailed, err)
+			}
+			if reads != tc.expectReads {
+				t.Errorf("Expected %d reads, got %d", tc.expectReads, reads)
+			}
+		})
+	}
+}
@@ -8,6 +8,10 @@ const (
 	// BetaStorageClassAnnotation represents the beta/previous StorageClass annotation.
 	// It's currently still used and will be held for backwards compatibility
 	BetaStorageClassAnnotation = "volume.beta.kubernetes.io/storage-class"
+
+	// provisionerComponent is the source component of the events recorded
+	// by the provisioner
+	provisionerComponent = "openebs-provisioner"
 )
 
 // GetPersistentVolumeClass returns StorageClassName.
@@ -0,0 +1,255 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	mv1alpha1 "github.com/openebs/openebs-k8s-provisioner/pkg/volume/v1alpha1"
+	mayav1 "github.com/openebs/openebs-k8s-provisioner/types/v1"
+)
+
+const (
+	// finalSnapshotPrefix prefixes the PV name in the names of the final
+	// snapshot and of its VolumeSnapshotData
+	finalSnapshotPrefix = "final-"
+
+	defaultFinalSnapshotRetention = 7 * 24 * time.Hour
+)
+
+// finalSnapshotParameters are the storage class parameters copied to the
+// annotations of the PVs, they are not cas configs
+var finalSnapshotParameters = []string{
+	crdv1.FinalSnapshotAnnotation,
+	crdv1.FinalSnapshotRetentionAnnotation,
+}
+
+// SnapshotDataClient gets and creates the VolumeSnapshotData of the final
+// snapshots
+type SnapshotDataClient interface {
+	// GetSnapshotData returns nil if there is no VolumeSnapshotData name
+	GetSnapshotData(name string) (*crdv1.VolumeSnapshotData, error)
+	CreateSnapshotData(data *crdv1.VolumeSnapshotData) error
+}
+
+// FinalSnapshotter takes the final snapshots through the snapshot engine of
+// the cas type of the volumes
+type FinalSnapshotter interface {
+	TakeFinalSnapshot(ctx context.Context, pv *v1.PersistentVolume, snapshotName string) (*crdv1.OpenEBSVolumeSnapshotSource, error)
+}
+
+type snapshotDataClient struct {
+	restClient *rest.RESTClient
+}
+
+// NewSnapshotDataClient returns a SnapshotDataClient using the rest client
+// of the snapshot API group
+func NewSnapshotDataClient(restClient *rest.RESTClient) SnapshotDataClient {
+	return &snapshotDataClient{restClient: restClient}
+}
+
+func (c *snapshotDataClient) GetSnapshotData(name string) (*crdv1.VolumeSnapshotData, error) {
+	var data crdv1.VolumeSnapshotData
+	err := c.restClient.Get().
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Name(name).
+		Do(context.TODO()).Into(&data)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (c *snapshotDataClient) CreateSnapshotData(data *crdv1.VolumeSnapshotData) error {
+	return c.restClient.Post().
+		Resource(crdv1.VolumeSnapshotDataResourcePlural).
+		Body(data).
+		Do(context.TODO()).Error()
+}
+
+// validateFinalSnapshotParameters checks the final snapshot parameters of a
+// storage class
+func validateFinalSnapshotParameters(parameters map[string]string) error {
+	if value, ok := parameters[crdv1.FinalSnapshotAnnotation]; ok {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid value %q of parameter %q: %v", value, crdv1.FinalSnapshotAnnotation, err)
+		}
+	}
+	if value, ok := parameters[crdv1.FinalSnapshotRetentionAnnotation]; ok {
+		if _, err := parseRetention(value); err != nil {
+			return fmt.Errorf("invalid value %q of parameter %q: %v", value, crdv1.FinalSnapshotRetentionAnnotation, err)
+		}
+	}
+	return nil
+}
+
+func parseRetention(value string) (time.Duration, error) {
+	retention, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if retention <= 0 {
+		return 0, fmt.Errorf("must be greater than zero")
+	}
+	return retention, nil
+}
+
+// finalSnapshotEnabled returns true if a final snapshot of the volume has to
+// be taken before it is deleted
+func finalSnapshotEnabled(pv *v1.PersistentVolume) bool {
+	enabled, _ := strconv.ParseBool(pv.Annotations[crdv1.FinalSnapshotAnnotation])
+	return enabled
+}
+
+// finalSnapshotRetention returns the time the final snapshot of the volume
+// is kept, from the annotation of the PV or the FinalSnapshotRetentionENVK
+// environment variable
+func finalSnapshotRetention(pv *v1.PersistentVolume) time.Duration {
+	if value := pv.Annotations[crdv1.FinalSnapshotRetentionAnnotation]; value != "" {
+		retention, err := parseRetention(value)
+		if err == nil {
+			return retention
+		}
+		glog.Warningf("Ignoring invalid %s %q of PV %s: %v", crdv1.FinalSnapshotRetentionAnnotation, value, pv.Name, err)
+	}
+	if value := mayav1.FinalSnapshotRetentionENV(); value != "" {
+		retention, err := parseRetention(value)
+		if err == nil {
+			return retention
+		}
+		glog.Warningf("Ignoring invalid value %q of %s: %v", value, mayav1.FinalSnapshotRetentionENVK, err)
+	}
+	return defaultFinalSnapshotRetention
+}
+
+// takeFinalSnapshot snapshots the volume and records the snapshot in a
+// VolumeSnapshotData not bound to any VolumeSnapshot. It is a no-op if the
+// VolumeSnapshotData exists already, i.e. on a retried Delete.
+func (p *openEBSCASProvisioner) takeFinalSnapshot(ctx context.Context, pv *v1.PersistentVolume) error {
+	if p.snapshotData == nil || p.finalSnapshotter == nil {
+		return fmt.Errorf("final snapshot of PV %s requested but the snapshot API is not available", pv.Name)
+	}
+	if pv.Spec.ClaimRef == nil {
+		return fmt.Errorf("PV %s has no claim", pv.Name)
+	}
+	casType := pv.Annotations[string(v1alpha1.CASTypeKey)]
+	if casType == "" {
+		return fmt.Errorf("PV %s has no %s annotation", pv.Name, v1alpha1.CASTypeKey)
+	}
+
+	name := finalSnapshotPrefix + pv.Name
+	existing, err := p.snapshotData.GetSnapshotData(name)
+	if err != nil {
+		return fmt.Errorf("failed to get VolumeSnapshotData %s: %v", name, err)
+	}
+	if existing != nil {
+		glog.Infof("Final snapshot %s of PV %s is taken already", name, pv.Name)
+		return nil
+	}
+
+	namespace := pv.Spec.ClaimRef.Namespace
+	exists, err := casSnapshotExists(ctx, namespace, pv.Name, name)
+	if err != nil {
+		return fmt.Errorf("failed to list the snapshots of volume %s: %v", pv.Name, err)
+	}
+	capacity := pv.Spec.Capacity[v1.ResourceStorage]
+	source := &crdv1.OpenEBSVolumeSnapshotSource{
+		SnapshotID: name,
+		Capacity:   capacity.String(),
+		CASType:    casType,
+		VolumeName: pv.Name,
+		Namespace:  namespace,
+	}
+	if !exists {
+		if source, err = p.finalSnapshotter.TakeFinalSnapshot(ctx, pv, name); err != nil {
+			return fmt.Errorf("failed to create final snapshot %s of volume %s: %v", name, pv.Name, err)
+		}
+	}
+	// A VolumeSnapshot bound to the final snapshot owns it, the snapshot no
+	// longer expires and deleting the VolumeSnapshot deletes the volume too
+	source.DeleteVolume = true
+
+	expiry := p.now().Add(finalSnapshotRetention(pv))
+	if err := p.snapshotData.CreateSnapshotData(newFinalSnapshotData(pv, source, expiry)); err != nil {
+		return fmt.Errorf("failed to create VolumeSnapshotData %s: %v", name, err)
+	}
+	glog.Infof("Took final snapshot %s of PV %s, the volume is kept until %s", name, pv.Name, expiry.Format(time.RFC3339))
+	return nil
+}
+
+func casSnapshotExists(ctx context.Context, namespace, volumeName, name string) (bool, error) {
+	openebsCASVol := mv1alpha1.CASVolume{}.WithContext(ctx)
+	var list v1alpha1.CASSnapshotList
+	if err := openebsCASVol.ListSnapshots(namespace, &list); err != nil {
+		return false, err
+	}
+	for _, snapshot := range list.Items {
+		if snapshot.Name == name && snapshot.Spec.VolumeName == volumeName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newFinalSnapshotData(pv *v1.PersistentVolume, source *crdv1.OpenEBSVolumeSnapshotSource, expiry time.Time) *crdv1.VolumeSnapshotData {
+	return &crdv1.VolumeSnapshotData{
+		Metadata: metav1.ObjectMeta{
+			Name: source.SnapshotID,
+			Labels: map[string]string{
+				crdv1.FinalSnapshotLabel: "true",
+			},
+			Annotations: map[string]string{
+				crdv1.FinalSnapshotExpiryAnnotation:       expiry.UTC().Format(time.RFC3339),
+				crdv1.FinalSnapshotClaimAnnotation:        pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name,
+				crdv1.FinalSnapshotStorageClassAnnotation: pv.Labels[string(v1alpha1.StorageClassKey)],
+			},
+		},
+		Spec: crdv1.VolumeSnapshotDataSpec{
+			VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+				OpenEBSSnapshot: source,
+			},
+			PersistentVolumeRef: &v1.ObjectReference{
+				Kind: "PersistentVolume",
+				Name: pv.Name,
+			},
+		},
+		Status: crdv1.VolumeSnapshotDataStatus{
+			Conditions: []crdv1.VolumeSnapshotDataCondition{
+				{
+					Type:               crdv1.VolumeSnapshotDataConditionReady,
+					Status:             v1.ConditionTrue,
+					Message:            "Final snapshot of the deleted volume",
+					LastTransitionTime: metav1.Now(),
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,163 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+)
+
+type fakeSnapshotDataClient struct {
+	items map[string]*crdv1.VolumeSnapshotData
+}
+
+func (c *fakeSnapshotDataClient) GetSnapshotData(name string) (*crdv1.VolumeSnapshotData, error) {
+	return c.items[name], nil
+}
+
+func (c *fakeSnapshotDataClient) CreateSnapshotData(data *crdv1.VolumeSnapshotData) error {
+	c.items[data.Metadata.Name] = data
+	return nil
+}
+
+// fakeMaya serves the snapshot API of maya-apiserver and counts the calls
+// deleting volumes
+type fakeMaya struct {
+	snapshots []v1alpha1.CASSnapshot
+	deletes   int
+}
+
+func (m *fakeMaya) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/latest/snapshots/" && r.Method == http.MethodPost:
+		var snapshot v1alpha1.CASSnapshot
+		json.NewDecoder(r.Body).Decode(&snapshot)
+		m.snapshots = append(m.snapshots, snapshot)
+	case r.URL.Path == "/latest/snapshots/":
+		json.NewEncoder(w).Encode(v1alpha1.CASSnapshotList{Items: m.snapshots})
+	case r.Method == http.MethodDelete:
+		m.deletes++
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// fakeFinalSnapshotter takes the final snapshots in fakeMaya
+type fakeFinalSnapshotter struct {
+	maya *fakeMaya
+}
+
+func (f *fakeFinalSnapshotter) TakeFinalSnapshot(ctx context.Context, pv *v1.PersistentVolume, snapshotName string) (*crdv1.OpenEBSVolumeSnapshotSource, error) {
+	casType := pv.Annotations[string(v1alpha1.CASTypeKey)]
+	snapshot := v1alpha1.CASSnapshot{}
+	snapshot.Name = snapshotName
+	snapshot.Spec.CasType = casType
+	snapshot.Spec.VolumeName = pv.Name
+	f.maya.snapshots = append(f.maya.snapshots, snapshot)
+	return &crdv1.OpenEBSVolumeSnapshotSource{
+		SnapshotID: snapshotName,
+		CASType:    casType,
+		VolumeName: pv.Name,
+		Namespace:  pv.Spec.ClaimRef.Namespace,
+	}, nil
+}
+
+func TestDeleteFinalSnapshot(t *testing.T) {
+	maya := &fakeMaya{}
+	server := httptest.NewServer(maya)
+	defer server.Close()
+	os.Setenv("MAPI_ADDR", server.URL)
+	defer os.Unsetenv("MAPI_ADDR")
+
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	snapshotData := &fakeSnapshotDataClient{items: make(map[string]*crdv1.VolumeSnapshotData)}
+	p := &openEBSCASProvisioner{
+		snapshotData:     snapshotData,
+		finalSnapshotter: &fakeFinalSnapshotter{maya: maya},
+		now:              func() time.Time { return now },
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pvc-1",
+			Annotations: map[string]string{
+				"openEBSProvisionerIdentity":           "node1",
+				string(v1alpha1.CASTypeKey):            "cstor",
+				crdv1.FinalSnapshotAnnotation:          "true",
+				crdv1.FinalSnapshotRetentionAnnotation: "24h",
+			},
+			Labels: map[string]string{string(v1alpha1.StorageClassKey): "openebs-cstor"},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("5G")},
+			ClaimRef: &v1.ObjectReference{Namespace: "app", Name: "data"},
+		},
+	}
+
+	// Delete is retried until it succeeds, the snapshot is taken once
+	for i := 0; i < 2; i++ {
+		if err := p.Delete(context.TODO(), pv); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if len(maya.snapshots) != 1 || maya.snapshots[0].Name != "final-pvc-1" || maya.snapshots[0].Spec.CasType != "cstor" {
+		t.Errorf("Expected final snapshot final-pvc-1 of the cstor volume, got %+v", maya.snapshots)
+	}
+	if maya.deletes != 0 {
+		t.Errorf("Expected the volume to be kept, got %d deletes", maya.deletes)
+	}
+	data := snapshotData.items["final-pvc-1"]
+	if data == nil {
+		t.Fatalf("Expected VolumeSnapshotData final-pvc-1, got %v", snapshotData.items)
+	}
+	expectAnnotations := map[string]string{
+		crdv1.FinalSnapshotExpiryAnnotation:       "2018-06-02T00:00:00Z",
+		crdv1.FinalSnapshotClaimAnnotation:        "app/data",
+		crdv1.FinalSnapshotStorageClassAnnotation: "openebs-cstor",
+	}
+	for key, value := range expectAnnotations {
+		if data.Metadata.Annotations[key] != value {
+			t.Errorf("Expected annotation %s %q, got %q", key, value, data.Metadata.Annotations[key])
+		}
+	}
+	if data.Spec.VolumeSnapshotRef != nil || data.Spec.PersistentVolumeRef.Name != "pvc-1" || data.Spec.OpenEBSSnapshot.CASType != "cstor" ||
+		data.Spec.OpenEBSSnapshot.VolumeName != "pvc-1" || data.Spec.OpenEBSSnapshot.Namespace != "app" || !data.Spec.OpenEBSSnapshot.DeleteVolume {
+		t.Errorf("Unexpected VolumeSnapshotData spec %+v", data.Spec)
+	}
+
+	// without a snapshot API the volume is not deleted either
+	p.snapshotData = nil
+	pv.Name = "pvc-2"
+	if err := p.Delete(context.TODO(), pv); err == nil || maya.deletes != 0 {
+		t.Errorf("Expected an error and the volume to be kept, got %v and %d deletes", err, maya.deletes)
+	}
+}
+
+func TestValidateFinalSnapshotParameters(t *testing.T) {
+	cases := map[string]struct {
+		parameters map[string]string
+		expectErr  bool
+	}{
+		"No parameters":     {nil, false},
+		"Enabled":           {map[string]string{crdv1.FinalSnapshotAnnotation: "true", crdv1.FinalSnapshotRetentionAnnotation: "720h"}, false},
+		"Invalid enabled":   {map[string]string{crdv1.FinalSnapshotAnnotation: "sure"}, true},
+		"Invalid retention": {map[string]string{crdv1.FinalSnapshotRetentionAnnotation: "-1h"}, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := validateFinalSnapshotParameters(tc.parameters)
+			if (err != nil) != tc.expectErr {
+				t.Errorf("Expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	mayav1 "github.com/openebs/openebs-k8s-provisioner/types/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// volumeTopology holds the placement constraints of a volume derived from
+// the node selected by the scheduler and the allowed topologies of the
+// storage class.
+type volumeTopology struct {
+	// selectedNode is the name of the node picked by the scheduler for the
+	// consuming pod, empty for immediate binding
+	selectedNode string
+
+	// keyDomain and keyType are the node label key and value the replicas
+	// should be placed on. Both are empty if the constraints can not be
+	// narrowed down to a single label.
+	keyDomain string
+	keyType   string
+
+	// allowed are the allowed topologies of the storage class
+	allowed []v1.TopologySelectorTerm
+
+	// terms are the node selector terms the PV has to be restricted to
+	terms []v1.NodeSelectorTerm
+}
+
+// validateAllowedTopologies checks the allowed topologies of a storage
+// class. The replicas are spread over the values of a single node label, so
+// a term may only have one requirement.
+func validateAllowedTopologies(allowed []v1.TopologySelectorTerm) error {
+	for _, term := range allowed {
+		if len(term.MatchLabelExpressions) > 1 {
+			return fmt.Errorf("invalid allowed topology %+v: only one key is supported per term", term)
+		}
+		for _, req := range term.MatchLabelExpressions {
+			if req.Key == "" || len(req.Values) == 0 {
+				return fmt.Errorf("invalid allowed topology %+v: key and values are required", req)
+			}
+		}
+	}
+	return nil
+}
+
+// getVolumeTopology resolves the placement constraints for a volume. It
+// returns an error if the selected node does not satisfy any of the
+// allowed topologies or if the allowed topologies are malformed.
+func getVolumeTopology(node *v1.Node, allowed []v1.TopologySelectorTerm) (*volumeTopology, error) {
+	if err := validateAllowedTopologies(allowed); err != nil {
+		return nil, err
+	}
+
+	topology := &volumeTopology{allowed: allowed}
+	if node == nil {
+		// immediate binding, the volume can land anywhere within the
+		// allowed topologies
+		for _, term := range allowed {
+			topology.terms = append(topology.terms, toNodeSelectorTerm(term.MatchLabelExpressions))
+		}
+		if len(allowed) == 1 && len(allowed[0].MatchLabelExpressions) == 1 &&
+			len(allowed[0].MatchLabelExpressions[0].Values) == 1 {
+			topology.keyDomain = allowed[0].MatchLabelExpressions[0].Key
+			topology.keyType = allowed[0].MatchLabelExpressions[0].Values[0]
+		}
+		return topology, nil
+	}
+
+	topology.selectedNode = node.Name
+	if len(allowed) == 0 {
+		hostname, ok := node.Labels[v1.LabelHostname]
+		if !ok {
+			hostname = node.Name
+		}
+		topology.keyDomain = v1.LabelHostname
+		topology.keyType = hostname
+		topology.terms = []v1.NodeSelectorTerm{
+			toNodeSelectorTerm([]v1.TopologySelectorLabelRequirement{
+				{Key: v1.LabelHostname, Values: []string{hostname}},
+			}),
+		}
+		return topology, nil
+	}
+
+	for _, term := range allowed {
+		reqs, ok := matchNodeTopology(node, term)
+		if !ok {
+			continue
+		}
+		// terms have at most one requirement, see validateAllowedTopologies
+		if len(reqs) > 0 {
+			topology.keyDomain = reqs[0].Key
+			topology.keyType = reqs[0].Values[0]
+		}
+		topology.terms = []v1.NodeSelectorTerm{toNodeSelectorTerm(reqs)}
+		return topology, nil
+	}
+	return nil, fmt.Errorf("selected node %q does not satisfy any of the allowed topologies", node.Name)
+}
+
+// matchNodeTopology checks whether the node labels satisfy every requirement
+// of the given term. On success it returns the requirements narrowed down
+// to the label values of the node.
+func matchNodeTopology(node *v1.Node, term v1.TopologySelectorTerm) ([]v1.TopologySelectorLabelRequirement, bool) {
+	var reqs []v1.TopologySelectorLabelRequirement
+	for _, req := range term.MatchLabelExpressions {
+		value, ok := node.Labels[req.Key]
+		if !ok || !containsString(req.Values, value) {
+			return nil, false
+		}
+		reqs = append(reqs, v1.TopologySelectorLabelRequirement{Key: req.Key, Values: []string{value}})
+	}
+	return reqs, true
+}
+
+func toNodeSelectorTerm(reqs []v1.TopologySelectorLabelRequirement) v1.NodeSelectorTerm {
+	term := v1.NodeSelectorTerm{}
+	for _, req := range reqs {
+		term.MatchExpressions = append(term.MatchExpressions, v1.NodeSelectorRequirement{
+			Key:      req.Key,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   req.Values,
+		})
+	}
+	return term
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// setCASVolumeTopology passes the placement constraints on to maya-apiserver
+// through the labels and annotations of the cas volume
+func setCASVolumeTopology(casVolume *v1alpha1.CASVolume, topology *volumeTopology) error {
+	if topology.selectedNode != "" {
+		casVolume.Labels[string(v1alpha1.SelectedNodeKey)] = topology.selectedNode
+	}
+	if topology.keyDomain != "" {
+		casVolume.Labels[mayav1.PVCLabelsReplicaTopKeyDomain] = topology.keyDomain
+		casVolume.Labels[mayav1.PVCLabelsReplicaTopKeyType] = topology.keyType
+	}
+	if len(topology.allowed) == 0 {
+		return nil
+	}
+	allowed, err := json.Marshal(topology.allowed)
+	if err != nil {
+		return err
+	}
+	if casVolume.Annotations == nil {
+		casVolume.Annotations = make(map[string]string)
+	}
+	casVolume.Annotations[string(v1alpha1.AllowedTopologiesKey)] = string(allowed)
+	return nil
+}
+
+// getNodeAffinity returns the node affinity of the PV for the given
+// placement constraints, nil if the volume is not restricted
+func getNodeAffinity(topology *volumeTopology) *v1.VolumeNodeAffinity {
+	if len(topology.terms) == 0 {
+		return nil
+	}
+	return &v1.VolumeNodeAffinity{
+		Required: &v1.NodeSelector{
+			NodeSelectorTerms: topology.terms,
+		},
+	}
+}
@@ -0,0 +1,108 @@
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func fakeNode(name string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func zoneTerm(zones ...string) v1.TopologySelectorTerm {
+	return v1.TopologySelectorTerm{
+		MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{
+			{Key: v1.LabelZoneFailureDomainStable, Values: zones},
+		},
+	}
+}
+
+func TestGetVolumeTopology(t *testing.T) {
+	cases := map[string]struct {
+		node         *v1.Node
+		allowed      []v1.TopologySelectorTerm
+		expectErr    bool
+		expectDomain string
+		expectType   string
+		expectTerms  int
+	}{
+		"Immediate binding without allowed topologies": {nil, nil, false, "", "", 0},
+		"Immediate binding with single zone":           {nil, []v1.TopologySelectorTerm{zoneTerm("zone-a")}, false, v1.LabelZoneFailureDomainStable, "zone-a", 1},
+		"Immediate binding with multiple zones":        {nil, []v1.TopologySelectorTerm{zoneTerm("zone-a", "zone-b")}, false, "", "", 1},
+		"Selected node without allowed topologies": {
+			fakeNode("node-1", map[string]string{v1.LabelHostname: "host-1"}),
+			nil, false, v1.LabelHostname, "host-1", 1,
+		},
+		"Selected node within allowed topologies": {
+			fakeNode("node-1", map[string]string{v1.LabelZoneFailureDomainStable: "zone-b"}),
+			[]v1.TopologySelectorTerm{zoneTerm("zone-a"), zoneTerm("zone-b", "zone-c")},
+			false, v1.LabelZoneFailureDomainStable, "zone-b", 1,
+		},
+		"Selected node outside allowed topologies": {
+			fakeNode("node-1", map[string]string{v1.LabelZoneFailureDomainStable: "zone-d"}),
+			[]v1.TopologySelectorTerm{zoneTerm("zone-a")},
+			true, "", "", 0,
+		},
+		"Allowed topology without values": {nil, []v1.TopologySelectorTerm{zoneTerm()}, true, "", "", 0},
+		"Allowed topology with several keys": {
+			fakeNode("node-1", map[string]string{v1.LabelZoneFailureDomainStable: "zone-a", v1.LabelHostname: "host-1"}),
+			[]v1.TopologySelectorTerm{{MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{
+				{Key: v1.LabelZoneFailureDomainStable, Values: []string{"zone-a"}},
+				{Key: v1.LabelHostname, Values: []string{"host-1"}},
+			}}},
+			true, "", "", 0,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			topology, err := getVolumeTopology(tc.node, tc.allowed)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if topology.keyDomain != tc.expectDomain || topology.keyType != tc.expectType {
+				t.Errorf("Expected %s=%s, got %s=%s", tc.expectDomain, tc.expectType, topology.keyDomain, topology.keyType)
+			}
+			if len(topology.terms) != tc.expectTerms {
+				t.Errorf("Expected %d node selector terms, got %d", tc.expectTerms, len(topology.terms))
+			}
+		})
+	}
+}
+
+func TestGetNodeAffinity(t *testing.T) {
+	topology, err := getVolumeTopology(
+		fakeNode("node-1", map[string]string{v1.LabelZoneFailureDomainStable: "zone-a"}),
+		[]v1.TopologySelectorTerm{zoneTerm("zone-a", "zone-b")},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := &v1.VolumeNodeAffinity{
+		Required: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelZoneFailureDomainStable, Operator: v1.NodeSelectorOpIn, Values: []string{"zone-a"}},
+					},
+				},
+			},
+		},
+	}
+	if got := getNodeAffinity(topology); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %#v, got %#v", expect, got)
+	}
+	if got := getNodeAffinity(&volumeTopology{}); got != nil {
+		t.Errorf("Expected no node affinity, got %#v", got)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	mayav1 "github.com/openebs/openebs-k8s-provisioner/types/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v7/controller"
+)
+
+// validateProvisionOptions runs the preflight checks of a provision request.
+// It is invoked before any call is made to maya-apiserver so that a request
+// that can never succeed does not leave a volume behind in the backend.
+func validateProvisionOptions(options controller.ProvisionOptions) error {
+	if options.PVC == nil {
+		return fmt.Errorf("claim is missing")
+	}
+	if options.StorageClass == nil {
+		return fmt.Errorf("claim has no storage class")
+	}
+	if className := GetStorageClassName(options); className == nil || *className == "" {
+		return fmt.Errorf("claim has no storage class specified")
+	}
+	if options.PVC.Spec.Selector != nil {
+		return fmt.Errorf("claim selector is not supported")
+	}
+	if err := validateAccessModes(options.PVC.Spec.AccessModes); err != nil {
+		return err
+	}
+	if err := validateVolumeMode(options.PVC.Spec.VolumeMode); err != nil {
+		return err
+	}
+	if err := validateVolumeSize(options.PVC.Spec.Resources.Requests[v1.ResourceStorage]); err != nil {
+		return err
+	}
+	if err := validateAllowedTopologies(options.StorageClass.AllowedTopologies); err != nil {
+		return err
+	}
+	if _, err := getCASConfig(options.StorageClass.Parameters, options.PVC); err != nil {
+		return err
+	}
+	if err := validateFinalSnapshotParameters(options.StorageClass.Parameters); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateAccessModes(accessModes []v1.PersistentVolumeAccessMode) error {
+	for _, accessMode := range accessModes {
+		if accessMode != v1.ReadWriteOnce {
+			return fmt.Errorf("access mode %s is not supported, only support ReadWriteOnce access mode", accessMode)
+		}
+	}
+	return nil
+}
+
+func validateVolumeMode(volumeMode *v1.PersistentVolumeMode) error {
+	if volumeMode == nil {
+		return nil
+	}
+	switch *volumeMode {
+	case v1.PersistentVolumeFilesystem, v1.PersistentVolumeBlock:
+		return nil
+	}
+	return fmt.Errorf("volume mode %s is not supported", *volumeMode)
+}
+
+// validateVolumeSize checks the requested size against the limits set
+// through the OPENEBS_IO_MIN_VOLUME_SIZE and OPENEBS_IO_MAX_VOLUME_SIZE
+// environment variables
+func validateVolumeSize(size resource.Quantity) error {
+	if size.Sign() <= 0 {
+		return fmt.Errorf("claim has no storage size requested")
+	}
+	if min, ok := getVolumeSizeLimit(mayav1.MinVolumeSizeENVK, mayav1.MinVolumeSizeENV()); ok && size.Cmp(min) < 0 {
+		return fmt.Errorf("requested size %s is less than the minimum size %s", size.String(), min.String())
+	}
+	if max, ok := getVolumeSizeLimit(mayav1.MaxVolumeSizeENVK, mayav1.MaxVolumeSizeENV()); ok && size.Cmp(max) > 0 {
+		return fmt.Errorf("requested size %s is more than the maximum size %s", size.String(), max.String())
+	}
+	return nil
+}
+
+func getVolumeSizeLimit(key mayav1.ENVKey, value string) (resource.Quantity, bool) {
+	if value == "" {
+		return resource.Quantity{}, false
+	}
+	limit, err := resource.ParseQuantity(value)
+	if err != nil {
+		glog.Warningf("Ignoring invalid value %q of %s: %v", value, key, err)
+		return resource.Quantity{}, false
+	}
+	return limit, true
+}
@@ -0,0 +1,76 @@
+package provisioner
+
+import (
+	"os"
+	"testing"
+
+	mayav1 "github.com/openebs/openebs-k8s-provisioner/types/v1"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v7/controller"
+)
+
+func TestValidateProvisionOptions(t *testing.T) {
+	os.Setenv(string(mayav1.MinVolumeSizeENVK), "1Gi")
+	os.Setenv(string(mayav1.MaxVolumeSizeENVK), "10Gi")
+	defer os.Unsetenv(string(mayav1.MinVolumeSizeENVK))
+	defer os.Unsetenv(string(mayav1.MaxVolumeSizeENVK))
+
+	block := v1.PersistentVolumeBlock
+	unknown := v1.PersistentVolumeMode("Unknown")
+	cases := map[string]struct {
+		modify    func(*v1.PersistentVolumeClaim, *storagev1.StorageClass)
+		expectErr bool
+	}{
+		"Valid claim":           {func(*v1.PersistentVolumeClaim, *storagev1.StorageClass) {}, false},
+		"Valid block claim":     {func(pvc *v1.PersistentVolumeClaim, _ *storagev1.StorageClass) { pvc.Spec.VolumeMode = &block }, false},
+		"Unsupported mode":      {func(pvc *v1.PersistentVolumeClaim, _ *storagev1.StorageClass) { pvc.Spec.VolumeMode = &unknown }, true},
+		"No storage class name": {func(pvc *v1.PersistentVolumeClaim, _ *storagev1.StorageClass) { pvc.Spec.StorageClassName = nil }, true},
+		"ReadWriteMany access mode": {func(pvc *v1.PersistentVolumeClaim, _ *storagev1.StorageClass) {
+			pvc.Spec.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}
+		}, true},
+		"Selector": {func(pvc *v1.PersistentVolumeClaim, _ *storagev1.StorageClass) {
+			pvc.Spec.Selector = &metav1.LabelSelector{}
+		}, true},
+		"No size": {func(pvc *v1.PersistentVolumeClaim, _ *storagev1.StorageClass) {
+			pvc.Spec.Resources.Requests = nil
+		}, true},
+		"Below minimum size": {func(pvc *v1.PersistentVolumeClaim, _ *storagev1.StorageClass) {
+			pvc.Spec.Resources.Requests[v1.ResourceStorage] = resource.MustParse("512Mi")
+		}, true},
+		"Above maximum size": {func(pvc *v1.PersistentVolumeClaim, _ *storagev1.StorageClass) {
+			pvc.Spec.Resources.Requests[v1.ResourceStorage] = resource.MustParse("20Gi")
+		}, true},
+		"Invalid cas config": {func(_ *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) {
+			sc.Parameters = map[string]string{"ReplicaCount": "0"}
+		}, true},
+		"Allowed topology with several keys": {func(_ *v1.PersistentVolumeClaim, sc *storagev1.StorageClass) {
+			sc.AllowedTopologies = []v1.TopologySelectorTerm{{MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{
+				{Key: v1.LabelZoneFailureDomainStable, Values: []string{"zone-a"}},
+				{Key: v1.LabelHostname, Values: []string{"host-1"}},
+			}}}
+		}, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			className := "openebs-jiva-default"
+			pvc := &v1.PersistentVolumeClaim{
+				Spec: v1.PersistentVolumeClaimSpec{
+					StorageClassName: &className,
+					AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("5Gi")},
+					},
+				},
+			}
+			sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: className}}
+			tc.modify(pvc, sc)
+			err := validateProvisionOptions(controller.ProvisionOptions{PVC: pvc, StorageClass: sc})
+			if (err != nil) != tc.expectErr {
+				t.Errorf("Expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// serviceAccountNamespaceFile holds the namespace of the pod
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// SyncedCheck returns a readiness check passing once all the informers
+// synced
+func SyncedCheck(hasSynced ...func() bool) func() error {
+	return func() error {
+		for _, synced := range hasSynced {
+			if !synced() {
+				return errors.New("the informer caches are not synced")
+			}
+		}
+		return nil
+	}
+}
+
+// LeaderCheck returns a readiness check passing while a replica of the
+// provisioner of the name holds its leader election lock, the lock is the
+// endpoints the external provisioner library elects its leader through
+func LeaderCheck(client kubernetes.Interface, provisionerName string) func() error {
+	namespace := leaderElectionNamespace()
+	name := strings.Replace(provisionerName, "/", "-", -1)
+	return func() error {
+		lock, err := resourcelock.New(resourcelock.EndpointsResourceLock, namespace, name,
+			client.CoreV1(), nil, resourcelock.ResourceLockConfig{})
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		record, _, err := lock.Get(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the leader election lock %s/%s: %v", namespace, name, err)
+		}
+		if record.HolderIdentity == "" {
+			return fmt.Errorf("no leader holds the lock %s/%s", namespace, name)
+		}
+		expiry := record.RenewTime.Add(time.Duration(record.LeaseDurationSeconds) * time.Second)
+		if time.Now().After(expiry) {
+			return fmt.Errorf("the lease of the leader %s expired at %s", record.HolderIdentity, expiry.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+// leaderElectionNamespace returns the namespace of the leader election lock,
+// the one the external provisioner library uses
+func leaderElectionNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := ioutil.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package server implements the HTTP server the openebs binaries share to
+// serve their liveness and readiness endpoints, their metrics and their
+// debug endpoints.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// shutdownTimeout is the time the server gives the running requests to
+	// finish once it stops
+	shutdownTimeout = 5 * time.Second
+)
+
+// Config holds the settings of the server
+type Config struct {
+	// Address to listen on, e.g. :9500
+	Address string
+	// EnablePprof serves the pprof endpoints under /debug/pprof/
+	EnablePprof bool
+}
+
+// Server serves:
+//
+//	/healthz        200 as long as the process serves requests
+//	/readyz         200 if all the readiness checks pass, 503 otherwise, the
+//	                results of the checks are listed with ?verbose
+//	/debug/state    the JSON of the registered states
+//	/debug/pprof/   the pprof endpoints if enabled
+//
+// and the handlers added with Handle.
+type Server struct {
+	config Config
+	mux    *http.ServeMux
+
+	lock   sync.RWMutex
+	checks map[string]func() error
+	states map[string]func() interface{}
+}
+
+// New returns a server, it listens once Run is called
+func New(config Config) *Server {
+	s := &Server{
+		config: config,
+		mux:    http.NewServeMux(),
+		checks: map[string]func() error{},
+		states: map[string]func() interface{}{},
+	}
+	s.mux.HandleFunc("/healthz", s.serveHealthz)
+	s.mux.HandleFunc("/readyz", s.serveReadyz)
+	s.mux.HandleFunc("/debug/state", s.serveState)
+	if config.EnablePprof {
+		s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+		s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return s
+}
+
+// Handle registers the handler for the pattern, e.g. the metrics handler
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AddReadyCheck adds the readiness check of the name, the server is ready
+// when all the checks return nil
+func (s *Server) AddReadyCheck(name string, check func() error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.checks[name] = check
+}
+
+// SetReady sets the result of the readiness check of the name, for the
+// conditions that are reached once, e.g. the registration of the CRDs
+func (s *Server) SetReady(name string, err error) {
+	s.AddReadyCheck(name, func() error { return err })
+}
+
+// AddState adds the state of the name to /debug/state, state returns a
+// value that can be encoded into JSON
+func (s *Server) AddState(name string, state func() interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.states[name] = state
+}
+
+// Run serves the requests until stopCh is closed
+func (s *Server) Run(stopCh <-chan struct{}) error {
+	srv := &http.Server{Addr: s.config.Address, Handler: s.mux}
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			glog.Warningf("Failed to shut down the HTTP server: %v", err)
+		}
+	}()
+	glog.Infof("Serving HTTP on %s", s.config.Address)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP serves the request, the server is an http.Handler for the tests
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+func (s *Server) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprint(w, "ok")
+}
+
+func (s *Server) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	s.lock.RLock()
+	names := make([]string, 0, len(s.checks))
+	checks := make(map[string]func() error, len(s.checks))
+	for name, check := range s.checks {
+		names = append(names, name)
+		checks[name] = check
+	}
+	s.lock.RUnlock()
+	sort.Strings(names)
+
+	ready := true
+	var report []string
+	for _, name := range names {
+		if err := checks[name](); err != nil {
+			ready = false
+			report = append(report, fmt.Sprintf("[-]%s failed: %v", name, err))
+			continue
+		}
+		report = append(report, fmt.Sprintf("[+]%s ok", name))
+	}
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if _, verbose := req.URL.Query()["verbose"]; verbose || !ready {
+		for _, line := range report {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if ready {
+		fmt.Fprint(w, "ok")
+	} else {
+		fmt.Fprint(w, "not ready")
+	}
+}
+
+func (s *Server) serveState(w http.ResponseWriter, req *http.Request) {
+	s.lock.RLock()
+	states := make(map[string]func() interface{}, len(s.states))
+	for name, state := range s.states {
+		states[name] = state
+	}
+	s.lock.RUnlock()
+
+	dump := make(map[string]interface{}, len(states))
+	for name, state := range states {
+		dump[name] = state()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(dump); err != nil {
+		glog.Errorf("Failed to encode the debug state: %v", err)
+	}
+}
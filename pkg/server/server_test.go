@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func get(s *Server, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestHealthz(t *testing.T) {
+	s := New(Config{})
+	s.SetReady("crd", errors.New("not registered"))
+	if code, body := get(s, "/healthz"); code != http.StatusOK || body != "ok" {
+		t.Errorf("expected 200 ok, got %d %q", code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := map[string]struct {
+		checks   map[string]error
+		path     string
+		code     int
+		contains []string
+		excludes []string
+	}{
+		"no check": {
+			path: "/readyz",
+			code: http.StatusOK,
+		},
+		"all checks pass": {
+			checks:   map[string]error{"crd": nil, "leader": nil},
+			path:     "/readyz",
+			code:     http.StatusOK,
+			excludes: []string{"[+]crd ok"},
+		},
+		"all checks pass, verbose": {
+			checks:   map[string]error{"crd": nil, "leader": nil},
+			path:     "/readyz?verbose",
+			code:     http.StatusOK,
+			contains: []string{"[+]crd ok", "[+]leader ok"},
+		},
+		"a check fails": {
+			checks:   map[string]error{"crd": nil, "leader": errors.New("no leader")},
+			path:     "/readyz",
+			code:     http.StatusServiceUnavailable,
+			contains: []string{"[+]crd ok", "[-]leader failed: no leader", "not ready"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(Config{})
+			for check, err := range test.checks {
+				s.SetReady(check, err)
+			}
+			code, body := get(s, test.path)
+			if code != test.code {
+				t.Errorf("expected %d, got %d: %s", test.code, code, body)
+			}
+			for _, c := range test.contains {
+				if !strings.Contains(body, c) {
+					t.Errorf("expected %q in %q", c, body)
+				}
+			}
+			for _, e := range test.excludes {
+				if strings.Contains(body, e) {
+					t.Errorf("unexpected %q in %q", e, body)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugState(t *testing.T) {
+	s := New(Config{})
+	s.AddState("controller", func() interface{} {
+		return map[string]int{"snapshots": 2}
+	})
+	code, body := get(s, "/debug/state")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	var state map[string]map[string]int
+	if err := json.Unmarshal([]byte(body), &state); err != nil {
+		t.Fatalf("failed to decode %q: %v", body, err)
+	}
+	if state["controller"]["snapshots"] != 2 {
+		t.Errorf("unexpected state %v", state)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	tests := map[string]struct {
+		enabled bool
+		code    int
+	}{
+		"disabled": {enabled: false, code: http.StatusNotFound},
+		"enabled":  {enabled: true, code: http.StatusOK},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New(Config{EnablePprof: test.enabled})
+			if code, _ := get(s, "/debug/pprof/"); code != test.code {
+				t.Errorf("expected %d, got %d", test.code, code)
+			}
+		})
+	}
+}
+
+func TestLeaderCheck(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		record    *resourcelock.LeaderElectionRecord
+		expectErr bool
+	}{
+		"no lock": {
+			expectErr: true,
+		},
+		"lease held": {
+			record: &resourcelock.LeaderElectionRecord{
+				HolderIdentity:       "node-1_abc",
+				LeaseDurationSeconds: 15,
+				RenewTime:            metav1.NewTime(now),
+			},
+		},
+		"lease expired": {
+			record: &resourcelock.LeaderElectionRecord{
+				HolderIdentity:       "node-1_abc",
+				LeaseDurationSeconds: 15,
+				RenewTime:            metav1.NewTime(now.Add(-time.Minute)),
+			},
+			expectErr: true,
+		},
+		"lease released": {
+			record: &resourcelock.LeaderElectionRecord{
+				LeaseDurationSeconds: 1,
+				RenewTime:            metav1.NewTime(now),
+			},
+			expectErr: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			os.Setenv("POD_NAMESPACE", "openebs")
+			defer os.Unsetenv("POD_NAMESPACE")
+			client := fake.NewSimpleClientset()
+			if test.record != nil {
+				data, err := json.Marshal(test.record)
+				if err != nil {
+					t.Fatal(err)
+				}
+				client = fake.NewSimpleClientset(&v1.Endpoints{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "openebs",
+						Name:      "openebs.io-provisioner-iscsi",
+						Annotations: map[string]string{
+							resourcelock.LeaderElectionRecordAnnotationKey: string(data),
+						},
+					},
+				})
+			}
+			err := LeaderCheck(client, "openebs.io/provisioner-iscsi")()
+			if test.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", test.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing sets up the OpenTelemetry tracing of the openebs binaries
+// and holds the helpers their packages start their spans with.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/golang/glog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracerName is the name of the tracer of the spans of the openebs
+	// binaries
+	TracerName = "github.com/openebs/openebs-k8s-provisioner"
+
+	// ExporterNone disables the tracing
+	ExporterNone = ""
+	// ExporterOTLP exports the spans to an OTLP/HTTP collector
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes the spans to stdout
+	ExporterStdout = "stdout"
+)
+
+// Config holds the settings of the tracing
+type Config struct {
+	// ServiceName is the name of the binary in the spans
+	ServiceName string
+	// Exporter is one of ExporterNone, ExporterOTLP and ExporterStdout
+	Exporter string
+	// Endpoint is the URL of the OTLP/HTTP collector, e.g.
+	// http://otel-collector:4318, the requests are not encrypted if its
+	// scheme is http
+	Endpoint string
+}
+
+// Setup sets the global tracer provider and the propagator of the trace
+// context through the HTTP headers. It returns the function flushing the
+// spans at shutdown.
+func Setup(config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		options, err = otlpOptions(config.Endpoint)
+		if err == nil {
+			exporter, err = otlptracehttp.New(context.Background(), options...)
+		}
+	default:
+		err = fmt.Errorf("unknown exporter %q, expected %q or %q", config.Exporter, ExporterOTLP, ExporterStdout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(config.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	glog.Infof("Exporting the traces of %s through %s", config.ServiceName, config.Exporter)
+	return provider.Shutdown, nil
+}
+
+// otlpOptions returns the options of the OTLP exporter of the endpoint
+func otlpOptions(endpoint string) ([]otlptracehttp.Option, error) {
+	if endpoint == "" {
+		// the exporter reads OTEL_EXPORTER_OTLP_ENDPOINT
+		return nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, expected a URL such as http://otel-collector:4318", endpoint)
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+	return options, nil
+}
+
+// Start starts the span of the name, a child of the span of ctx if any
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End ends the span, recording err as its error if not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setupInMemory sets the global tracer provider to one recording the spans
+// in the returned exporter
+func setupInMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+}
+
+func TestTransport(t *testing.T) {
+	exporter := setupInMemory()
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	ctx, parent := Start(context.Background(), "parent")
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/latest/volumes/", nil)
+	resp, err := (&http.Client{Transport: NewTransport(nil)}).Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+	End(parent, nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	client := spans[0]
+	if client.Name != "HTTP GET" {
+		t.Errorf("span name = %q, want %q", client.Name, "HTTP GET")
+	}
+	if client.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("span parent = %v, want %v", client.Parent.SpanID(), parent.SpanContext().SpanID())
+	}
+	if client.Status.Code != codes.Error {
+		t.Errorf("span status = %v, want %v", client.Status.Code, codes.Error)
+	}
+	want := "00-" + client.SpanContext.TraceID().String() + "-" + client.SpanContext.SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("traceparent header = %q, want %q", traceparent, want)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want codes.Code
+	}{
+		"success": {want: codes.Unset},
+		"error":   {err: errors.New("failed"), want: codes.Error},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			exporter := setupInMemory()
+			_, span := Start(context.Background(), name)
+			End(span, test.err)
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if spans[0].Status.Code != test.want {
+				t.Errorf("span status = %v, want %v", spans[0].Status.Code, test.want)
+			}
+			if test.err != nil && len(spans[0].Events) != 1 {
+				t.Errorf("got %d events, want the error event", len(spans[0].Events))
+			}
+		})
+	}
+}
+
+func Test_otlpOptions(t *testing.T) {
+	tests := map[string]struct {
+		endpoint    string
+		wantOptions int
+		wantErr     bool
+	}{
+		"empty":        {endpoint: "", wantOptions: 0},
+		"http":         {endpoint: "http://otel-collector:4318", wantOptions: 2},
+		"https":        {endpoint: "https://otel-collector:4318", wantOptions: 1},
+		"path":         {endpoint: "http://otel-collector:4318/custom/traces", wantOptions: 3},
+		"missing host": {endpoint: "otel-collector:4318", wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			options, err := otlpOptions(test.endpoint)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("otlpOptions() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if len(options) != test.wantOptions {
+				t.Errorf("got %d options, want %d", len(options), test.wantOptions)
+			}
+		})
+	}
+}
+
+func TestSetup(t *testing.T) {
+	tests := map[string]struct {
+		exporter string
+		endpoint string
+		wantErr  bool
+	}{
+		"none":             {exporter: ExporterNone},
+		"stdout":           {exporter: ExporterStdout},
+		"otlp":             {exporter: ExporterOTLP, endpoint: "http://localhost:4318"},
+		"invalid endpoint": {exporter: ExporterOTLP, endpoint: "otel-collector", wantErr: true},
+		"unknown":          {exporter: "jaeger", wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			shutdown, err := Setup(Config{ServiceName: "test", Exporter: test.exporter, Endpoint: test.endpoint})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Setup() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err == nil {
+				if err := shutdown(context.Background()); err != nil {
+					t.Errorf("shutdown() error = %v", err)
+				}
+			}
+		})
+	}
+}
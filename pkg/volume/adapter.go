@@ -0,0 +1,159 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
+)
+
+// pluginAdapter runs a Plugin as a PluginV2. A Plugin can not be stopped,
+// the context is only checked before the calls. The plugins that can be
+// stopped implement PluginV2 themselves.
+type pluginAdapter struct {
+	plugin Plugin
+}
+
+var _ PluginV2 = &pluginAdapter{}
+
+// AdaptPlugin returns the Plugin as a PluginV2. The plugin supports every
+// call unless it implements CapabilitiesPlugin, the errors of its
+// FindSnapshot are NotFound errors.
+func AdaptPlugin(plugin Plugin) PluginV2 {
+	return &pluginAdapter{plugin: plugin}
+}
+
+// unwrap returns the Plugin adapted by plugin, or plugin if it is not an
+// adapter
+func unwrap(plugin PluginV2) interface{} {
+	if adapter, ok := plugin.(*pluginAdapter); ok {
+		return adapter.plugin
+	}
+	return plugin
+}
+
+// SetKubeClient sets the client of the plugin if it is a KubeClientPlugin
+func SetKubeClient(plugin PluginV2, client kubernetes.Interface) {
+	if clientPlugin, ok := unwrap(plugin).(KubeClientPlugin); ok {
+		clientPlugin.SetKubeClient(client)
+	}
+}
+
+// RestoreNodeAffinity returns the node affinity of the volumes restored
+// from the snapshot if the plugin is a NodeAffinityPlugin, nil otherwise
+func RestoreNodeAffinity(ctx context.Context, plugin PluginV2, snapshotData *crdv1.VolumeSnapshotData) (*v1.VolumeNodeAffinity, error) {
+	if affinityPlugin, ok := unwrap(plugin).(NodeAffinityPlugin); ok {
+		return affinityPlugin.RestoreNodeAffinity(ctx, snapshotData)
+	}
+	return nil, nil
+}
+
+func (a *pluginAdapter) Init(cloud cloudprovider.Interface) {
+	a.plugin.Init(cloud)
+}
+
+func (a *pluginAdapter) Capabilities() Capabilities {
+	if capabilitiesPlugin, ok := a.plugin.(CapabilitiesPlugin); ok {
+		return capabilitiesPlugin.Capabilities()
+	}
+	return AllCapabilities
+}
+
+func (a *pluginAdapter) SnapshotCreate(ctx context.Context, snapshot *crdv1.VolumeSnapshot, pv *v1.PersistentVolume, tags map[string]string) (SnapshotResult, error) {
+	if err := ctx.Err(); err != nil {
+		return SnapshotResult{}, err
+	}
+	return NewSnapshotResult(a.plugin.SnapshotCreate(snapshot, pv, &tags))
+}
+
+func (a *pluginAdapter) SnapshotDelete(ctx context.Context, source *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.plugin.SnapshotDelete(source, pv)
+}
+
+func (a *pluginAdapter) SnapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (RestoreResult, error) {
+	if err := ctx.Err(); err != nil {
+		return RestoreResult{}, err
+	}
+	return NewRestoreResult(a.plugin.SnapshotRestore(snapshotData, pvc, pvName, parameters))
+}
+
+func (a *pluginAdapter) DescribeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (DescribeResult, error) {
+	if err := ctx.Err(); err != nil {
+		return DescribeResult{}, err
+	}
+	return NewDescribeResult(a.plugin.DescribeSnapshot(snapshotData))
+}
+
+func (a *pluginAdapter) FindSnapshot(ctx context.Context, tags map[string]string) (SnapshotResult, error) {
+	if err := ctx.Err(); err != nil {
+		return SnapshotResult{}, err
+	}
+	result, err := NewSnapshotResult(a.plugin.FindSnapshot(&tags))
+	if err != nil && ReasonForError(err) == "" {
+		// a Plugin fails FindSnapshot when it finds no snapshot
+		err = &Error{Reason: ReasonNotFound, Err: err}
+	}
+	return result, err
+}
+
+func (a *pluginAdapter) VolumeDelete(ctx context.Context, pv *v1.PersistentVolume) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.plugin.VolumeDelete(pv)
+}
+
+// NewSnapshotResult returns the SnapshotResult of the values returned by the
+// SnapshotCreate and FindSnapshot of a Plugin
+func NewSnapshotResult(source *crdv1.VolumeSnapshotDataSource, conditions *[]crdv1.VolumeSnapshotCondition, err error) (SnapshotResult, error) {
+	var result SnapshotResult
+	if source != nil {
+		result.Source = *source
+	}
+	if conditions != nil {
+		result.Conditions = *conditions
+	}
+	return result, err
+}
+
+// NewRestoreResult returns the RestoreResult of the values returned by the
+// SnapshotRestore of a Plugin
+func NewRestoreResult(source *v1.PersistentVolumeSource, labels map[string]string, err error) (RestoreResult, error) {
+	result := RestoreResult{Labels: labels}
+	if source != nil {
+		result.Source = *source
+	}
+	return result, err
+}
+
+// NewDescribeResult returns the DescribeResult of the values returned by the
+// DescribeSnapshot of a Plugin
+func NewDescribeResult(conditions *[]crdv1.VolumeSnapshotCondition, completed bool, err error) (DescribeResult, error) {
+	result := DescribeResult{Completed: completed}
+	if conditions != nil {
+		result.Conditions = *conditions
+	}
+	return result, err
+}
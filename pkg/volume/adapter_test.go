@@ -0,0 +1,120 @@
+package volume
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
+)
+
+// fakePlugin fails FindSnapshot with findErr and counts its calls
+type fakePlugin struct {
+	findErr error
+	calls   int
+	client  kubernetes.Interface
+}
+
+func (f *fakePlugin) Init(cloudprovider.Interface) {}
+
+func (f *fakePlugin) SnapshotCreate(*crdv1.VolumeSnapshot, *v1.PersistentVolume, *map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	f.calls++
+	return &crdv1.VolumeSnapshotDataSource{HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: "/snap"}}, nil, nil
+}
+
+func (f *fakePlugin) SnapshotDelete(*crdv1.VolumeSnapshotDataSource, *v1.PersistentVolume) error {
+	f.calls++
+	return nil
+}
+
+func (f *fakePlugin) SnapshotRestore(*crdv1.VolumeSnapshotData, *v1.PersistentVolumeClaim, string, map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
+	f.calls++
+	return nil, nil, nil
+}
+
+func (f *fakePlugin) DescribeSnapshot(*crdv1.VolumeSnapshotData) (*[]crdv1.VolumeSnapshotCondition, bool, error) {
+	f.calls++
+	return nil, true, nil
+}
+
+func (f *fakePlugin) FindSnapshot(*map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	f.calls++
+	return nil, nil, f.findErr
+}
+
+func (f *fakePlugin) VolumeDelete(*v1.PersistentVolume) error {
+	f.calls++
+	return nil
+}
+
+func (f *fakePlugin) SetKubeClient(client kubernetes.Interface) {
+	f.client = client
+}
+
+// noFindPlugin is a fakePlugin that can not find snapshots
+type noFindPlugin struct {
+	fakePlugin
+}
+
+func (f *noFindPlugin) Capabilities() Capabilities {
+	return Capabilities{SnapshotRestore: true, DescribeSnapshot: true, VolumeDelete: true}
+}
+
+func TestAdaptPlugin(t *testing.T) {
+	if capabilities := AdaptPlugin(&fakePlugin{}).Capabilities(); capabilities != AllCapabilities {
+		t.Errorf("Expected every capability, got %+v", capabilities)
+	}
+	if capabilities := AdaptPlugin(&noFindPlugin{}).Capabilities(); capabilities.FindSnapshot {
+		t.Errorf("Expected FindSnapshot not to be supported, got %+v", capabilities)
+	}
+
+	tests := map[string]struct {
+		findErr error
+		reason  ErrorReason
+	}{
+		"found":       {},
+		"not found":   {findErr: fmt.Errorf("Snapshot not found"), reason: ReasonNotFound},
+		"transient":   {findErr: NewTransientError("pool is busy"), reason: ReasonTransient},
+		"unsupported": {findErr: NewUnsupportedError("not supported"), reason: ReasonUnsupported},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			plugin := AdaptPlugin(&fakePlugin{findErr: test.findErr})
+			_, err := plugin.FindSnapshot(context.Background(), map[string]string{})
+			if (err == nil) != (test.findErr == nil) || ReasonForError(err) != test.reason {
+				t.Errorf("Expected error with reason %q, got %v", test.reason, err)
+			}
+		})
+	}
+}
+
+func TestAdaptPluginCanceled(t *testing.T) {
+	plugin := &fakePlugin{}
+	adapted := AdaptPlugin(plugin)
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err := adapted.SnapshotCreate(ctx, &crdv1.VolumeSnapshot{}, &v1.PersistentVolume{}, nil)
+	if err != nil || result.Source.HostPath == nil {
+		t.Fatalf("Unexpected result %+v, %v", result, err)
+	}
+	cancel()
+	if _, err := adapted.SnapshotCreate(ctx, &crdv1.VolumeSnapshot{}, &v1.PersistentVolume{}, nil); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if plugin.calls != 1 {
+		t.Errorf("Expected the plugin not to be called once the context is done, got %d calls", plugin.calls)
+	}
+}
+
+func TestSetKubeClient(t *testing.T) {
+	plugin := &fakePlugin{}
+	client := fake.NewSimpleClientset()
+	SetKubeClient(AdaptPlugin(plugin), client)
+	if plugin.client != client {
+		t.Errorf("Expected the client to be set on the adapted plugin")
+	}
+}
@@ -0,0 +1,506 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+const (
+	// EndpointENVK is the environment variable holding the controller socket
+	// of the CSI driver, as unix:///path or /path
+	EndpointENVK = "OPENEBS_IO_CSI_ENDPOINT"
+	// TimeoutENVK is the environment variable holding the timeout of the
+	// calls to the CSI driver
+	TimeoutENVK = "OPENEBS_IO_CSI_TIMEOUT"
+
+	defaultTimeout = time.Minute
+
+	// csiParameterPrefix prefixes the storage class parameters that are
+	// interpreted by the sidecars and are not passed to the driver
+	csiParameterPrefix = "csi.storage.k8s.io/"
+	fsTypeParameter    = csiParameterPrefix + "fstype"
+
+	uidTag         = "kubernetes.io/created-for/uid"
+	snapshotPrefix = "snapshot-"
+)
+
+type csiPlugin struct {
+	endpoint string
+	timeout  time.Duration
+
+	// the connection is made on first use, the driver may start after the
+	// snapshot controller
+	mutex        sync.Mutex
+	conn         *grpc.ClientConn
+	driver       string
+	capabilities map[csipbv1.ControllerServiceCapability_RPC_Type]bool
+	controller   csipbv1.ControllerClient
+	// topology is true if the volumes of the driver are not accessible
+	// from every node
+	topology bool
+
+	// kubeClient reads the secrets, the storage classes and the nodes of
+	// the restored volumes
+	kubeClient kubernetes.Interface
+}
+
+var _ volume.PluginV2 = &csiPlugin{}
+var _ volume.KubeClientPlugin = &csiPlugin{}
+
+// RegisterPlugin registers the volume plugin, its calls to the driver stop
+// when the context of the calls is done
+func RegisterPlugin() volume.PluginV2 {
+	p := &csiPlugin{
+		endpoint: os.Getenv(EndpointENVK),
+		timeout:  defaultTimeout,
+	}
+	if value := os.Getenv(TimeoutENVK); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			glog.Errorf("Ignoring invalid %s %q", TimeoutENVK, value)
+		} else {
+			p.timeout = timeout
+		}
+	}
+	return p
+}
+
+// GetPluginName gets the name of the volume plugin
+func GetPluginName() string {
+	return "csi"
+}
+
+func (p *csiPlugin) Init(_ cloudprovider.Interface) {
+}
+
+// SetKubeClient sets the client reading the secrets and the topology of the
+// restored volumes
+func (p *csiPlugin) SetKubeClient(client kubernetes.Interface) {
+	p.kubeClient = client
+}
+
+// Capabilities returns the optional calls the plugin supports, CSI snapshots can not be listed by their name, SnapshotCreate is
+// idempotent instead
+func (p *csiPlugin) Capabilities() volume.Capabilities {
+	capabilities := volume.AllCapabilities
+	capabilities.FindSnapshot = false
+	return capabilities
+}
+
+// connect connects to the driver and reads its name and capabilities
+func (p *csiPlugin) connect(ctx context.Context) (csipbv1.ControllerClient, string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.controller != nil {
+		return p.controller, p.driver, nil
+	}
+	if p.endpoint == "" {
+		return nil, "", fmt.Errorf("no CSI driver is configured, %s is not set", EndpointENVK)
+	}
+	// the socket path is dialed through the passthrough resolver, a unix://
+	// target would be handed to the dialer with its scheme by grpc >= 1.34
+	address := strings.TrimPrefix(p.endpoint, "unix://")
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, address,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", address)
+		}))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to CSI driver at %s: %v", p.endpoint, err)
+	}
+
+	identity := csipbv1.NewIdentityClient(conn)
+	info, err := identity.GetPluginInfo(ctx, &csipbv1.GetPluginInfoRequest{})
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("failed to get the name of CSI driver at %s: %v", p.endpoint, err)
+	}
+	topology, err := hasAccessibilityConstraints(ctx, identity)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("failed to get the plugin capabilities of CSI driver %s: %v", info.GetName(), err)
+	}
+	controller := csipbv1.NewControllerClient(conn)
+	rsp, err := controller.ControllerGetCapabilities(ctx, &csipbv1.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("failed to get the capabilities of CSI driver %s: %v", info.GetName(), err)
+	}
+	capabilities := make(map[csipbv1.ControllerServiceCapability_RPC_Type]bool)
+	for _, capability := range rsp.GetCapabilities() {
+		capabilities[capability.GetRpc().GetType()] = true
+	}
+	if !capabilities[csipbv1.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT] {
+		conn.Close()
+		return nil, "", fmt.Errorf("CSI driver %s does not support snapshots", info.GetName())
+	}
+	glog.Infof("Connected to CSI driver %s at %s", info.GetName(), p.endpoint)
+
+	p.conn = conn
+	p.driver = info.GetName()
+	p.capabilities = capabilities
+	p.controller = controller
+	p.topology = topology
+	return p.controller, p.driver, nil
+}
+
+// hasAccessibilityConstraints returns true if the volumes of the driver are
+// not accessible from every node, a driver not implementing
+// GetPluginCapabilities has no constraints
+func hasAccessibilityConstraints(ctx context.Context, identity csipbv1.IdentityClient) (bool, error) {
+	rsp, err := identity.GetPluginCapabilities(ctx, &csipbv1.GetPluginCapabilitiesRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, capability := range rsp.GetCapabilities() {
+		if capability.GetService().GetType() == csipbv1.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// controllerFor connects to the driver and checks it is the driver named
+// driver
+func (p *csiPlugin) controllerFor(ctx context.Context, driver string) (csipbv1.ControllerClient, error) {
+	controller, name, err := p.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if driver != name {
+		return nil, fmt.Errorf("volume of CSI driver %s can not be handled by the configured driver %s", driver, name)
+	}
+	return controller, nil
+}
+
+// context returns the context of a call to the driver, done at the latest
+// once the timeout of the calls has passed
+func (p *csiPlugin) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, p.timeout)
+}
+
+// SnapshotCreate calls CreateSnapshot of the driver. The name of the snapshot
+// is derived from the UID of the VolumeSnapshot, CreateSnapshot returns the
+// existing snapshot if it is called again.
+func (p *csiPlugin) SnapshotCreate(
+	ctx context.Context,
+	snapshot *crdv1.VolumeSnapshot,
+	pv *v1.PersistentVolume,
+	tags map[string]string,
+) (volume.SnapshotResult, error) {
+	spec := &pv.Spec
+	if spec == nil || spec.CSI == nil {
+		return volume.SnapshotResult{}, fmt.Errorf("invalid PV spec %v", spec)
+	}
+	controller, err := p.controllerFor(ctx, spec.CSI.Driver)
+	if err != nil {
+		return volume.SnapshotResult{}, err
+	}
+	uid := string(snapshot.Metadata.UID)
+	if tags[uidTag] != "" {
+		uid = tags[uidTag]
+	}
+	name := snapshotPrefix + uid
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	rsp, err := controller.CreateSnapshot(ctx, &csipbv1.CreateSnapshotRequest{
+		SourceVolumeId: spec.CSI.VolumeHandle,
+		Name:           name,
+	})
+	if err != nil {
+		glog.Errorf("failed to create snapshot of volume %v: %v", spec.CSI.VolumeHandle, err)
+		return volume.SnapshotResult{}, fmt.Errorf("failed to create snapshot of volume %s: %v", spec.CSI.VolumeHandle, err)
+	}
+	glog.V(1).Infof("snapshot %v of volume %v created", rsp.GetSnapshot().GetSnapshotId(), spec.CSI.VolumeHandle)
+
+	res := &crdv1.VolumeSnapshotDataSource{
+		CSISnapshot: &crdv1.CSIVolumeSnapshotSource{
+			Driver:             spec.CSI.Driver,
+			SnapshotHandle:     rsp.GetSnapshot().GetSnapshotId(),
+			SourceVolumeHandle: spec.CSI.VolumeHandle,
+			RestoreSize:        rsp.GetSnapshot().GetSizeBytes(),
+			SnapshotName:       name,
+		},
+	}
+	conditions, _ := snapshotConditions(rsp.GetSnapshot())
+	return volume.NewSnapshotResult(res, conditions, nil)
+}
+
+// snapshotConditions maps ready_to_use of the snapshot to a condition
+func snapshotConditions(snapshot *csipbv1.Snapshot) (*[]crdv1.VolumeSnapshotCondition, bool) {
+	if !snapshot.GetReadyToUse() {
+		return newConditions(crdv1.VolumeSnapshotConditionPending, "Snapshot is being processed by the CSI driver"), false
+	}
+	message := "Snapshot created successfully"
+	if size := snapshot.GetSizeBytes(); size > 0 {
+		message = fmt.Sprintf("Snapshot created successfully, %d bytes", size)
+	}
+	return newConditions(crdv1.VolumeSnapshotConditionReady, message), true
+}
+
+func newConditions(conditionType crdv1.VolumeSnapshotConditionType, message string) *[]crdv1.VolumeSnapshotCondition {
+	return &[]crdv1.VolumeSnapshotCondition{
+		{
+			Status:             v1.ConditionTrue,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+			Type:               conditionType,
+		},
+	}
+}
+
+// SnapshotDelete calls DeleteSnapshot of the driver, a snapshot that does
+// not exist is deleted successfully by the driver
+func (p *csiPlugin) SnapshotDelete(ctx context.Context, src *crdv1.VolumeSnapshotDataSource, _ *v1.PersistentVolume) error {
+	if src == nil || src.CSISnapshot == nil {
+		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", src)
+	}
+	controller, err := p.controllerFor(ctx, src.CSISnapshot.Driver)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	_, err = controller.DeleteSnapshot(ctx, &csipbv1.DeleteSnapshotRequest{SnapshotId: src.CSISnapshot.SnapshotHandle})
+	if err != nil {
+		return fmt.Errorf("failed to delete snapshot %s: %v", src.CSISnapshot.SnapshotHandle, err)
+	}
+	glog.V(1).Infof("snapshot %v deleted successfully", src.CSISnapshot.SnapshotHandle)
+	return nil
+}
+
+// DescribeSnapshot lists the snapshot and maps its ready_to_use field to a
+// condition. The snapshot is created again by a driver that can not list
+// snapshots, CreateSnapshot returns the existing snapshot.
+func (p *csiPlugin) DescribeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (volume.DescribeResult, error) {
+	if snapshotData == nil || snapshotData.Spec.CSISnapshot == nil {
+		return volume.DescribeResult{}, fmt.Errorf("failed to retrieve Snapshot spec")
+	}
+	src := snapshotData.Spec.CSISnapshot
+	controller, err := p.controllerFor(ctx, src.Driver)
+	if err != nil {
+		return volume.DescribeResult{}, err
+	}
+	if !p.capabilities[csipbv1.ControllerServiceCapability_RPC_LIST_SNAPSHOTS] {
+		conditions := snapshotData.Status.Conditions
+		if len(conditions) == 0 {
+			return volume.DescribeResult{}, fmt.Errorf("CSI driver %s can not list snapshots and snapshot %s has no condition", src.Driver, src.SnapshotHandle)
+		}
+		last := conditions[len(conditions)-1]
+		if last.Type == crdv1.VolumeSnapshotDataConditionReady {
+			return volume.NewDescribeResult(newConditions(crdv1.VolumeSnapshotConditionReady, last.Message), true, nil)
+		}
+		if src.SnapshotName == "" {
+			// taken before the name was recorded
+			return volume.NewDescribeResult(newConditions(crdv1.VolumeSnapshotConditionPending, last.Message), false, nil)
+		}
+		return p.createSnapshotAgain(ctx, controller, src)
+	}
+
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	rsp, err := controller.ListSnapshots(ctx, &csipbv1.ListSnapshotsRequest{SnapshotId: src.SnapshotHandle})
+	if err != nil {
+		return volume.DescribeResult{}, fmt.Errorf("failed to list snapshot %s: %v", src.SnapshotHandle, err)
+	}
+	for _, entry := range rsp.GetEntries() {
+		if entry.GetSnapshot().GetSnapshotId() == src.SnapshotHandle {
+			conditions, ready := snapshotConditions(entry.GetSnapshot())
+			return volume.NewDescribeResult(conditions, ready, nil)
+		}
+	}
+	return volume.NewDescribeResult(newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Snapshot %s not found", src.SnapshotHandle)), true, nil)
+}
+
+// createSnapshotAgain calls CreateSnapshot with the name and the source
+// volume of the snapshot to read its ready_to_use field
+func (p *csiPlugin) createSnapshotAgain(ctx context.Context, controller csipbv1.ControllerClient, src *crdv1.CSIVolumeSnapshotSource) (volume.DescribeResult, error) {
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	rsp, err := controller.CreateSnapshot(ctx, &csipbv1.CreateSnapshotRequest{
+		SourceVolumeId: src.SourceVolumeHandle,
+		Name:           src.SnapshotName,
+	})
+	if err != nil {
+		return volume.DescribeResult{}, fmt.Errorf("failed to describe snapshot %s: %v", src.SnapshotHandle, err)
+	}
+	if id := rsp.GetSnapshot().GetSnapshotId(); id != src.SnapshotHandle {
+		return volume.DescribeResult{}, fmt.Errorf("CSI driver %s returned snapshot %s for snapshot %s", src.Driver, id, src.SnapshotHandle)
+	}
+	conditions, ready := snapshotConditions(rsp.GetSnapshot())
+	return volume.NewDescribeResult(conditions, ready, nil)
+}
+
+// FindSnapshot is not supported, see Capabilities
+func (p *csiPlugin) FindSnapshot(ctx context.Context, tags map[string]string) (volume.SnapshotResult, error) {
+	return volume.SnapshotResult{}, volume.NewUnsupportedError("FindSnapshot is not supported by the %s plugin", GetPluginName())
+}
+
+// SnapshotRestore creates a volume named after the PV with the snapshot as
+// its content source. The secrets and the topology of the storage class
+// are resolved as by the external-provisioner.
+func (p *csiPlugin) SnapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (volume.RestoreResult, error) {
+	if snapshotData == nil || snapshotData.Spec.CSISnapshot == nil {
+		return volume.RestoreResult{}, fmt.Errorf("failed to retrieve Snapshot spec")
+	}
+	if pvc == nil {
+		return volume.RestoreResult{}, fmt.Errorf("failed to restore, no claim")
+	}
+	src := snapshotData.Spec.CSISnapshot
+	controller, err := p.controllerFor(ctx, src.Driver)
+	if err != nil {
+		return volume.RestoreResult{}, err
+	}
+
+	size := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	requiredBytes := size.Value()
+	if src.RestoreSize > requiredBytes {
+		requiredBytes = src.RestoreSize
+	}
+	capabilities, err := volumeCapabilities(pvc, parameters[fsTypeParameter])
+	if err != nil {
+		return volume.RestoreResult{}, err
+	}
+	driverParameters := make(map[string]string)
+	for key, value := range parameters {
+		if !strings.HasPrefix(key, csiParameterPrefix) {
+			driverParameters[key] = value
+		}
+	}
+	pvSource := &v1.CSIPersistentVolumeSource{
+		Driver: src.Driver,
+		FSType: parameters[fsTypeParameter],
+	}
+	if err := setSecretReferences(pvSource, parameters, pvName, pvc); err != nil {
+		return volume.RestoreResult{}, err
+	}
+	secrets, err := p.provisionerSecrets(ctx, parameters, pvName, pvc)
+	if err != nil {
+		return volume.RestoreResult{}, err
+	}
+	requirements, err := p.accessibilityRequirements(ctx, src.Driver, pvc)
+	if err != nil {
+		return volume.RestoreResult{}, err
+	}
+
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	rsp, err := controller.CreateVolume(ctx, &csipbv1.CreateVolumeRequest{
+		Name:                      pvName,
+		CapacityRange:             &csipbv1.CapacityRange{RequiredBytes: requiredBytes},
+		VolumeCapabilities:        capabilities,
+		Parameters:                driverParameters,
+		Secrets:                   secrets,
+		AccessibilityRequirements: requirements,
+		VolumeContentSource: &csipbv1.VolumeContentSource{
+			Type: &csipbv1.VolumeContentSource_Snapshot{
+				Snapshot: &csipbv1.VolumeContentSource_SnapshotSource{SnapshotId: src.SnapshotHandle},
+			},
+		},
+	})
+	if err != nil {
+		glog.Errorf("snapshot :%v restore failed, err:%v", src.SnapshotHandle, err)
+		return volume.RestoreResult{}, fmt.Errorf("failed to restore %s: %v", src.SnapshotHandle, err)
+	}
+	glog.V(1).Infof("snapshot %v restored successfully to volume %v", src.SnapshotHandle, rsp.GetVolume().GetVolumeId())
+
+	pvSource.VolumeHandle = rsp.GetVolume().GetVolumeId()
+	pvSource.VolumeAttributes = rsp.GetVolume().GetVolumeContext()
+	return volume.RestoreResult{
+		Source:       v1.PersistentVolumeSource{CSI: pvSource},
+		NodeAffinity: nodeAffinity(rsp.GetVolume().GetAccessibleTopology()),
+	}, nil
+}
+
+// volumeCapabilities returns the capabilities of the volume requested by
+// the claim
+func volumeCapabilities(pvc *v1.PersistentVolumeClaim, fsType string) ([]*csipbv1.VolumeCapability, error) {
+	var capabilities []*csipbv1.VolumeCapability
+	for _, mode := range pvc.Spec.AccessModes {
+		capability := &csipbv1.VolumeCapability{
+			AccessMode: &csipbv1.VolumeCapability_AccessMode{},
+		}
+		switch mode {
+		case v1.ReadWriteOnce:
+			capability.AccessMode.Mode = csipbv1.VolumeCapability_AccessMode_SINGLE_NODE_WRITER
+		case v1.ReadOnlyMany:
+			capability.AccessMode.Mode = csipbv1.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+		case v1.ReadWriteMany:
+			capability.AccessMode.Mode = csipbv1.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+		default:
+			return nil, fmt.Errorf("access mode %s is not supported", mode)
+		}
+		if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock {
+			capability.AccessType = &csipbv1.VolumeCapability_Block{Block: &csipbv1.VolumeCapability_BlockVolume{}}
+		} else {
+			capability.AccessType = &csipbv1.VolumeCapability_Mount{Mount: &csipbv1.VolumeCapability_MountVolume{FsType: fsType}}
+		}
+		capabilities = append(capabilities, capability)
+	}
+	if len(capabilities) == 0 {
+		return nil, fmt.Errorf("claim %s/%s has no access mode", pvc.Namespace, pvc.Name)
+	}
+	return capabilities, nil
+}
+
+// VolumeDelete calls DeleteVolume of the driver
+func (p *csiPlugin) VolumeDelete(ctx context.Context, pv *v1.PersistentVolume) error {
+	if pv == nil || pv.Spec.CSI == nil {
+		return fmt.Errorf("invalid PV: %v", pv)
+	}
+	controller, err := p.controllerFor(ctx, pv.Spec.CSI.Driver)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := p.context(ctx)
+	defer cancel()
+	_, err = controller.DeleteVolume(ctx, &csipbv1.DeleteVolumeRequest{VolumeId: pv.Spec.CSI.VolumeHandle})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete volume %s: %v", pv.Spec.CSI.VolumeHandle, err)
+	}
+	glog.V(1).Infof("volume %v deleted successfully", pv.Spec.CSI.VolumeHandle)
+	return nil
+}
@@ -0,0 +1,558 @@
+package csi
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+)
+
+const testDriver = "test.csi.openebs.io"
+
+// fakeDriver is a CSI driver keeping its snapshots and volumes in memory
+type fakeDriver struct {
+	csipbv1.UnimplementedIdentityServer
+	csipbv1.UnimplementedControllerServer
+
+	ready bool
+	// topology reports the VOLUME_ACCESSIBILITY_CONSTRAINTS capability
+	topology bool
+	// noList does not report the LIST_SNAPSHOTS capability
+	noList    bool
+	snapshots map[string]*csipbv1.Snapshot
+	volumes   map[string]*csipbv1.CreateVolumeRequest
+}
+
+func (f *fakeDriver) GetPluginInfo(context.Context, *csipbv1.GetPluginInfoRequest) (*csipbv1.GetPluginInfoResponse, error) {
+	return &csipbv1.GetPluginInfoResponse{Name: testDriver, VendorVersion: "test"}, nil
+}
+
+func (f *fakeDriver) GetPluginCapabilities(context.Context, *csipbv1.GetPluginCapabilitiesRequest) (*csipbv1.GetPluginCapabilitiesResponse, error) {
+	rsp := &csipbv1.GetPluginCapabilitiesResponse{}
+	if f.topology {
+		rsp.Capabilities = append(rsp.Capabilities, &csipbv1.PluginCapability{
+			Type: &csipbv1.PluginCapability_Service_{Service: &csipbv1.PluginCapability_Service{
+				Type: csipbv1.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+			}},
+		})
+	}
+	return rsp, nil
+}
+
+func (f *fakeDriver) ControllerGetCapabilities(context.Context, *csipbv1.ControllerGetCapabilitiesRequest) (*csipbv1.ControllerGetCapabilitiesResponse, error) {
+	var capabilities []*csipbv1.ControllerServiceCapability
+	rpcs := []csipbv1.ControllerServiceCapability_RPC_Type{
+		csipbv1.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csipbv1.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+	}
+	if !f.noList {
+		rpcs = append(rpcs, csipbv1.ControllerServiceCapability_RPC_LIST_SNAPSHOTS)
+	}
+	for _, rpc := range rpcs {
+		capabilities = append(capabilities, &csipbv1.ControllerServiceCapability{
+			Type: &csipbv1.ControllerServiceCapability_Rpc{Rpc: &csipbv1.ControllerServiceCapability_RPC{Type: rpc}},
+		})
+	}
+	return &csipbv1.ControllerGetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+func (f *fakeDriver) CreateSnapshot(_ context.Context, req *csipbv1.CreateSnapshotRequest) (*csipbv1.CreateSnapshotResponse, error) {
+	if snapshot, ok := f.snapshots[req.Name]; ok {
+		return &csipbv1.CreateSnapshotResponse{Snapshot: snapshot}, nil
+	}
+	snapshot := &csipbv1.Snapshot{
+		SnapshotId:     req.Name,
+		SourceVolumeId: req.SourceVolumeId,
+		SizeBytes:      1 << 30,
+		ReadyToUse:     f.ready,
+	}
+	f.snapshots[req.Name] = snapshot
+	return &csipbv1.CreateSnapshotResponse{Snapshot: snapshot}, nil
+}
+
+func (f *fakeDriver) ListSnapshots(_ context.Context, req *csipbv1.ListSnapshotsRequest) (*csipbv1.ListSnapshotsResponse, error) {
+	rsp := &csipbv1.ListSnapshotsResponse{}
+	if snapshot, ok := f.snapshots[req.SnapshotId]; ok {
+		rsp.Entries = append(rsp.Entries, &csipbv1.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+	return rsp, nil
+}
+
+func (f *fakeDriver) DeleteSnapshot(_ context.Context, req *csipbv1.DeleteSnapshotRequest) (*csipbv1.DeleteSnapshotResponse, error) {
+	delete(f.snapshots, req.SnapshotId)
+	return &csipbv1.DeleteSnapshotResponse{}, nil
+}
+
+func (f *fakeDriver) CreateVolume(_ context.Context, req *csipbv1.CreateVolumeRequest) (*csipbv1.CreateVolumeResponse, error) {
+	if _, ok := f.snapshots[req.GetVolumeContentSource().GetSnapshot().GetSnapshotId()]; !ok {
+		return nil, status.Error(codes.NotFound, "snapshot not found")
+	}
+	f.volumes[req.Name] = req
+	return &csipbv1.CreateVolumeResponse{
+		Volume: &csipbv1.Volume{
+			VolumeId:      "vol-" + req.Name,
+			CapacityBytes: req.CapacityRange.RequiredBytes,
+			VolumeContext: map[string]string{"pool": req.Parameters["pool"]},
+			// the volume is accessible from the requisite segments
+			AccessibleTopology: req.GetAccessibilityRequirements().GetRequisite(),
+		},
+	}, nil
+}
+
+func (f *fakeDriver) DeleteVolume(_ context.Context, req *csipbv1.DeleteVolumeRequest) (*csipbv1.DeleteVolumeResponse, error) {
+	for name := range f.volumes {
+		if "vol-"+name == req.VolumeId {
+			delete(f.volumes, name)
+			return &csipbv1.DeleteVolumeResponse{}, nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "volume not found")
+}
+
+// startDriver serves the driver on a socket in a temporary directory and
+// returns a plugin connected to it
+func startDriver(t *testing.T, driver *fakeDriver) *csiPlugin {
+	dir, err := ioutil.TempDir("", "csi")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	socket := filepath.Join(dir, "csi.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	csipbv1.RegisterIdentityServer(server, driver)
+	csipbv1.RegisterControllerServer(server, driver)
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		server.Stop()
+		os.RemoveAll(dir)
+	})
+	return &csiPlugin{endpoint: "unix://" + socket, timeout: 10 * time.Second}
+}
+
+func csiPV(driver string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: "vol-1"},
+			},
+		},
+	}
+}
+
+func TestSnapshotCreate(t *testing.T) {
+	tests := map[string]struct {
+		driver    string
+		ready     bool
+		condition crdv1.VolumeSnapshotConditionType
+	}{
+		"ready":        {driver: testDriver, ready: true, condition: crdv1.VolumeSnapshotConditionReady},
+		"processing":   {driver: testDriver, condition: crdv1.VolumeSnapshotConditionPending},
+		"other driver": {driver: "other.csi.openebs.io"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			driver := &fakeDriver{ready: test.ready, snapshots: map[string]*csipbv1.Snapshot{}}
+			plugin := startDriver(t, driver)
+			tags := map[string]string{uidTag: "uid-1"}
+			result, err := plugin.SnapshotCreate(context.Background(), &crdv1.VolumeSnapshot{}, csiPV(test.driver), tags)
+			if test.condition == "" {
+				if err == nil {
+					t.Errorf("Expected snapshot to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expected := crdv1.CSIVolumeSnapshotSource{
+				Driver:             testDriver,
+				SnapshotHandle:     "snapshot-uid-1",
+				SourceVolumeHandle: "vol-1",
+				RestoreSize:        1 << 30,
+				SnapshotName:       "snapshot-uid-1",
+			}
+			if *result.Source.CSISnapshot != expected {
+				t.Errorf("Expected %+v, got %+v", expected, *result.Source.CSISnapshot)
+			}
+			if result.Conditions[0].Type != test.condition {
+				t.Errorf("Expected condition %s, got %s", test.condition, result.Conditions[0].Type)
+			}
+			if len(driver.snapshots) != 1 {
+				t.Errorf("Expected one snapshot, got %d", len(driver.snapshots))
+			}
+		})
+	}
+}
+
+func TestDescribeSnapshot(t *testing.T) {
+	driver := &fakeDriver{snapshots: map[string]*csipbv1.Snapshot{
+		"snapshot-uid-1": {SnapshotId: "snapshot-uid-1", ReadyToUse: true},
+		"snapshot-uid-2": {SnapshotId: "snapshot-uid-2"},
+	}}
+	plugin := startDriver(t, driver)
+	tests := map[string]struct {
+		handle    string
+		condition crdv1.VolumeSnapshotConditionType
+		completed bool
+	}{
+		"ready":     {handle: "snapshot-uid-1", condition: crdv1.VolumeSnapshotConditionReady, completed: true},
+		"pending":   {handle: "snapshot-uid-2", condition: crdv1.VolumeSnapshotConditionPending},
+		"not found": {handle: "snapshot-uid-3", condition: crdv1.VolumeSnapshotConditionError, completed: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := &crdv1.VolumeSnapshotData{
+				Spec: crdv1.VolumeSnapshotDataSpec{
+					VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+						CSISnapshot: &crdv1.CSIVolumeSnapshotSource{Driver: testDriver, SnapshotHandle: test.handle},
+					},
+				},
+			}
+			result, err := plugin.DescribeSnapshot(context.Background(), data)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Conditions[0].Type != test.condition || result.Completed != test.completed {
+				t.Errorf("Expected %s/%v, got %s/%v", test.condition, test.completed, result.Conditions[0].Type, result.Completed)
+			}
+		})
+	}
+}
+
+func TestDescribeSnapshotWithoutList(t *testing.T) {
+	driver := &fakeDriver{noList: true, snapshots: map[string]*csipbv1.Snapshot{
+		"snapshot-uid-1": {SnapshotId: "snapshot-uid-1", SourceVolumeId: "vol-1", ReadyToUse: true},
+	}}
+	plugin := startDriver(t, driver)
+	tests := map[string]struct {
+		name      string
+		condition crdv1.VolumeSnapshotConditionType
+		completed bool
+	}{
+		"created again":  {name: "snapshot-uid-1", condition: crdv1.VolumeSnapshotConditionReady, completed: true},
+		"unknown name":   {condition: crdv1.VolumeSnapshotConditionPending},
+		"other snapshot": {name: "snapshot-uid-2"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := &crdv1.VolumeSnapshotData{
+				Spec: crdv1.VolumeSnapshotDataSpec{
+					VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+						CSISnapshot: &crdv1.CSIVolumeSnapshotSource{
+							Driver:             testDriver,
+							SnapshotHandle:     "snapshot-uid-1",
+							SourceVolumeHandle: "vol-1",
+							SnapshotName:       test.name,
+						},
+					},
+				},
+				Status: crdv1.VolumeSnapshotDataStatus{
+					Conditions: []crdv1.VolumeSnapshotDataCondition{{Type: crdv1.VolumeSnapshotDataConditionPending}},
+				},
+			}
+			result, err := plugin.DescribeSnapshot(context.Background(), data)
+			if test.condition == "" {
+				if err == nil {
+					t.Errorf("Expected describe to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Conditions[0].Type != test.condition || result.Completed != test.completed {
+				t.Errorf("Expected %s/%v, got %s/%v", test.condition, test.completed, result.Conditions[0].Type, result.Completed)
+			}
+		})
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	driver := &fakeDriver{
+		snapshots: map[string]*csipbv1.Snapshot{"snapshot-uid-1": {SnapshotId: "snapshot-uid-1", ReadyToUse: true}},
+		volumes:   map[string]*csipbv1.CreateVolumeRequest{},
+	}
+	plugin := startDriver(t, driver)
+	block := v1.PersistentVolumeBlock
+	tests := map[string]struct {
+		handle     string
+		mode       *v1.PersistentVolumeMode
+		request    string
+		required   int64
+		accessType string
+	}{
+		"filesystem":       {handle: "snapshot-uid-1", request: "2Gi", required: 2 << 30, accessType: "mount"},
+		"block":            {handle: "snapshot-uid-1", mode: &block, request: "2Gi", required: 2 << 30, accessType: "block"},
+		"smaller claim":    {handle: "snapshot-uid-1", request: "512Mi", required: 1 << 30, accessType: "mount"},
+		"missing snapshot": {handle: "snapshot-uid-2", request: "2Gi"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := &crdv1.VolumeSnapshotData{
+				Spec: crdv1.VolumeSnapshotDataSpec{
+					VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+						CSISnapshot: &crdv1.CSIVolumeSnapshotSource{Driver: testDriver, SnapshotHandle: test.handle, RestoreSize: 1 << 30},
+					},
+				},
+			}
+			pvc := &v1.PersistentVolumeClaim{
+				Spec: v1.PersistentVolumeClaimSpec{
+					AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+					VolumeMode:  test.mode,
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(test.request)},
+					},
+				},
+			}
+			parameters := map[string]string{"pool": "pool1", fsTypeParameter: "ext4"}
+			result, err := plugin.SnapshotRestore(context.Background(), data, pvc, "pv2", parameters)
+			if test.accessType == "" {
+				if err == nil {
+					t.Errorf("Expected restore to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			src := result.Source
+			if src.CSI.Driver != testDriver || src.CSI.VolumeHandle != "vol-pv2" || src.CSI.VolumeAttributes["pool"] != "pool1" {
+				t.Errorf("Unexpected source %+v", src.CSI)
+			}
+			req := driver.volumes["pv2"]
+			if req.CapacityRange.RequiredBytes != test.required {
+				t.Errorf("Expected %d bytes, got %d", test.required, req.CapacityRange.RequiredBytes)
+			}
+			if _, ok := req.Parameters[fsTypeParameter]; ok {
+				t.Errorf("Expected %s not to be passed to the driver", fsTypeParameter)
+			}
+			capability := req.VolumeCapabilities[0]
+			if (capability.GetBlock() != nil) != (test.accessType == "block") {
+				t.Errorf("Expected access type %s, got %v", test.accessType, capability.AccessType)
+			}
+			if capability.GetAccessMode().GetMode() != csipbv1.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
+				t.Errorf("Unexpected access mode %v", capability.GetAccessMode())
+			}
+		})
+	}
+}
+
+func restoreClaim(annotations map[string]string) *v1.PersistentVolumeClaim {
+	class := "csi"
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "claim1", Namespace: "ns1", Annotations: annotations},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &class,
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+	}
+}
+
+func restoreData() *crdv1.VolumeSnapshotData {
+	return &crdv1.VolumeSnapshotData{
+		Spec: crdv1.VolumeSnapshotDataSpec{
+			VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+				CSISnapshot: &crdv1.CSIVolumeSnapshotSource{Driver: testDriver, SnapshotHandle: "snapshot-uid-1"},
+			},
+		},
+	}
+}
+
+func TestSnapshotRestoreSecrets(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "claim1-secret", Namespace: "ns1"},
+		Data:       map[string][]byte{"key": []byte("value")},
+	}
+	tests := map[string]struct {
+		parameters map[string]string
+		secrets    map[string]string
+		nodeStage  *v1.SecretReference
+		fails      bool
+	}{
+		"templates": {
+			parameters: map[string]string{
+				"csi.storage.k8s.io/provisioner-secret-name":      "${pvc.name}-secret",
+				"csi.storage.k8s.io/provisioner-secret-namespace": "${pvc.namespace}",
+				"csi.storage.k8s.io/node-stage-secret-name":       "${pv.name}",
+				"csi.storage.k8s.io/node-stage-secret-namespace":  "system",
+			},
+			secrets:   map[string]string{"key": "value"},
+			nodeStage: &v1.SecretReference{Name: "pv2", Namespace: "system"},
+		},
+		"no secrets": {parameters: map[string]string{}},
+		"missing namespace": {
+			parameters: map[string]string{"csi.storage.k8s.io/provisioner-secret-name": "claim1-secret"},
+			fails:      true,
+		},
+		"unsupported template": {
+			parameters: map[string]string{
+				"csi.storage.k8s.io/node-publish-secret-name":      "${pvc.annotations['secret']}",
+				"csi.storage.k8s.io/node-publish-secret-namespace": "ns1",
+			},
+			fails: true,
+		},
+		"missing secret": {
+			parameters: map[string]string{
+				"csi.storage.k8s.io/provisioner-secret-name":      "other",
+				"csi.storage.k8s.io/provisioner-secret-namespace": "ns1",
+			},
+			fails: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			driver := &fakeDriver{
+				snapshots: map[string]*csipbv1.Snapshot{"snapshot-uid-1": {SnapshotId: "snapshot-uid-1", ReadyToUse: true}},
+				volumes:   map[string]*csipbv1.CreateVolumeRequest{},
+			}
+			plugin := startDriver(t, driver)
+			plugin.SetKubeClient(fake.NewSimpleClientset(secret))
+			result, err := plugin.SnapshotRestore(context.Background(), restoreData(), restoreClaim(nil), "pv2", test.parameters)
+			if test.fails {
+				if err == nil {
+					t.Errorf("Expected restore to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(driver.volumes["pv2"].Secrets, test.secrets) {
+				t.Errorf("Expected secrets %v, got %v", test.secrets, driver.volumes["pv2"].Secrets)
+			}
+			if !reflect.DeepEqual(result.Source.CSI.NodeStageSecretRef, test.nodeStage) {
+				t.Errorf("Expected node stage secret %v, got %v", test.nodeStage, result.Source.CSI.NodeStageSecretRef)
+			}
+			if result.Source.CSI.NodePublishSecretRef != nil {
+				t.Errorf("Unexpected node publish secret %v", result.Source.CSI.NodePublishSecretRef)
+			}
+		})
+	}
+}
+
+func TestSnapshotRestoreTopology(t *testing.T) {
+	const zoneKey = "topology.test.csi.openebs.io/zone"
+	class := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi"},
+		AllowedTopologies: []v1.TopologySelectorTerm{{
+			MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{{Key: zoneKey, Values: []string{"zone1", "zone2"}}},
+		}},
+	}
+	csiNode := &storagev1.CSINode{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec: storagev1.CSINodeSpec{
+			Drivers: []storagev1.CSINodeDriver{{Name: testDriver, NodeID: "node1", TopologyKeys: []string{zoneKey}}},
+		},
+	}
+	node := func(name, zone string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{zoneKey: zone}}}
+	}
+	tests := map[string]struct {
+		topology     bool
+		selectedNode string
+		zone         string
+		zones        []string
+		fails        bool
+	}{
+		"selected node":       {topology: true, selectedNode: "node1", zone: "zone2", zones: []string{"zone2"}},
+		"allowed topologies":  {topology: true, zones: []string{"zone1", "zone2"}},
+		"node not allowed":    {topology: true, selectedNode: "node1", zone: "zone3", fails: true},
+		"no topology support": {selectedNode: "node1", zone: "zone2"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			driver := &fakeDriver{
+				topology:  test.topology,
+				snapshots: map[string]*csipbv1.Snapshot{"snapshot-uid-1": {SnapshotId: "snapshot-uid-1", ReadyToUse: true}},
+				volumes:   map[string]*csipbv1.CreateVolumeRequest{},
+			}
+			plugin := startDriver(t, driver)
+			plugin.SetKubeClient(fake.NewSimpleClientset(class, csiNode, node("node1", test.zone)))
+			var annotations map[string]string
+			if test.selectedNode != "" {
+				annotations = map[string]string{selectedNodeAnnotation: test.selectedNode}
+			}
+			result, err := plugin.SnapshotRestore(context.Background(), restoreData(), restoreClaim(annotations), "pv2", map[string]string{})
+			if test.fails {
+				if err == nil {
+					t.Errorf("Expected restore to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var zones []string
+			for _, segment := range driver.volumes["pv2"].GetAccessibilityRequirements().GetRequisite() {
+				zones = append(zones, segment.Segments[zoneKey])
+			}
+			if !reflect.DeepEqual(zones, test.zones) {
+				t.Errorf("Expected requisite zones %v, got %v", test.zones, zones)
+			}
+			if test.zones == nil {
+				if result.NodeAffinity != nil {
+					t.Errorf("Unexpected node affinity %+v", result.NodeAffinity)
+				}
+				return
+			}
+			terms := result.NodeAffinity.Required.NodeSelectorTerms
+			if len(terms) != len(test.zones) || terms[0].MatchExpressions[0].Key != zoneKey || terms[0].MatchExpressions[0].Values[0] != test.zones[0] {
+				t.Errorf("Unexpected node affinity %+v", terms)
+			}
+		})
+	}
+}
+
+func TestVolumeDelete(t *testing.T) {
+	driver := &fakeDriver{volumes: map[string]*csipbv1.CreateVolumeRequest{"1": {}}}
+	plugin := startDriver(t, driver)
+	for i := 0; i < 2; i++ {
+		if err := plugin.VolumeDelete(context.Background(), csiPV(testDriver)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if len(driver.volumes) != 0 {
+		t.Errorf("Expected volume to be deleted")
+	}
+}
+
+func TestSnapshotCreateCanceled(t *testing.T) {
+	driver := &fakeDriver{ready: true, snapshots: map[string]*csipbv1.Snapshot{}}
+	plugin := startDriver(t, driver)
+	// connect first, the dial is made with the context of the first call
+	if _, err := plugin.controllerFor(context.Background(), testDriver); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := plugin.SnapshotCreate(ctx, &crdv1.VolumeSnapshot{}, csiPV(testDriver), map[string]string{uidTag: "uid-1"})
+	if err == nil {
+		t.Errorf("Expected the canceled snapshot to fail")
+	}
+	if len(driver.snapshots) != 0 {
+		t.Errorf("Expected the driver not to be called, got %d snapshots", len(driver.snapshots))
+	}
+}
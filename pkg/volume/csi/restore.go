@@ -0,0 +1,280 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// selectedNodeAnnotation is set on the claims by the scheduler once it
+	// picked the node of their pod, for the WaitForFirstConsumer storage
+	// classes
+	selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+
+	// the secrets are referenced by the storage class parameters
+	// csi.storage.k8s.io/<kind>-secret-name and
+	// csi.storage.k8s.io/<kind>-secret-namespace, as for the
+	// external-provisioner
+	secretNameSuffix      = "-secret-name"
+	secretNamespaceSuffix = "-secret-namespace"
+	provisionerSecretKind = "provisioner"
+)
+
+// pvSecretKinds are the kinds of the secrets the restored PV references, by
+// the field of the CSI source they are set on
+var pvSecretKinds = map[string]func(*v1.CSIPersistentVolumeSource) **v1.SecretReference{
+	"controller-publish": func(src *v1.CSIPersistentVolumeSource) **v1.SecretReference { return &src.ControllerPublishSecretRef },
+	"node-stage":         func(src *v1.CSIPersistentVolumeSource) **v1.SecretReference { return &src.NodeStageSecretRef },
+	"node-publish":       func(src *v1.CSIPersistentVolumeSource) **v1.SecretReference { return &src.NodePublishSecretRef },
+	"controller-expand":  func(src *v1.CSIPersistentVolumeSource) **v1.SecretReference { return &src.ControllerExpandSecretRef },
+}
+
+// secretReference returns the secret of the kind referenced by the
+// parameters, nil if there is none. The ${pv.name}, ${pvc.namespace} and
+// ${pvc.name} templates are resolved, the other templates of the
+// external-provisioner are not supported.
+func secretReference(parameters map[string]string, kind, pvName string, pvc *v1.PersistentVolumeClaim) (*v1.SecretReference, error) {
+	nameKey := csiParameterPrefix + kind + secretNameSuffix
+	namespaceKey := csiParameterPrefix + kind + secretNamespaceSuffix
+	name, hasName := parameters[nameKey]
+	namespace, hasNamespace := parameters[namespaceKey]
+	if !hasName && !hasNamespace {
+		return nil, nil
+	}
+	if !hasName || !hasNamespace {
+		return nil, fmt.Errorf("parameters %s and %s must be set together", nameKey, namespaceKey)
+	}
+	namespace, err := resolveTemplate(namespaceKey, namespace, strings.NewReplacer(
+		"${pv.name}", pvName,
+		"${pvc.namespace}", pvc.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	name, err = resolveTemplate(nameKey, name, strings.NewReplacer(
+		"${pv.name}", pvName,
+		"${pvc.namespace}", pvc.Namespace,
+		"${pvc.name}", pvc.Name))
+	if err != nil {
+		return nil, err
+	}
+	return &v1.SecretReference{Name: name, Namespace: namespace}, nil
+}
+
+func resolveTemplate(key, value string, replacer *strings.Replacer) (string, error) {
+	resolved := replacer.Replace(value)
+	if strings.Contains(resolved, "${") {
+		return "", fmt.Errorf("parameter %s %q has an unsupported template", key, value)
+	}
+	if resolved == "" {
+		return "", fmt.Errorf("parameter %s is empty", key)
+	}
+	return resolved, nil
+}
+
+// provisionerSecrets returns the content of the provisioner secret
+// referenced by the parameters, passed to CreateVolume
+func (p *csiPlugin) provisionerSecrets(ctx context.Context, parameters map[string]string, pvName string, pvc *v1.PersistentVolumeClaim) (map[string]string, error) {
+	ref, err := secretReference(parameters, provisionerSecretKind, pvName, pvc)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+	if p.kubeClient == nil {
+		return nil, fmt.Errorf("secret %s/%s can not be read, the plugin has no kubernetes client", ref.Namespace, ref.Name)
+	}
+	secret, err := p.kubeClient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+	secrets := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		secrets[key] = string(value)
+	}
+	return secrets, nil
+}
+
+// setSecretReferences sets the secrets referenced by the parameters on the
+// CSI source of the restored PV
+func setSecretReferences(src *v1.CSIPersistentVolumeSource, parameters map[string]string, pvName string, pvc *v1.PersistentVolumeClaim) error {
+	for kind, field := range pvSecretKinds {
+		ref, err := secretReference(parameters, kind, pvName, pvc)
+		if err != nil {
+			return err
+		}
+		*field(src) = ref
+	}
+	return nil
+}
+
+// accessibilityRequirements returns the topology the restored volume has to
+// be accessible from, nil if the driver does not support topology or the
+// volume is not restricted. The segment of the node selected by the
+// scheduler is required if any, the allowed topologies of the storage
+// class otherwise.
+func (p *csiPlugin) accessibilityRequirements(ctx context.Context, driver string, pvc *v1.PersistentVolumeClaim) (*csipbv1.TopologyRequirement, error) {
+	if !p.topology {
+		return nil, nil
+	}
+	if p.kubeClient == nil {
+		return nil, fmt.Errorf("the topology of the volume can not be read, the plugin has no kubernetes client")
+	}
+	var allowed []v1.TopologySelectorTerm
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		class, err := p.kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get storage class %s: %v", *pvc.Spec.StorageClassName, err)
+		}
+		allowed = class.AllowedTopologies
+	}
+
+	var segments []*csipbv1.Topology
+	if nodeName := pvc.Annotations[selectedNodeAnnotation]; nodeName != "" {
+		segment, err := p.nodeSegment(ctx, driver, nodeName)
+		if err != nil {
+			return nil, err
+		}
+		if len(allowed) > 0 && !segmentAllowed(segment, allowed) {
+			return nil, fmt.Errorf("selected node %s does not satisfy any of the allowed topologies", nodeName)
+		}
+		segments = []*csipbv1.Topology{segment}
+	} else {
+		for _, term := range allowed {
+			segments = append(segments, termSegments(term)...)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	return &csipbv1.TopologyRequirement{Requisite: segments, Preferred: segments}, nil
+}
+
+// nodeSegment returns the topology segment of the node, made of the labels
+// of the topology keys the driver registered on the node
+func (p *csiPlugin) nodeSegment(ctx context.Context, driver, nodeName string) (*csipbv1.Topology, error) {
+	csiNode, err := p.kubeClient.StorageV1().CSINodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CSINode %s: %v", nodeName, err)
+	}
+	var keys []string
+	for _, nodeDriver := range csiNode.Spec.Drivers {
+		if nodeDriver.Name == driver {
+			keys = nodeDriver.TopologyKeys
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("CSI driver %s has no topology keys on node %s", driver, nodeName)
+	}
+	node, err := p.kubeClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %v", nodeName, err)
+	}
+	segment := &csipbv1.Topology{Segments: make(map[string]string)}
+	for _, key := range keys {
+		value, ok := node.Labels[key]
+		if !ok {
+			return nil, fmt.Errorf("node %s has no label %s", nodeName, key)
+		}
+		segment.Segments[key] = value
+	}
+	return segment, nil
+}
+
+// segmentAllowed returns true if the segment satisfies every requirement of
+// one of the terms
+func segmentAllowed(segment *csipbv1.Topology, allowed []v1.TopologySelectorTerm) bool {
+	for _, term := range allowed {
+		matches := true
+		for _, req := range term.MatchLabelExpressions {
+			if !containsString(req.Values, segment.Segments[req.Key]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// termSegments returns the segments satisfying the term, one per
+// combination of the values of its requirements
+func termSegments(term v1.TopologySelectorTerm) []*csipbv1.Topology {
+	segments := []map[string]string{{}}
+	for _, req := range term.MatchLabelExpressions {
+		var next []map[string]string
+		for _, segment := range segments {
+			for _, value := range req.Values {
+				combined := map[string]string{req.Key: value}
+				for key, value := range segment {
+					combined[key] = value
+				}
+				next = append(next, combined)
+			}
+		}
+		segments = next
+	}
+	var topologies []*csipbv1.Topology
+	for _, segment := range segments {
+		if len(segment) > 0 {
+			topologies = append(topologies, &csipbv1.Topology{Segments: segment})
+		}
+	}
+	return topologies
+}
+
+// nodeAffinity returns the node affinity of a volume accessible from the
+// topologies, nil if it is accessible from every node
+func nodeAffinity(topologies []*csipbv1.Topology) *v1.VolumeNodeAffinity {
+	var terms []v1.NodeSelectorTerm
+	for _, topology := range topologies {
+		keys := make([]string, 0, len(topology.GetSegments()))
+		for key := range topology.GetSegments() {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		term := v1.NodeSelectorTerm{}
+		for _, key := range keys {
+			term.MatchExpressions = append(term.MatchExpressions, v1.NodeSelectorRequirement{
+				Key:      key,
+				Operator: v1.NodeSelectorOpIn,
+				Values:   []string{topology.GetSegments()[key]},
+			})
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	return &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: terms}}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
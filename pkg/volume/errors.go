@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorReason tells why a call to a volume plugin failed
+type ErrorReason string
+
+const (
+	// ReasonNotFound is the reason of the calls failing because the
+	// snapshot or the volume does not exist
+	ReasonNotFound ErrorReason = "NotFound"
+	// ReasonUnsupported is the reason of the calls the plugin does not
+	// support, for the volume or at all
+	ReasonUnsupported ErrorReason = "Unsupported"
+	// ReasonTransient is the reason of the calls that may succeed if they
+	// are retried
+	ReasonTransient ErrorReason = "Transient"
+)
+
+// Error is an error of a volume plugin with its reason
+type Error struct {
+	Reason ErrorReason
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error the reason is given to
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewNotFoundError returns an error with the NotFound reason
+func NewNotFoundError(format string, args ...interface{}) error {
+	return &Error{Reason: ReasonNotFound, Err: fmt.Errorf(format, args...)}
+}
+
+// NewUnsupportedError returns an error with the Unsupported reason
+func NewUnsupportedError(format string, args ...interface{}) error {
+	return &Error{Reason: ReasonUnsupported, Err: fmt.Errorf(format, args...)}
+}
+
+// NewTransientError returns an error with the Transient reason
+func NewTransientError(format string, args ...interface{}) error {
+	return &Error{Reason: ReasonTransient, Err: fmt.Errorf(format, args...)}
+}
+
+// ReasonForError returns the reason of the error, an empty reason if it
+// has none
+func ReasonForError(err error) ErrorReason {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+	return ""
+}
+
+// IsNotFound returns true if the error has the NotFound reason
+func IsNotFound(err error) bool {
+	return ReasonForError(err) == ReasonNotFound
+}
+
+// IsUnsupported returns true if the error has the Unsupported reason
+func IsUnsupported(err error) bool {
+	return ReasonForError(err) == ReasonUnsupported
+}
+
+// IsTransient returns true if the error has the Transient reason
+func IsTransient(err error) bool {
+	return ReasonForError(err) == ReasonTransient
+}
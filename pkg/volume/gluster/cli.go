@@ -0,0 +1,170 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gluster
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// snapshot states reported by gluster, a snapshot is started once it
+	// is activated
+	snapshotStarted = "Started"
+	snapshotStopped = "Stopped"
+
+	// descriptionSeparator separates the tags in the description of a
+	// snapshot
+	descriptionSeparator = ";"
+)
+
+var (
+	errNotFound   = errors.New("not found")
+	errNotStarted = errors.New("volume is not started")
+)
+
+// cliOutput is the output of the gluster CLI run with --xml
+type cliOutput struct {
+	XMLName  xml.Name  `xml:"cliOutput"`
+	OpRet    int       `xml:"opRet"`
+	OpErrno  int       `xml:"opErrno"`
+	OpErrstr string    `xml:"opErrstr"`
+	SnapInfo *snapInfo `xml:"snapInfo"`
+	SnapList *snapList `xml:"snapList"`
+}
+
+type snapList struct {
+	Count     int      `xml:"count"`
+	Snapshots []string `xml:"snapshot"`
+}
+
+type snapInfo struct {
+	Count     int            `xml:"count"`
+	Snapshots []snapshotInfo `xml:"snapshots>snapshot"`
+}
+
+// snapshotInfo is a snapshot as described by gluster snapshot info
+type snapshotInfo struct {
+	Name        string       `xml:"name"`
+	UUID        string       `xml:"uuid"`
+	Description string       `xml:"description"`
+	CreateTime  string       `xml:"createTime"`
+	SnapVolumes []snapVolume `xml:"snapVolume"`
+}
+
+type snapVolume struct {
+	Name         string `xml:"name"`
+	Status       string `xml:"status"`
+	OriginVolume struct {
+		Name string `xml:"name"`
+	} `xml:"originVolume"`
+}
+
+// status returns the status of the volume of the snapshot
+func (s *snapshotInfo) status() string {
+	if len(s.SnapVolumes) == 0 {
+		return ""
+	}
+	return s.SnapVolumes[0].Status
+}
+
+// tags returns the tags stored in the description of the snapshot
+func (s *snapshotInfo) tags() map[string]string {
+	return parseDescription(s.Description)
+}
+
+// parseCLIOutput parses the output of the gluster CLI and fails if the
+// command failed
+func parseCLIOutput(data []byte) (*cliOutput, error) {
+	out := &cliOutput{}
+	if err := xml.Unmarshal(data, out); err != nil {
+		return nil, fmt.Errorf("invalid output of gluster: %v", err)
+	}
+	if out.OpRet != 0 {
+		if strings.Contains(out.OpErrstr, "does not exist") {
+			return nil, errNotFound
+		}
+		if strings.Contains(out.OpErrstr, "is not in the started state") {
+			return nil, errNotStarted
+		}
+		return nil, fmt.Errorf("gluster failed with errno %d: %s", out.OpErrno, out.OpErrstr)
+	}
+	return out, nil
+}
+
+// run runs gluster with --xml and parses its output. The CLI exits with a
+// non zero code on a failed operation, the output tells the reason.
+func run(runner commandRunner, args ...string) (*cliOutput, error) {
+	data, err := runner.Run(append([]string{"--xml"}, args...)...)
+	if len(data) == 0 && err != nil {
+		return nil, err
+	}
+	out, parseErr := parseCLIOutput(data)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return out, err
+}
+
+// getSnapshotInfo returns the snapshot named name
+func getSnapshotInfo(runner commandRunner, name string) (*snapshotInfo, error) {
+	out, err := run(runner, "snapshot", "info", name)
+	if err != nil {
+		return nil, err
+	}
+	if out.SnapInfo == nil || len(out.SnapInfo.Snapshots) == 0 {
+		return nil, errNotFound
+	}
+	return &out.SnapInfo.Snapshots[0], nil
+}
+
+// listSnapshots returns the names of all the snapshots
+func listSnapshots(runner commandRunner) ([]string, error) {
+	out, err := run(runner, "snapshot", "list")
+	if err != nil {
+		return nil, err
+	}
+	if out.SnapList == nil {
+		return nil, nil
+	}
+	return out.SnapList.Snapshots, nil
+}
+
+// formatDescription stores tags in the description of a snapshot as
+// key=value pairs
+func formatDescription(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, descriptionSeparator)
+}
+
+func parseDescription(description string) map[string]string {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(description, descriptionSeparator) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			tags[parts[0]] = parts[1]
+		}
+	}
+	return tags
+}
@@ -18,7 +18,6 @@ package gluster
 
 import (
 	"fmt"
-	"os/exec"
 
 	"github.com/golang/glog"
 	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
@@ -31,17 +30,35 @@ import (
 
 const (
 	glusterfsBinary = "gluster"
-	glusterfsEp     = "glusterfs-cluster"
+	// glusterfsEp is the endpoints of the volumes restored from snapshots
+	// that record no endpoints
+	glusterfsEp = "glusterfs-cluster"
+
+	// EndpointsParameter is the storage class parameter overriding the
+	// endpoints of the restored volumes
+	EndpointsParameter = "endpoints"
+	// EndpointsNamespaceParameter is the storage class parameter overriding
+	// the namespace of the endpoints of the restored volumes
+	EndpointsNamespaceParameter = "endpointsNamespace"
+
+	// CloneLabel is set on the PVs of the clones created by SnapshotRestore,
+	// VolumeDelete only deletes the volumes carrying it
+	CloneLabel = "glusterfs.snapshot.openebs.io/clone"
+
+	// uidTag is the tag holding the UID of the VolumeSnapshot a snapshot is
+	// created for
+	uidTag = "kubernetes.io/created-for/uid"
 )
 
 type glusterfsPlugin struct {
+	runner commandRunner
 }
 
 var _ volume.Plugin = &glusterfsPlugin{}
 
 // RegisterPlugin registers the volume plugin
 func RegisterPlugin() volume.Plugin {
-	return &glusterfsPlugin{}
+	return &glusterfsPlugin{runner: execRunner{binary: glusterfsBinary}}
 }
 
 // GetPluginName gets the name of the volume plugin
@@ -52,6 +69,8 @@ func GetPluginName() string {
 func (h *glusterfsPlugin) Init(_ cloudprovider.Interface) {
 }
 
+// SnapshotCreate creates and activates a snapshot of the volume, the tags
+// are stored in the description of the snapshot for FindSnapshot
 func (h *glusterfsPlugin) SnapshotCreate(
 	snapshot *crdv1.VolumeSnapshot,
 	pv *v1.PersistentVolume,
@@ -64,69 +83,68 @@ func (h *glusterfsPlugin) SnapshotCreate(
 
 	volumePath := spec.Glusterfs.Path
 	snapshotName := volumePath + "_" + uuid.New()
-	cmd := exec.Command(glusterfsBinary, "snapshot", "create", snapshotName, volumePath, "no-timestamp")
-	out, err := cmd.CombinedOutput()
-
-	if err != nil {
-		glog.Errorf("failed to create snapshot for volume :%v, out:%v, err: %v, command args: %s", volumePath, out, err, cmd.Args)
+	args := []string{"--mode=script", "snapshot", "create", snapshotName, volumePath, "no-timestamp"}
+	if tags != nil && len(*tags) > 0 {
+		args = append(args, "description", formatDescription(*tags))
+	}
+	if _, err := run(h.runner, args...); err != nil {
+		glog.Errorf("failed to create snapshot for volume :%v, err: %v", volumePath, err)
+		return nil, nil, fmt.Errorf("failed to create snapshot of volume %s: %v", volumePath, err)
 	}
 	glog.V(1).Infof("snapshot %v created successfully", snapshotName)
-	cmd = exec.Command(glusterfsBinary, "snapshot", "activate", snapshotName)
-	_, err = cmd.CombinedOutput()
 
-	if err != nil {
-		glog.Errorf("failed to activate snapshot:%v , err: %s, snapshot command is %s", snapshotName, err, cmd.Args)
+	res := &crdv1.VolumeSnapshotDataSource{
+		GlusterSnapshotVolume: &crdv1.GlusterVolumeSnapshotSource{
+			SnapshotID:    snapshotName,
+			EndpointsName: spec.Glusterfs.EndpointsName,
+		},
+	}
+	if spec.Glusterfs.EndpointsNamespace != nil {
+		res.GlusterSnapshotVolume.EndpointsNamespace = *spec.Glusterfs.EndpointsNamespace
 	}
 
-	cond := []crdv1.VolumeSnapshotCondition{}
-	if err == nil {
-		cond = []crdv1.VolumeSnapshotCondition{
-			{
-				Status:             v1.ConditionTrue,
-				Message:            "Snapshot created successfully",
-				LastTransitionTime: metav1.Now(),
-				Type:               crdv1.VolumeSnapshotConditionReady,
-			},
-		}
-	} else {
-		glog.V(2).Infof("failed to create snapshot, err: %v", err)
-		cond = []crdv1.VolumeSnapshotCondition{
-			{
-				Status:             v1.ConditionTrue,
-				Message:            fmt.Sprintf("Failed to create the snapshot: %v", err),
-				LastTransitionTime: metav1.Now(),
-				Type:               crdv1.VolumeSnapshotConditionError,
-			},
-		}
+	_, err := run(h.runner, "--mode=script", "snapshot", "activate", snapshotName)
+	if err != nil {
+		glog.Errorf("failed to activate snapshot:%v , err: %s", snapshotName, err)
+		cond := newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Failed to activate the snapshot: %v", err))
+		return res, cond, err
 	}
+	return res, newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"), nil
+}
 
-	res := &crdv1.VolumeSnapshotDataSource{
-		GlusterSnapshotVolume: &crdv1.GlusterVolumeSnapshotSource{
-			SnapshotID: snapshotName,
+func newConditions(conditionType crdv1.VolumeSnapshotConditionType, message string) *[]crdv1.VolumeSnapshotCondition {
+	return &[]crdv1.VolumeSnapshotCondition{
+		{
+			Status:             v1.ConditionTrue,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+			Type:               conditionType,
 		},
 	}
-	return res, &cond, err
 }
 
 func (h *glusterfsPlugin) SnapshotDelete(src *crdv1.VolumeSnapshotDataSource, _ *v1.PersistentVolume) error {
-	var err error
 	if src == nil || src.GlusterSnapshotVolume == nil {
 		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", src)
 	}
 
 	snapshotID := src.GlusterSnapshotVolume.SnapshotID
 	glog.V(1).Infof("Received snapshot :%v delete request", snapshotID)
-	cmd := exec.Command(glusterfsBinary, "--mode=script", "snapshot", "delete", snapshotID)
-	output, err := cmd.CombinedOutput()
-
+	_, err := run(h.runner, "--mode=script", "snapshot", "delete", snapshotID)
+	if err == errNotFound {
+		glog.V(1).Infof("snapshot %v is already deleted", snapshotID)
+		return nil
+	}
 	if err != nil {
 		glog.Errorf("failed to delete snapshot: %v, err: %v", snapshotID, err)
+		return err
 	}
-
-	glog.V(1).Infof("snapshot deleted :%v successfully", string(output))
-	return err
+	glog.V(1).Infof("snapshot deleted :%v successfully", snapshotID)
+	return nil
 }
 
+// DescribeSnapshot maps the state of the snapshot reported by gluster to a
+// condition, an activated snapshot is ready
 func (h *glusterfsPlugin) DescribeSnapshot(snapshotData *crdv1.VolumeSnapshotData) (snapConditions *[]crdv1.VolumeSnapshotCondition, isCompleted bool, err error) {
 	if snapshotData == nil || snapshotData.Spec.GlusterSnapshotVolume == nil {
 		return nil, false, fmt.Errorf("failed to retrieve Snapshot spec")
@@ -134,82 +152,171 @@ func (h *glusterfsPlugin) DescribeSnapshot(snapshotData *crdv1.VolumeSnapshotDat
 
 	snapshotID := snapshotData.Spec.GlusterSnapshotVolume.SnapshotID
 	glog.V(1).Infof("received describe request on snapshot:%v", snapshotID)
-	cmd := exec.Command(glusterfsBinary, "snapshot", "info", snapshotID)
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
-		glog.Errorf("failed to describe snapshot:%v", snapshotID)
+	info, err := getSnapshotInfo(h.runner, snapshotID)
+	if err == errNotFound {
+		return newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Snapshot %s not found", snapshotID)), true, nil
 	}
-
-	glog.V(1).Infof("snapshot details:%v", string(output))
-
-	if len(snapshotData.Status.Conditions) == 0 {
-		return nil, false, fmt.Errorf("No status condtions in VoluemSnapshotData for gluster snapshot type")
+	if err != nil {
+		glog.Errorf("failed to describe snapshot %v: %v", snapshotID, err)
+		return nil, false, err
 	}
+	return snapshotConditions(info)
+}
 
-	lastCondIdx := len(snapshotData.Status.Conditions) - 1
-	retCondType := crdv1.VolumeSnapshotConditionError
-
-	switch snapshotData.Status.Conditions[lastCondIdx].Type {
-	case crdv1.VolumeSnapshotDataConditionReady:
-		retCondType = crdv1.VolumeSnapshotConditionReady
-	case crdv1.VolumeSnapshotDataConditionPending:
-		retCondType = crdv1.VolumeSnapshotConditionPending
-		// Error out.
+func snapshotConditions(info *snapshotInfo) (*[]crdv1.VolumeSnapshotCondition, bool, error) {
+	switch status := info.status(); status {
+	case snapshotStarted:
+		return newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"), true, nil
+	case snapshotStopped:
+		return newConditions(crdv1.VolumeSnapshotConditionPending, "Snapshot is not activated"), false, nil
+	default:
+		return newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Snapshot is in state %q", status)), true, nil
 	}
-	retCond := []crdv1.VolumeSnapshotCondition{
-		{
-			Status:             snapshotData.Status.Conditions[lastCondIdx].Status,
-			Message:            snapshotData.Status.Conditions[lastCondIdx].Message,
-			LastTransitionTime: snapshotData.Status.Conditions[lastCondIdx].LastTransitionTime,
-			Type:               retCondType,
-		},
-	}
-	return &retCond, true, nil
 }
 
-// FindSnapshot finds a VolumeSnapshot by matching metadata
+// FindSnapshot finds the snapshot created for the VolumeSnapshot by the UID
+// stored in the description of the snapshot
 func (h *glusterfsPlugin) FindSnapshot(tags *map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
 	glog.Infof("FindSnapshot by tags: %#v", *tags)
-
-	// TODO: Implement FindSnapshot
+	uid := (*tags)[uidTag]
+	if uid == "" {
+		return nil, nil, fmt.Errorf("Snapshot not found, no %s tag", uidTag)
+	}
+	names, err := listSnapshots(h.runner)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range names {
+		info, err := getSnapshotInfo(h.runner, name)
+		if err == errNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if info.tags()[uidTag] != uid {
+			continue
+		}
+		conditions, _, err := snapshotConditions(info)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the endpoints are not known without the PV, the restore falls
+		// back to the storage class or the default endpoints
+		res := &crdv1.VolumeSnapshotDataSource{
+			GlusterSnapshotVolume: &crdv1.GlusterVolumeSnapshotSource{
+				SnapshotID: info.Name,
+			},
+		}
+		return res, conditions, nil
+	}
 	return nil, nil, fmt.Errorf("Snapshot not found")
 }
 
-func (h *glusterfsPlugin) SnapshotRestore(snapshotData *crdv1.VolumeSnapshotData, _ *v1.PersistentVolumeClaim, _ string, _ map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
+// SnapshotRestore clones the snapshot into a new volume named after the PV
+// and starts it. A clone that fails to start is deleted again.
+func (h *glusterfsPlugin) SnapshotRestore(snapshotData *crdv1.VolumeSnapshotData, _ *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
 	// retrieve VolumeSnapshotDataSource
 	if snapshotData == nil || snapshotData.Spec.GlusterSnapshotVolume == nil {
 		return nil, nil, fmt.Errorf("failed to retrieve Snapshot spec")
 	}
 
-	// restore snapshot to a PV
-	snapID := snapshotData.Spec.GlusterSnapshotVolume.SnapshotID
-	newSnapPV := snapID
-	cmd := exec.Command(glusterfsBinary, "snapshot", "clone", newSnapPV, snapID)
-	out, err := cmd.CombinedOutput()
+	src := snapshotData.Spec.GlusterSnapshotVolume
+	snapID := src.SnapshotID
+	clone := pvName
+	if clone == "" {
+		return nil, nil, fmt.Errorf("failed to restore %s, no volume name", snapID)
+	}
+
+	// only activated snapshots can be cloned
+	info, err := getSnapshotInfo(h.runner, snapID)
 	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get snapshot %s: %v", snapID, err)
+	}
+	if info.status() != snapshotStarted {
+		if _, err := run(h.runner, "--mode=script", "snapshot", "activate", snapID); err != nil {
+			return nil, nil, fmt.Errorf("failed to activate snapshot %s: %v", snapID, err)
+		}
+	}
+
+	if _, err := run(h.runner, "--mode=script", "snapshot", "clone", clone, snapID); err != nil {
 		glog.Errorf("snapshot :%v restore failed, err:%v", snapID, err)
-		return nil, nil, fmt.Errorf("failed to restore %s, out: %v, err: %v", snapID, out, err)
+		return nil, nil, fmt.Errorf("failed to clone %s to %s: %v", snapID, clone, err)
+	}
+	if _, err := run(h.runner, "--mode=script", "volume", "start", clone); err != nil {
+		glog.Errorf("failed to start clone %v of snapshot %v, err: %v", clone, snapID, err)
+		if rollbackErr := h.deleteVolume(clone); rollbackErr != nil {
+			glog.Errorf("failed to delete clone %v after a failed start, err: %v", clone, rollbackErr)
+		}
+		return nil, nil, fmt.Errorf("failed to start clone %s of %s: %v", clone, snapID, err)
 	}
 
-	glog.V(1).Infof("snapshot restored successfully to PV: %v", newSnapPV)
+	glog.V(1).Infof("snapshot %v restored successfully to volume: %v", snapID, clone)
 
 	pv := &v1.PersistentVolumeSource{
-		Glusterfs: &v1.GlusterfsPersistentVolumeSource{
-			Path:          newSnapPV,
-			EndpointsName: glusterfsEp,
-		},
+		Glusterfs: restoreEndpoints(src, parameters),
+	}
+	pv.Glusterfs.Path = clone
+	labels := map[string]string{
+		CloneLabel: "true",
+	}
+	return pv, labels, nil
+}
+
+// restoreEndpoints returns the endpoints of a volume restored from the
+// snapshot, the storage class parameters override the endpoints of the
+// volume the snapshot is taken from
+func restoreEndpoints(src *crdv1.GlusterVolumeSnapshotSource, parameters map[string]string) *v1.GlusterfsPersistentVolumeSource {
+	name, namespace := src.EndpointsName, src.EndpointsNamespace
+	if value := parameters[EndpointsParameter]; value != "" {
+		name = value
+	}
+	if value := parameters[EndpointsNamespaceParameter]; value != "" {
+		namespace = value
 	}
-	return pv, nil, nil
+	if name == "" {
+		name = glusterfsEp
+	}
+	res := &v1.GlusterfsPersistentVolumeSource{
+		EndpointsName: name,
+	}
+	if namespace != "" {
+		res.EndpointsNamespace = &namespace
+	}
+	return res
 }
 
+// VolumeDelete stops and deletes a clone created by SnapshotRestore
 func (h *glusterfsPlugin) VolumeDelete(pv *v1.PersistentVolume) error {
 	if pv == nil || pv.Spec.Glusterfs == nil {
 		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", pv)
 	}
 
 	path := pv.Spec.Glusterfs.Path
-	glog.Errorf("Going to delete volume with path:%v", path)
-	//TODO: Delete this volume
+	if pv.Labels[CloneLabel] != "true" {
+		glog.Warningf("Not deleting volume %v of PV %v, it is not a clone restored from a snapshot", path, pv.Name)
+		return nil
+	}
+	glog.V(1).Infof("Going to delete volume with path:%v", path)
+	return h.deleteVolume(path)
+}
+
+// deleteVolume stops and deletes the volume, a volume that does not exist
+// is deleted already
+func (h *glusterfsPlugin) deleteVolume(name string) error {
+	_, err := run(h.runner, "--mode=script", "volume", "stop", name, "force")
+	switch err {
+	case nil, errNotStarted:
+	case errNotFound:
+		glog.V(1).Infof("volume %v is already deleted", name)
+		return nil
+	default:
+		return fmt.Errorf("failed to stop volume %s: %v", name, err)
+	}
+	_, err = run(h.runner, "--mode=script", "volume", "delete", name)
+	if err != nil && err != errNotFound {
+		return fmt.Errorf("failed to delete volume %s: %v", name, err)
+	}
+	glog.V(1).Infof("volume %v deleted successfully", name)
 	return nil
 }
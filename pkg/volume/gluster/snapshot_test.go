@@ -0,0 +1,355 @@
+package gluster
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeRunner returns the output registered for the command line, or
+// defaultOutput if set, and records the commands it runs
+type fakeRunner struct {
+	outputs       map[string]string
+	defaultOutput string
+	calls         []string
+}
+
+func (f *fakeRunner) Run(args ...string) ([]byte, error) {
+	cmd := strings.Join(args, " ")
+	f.calls = append(f.calls, cmd)
+	out, ok := f.outputs[cmd]
+	if !ok {
+		if f.defaultOutput == "" {
+			return nil, fmt.Errorf("unexpected command %q", cmd)
+		}
+		out = f.defaultOutput
+	}
+	return []byte(out), nil
+}
+
+const (
+	notFoundXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput><opRet>-1</opRet><opErrno>30806</opErrno><opErrstr>Snapshot (snap1) does not exist</opErrstr></cliOutput>`
+	successXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput><opRet>0</opRet><opErrno>0</opErrno><opErrstr/></cliOutput>`
+	listXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput><opRet>0</opRet><opErrno>0</opErrno><opErrstr/>
+  <snapList><count>2</count><snapshot>vol_a</snapshot><snapshot>vol_b</snapshot></snapList>
+</cliOutput>`
+)
+
+func infoXML(name, description, status string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput>
+  <opRet>0</opRet>
+  <opErrno>0</opErrno>
+  <opErrstr/>
+  <snapInfo>
+    <count>1</count>
+    <snapshots>
+      <snapshot>
+        <name>` + name + `</name>
+        <uuid>3a5b1c4e-0c1e-4b44-9a9c-2b9c7b5d1f00</uuid>
+        <description>` + description + `</description>
+        <createTime>2018-05-02 10:11:12</createTime>
+        <volCount>1</volCount>
+        <snapVolume>
+          <name>8f1a0e5b7c6d4e3f9a2b1c0d9e8f7a6b</name>
+          <status>` + status + `</status>
+          <originVolume>
+            <name>vol</name>
+            <snapCount>2</snapCount>
+            <snapRemaining>254</snapRemaining>
+          </originVolume>
+        </snapVolume>
+      </snapshot>
+    </snapshots>
+  </snapInfo>
+</cliOutput>`
+}
+
+func TestDescribeSnapshot(t *testing.T) {
+	tests := map[string]struct {
+		output    string
+		condition crdv1.VolumeSnapshotConditionType
+		completed bool
+	}{
+		"activated": {
+			output:    infoXML("snap1", "", snapshotStarted),
+			condition: crdv1.VolumeSnapshotConditionReady,
+			completed: true,
+		},
+		"not activated": {
+			output:    infoXML("snap1", "", snapshotStopped),
+			condition: crdv1.VolumeSnapshotConditionPending,
+			completed: false,
+		},
+		"unknown state": {
+			output:    infoXML("snap1", "", "Failed"),
+			condition: crdv1.VolumeSnapshotConditionError,
+			completed: true,
+		},
+		"not found": {
+			output:    notFoundXML,
+			condition: crdv1.VolumeSnapshotConditionError,
+			completed: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{outputs: map[string]string{"--xml snapshot info snap1": test.output}}
+			plugin := &glusterfsPlugin{runner: runner}
+			data := &crdv1.VolumeSnapshotData{
+				Spec: crdv1.VolumeSnapshotDataSpec{
+					VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+						GlusterSnapshotVolume: &crdv1.GlusterVolumeSnapshotSource{SnapshotID: "snap1"},
+					},
+				},
+			}
+			conditions, completed, err := plugin.DescribeSnapshot(data)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if (*conditions)[0].Type != test.condition || completed != test.completed {
+				t.Errorf("Expected %s, %v, got %s, %v", test.condition, test.completed, (*conditions)[0].Type, completed)
+			}
+		})
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"--xml snapshot list":       listXML,
+		"--xml snapshot info vol_a": infoXML("vol_a", formatDescription(map[string]string{uidTag: "uid-a"}), snapshotStarted),
+		"--xml snapshot info vol_b": infoXML("vol_b", formatDescription(map[string]string{uidTag: "uid-b", "kubernetes.io/created-for/name": "b"}), snapshotStarted),
+	}}
+	plugin := &glusterfsPlugin{runner: runner}
+
+	tests := map[string]struct {
+		uid      string
+		expected string
+	}{
+		"first":     {uid: "uid-a", expected: "vol_a"},
+		"second":    {uid: "uid-b", expected: "vol_b"},
+		"not found": {uid: "uid-c"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			src, _, err := plugin.FindSnapshot(&map[string]string{uidTag: test.uid})
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("Expected snapshot not to be found, got %v", src.GlusterSnapshotVolume)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if src.GlusterSnapshotVolume.SnapshotID != test.expected {
+				t.Errorf("Expected snapshot %s, got %s", test.expected, src.GlusterSnapshotVolume.SnapshotID)
+			}
+		})
+	}
+}
+
+func TestSnapshotCreate(t *testing.T) {
+	// the snapshot name is random, every command succeeds
+	runner := &fakeRunner{defaultOutput: successXML}
+	plugin := &glusterfsPlugin{runner: runner}
+	namespace := "storage"
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				Glusterfs: &v1.GlusterfsPersistentVolumeSource{
+					Path:               "vol",
+					EndpointsName:      "gluster-ep",
+					EndpointsNamespace: &namespace,
+				},
+			},
+		},
+	}
+	tags := map[string]string{uidTag: "uid-a"}
+	src, conditions, err := plugin.SnapshotCreate(&crdv1.VolumeSnapshot{}, pv, &tags)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &crdv1.GlusterVolumeSnapshotSource{
+		SnapshotID:         src.GlusterSnapshotVolume.SnapshotID,
+		EndpointsName:      "gluster-ep",
+		EndpointsNamespace: namespace,
+	}
+	if !reflect.DeepEqual(src.GlusterSnapshotVolume, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, src.GlusterSnapshotVolume)
+	}
+	if (*conditions)[0].Type != crdv1.VolumeSnapshotConditionReady {
+		t.Errorf("Expected a ready snapshot, got %v", *conditions)
+	}
+	create := fmt.Sprintf("--xml --mode=script snapshot create %s vol no-timestamp description %s=uid-a", expected.SnapshotID, uidTag)
+	activate := fmt.Sprintf("--xml --mode=script snapshot activate %s", expected.SnapshotID)
+	if !reflect.DeepEqual(runner.calls, []string{create, activate}) {
+		t.Errorf("Unexpected commands %q", runner.calls)
+	}
+}
+
+func TestRestoreEndpoints(t *testing.T) {
+	namespace := func(value string) *string { return &value }
+	tests := map[string]struct {
+		src        crdv1.GlusterVolumeSnapshotSource
+		parameters map[string]string
+		expected   *v1.GlusterfsPersistentVolumeSource
+	}{
+		"default": {
+			expected: &v1.GlusterfsPersistentVolumeSource{EndpointsName: glusterfsEp},
+		},
+		"source volume": {
+			src:      crdv1.GlusterVolumeSnapshotSource{EndpointsName: "ep", EndpointsNamespace: "ns"},
+			expected: &v1.GlusterfsPersistentVolumeSource{EndpointsName: "ep", EndpointsNamespace: namespace("ns")},
+		},
+		"class parameters": {
+			src: crdv1.GlusterVolumeSnapshotSource{EndpointsName: "ep", EndpointsNamespace: "ns"},
+			parameters: map[string]string{
+				EndpointsParameter:          "class-ep",
+				EndpointsNamespaceParameter: "class-ns",
+			},
+			expected: &v1.GlusterfsPersistentVolumeSource{EndpointsName: "class-ep", EndpointsNamespace: namespace("class-ns")},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := restoreEndpoints(&test.src, test.parameters)
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, got)
+			}
+		})
+	}
+}
+
+func errorXML(message string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cliOutput><opRet>-1</opRet><opErrno>0</opErrno><opErrstr>` + message + `</opErrstr></cliOutput>`
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	const (
+		info     = "--xml snapshot info snap1"
+		activate = "--xml --mode=script snapshot activate snap1"
+		clone    = "--xml --mode=script snapshot clone pv1 snap1"
+		start    = "--xml --mode=script volume start pv1"
+		stop     = "--xml --mode=script volume stop pv1 force"
+		remove   = "--xml --mode=script volume delete pv1"
+	)
+	tests := map[string]struct {
+		outputs  map[string]string
+		calls    []string
+		restored bool
+	}{
+		"activated snapshot": {
+			outputs:  map[string]string{info: infoXML("snap1", "", snapshotStarted)},
+			calls:    []string{info, clone, start},
+			restored: true,
+		},
+		"snapshot not activated": {
+			outputs:  map[string]string{info: infoXML("snap1", "", snapshotStopped)},
+			calls:    []string{info, activate, clone, start},
+			restored: true,
+		},
+		"clone failed": {
+			outputs: map[string]string{
+				info:  infoXML("snap1", "", snapshotStarted),
+				clone: errorXML("Commit failed on localhost"),
+			},
+			calls: []string{info, clone},
+		},
+		"start failed": {
+			outputs: map[string]string{
+				info:  infoXML("snap1", "", snapshotStarted),
+				start: errorXML("Commit failed on localhost"),
+				stop:  errorXML("Volume pv1 is not in the started state"),
+			},
+			calls: []string{info, clone, start, stop, remove},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{outputs: test.outputs, defaultOutput: successXML}
+			plugin := &glusterfsPlugin{runner: runner}
+			data := &crdv1.VolumeSnapshotData{
+				Spec: crdv1.VolumeSnapshotDataSpec{
+					VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+						GlusterSnapshotVolume: &crdv1.GlusterVolumeSnapshotSource{SnapshotID: "snap1"},
+					},
+				},
+			}
+			src, labels, err := plugin.SnapshotRestore(data, nil, "pv1", nil)
+			if !reflect.DeepEqual(runner.calls, test.calls) {
+				t.Errorf("Expected commands %q, got %q", test.calls, runner.calls)
+			}
+			if !test.restored {
+				if err == nil {
+					t.Errorf("Expected restore to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if src.Glusterfs.Path != "pv1" || labels[CloneLabel] != "true" {
+				t.Errorf("Unexpected volume %+v with labels %v", src.Glusterfs, labels)
+			}
+		})
+	}
+}
+
+func TestVolumeDelete(t *testing.T) {
+	const (
+		stop   = "--xml --mode=script volume stop pv1 force"
+		remove = "--xml --mode=script volume delete pv1"
+	)
+	tests := map[string]struct {
+		labels  map[string]string
+		outputs map[string]string
+		calls   []string
+	}{
+		"clone": {
+			labels: map[string]string{CloneLabel: "true"},
+			calls:  []string{stop, remove},
+		},
+		"clone not started": {
+			labels:  map[string]string{CloneLabel: "true"},
+			outputs: map[string]string{stop: errorXML("Volume pv1 is not in the started state")},
+			calls:   []string{stop, remove},
+		},
+		"clone already deleted": {
+			labels:  map[string]string{CloneLabel: "true"},
+			outputs: map[string]string{stop: errorXML("Volume pv1 does not exist")},
+			calls:   []string{stop},
+		},
+		"not a clone": {},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{outputs: test.outputs, defaultOutput: successXML}
+			plugin := &glusterfsPlugin{runner: runner}
+			pv := &v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv1", Labels: test.labels},
+				Spec: v1.PersistentVolumeSpec{
+					PersistentVolumeSource: v1.PersistentVolumeSource{
+						Glusterfs: &v1.GlusterfsPersistentVolumeSource{Path: "pv1"},
+					},
+				},
+			}
+			if err := plugin.VolumeDelete(pv); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(runner.calls, test.calls) {
+				t.Errorf("Expected commands %q, got %q", test.calls, runner.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,600 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the compression of a snapshot archive
+type Compression string
+
+const (
+	// CompressionNone stores the archive as a plain tar
+	CompressionNone Compression = "none"
+	// CompressionGzip compresses the archive with gzip
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses the archive with zstd
+	CompressionZstd Compression = "zstd"
+)
+
+const (
+	// PAX records of the sparse files. archive/tar drops the GNU sparse
+	// records on write, so the data map of a sparse file is stored in
+	// records of our own. Only the data regions are stored in the archive,
+	// a foreign tar extracts them back to back.
+	paxSparseMap      = "OPENEBS.sparse.map"
+	paxSparseRealSize = "OPENEBS.sparse.realsize"
+	paxSchilyXattr    = "SCHILY.xattr."
+
+	partialSuffix = ".partial"
+
+	// legacyArchiveExtension is the extension of the archives written by
+	// tar czf before the manifests were introduced
+	legacyArchiveExtension = ".tgz"
+)
+
+// archiveExtension returns the file extension of an archive
+func archiveExtension(compression Compression) string {
+	switch compression {
+	case CompressionGzip:
+		return ".tar.gz"
+	case CompressionZstd:
+		return ".tar.zst"
+	}
+	return ".tar"
+}
+
+// compressionOfArchive returns the compression of the archive from its file
+// extension
+func compressionOfArchive(path string) Compression {
+	if strings.HasSuffix(path, legacyArchiveExtension) {
+		return CompressionGzip
+	}
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		if strings.HasSuffix(path, archiveExtension(compression)) {
+			return compression
+		}
+	}
+	return CompressionNone
+}
+
+func parseCompression(value string) (Compression, error) {
+	switch c := Compression(value); c {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return c, nil
+	}
+	return "", fmt.Errorf("unsupported compression %q, supported values are none, gzip and zstd", value)
+}
+
+func newCompressor(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", compression)
+}
+
+func newDecompressor(r io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", compression)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// archiveProgress tracks an archive being written, it is shared between
+// the goroutine writing the archive and DescribeSnapshot
+type archiveProgress struct {
+	sync.Mutex
+	total    int64
+	done     int64
+	finished bool
+	err      error
+}
+
+func (p *archiveProgress) add(n int64) {
+	p.Lock()
+	p.done += n
+	p.Unlock()
+}
+
+func (p *archiveProgress) finish(err error) {
+	p.Lock()
+	p.finished = true
+	p.err = err
+	p.Unlock()
+}
+
+// status returns whether the archive is written, a human readable progress
+// and the error it failed with
+func (p *archiveProgress) status() (bool, string, error) {
+	p.Lock()
+	defer p.Unlock()
+	percent := int64(100)
+	if p.total > 0 {
+		percent = p.done * 100 / p.total
+	}
+	return p.finished, fmt.Sprintf("Archived %d of %d bytes (%d%%)", p.done, p.total, percent), p.err
+}
+
+// dataSize returns the apparent size of the regular files under path, the
+// total of the progress of archiving it
+func dataSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// createArchive writes the content of the directory src as a tar archive to
+// dst and returns its manifest. The archive is written to a partial file
+// first so that an interrupted run never leaves a file at dst. The archive
+// is encrypted with key unless it is nil, the manifest of an encrypted
+// archive lists no files so that their names are not stored in plaintext.
+func createArchive(src, dst string, compression Compression, key []byte, progress *archiveProgress) (*manifest, error) {
+	partial := dst + partialSuffix
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(partial)
+	}()
+
+	archiveHash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(f, archiveHash)}
+	var w io.WriteCloser = nopWriteCloser{counter}
+	if key != nil {
+		if w, err = newEncryptingWriter(counter, key); err != nil {
+			return nil, err
+		}
+	}
+	compressor, err := newCompressor(w, compression)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(compressor)
+
+	m := &manifest{
+		Version:     manifestVersion,
+		Compression: compression,
+		CreatedAt:   time.Now().UTC(),
+	}
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		entry, err := writeEntry(tw, path, filepath.ToSlash(rel), info, progress)
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %v", path, err)
+		}
+		if entry != nil && key == nil {
+			m.Files = append(m.Files, *entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		return nil, err
+	}
+
+	m.ArchiveSize = counter.n
+	m.ArchiveSHA256 = hex.EncodeToString(archiveHash.Sum(nil))
+	return m, nil
+}
+
+// writeEntry writes a single file to the archive, it returns the manifest
+// entry of regular files
+func writeEntry(tw *tar.Writer, path, name string, info os.FileInfo, progress *archiveProgress) (*manifestEntry, error) {
+	var link string
+	mode := info.Mode()
+	switch {
+	case mode.IsRegular(), mode.IsDir():
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		link = target
+	default:
+		glog.Warningf("Skipping %s, file type %v is not supported", path, mode.Type())
+		return nil, nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	if mode.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Format = tar.FormatPAX
+	hdr.PAXRecords = make(map[string]string)
+	xattrs, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range xattrs {
+		hdr.PAXRecords[paxSchilyXattr+key] = value
+	}
+	if !mode.IsRegular() {
+		return nil, tw.WriteHeader(hdr)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	regions, err := dataRegions(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	var stored int64
+	for _, region := range regions {
+		stored += region.length
+	}
+	if stored < info.Size() {
+		hdr.Size = stored
+		hdr.PAXRecords[paxSparseMap] = formatSparseMap(regions)
+		hdr.PAXRecords[paxSparseRealSize] = strconv.FormatInt(info.Size(), 10)
+		// the holes are done as soon as they are found
+		progress.add(info.Size() - stored)
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+
+	fileHash := sha256.New()
+	w := io.MultiWriter(tw, fileHash)
+	for _, region := range regions {
+		if _, err := f.Seek(region.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := copyWithProgress(w, io.LimitReader(f, region.length), region.length, progress); err != nil {
+			return nil, err
+		}
+	}
+	return &manifestEntry{
+		Name:   name,
+		Size:   info.Size(),
+		SHA256: hex.EncodeToString(fileHash.Sum(nil)),
+	}, nil
+}
+
+func copyWithProgress(w io.Writer, r io.Reader, length int64, progress *archiveProgress) error {
+	buf := make([]byte, 1<<20)
+	var copied int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			copied += int64(n)
+			progress.add(int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if copied != length {
+		return fmt.Errorf("file changed while being archived, read %d of %d bytes", copied, length)
+	}
+	return nil
+}
+
+// extractArchive extracts the archive into the directory dst, the content
+// of each regular file is checked against the manifest. An encrypted
+// archive is decrypted with key, its content is authenticated instead.
+func extractArchive(archive, dst string, m *manifest, key []byte) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if m.Encryption != nil {
+		if key == nil {
+			return fmt.Errorf("archive is encrypted with key %s, no key given", m.Encryption.KeyID)
+		}
+		if r, err = newDecryptingReader(f, key); err != nil {
+			return err
+		}
+	}
+	decompressor, err := newDecompressor(r, m.Compression)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+
+	checksums := make(map[string]string, len(m.Files))
+	for _, entry := range m.Files {
+		checksums[entry.Name] = entry.SHA256
+	}
+
+	dst = filepath.Clean(dst)
+	// The symlinks are created once all the files are extracted so that no
+	// entry is written through a link, the modes and times of the
+	// directories are set last, deepest first, so that extracting their
+	// children neither changes their times nor fails on read-only ones.
+	var links, dirs []*tar.Header
+	targets := make(map[*tar.Header]string)
+	tr := tar.NewReader(decompressor)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		// the legacy archives of tar -C dir . hold the root as ./
+		if target == dst && hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if !withinDir(dst, target) {
+			return fmt.Errorf("invalid entry %q, it is outside of the archive root", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			if err := checkLink(dst, target, hdr.Linkname); err != nil {
+				return fmt.Errorf("invalid entry %q: %v", hdr.Name, err)
+			}
+			links = append(links, hdr)
+			targets[hdr] = target
+			continue
+		case tar.TypeDir:
+			dirs = append(dirs, hdr)
+			targets[hdr] = target
+		}
+		if err := extractEntry(tr, hdr, target, checksums); err != nil {
+			return fmt.Errorf("failed to extract %s: %v", hdr.Name, err)
+		}
+	}
+	for _, hdr := range links {
+		if err := extractEntry(tr, hdr, targets[hdr], checksums); err != nil {
+			return fmt.Errorf("failed to extract %s: %v", hdr.Name, err)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setModeAndTimes(targets[dirs[i]], dirs[i]); err != nil {
+			return fmt.Errorf("failed to extract %s: %v", dirs[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// withinDir returns true if path is a path below dir, both are clean
+func withinDir(dir, path string) bool {
+	return strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// checkLink returns an error if the symlink at target, under dst, points
+// outside of dst
+func checkLink(dst, target, linkname string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("absolute link target %q", linkname)
+	}
+	resolved := filepath.Join(filepath.Dir(target), filepath.FromSlash(linkname))
+	if resolved != dst && !withinDir(dst, resolved) {
+		return fmt.Errorf("link target %q is outside of the archive root", linkname)
+	}
+	return nil
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, target string, checksums map[string]string) error {
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		// writable until its mode is set, after its children are extracted
+		if err := os.MkdirAll(target, 0700); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		if err := extractFile(tr, hdr, target, checksums[hdr.Name]); err != nil {
+			return err
+		}
+	default:
+		glog.Warningf("Skipping %s, entry type %c is not supported", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+
+	xattrs := make(map[string]string)
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, paxSchilyXattr) {
+			xattrs[strings.TrimPrefix(key, paxSchilyXattr)] = value
+		}
+	}
+	if err := setXattrs(target, xattrs); err != nil {
+		glog.Warningf("Failed to restore extended attributes of %s: %v", target, err)
+	}
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	return setModeAndTimes(target, hdr)
+}
+
+// setModeAndTimes sets the mode and the modification time of the entry
+func setModeAndTimes(target string, hdr *tar.Header) error {
+	if err := os.Chmod(target, hdr.FileInfo().Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+func extractFile(tr *tar.Reader, hdr *tar.Header, target, checksum string) error {
+	// O_EXCL fails rather than writing through an existing file or link
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	regions := []region{{offset: 0, length: hdr.Size}}
+	size := hdr.Size
+	if sparseMap, ok := hdr.PAXRecords[paxSparseMap]; ok {
+		if regions, err = parseSparseMap(sparseMap); err != nil {
+			return err
+		}
+		if size, err = strconv.ParseInt(hdr.PAXRecords[paxSparseRealSize], 10, 64); err != nil {
+			return fmt.Errorf("invalid sparse file size: %v", err)
+		}
+	}
+
+	fileHash := sha256.New()
+	r := io.TeeReader(tr, fileHash)
+	for _, region := range regions {
+		if _, err := f.Seek(region.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(f, r, region.length); err != nil {
+			return err
+		}
+	}
+	// leaves the holes of a sparse file unallocated
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(fileHash.Sum(nil)); checksum != "" && sum != checksum {
+		return fmt.Errorf("checksum mismatch, expected %s got %s", checksum, sum)
+	}
+	return f.Close()
+}
+
+// region is a range of a file holding data
+type region struct {
+	offset int64
+	length int64
+}
+
+func formatSparseMap(regions []region) string {
+	values := make([]string, 0, 2*len(regions))
+	for _, r := range regions {
+		values = append(values, strconv.FormatInt(r.offset, 10), strconv.FormatInt(r.length, 10))
+	}
+	return strings.Join(values, ",")
+}
+
+func parseSparseMap(value string) ([]region, error) {
+	regions := []region{}
+	if value == "" {
+		return regions, nil
+	}
+	values := strings.Split(value, ",")
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("invalid sparse map %q", value)
+	}
+	for i := 0; i < len(values); i += 2 {
+		offset, err := strconv.ParseInt(values[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sparse map %q: %v", value, err)
+		}
+		length, err := strconv.ParseInt(values[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sparse map %q: %v", value, err)
+		}
+		regions = append(regions, region{offset: offset, length: length})
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].offset < regions[j].offset })
+	return regions, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// whence values of lseek(2) to find the data and the holes of a file
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// listXattrs returns the extended attributes of path, POSIX ACLs are
+// stored as extended attributes too
+func listXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "llistxattr", Path: path, Err: err}
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, &os.PathError{Op: "llistxattr", Path: path, Err: err}
+	}
+
+	xattrs := make(map[string]string)
+	for _, key := range bytes.Split(buf[:size], []byte{0}) {
+		if len(key) == 0 {
+			continue
+		}
+		size, err := unix.Lgetxattr(path, string(key), nil)
+		if err != nil {
+			return nil, &os.PathError{Op: "lgetxattr", Path: path, Err: err}
+		}
+		value := make([]byte, size)
+		size, err = unix.Lgetxattr(path, string(key), value)
+		if err != nil {
+			return nil, &os.PathError{Op: "lgetxattr", Path: path, Err: err}
+		}
+		xattrs[string(key)] = string(value[:size])
+	}
+	return xattrs, nil
+}
+
+func setXattrs(path string, xattrs map[string]string) error {
+	for key, value := range xattrs {
+		if err := unix.Lsetxattr(path, key, []byte(value), 0); err != nil {
+			return &os.PathError{Op: "lsetxattr " + key, Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+// dataRegions returns the ranges of the file holding data, the holes of a
+// sparse file are left out. The whole file is returned as a single region
+// if the file system can not report holes.
+func dataRegions(f *os.File, size int64) ([]region, error) {
+	whole := []region{{offset: 0, length: size}}
+	if size == 0 {
+		return []region{}, nil
+	}
+	regions := []region{}
+	var offset int64
+	for offset < size {
+		data, err := unix.Seek(int(f.Fd()), offset, seekData)
+		if err == unix.ENXIO {
+			// no data after offset, the rest is a hole
+			break
+		}
+		if err != nil {
+			return whole, nil
+		}
+		hole, err := unix.Seek(int(f.Fd()), data, seekHole)
+		if err != nil {
+			return whole, nil
+		}
+		if hole > size {
+			hole = size
+		}
+		regions = append(regions, region{offset: data, length: hole - data})
+		offset = hole
+	}
+	return regions, nil
+}
+
+// fileOwner returns the uid and the gid of the file
+func fileOwner(info os.FileInfo) (int, int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return 0, 0
+}
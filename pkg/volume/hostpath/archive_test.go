@@ -0,0 +1,234 @@
+package hostpath
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "hostpath-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	content := map[string][]byte{
+		"file":        []byte("hello world"),
+		"dir/nested":  bytes.Repeat([]byte("openebs"), 1024),
+		"dir/empty":   {},
+		"dir2/sparse": nil,
+	}
+	for name, data := range content {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if data == nil {
+			continue
+		}
+		if err := ioutil.WriteFile(path, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a 4MiB file holding data at its end only
+	sparse, err := os.Create(filepath.Join(src, "dir2/sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sparse.WriteAt([]byte("tail"), 4<<20); err != nil {
+		t.Fatal(err)
+	}
+	sparse.Close()
+	content["dir2/sparse"] = append(make([]byte, 4<<20), []byte("tail")...)
+	if err := os.Symlink("file", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		compression Compression
+		corrupt     bool
+		expectErr   bool
+	}{
+		"No compression": {CompressionNone, false, false},
+		"Gzip":           {CompressionGzip, false, false},
+		"Zstd":           {CompressionZstd, false, false},
+		"Corrupted":      {CompressionGzip, true, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			depot, err := ioutil.TempDir("", "hostpath-depot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(depot)
+			archive := filepath.Join(depot, "snap"+archiveExtension(tc.compression))
+			progress := &archiveProgress{}
+			if progress.total, err = dataSize(src); err != nil {
+				t.Fatal(err)
+			}
+			m, err := createArchive(src, archive, tc.compression, nil, progress)
+			if err != nil {
+				t.Fatalf("Failed to create archive: %v", err)
+			}
+			if err := writeManifest(archive, m); err != nil {
+				t.Fatal(err)
+			}
+			if progress.done != progress.total {
+				t.Errorf("Expected progress %d, got %d", progress.total, progress.done)
+			}
+			if tc.corrupt {
+				f, err := os.OpenFile(archive, os.O_WRONLY, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteAt([]byte("corrupt"), 16)
+				f.Close()
+			}
+
+			verified, err := verifyArchive(archive)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(verified.Files, m.Files) {
+				t.Errorf("Expected manifest files %v, got %v", m.Files, verified.Files)
+			}
+
+			dst := filepath.Join(depot, "restore")
+			if err := os.MkdirAll(dst, 0750); err != nil {
+				t.Fatal(err)
+			}
+			if err := extractArchive(archive, dst, verified, nil); err != nil {
+				t.Fatalf("Failed to extract archive: %v", err)
+			}
+			for name, data := range content {
+				restored, err := ioutil.ReadFile(filepath.Join(dst, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(restored, data) {
+					t.Errorf("Content of %s differs after restore", name)
+				}
+			}
+			info, err := os.Stat(filepath.Join(dst, "file"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("Expected mode 0640, got %v", info.Mode().Perm())
+			}
+			if target, err := os.Readlink(filepath.Join(dst, "link")); err != nil || target != "file" {
+				t.Errorf("Expected link to file, got %q, %v", target, err)
+			}
+		})
+	}
+}
+
+// writeTestArchive writes an uncompressed archive of the entries, the
+// regular files hold their name
+func writeTestArchive(t *testing.T, path string, entries []*tar.Header) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, hdr := range entries {
+		var data []byte
+		if hdr.Typeflag == tar.TypeReg {
+			data = []byte(hdr.Name)
+			hdr.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	modTime := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := map[string]struct {
+		entries   []*tar.Header
+		expectErr bool
+	}{
+		"Absolute link": {
+			entries: []*tar.Header{
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/tmp"},
+			},
+			expectErr: true,
+		},
+		"Link outside of the root": {
+			entries: []*tar.Header{
+				{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"},
+			},
+			expectErr: true,
+		},
+		"Write through a link": {
+			entries: []*tar.Header{
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir"},
+				{Name: "link/file", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+			expectErr: true,
+		},
+		"Read-only directory": {
+			entries: []*tar.Header{
+				{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0555, ModTime: modTime},
+				{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modTime},
+				{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "file"},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			depot, err := ioutil.TempDir("", "hostpath-depot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				os.Chmod(filepath.Join(depot, "restore", "dir"), 0755)
+				os.RemoveAll(depot)
+			}()
+			archive := filepath.Join(depot, "snap.tar")
+			writeTestArchive(t, archive, tc.entries)
+			dst := filepath.Join(depot, "restore")
+			if err := os.MkdirAll(filepath.Join(dst, "dir"), 0750); err != nil {
+				t.Fatal(err)
+			}
+
+			err = extractArchive(archive, dst, &manifest{Compression: CompressionNone}, nil)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if _, err := os.Lstat(filepath.Join(depot, "outside")); !os.IsNotExist(err) {
+				t.Errorf("Expected nothing written outside of the root, got %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dst, "dir", "file")); tc.expectErr && !os.IsNotExist(err) {
+				t.Errorf("Expected no file written through the link, got %v", err)
+			}
+			if tc.expectErr {
+				return
+			}
+			info, err := os.Stat(filepath.Join(dst, "dir"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0555 || !info.ModTime().Equal(modTime) {
+				t.Errorf("Expected mode 0555 and time %v, got %v and %v", modTime, info.Mode().Perm(), info.ModTime())
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"os"
+)
+
+func listXattrs(_ string) (map[string]string, error) {
+	return nil, nil
+}
+
+func setXattrs(_ string, xattrs map[string]string) error {
+	return nil
+}
+
+func dataRegions(_ *os.File, size int64) ([]region, error) {
+	if size == 0 {
+		return []region{}, nil
+	}
+	return []region{{offset: 0, length: size}}, nil
+}
+
+func fileOwner(_ os.FileInfo) (int, int) {
+	return 0, 0
+}
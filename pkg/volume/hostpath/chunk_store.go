@@ -0,0 +1,592 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Format is the on disk format of a hostPath snapshot
+type Format string
+
+const (
+	// FormatArchive stores each snapshot as a single tar archive
+	FormatArchive Format = "archive"
+	// FormatChunks splits the files into content defined chunks stored once
+	// in a content addressed store shared by all the snapshots of the depot,
+	// each snapshot is a manifest listing the chunks of its files
+	FormatChunks Format = "chunks"
+)
+
+const (
+	chunksDir            = "chunks"
+	chunkManifestSuffix  = ".chunks.json"
+	chunkManifestVersion = 1
+
+	entryDir     = "dir"
+	entryFile    = "file"
+	entrySymlink = "symlink"
+)
+
+func parseFormat(value string) (Format, error) {
+	switch f := Format(value); f {
+	case FormatArchive, FormatChunks:
+		return f, nil
+	}
+	return "", fmt.Errorf("unsupported format %q, supported values are archive and chunks", value)
+}
+
+func isChunkSnapshot(path string) bool {
+	return strings.HasSuffix(path, chunkManifestSuffix)
+}
+
+// chunkSnapshot is the manifest of a snapshot in the chunk store
+type chunkSnapshot struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Entries   []chunkEntry `json:"entries"`
+}
+
+// chunkEntry is a file of a snapshot, the chunks of a regular file cover its
+// data regions, any gap between them is a hole
+type chunkEntry struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Mode     os.FileMode       `json:"mode"`
+	UID      int               `json:"uid"`
+	GID      int               `json:"gid"`
+	ModTime  time.Time         `json:"modTime"`
+	Linkname string            `json:"linkname,omitempty"`
+	Size     int64             `json:"size,omitempty"`
+	Xattrs   map[string][]byte `json:"xattrs,omitempty"`
+	Chunks   []chunkRef        `json:"chunks,omitempty"`
+}
+
+type chunkRef struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Hash   string `json:"sha256"`
+}
+
+// chunkStore is the content addressed store of the chunks under the depot,
+// a chunk is stored at chunks/<first two digits of its hash>/<hash>
+type chunkStore struct {
+	depot string
+	// snapshots being written hold the read lock until their manifest is
+	// written, pruning holds the write lock so that it never removes a chunk
+	// a manifest is about to reference
+	lock sync.RWMutex
+}
+
+func newChunkStore(depot string) *chunkStore {
+	return &chunkStore{depot: depot}
+}
+
+func (s *chunkStore) chunkPath(hash string) string {
+	return filepath.Join(s.depot, chunksDir, hash[:2], hash)
+}
+
+// put stores the chunk unless it is stored already and returns its hash
+func (s *chunkStore) put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := s.chunkPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), hash+partialSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp.Name(), path)
+}
+
+// get reads a chunk and checks its content against its hash
+func (s *chunkStore) get(hash string) ([]byte, error) {
+	if len(hash) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid chunk hash %q", hash)
+	}
+	data, err := ioutil.ReadFile(s.chunkPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("chunk %s is corrupted", hash)
+	}
+	return data, nil
+}
+
+// createSnapshot stores the content of the directory src in the store and
+// writes the manifest of the snapshot to path
+func (s *chunkStore) createSnapshot(src, path string, progress *archiveProgress) (*chunkSnapshot, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	snapshot := &chunkSnapshot{
+		Version:   chunkManifestVersion,
+		CreatedAt: time.Now().UTC(),
+	}
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		entry, err := s.storeEntry(file, filepath.ToSlash(rel), info, progress)
+		if err != nil {
+			return fmt.Errorf("failed to store %s: %v", file, err)
+		}
+		if entry != nil {
+			snapshot.Entries = append(snapshot.Entries, *entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path+partialSuffix, data, 0600); err != nil {
+		return nil, err
+	}
+	return snapshot, os.Rename(path+partialSuffix, path)
+}
+
+func (s *chunkStore) storeEntry(file, name string, info os.FileInfo, progress *archiveProgress) (*chunkEntry, error) {
+	entry := &chunkEntry{
+		Name:    name,
+		Mode:    info.Mode().Perm(),
+		ModTime: info.ModTime(),
+	}
+	entry.UID, entry.GID = fileOwner(info)
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		entry.Type = entryDir
+	case mode&os.ModeSymlink != 0:
+		entry.Type = entrySymlink
+		target, err := os.Readlink(file)
+		if err != nil {
+			return nil, err
+		}
+		entry.Linkname = target
+	case mode.IsRegular():
+		entry.Type = entryFile
+		entry.Size = info.Size()
+	default:
+		glog.Warningf("Skipping %s, file type %v is not supported", file, mode.Type())
+		return nil, nil
+	}
+	xattrs, err := listXattrs(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(xattrs) != 0 {
+		entry.Xattrs = make(map[string][]byte, len(xattrs))
+		for key, value := range xattrs {
+			entry.Xattrs[key] = []byte(value)
+		}
+	}
+	if entry.Type != entryFile {
+		return entry, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	regions, err := dataRegions(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	var stored int64
+	for _, region := range regions {
+		if _, err := f.Seek(region.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		c := newChunker(io.LimitReader(f, region.length))
+		offset := region.offset
+		for {
+			data, err := c.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			hash, err := s.put(data)
+			if err != nil {
+				return nil, err
+			}
+			entry.Chunks = append(entry.Chunks, chunkRef{Offset: offset, Length: int64(len(data)), Hash: hash})
+			offset += int64(len(data))
+			progress.add(int64(len(data)))
+		}
+		if read := offset - region.offset; read != region.length {
+			return nil, fmt.Errorf("file changed while being stored, read %d of %d bytes", read, region.length)
+		}
+		stored += region.length
+	}
+	// the holes are done once the data is stored
+	progress.add(info.Size() - stored)
+	return entry, nil
+}
+
+func readChunkSnapshot(path string) (*chunkSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &chunkSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", path, err)
+	}
+	if snapshot.Version != chunkManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d of %s", snapshot.Version, path)
+	}
+	return snapshot, nil
+}
+
+// restoreSnapshot restores the snapshot with the manifest at path into the
+// directory dst
+func (s *chunkStore) restoreSnapshot(path, dst string) error {
+	snapshot, err := readChunkSnapshot(path)
+	if err != nil {
+		return err
+	}
+	dst = filepath.Clean(dst)
+	// like for the archives, the symlinks are restored after the files and
+	// the modes and times of the directories last, deepest first
+	var links, dirs []chunkEntry
+	for _, entry := range snapshot.Entries {
+		target := filepath.Join(dst, filepath.FromSlash(entry.Name))
+		if !withinDir(dst, target) {
+			return fmt.Errorf("invalid entry %q, it is outside of the snapshot root", entry.Name)
+		}
+		switch entry.Type {
+		case entrySymlink:
+			if err := checkLink(dst, target, entry.Linkname); err != nil {
+				return fmt.Errorf("invalid entry %q: %v", entry.Name, err)
+			}
+			links = append(links, entry)
+			continue
+		case entryDir:
+			dirs = append(dirs, entry)
+		}
+		if err := s.restoreEntry(entry, target); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", entry.Name, err)
+		}
+	}
+	for _, entry := range links {
+		if err := s.restoreEntry(entry, filepath.Join(dst, filepath.FromSlash(entry.Name))); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", entry.Name, err)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target := filepath.Join(dst, filepath.FromSlash(dirs[i].Name))
+		if err := os.Chmod(target, dirs[i].Mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", dirs[i].Name, err)
+		}
+		if err := os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", dirs[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *chunkStore) restoreEntry(entry chunkEntry, target string) error {
+	switch entry.Type {
+	case entryDir:
+		// writable until its mode is set, after its children are restored
+		if err := os.MkdirAll(target, 0700); err != nil {
+			return err
+		}
+	case entrySymlink:
+		if err := os.Symlink(entry.Linkname, target); err != nil {
+			return err
+		}
+	case entryFile:
+		if err := s.restoreFile(entry, target); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown entry type %q", entry.Type)
+	}
+
+	xattrs := make(map[string]string, len(entry.Xattrs))
+	for key, value := range entry.Xattrs {
+		xattrs[key] = string(value)
+	}
+	if err := setXattrs(target, xattrs); err != nil {
+		glog.Warningf("Failed to restore extended attributes of %s: %v", target, err)
+	}
+	if err := os.Lchown(target, entry.UID, entry.GID); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	if entry.Type != entryFile {
+		return nil
+	}
+	if err := os.Chmod(target, entry.Mode); err != nil {
+		return err
+	}
+	return os.Chtimes(target, entry.ModTime, entry.ModTime)
+}
+
+func (s *chunkStore) restoreFile(entry chunkEntry, target string) error {
+	// O_EXCL fails rather than writing through an existing file or link
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, ref := range entry.Chunks {
+		data, err := s.get(ref.Hash)
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) != ref.Length {
+			return fmt.Errorf("chunk %s has %d bytes, expected %d", ref.Hash, len(data), ref.Length)
+		}
+		if _, err := f.WriteAt(data, ref.Offset); err != nil {
+			return err
+		}
+	}
+	// leaves the holes of a sparse file unallocated
+	if err := f.Truncate(entry.Size); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// verifySnapshot checks that every chunk of the snapshot is stored and
+// intact, it returns the manifest and the number of distinct chunks
+func (s *chunkStore) verifySnapshot(path string) (*chunkSnapshot, int, error) {
+	snapshot, err := readChunkSnapshot(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	verified := make(map[string]bool)
+	for _, entry := range snapshot.Entries {
+		for _, ref := range entry.Chunks {
+			if verified[ref.Hash] {
+				continue
+			}
+			data, err := s.get(ref.Hash)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %v", entry.Name, err)
+			}
+			if int64(len(data)) != ref.Length {
+				return nil, 0, fmt.Errorf("%s: chunk %s has %d bytes, expected %d", entry.Name, ref.Hash, len(data), ref.Length)
+			}
+			verified[ref.Hash] = true
+		}
+	}
+	return snapshot, len(verified), nil
+}
+
+// deleteSnapshot removes the manifest of the snapshot and the chunks that
+// are no longer referenced by any other snapshot
+func (s *chunkStore) deleteSnapshot(path string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	pruned, err := s.prune(0)
+	if err != nil {
+		return fmt.Errorf("deleted %s but failed to prune the chunk store: %v", path, err)
+	}
+	glog.Infof("Deleted %s, pruned %d chunks", path, pruned)
+	return nil
+}
+
+// refCounts returns the number of references to each chunk from the
+// manifests of the depot
+func (s *chunkStore) refCounts() (map[string]int, error) {
+	manifests, err := filepath.Glob(filepath.Join(s.depot, "*"+chunkManifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]int)
+	for _, path := range manifests {
+		snapshot, err := readChunkSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range snapshot.Entries {
+			for _, ref := range entry.Chunks {
+				refs[ref.Hash]++
+			}
+		}
+	}
+	return refs, nil
+}
+
+// prune removes the chunks without references that are older than minAge,
+// the reference counts are computed from the manifests so that they can
+// not get out of sync with them. Nothing is removed if any manifest can not
+// be read.
+func (s *chunkStore) prune(minAge time.Duration) (int, error) {
+	refs, err := s.refCounts()
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	err = s.walkChunks(func(hash, path string, info os.FileInfo) error {
+		if refs[hash] > 0 || time.Since(info.ModTime()) < minAge {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		pruned++
+		return nil
+	})
+	return pruned, err
+}
+
+func (s *chunkStore) walkChunks(fn func(hash, path string, info os.FileInfo) error) error {
+	root := filepath.Join(s.depot, chunksDir)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.Contains(info.Name(), partialSuffix) {
+			return nil
+		}
+		return fn(info.Name(), path, info)
+	})
+	return err
+}
+
+// ChunkStoreReport is the result of the integrity check of a chunk store
+type ChunkStoreReport struct {
+	// Snapshots is the number of snapshots in the store
+	Snapshots int
+	// Chunks is the number of chunks in the store
+	Chunks int
+	// Corrupted are the chunks whose content does not match their hash
+	Corrupted []string
+	// Missing are the chunks referenced by a snapshot but not stored, keyed
+	// by the manifest of the snapshot
+	Missing map[string][]string
+	// Unreferenced are the chunks not referenced by any snapshot
+	Unreferenced []string
+}
+
+// OK returns true if no snapshot of the store is damaged
+func (r *ChunkStoreReport) OK() bool {
+	return len(r.Corrupted) == 0 && len(r.Missing) == 0
+}
+
+// VerifyChunkStore checks the integrity of the chunk store of the depot
+func VerifyChunkStore(depot string) (*ChunkStoreReport, error) {
+	s := newChunkStore(depot)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	report := &ChunkStoreReport{Missing: make(map[string][]string)}
+	stored := make(map[string]bool)
+	err := s.walkChunks(func(hash, path string, _ os.FileInfo) error {
+		report.Chunks++
+		stored[hash] = true
+		if _, err := s.get(hash); err != nil {
+			report.Corrupted = append(report.Corrupted, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := filepath.Glob(filepath.Join(depot, "*"+chunkManifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, path := range manifests {
+		snapshot, err := readChunkSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		report.Snapshots++
+		missing := make(map[string]bool)
+		for _, entry := range snapshot.Entries {
+			for _, ref := range entry.Chunks {
+				referenced[ref.Hash] = true
+				if !stored[ref.Hash] && !missing[ref.Hash] {
+					missing[ref.Hash] = true
+					report.Missing[path] = append(report.Missing[path], ref.Hash)
+				}
+			}
+		}
+	}
+	for hash := range stored {
+		if !referenced[hash] {
+			report.Unreferenced = append(report.Unreferenced, hash)
+		}
+	}
+	sort.Strings(report.Corrupted)
+	sort.Strings(report.Unreferenced)
+	return report, nil
+}
+
+// PruneChunkStore removes the chunks of the depot that are not referenced by
+// any snapshot and are older than minAge. minAge protects the chunks of the
+// snapshots being written by another process.
+func PruneChunkStore(depot string, minAge time.Duration) (int, error) {
+	s := newChunkStore(depot)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.prune(minAge)
+}
@@ -0,0 +1,180 @@
+package hostpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChunkStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "hostpath-chunks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	src := filepath.Join(root, "src")
+	depot := filepath.Join(root, "depot")
+	for _, dir := range []string{src, depot} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := ioutil.WriteFile(filepath.Join(src, "large"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := newChunkStore(depot)
+
+	first := filepath.Join(depot, "first"+chunkManifestSuffix)
+	if _, err := store.createSnapshot(src, first, &archiveProgress{}); err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	_, firstChunks, err := store.verifySnapshot(first)
+	if err != nil {
+		t.Fatalf("Failed to verify snapshot: %v", err)
+	}
+
+	// change a few bytes in the middle of the file, only the chunk around
+	// them has to be stored again
+	copy(data[4<<20:], []byte("changed"))
+	if err := ioutil.WriteFile(filepath.Join(src, "large"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(depot, "second"+chunkManifestSuffix)
+	if _, err := store.createSnapshot(src, second, &archiveProgress{}); err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	report, err := VerifyChunkStore(depot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Snapshots != 2 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if report.Chunks >= 2*firstChunks {
+		t.Errorf("Expected chunks to be shared, %d chunks for a snapshot of %d chunks", report.Chunks, firstChunks)
+	}
+
+	if err := store.deleteSnapshot(first); err != nil {
+		t.Fatalf("Failed to delete snapshot: %v", err)
+	}
+	report, err = VerifyChunkStore(depot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Snapshots != 1 || len(report.Unreferenced) != 0 {
+		t.Fatalf("Unexpected report after delete %+v", report)
+	}
+
+	dst := filepath.Join(root, "restore")
+	if err := os.MkdirAll(dst, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.restoreSnapshot(second, dst); err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	restored, err := ioutil.ReadFile(filepath.Join(dst, "large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, data) {
+		t.Errorf("Content differs after restore")
+	}
+
+	// corrupt a chunk
+	snapshot, err := readChunkSnapshot(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := snapshot.Entries[0].Chunks[0].Hash
+	if err := ioutil.WriteFile(store.chunkPath(hash), []byte("corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.verifySnapshot(second); err == nil {
+		t.Errorf("Expected corrupted chunk to fail the verification")
+	}
+	report, err = VerifyChunkStore(depot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || len(report.Corrupted) != 1 {
+		t.Errorf("Expected one corrupted chunk, got %+v", report)
+	}
+}
+
+func TestChunkStoreRestoreLinks(t *testing.T) {
+	cases := map[string]struct {
+		entries   []chunkEntry
+		expectErr bool
+	}{
+		"Absolute link": {
+			entries:   []chunkEntry{{Name: "link", Type: entrySymlink, Linkname: "/tmp"}},
+			expectErr: true,
+		},
+		"Link outside of the root": {
+			entries:   []chunkEntry{{Name: "link", Type: entrySymlink, Linkname: "../outside"}},
+			expectErr: true,
+		},
+		"Write through a link": {
+			entries: []chunkEntry{
+				{Name: "link", Type: entrySymlink, Linkname: "dir"},
+				{Name: "link/file", Type: entryFile, Mode: 0644},
+			},
+			expectErr: true,
+		},
+		"Read-only directory": {
+			entries: []chunkEntry{
+				{Name: "dir", Type: entryDir, Mode: os.ModeDir | 0555},
+				{Name: "dir/file", Type: entryFile, Mode: 0644},
+				{Name: "dir/link", Type: entrySymlink, Linkname: "file"},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "hostpath-chunks")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				os.Chmod(filepath.Join(root, "restore", "dir"), 0755)
+				os.RemoveAll(root)
+			}()
+			data, err := json.Marshal(&chunkSnapshot{Version: chunkManifestVersion, Entries: tc.entries})
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(root, "snap"+chunkManifestSuffix)
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(root, "restore")
+			if err := os.MkdirAll(filepath.Join(dst, "dir"), 0750); err != nil {
+				t.Fatal(err)
+			}
+
+			err = newChunkStore(root).restoreSnapshot(path, dst)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if _, err := os.Stat(filepath.Join(dst, "dir", "file")); tc.expectErr && !os.IsNotExist(err) {
+				t.Errorf("Expected no file written through the link, got %v", err)
+			}
+			if tc.expectErr {
+				return
+			}
+			info, err := os.Stat(filepath.Join(dst, "dir"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0555 {
+				t.Errorf("Expected mode 0555, got %v", info.Mode().Perm())
+			}
+		})
+	}
+}
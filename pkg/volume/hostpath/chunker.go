@@ -0,0 +1,114 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// Sizes of the content defined chunks, a boundary is cut where the
+	// low bits of the rolling hash selected by chunkMask are zero
+	minChunkSize = 256 << 10
+	maxChunkSize = 4 << 20
+	chunkMask    = 1<<20 - 1
+)
+
+// gearTable maps every byte to a random value of the gear rolling hash, it
+// is derived from sha256 so that chunk boundaries never change
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// chunker splits a stream into content defined chunks, an insert or a
+// delete in a file only changes the chunks around it
+type chunker struct {
+	r   io.Reader
+	buf []byte
+	// start and end of the bytes read but not yet returned
+	start, end int
+	eof        bool
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: r, buf: make([]byte, 2*maxChunkSize)}
+}
+
+// next returns the next chunk, it is only valid until the following call.
+// io.EOF is returned once the stream is exhausted.
+func (c *chunker) next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	data := c.buf[c.start:c.end]
+	n := cutPoint(data)
+	chunk := data[:n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill reads until at least maxChunkSize bytes are buffered or the stream
+// is exhausted
+func (c *chunker) fill() error {
+	if c.end-c.start >= maxChunkSize || c.eof {
+		return nil
+	}
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+	for c.end < len(c.buf) && !c.eof {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+		if c.end >= maxChunkSize {
+			break
+		}
+	}
+	return nil
+}
+
+// cutPoint returns the length of the first chunk of data
+func cutPoint(data []byte) int {
+	if len(data) <= minChunkSize {
+		return len(data)
+	}
+	if len(data) > maxChunkSize {
+		data = data[:maxChunkSize]
+	}
+	var hash uint64
+	for i := minChunkSize; i < len(data); i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
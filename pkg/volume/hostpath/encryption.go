@@ -0,0 +1,344 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// An encrypted archive is the compressed tar stream sealed with AES-256-GCM
+// in segments. Each archive has a random data key of its own, the data key
+// is wrapped by a key of the encryption secret and stored in the manifest.
+// Moving a snapshot to another key only rewrites its manifest.
+//
+// The archive starts with the magic and a random nonce prefix, both are
+// authenticated with every segment. The nonce of a segment is the prefix,
+// the index of the segment and a flag set on the last segment only, so that
+// reordered, dropped or truncated segments fail the authentication.
+const (
+	// ActiveKeyName is the entry of the encryption secret naming the key the
+	// new snapshots are encrypted with, every other entry is a key named by
+	// its key ID
+	ActiveKeyName = "active"
+
+	encryptionMagic   = "OEBSENC1"
+	noncePrefixSize   = 7
+	segmentSize       = 64 << 10
+	dataKeySize       = 32
+	wrapAdditionalKey = "openebs.io/hostpath-snapshot-key/"
+)
+
+var errAuthentication = errors.New("authentication failed, the snapshot is tampered with or the key is wrong")
+
+// EncryptionKeys are the keys of an encryption secret
+type EncryptionKeys struct {
+	// Active is the ID of the key new snapshots are encrypted with
+	Active string
+	keys   map[string][]byte
+}
+
+// ParseEncryptionKeys reads the keys of an encryption secret. Every entry
+// but ActiveKeyName is an AES-256 key, i.e. 32 bytes, named by its key ID.
+func ParseEncryptionKeys(secret *v1.Secret) (*EncryptionKeys, error) {
+	keys := &EncryptionKeys{
+		Active: strings.TrimSpace(string(secret.Data[ActiveKeyName])),
+		keys:   make(map[string][]byte),
+	}
+	for id, key := range secret.Data {
+		if id == ActiveKeyName {
+			continue
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("key %s of secret %s/%s has %d bytes, expected %d", id, secret.Namespace, secret.Name, len(key), dataKeySize)
+		}
+		keys.keys[id] = key
+	}
+	if keys.Active == "" {
+		if len(keys.keys) != 1 {
+			return nil, fmt.Errorf("secret %s/%s has %d keys and no %q entry naming the active one", secret.Namespace, secret.Name, len(keys.keys), ActiveKeyName)
+		}
+		for id := range keys.keys {
+			keys.Active = id
+		}
+	}
+	if _, ok := keys.keys[keys.Active]; !ok {
+		return nil, fmt.Errorf("active key %s not found in secret %s/%s", keys.Active, secret.Namespace, secret.Name)
+	}
+	return keys, nil
+}
+
+// IDs returns the IDs of the keys
+func (k *EncryptionKeys) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// newDataKey generates the data key of an archive and wraps it with the
+// active key
+func (k *EncryptionKeys) newDataKey() ([]byte, *manifestEncryption, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	encryption, err := k.wrap(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, encryption, nil
+}
+
+func (k *EncryptionKeys) wrap(dataKey []byte) (*manifestEncryption, error) {
+	aead, err := newAEAD(k.keys[k.Active])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	wrapped := aead.Seal(nonce, nonce, dataKey, []byte(wrapAdditionalKey+k.Active))
+	return &manifestEncryption{
+		KeyID:      k.Active,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+	}, nil
+}
+
+// unwrap returns the data key of an archive
+func (k *EncryptionKeys) unwrap(encryption *manifestEncryption) ([]byte, error) {
+	key, ok := k.keys[encryption.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in the encryption secret", encryption.KeyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(encryption.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped key: %v", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key, %d bytes", len(wrapped))
+	}
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(wrapAdditionalKey+encryption.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the key of the snapshot with key %s: %v", encryption.KeyID, errAuthentication)
+	}
+	return dataKey, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptingWriter seals what is written to it in segments, Close seals the
+// last segment and must be called for the archive to be readable
+type encryptingWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	buf    []byte
+}
+
+func newEncryptingWriter(w io.Writer, key []byte) (*encryptingWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header := append([]byte(encryptionMagic), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, segmentSize),
+	}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full segment is only sealed once more data follows, the last
+		// segment is sealed by Close
+		if len(e.buf) == segmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):segmentSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptingWriter) seal(last bool) error {
+	if e.index == ^uint32(0) {
+		return fmt.Errorf("archive is too large to be encrypted")
+	}
+	sealed := e.aead.Seal(nil, segmentNonce(e.prefix, e.index, last), e.buf, e.header)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(true)
+}
+
+// decryptingReader opens the segments of an encrypted archive, it fails
+// with errAuthentication on tampered, truncated or wrong-key data
+type decryptingReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	sealed []byte
+	plain  []byte
+	done   bool
+}
+
+func newDecryptingReader(r io.Reader, key []byte) (*decryptingReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(encryptionMagic)+noncePrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read the header of the encrypted archive: %v", err)
+	}
+	if !bytes.Equal(header[:len(encryptionMagic)], []byte(encryptionMagic)) {
+		return nil, fmt.Errorf("archive is not encrypted")
+	}
+	return &decryptingReader{
+		r:      bufio.NewReaderSize(r, segmentSize+aead.Overhead()+1),
+		aead:   aead,
+		header: header,
+		prefix: header[len(encryptionMagic):],
+		sealed: make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	last := false
+	switch err {
+	case nil:
+		// a full segment is the last one if nothing follows it
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF, io.EOF:
+		last = true
+	default:
+		return err
+	}
+	plain, err := d.aead.Open(d.sealed[:0], segmentNonce(d.prefix, d.index, last), d.sealed[:n], d.header)
+	if err != nil {
+		return errAuthentication
+	}
+	d.index++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+// RewrapSnapshot moves the encrypted archive to the active key of keys, it
+// returns the ID of the key the archive was encrypted with. Only the
+// manifest is rewritten, the archive is left as it is.
+func RewrapSnapshot(archive string, keys *EncryptionKeys) (string, error) {
+	m, err := readManifest(archive)
+	if err != nil {
+		return "", err
+	}
+	if m.Encryption == nil {
+		return "", fmt.Errorf("archive %s is not encrypted", archive)
+	}
+	previous := m.Encryption.KeyID
+	if previous == keys.Active {
+		return previous, nil
+	}
+	dataKey, err := keys.unwrap(m.Encryption)
+	if err != nil {
+		return "", err
+	}
+	if m.Encryption, err = keys.wrap(dataKey); err != nil {
+		return "", err
+	}
+	return previous, writeManifest(archive, m)
+}
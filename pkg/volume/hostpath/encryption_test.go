@@ -0,0 +1,205 @@
+package hostpath
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testKeys(t *testing.T, active string, ids ...string) *EncryptionKeys {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "keys"},
+		Data:       map[string][]byte{ActiveKeyName: []byte(active)},
+	}
+	for _, id := range ids {
+		secret.Data[id] = bytes.Repeat([]byte(id[:1]), dataKeySize)
+	}
+	keys, err := ParseEncryptionKeys(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := newEncryptingWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key, sealed []byte) ([]byte, error) {
+	r, err := newDecryptingReader(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, dataKeySize)
+	tests := map[string]int{
+		"empty":                  0,
+		"partial segment":        100,
+		"one segment":            segmentSize,
+		"one segment and a byte": segmentSize + 1,
+		"two segments":           2 * segmentSize,
+		"several segments":       3*segmentSize + 17,
+	}
+	for name, size := range tests {
+		t.Run(name, func(t *testing.T) {
+			plain := make([]byte, size)
+			rand.New(rand.NewSource(int64(size))).Read(plain)
+			got, err := decrypt(key, encrypt(t, key, plain))
+			if err != nil {
+				t.Fatalf("Failed to decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("Decrypted %d bytes differ from the %d bytes encrypted", len(got), len(plain))
+			}
+		})
+	}
+}
+
+func TestEncryptionTampered(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, dataKeySize)
+	plain := make([]byte, 3*segmentSize)
+	sealed := encrypt(t, key, plain)
+	header := len(encryptionMagic) + noncePrefixSize
+	sealedSegment := segmentSize + 16
+
+	tests := map[string]struct {
+		key    []byte
+		sealed func() []byte
+	}{
+		"flipped bit": {
+			key: key,
+			sealed: func() []byte {
+				s := append([]byte{}, sealed...)
+				s[header+10] ^= 1
+				return s
+			},
+		},
+		"flipped nonce prefix": {
+			key: key,
+			sealed: func() []byte {
+				s := append([]byte{}, sealed...)
+				s[len(encryptionMagic)] ^= 1
+				return s
+			},
+		},
+		"truncated at a segment": {
+			key: key,
+			sealed: func() []byte {
+				return sealed[:header+sealedSegment]
+			},
+		},
+		"truncated in a segment": {
+			key: key,
+			sealed: func() []byte {
+				return sealed[:len(sealed)-1]
+			},
+		},
+		"dropped segment": {
+			key: key,
+			sealed: func() []byte {
+				s := append([]byte{}, sealed[:header]...)
+				return append(s, sealed[header+sealedSegment:]...)
+			},
+		},
+		"wrong key": {
+			key: bytes.Repeat([]byte{2}, dataKeySize),
+			sealed: func() []byte {
+				return sealed
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decrypt(test.key, test.sealed()); err != errAuthentication {
+				t.Errorf("Expected %v, got %v", errAuthentication, err)
+			}
+		})
+	}
+}
+
+func TestEncryptedArchive(t *testing.T) {
+	root, err := ioutil.TempDir("", "hostpath-encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	src := filepath.Join(root, "src")
+	if err := os.MkdirAll(src, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "secret-name"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := testKeys(t, "a1", "a1")
+	key, encryption, err := keys.newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(root, "snapshot.tar.gz")
+	m, err := createArchive(src, archive, CompressionGzip, key, &archiveProgress{})
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	if len(m.Files) != 0 {
+		t.Errorf("Expected no files in the manifest of an encrypted archive, got %v", m.Files)
+	}
+	m.Encryption = encryption
+	if err := writeManifest(archive, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate to a new key, the previous one is still needed to rewrap
+	if _, err := testKeys(t, "b2", "b2").unwrap(m.Encryption); err == nil {
+		t.Errorf("Expected the data key not to unwrap with another key")
+	}
+	rotated := testKeys(t, "b2", "a1", "b2")
+	previous, err := RewrapSnapshot(archive, rotated)
+	if err != nil {
+		t.Fatalf("Failed to rewrap: %v", err)
+	}
+	if previous != "a1" {
+		t.Errorf("Expected previous key a1, got %s", previous)
+	}
+
+	// the old key is removed once the archives are rewrapped
+	m, err = verifyArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = testKeys(t, "b2", "b2").unwrap(m.Encryption)
+	if err != nil {
+		t.Fatalf("Failed to unwrap with the new key: %v", err)
+	}
+	dst := filepath.Join(root, "dst")
+	if err := os.MkdirAll(dst, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractArchive(archive, dst, m, key); err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dst, "secret-name"))
+	if err != nil || string(content) != "content" {
+		t.Errorf("Unexpected content %q: %v", content, err)
+	}
+}
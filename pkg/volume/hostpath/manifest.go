@@ -0,0 +1,139 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	manifestVersion = 1
+	manifestSuffix  = ".manifest.json"
+)
+
+// manifest is stored next to each archive, it records the checksum of the
+// archive and of every regular file in it
+type manifest struct {
+	Version       int             `json:"version"`
+	Compression   Compression     `json:"compression"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	ArchiveSize   int64           `json:"archiveSize"`
+	ArchiveSHA256 string          `json:"archiveSHA256"`
+	Files         []manifestEntry `json:"files"`
+	// Encryption is set on encrypted archives
+	Encryption *manifestEncryption `json:"encryption,omitempty"`
+}
+
+// manifestEncryption is the data key of an encrypted archive wrapped by a
+// key of the encryption secret
+type manifestEncryption struct {
+	KeyID      string `json:"keyID"`
+	WrappedKey string `json:"wrappedKey"`
+}
+
+// manifestEntry is the checksum of the content of a regular file as stored
+// in the archive, i.e. without the holes of sparse files
+type manifestEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func manifestPath(archive string) string {
+	return archive + manifestSuffix
+}
+
+func writeManifest(archive string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := manifestPath(archive)
+	if err := ioutil.WriteFile(path+partialSuffix, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+partialSuffix, path)
+}
+
+func readManifest(archive string) (*manifest, error) {
+	data, err := ioutil.ReadFile(manifestPath(archive))
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest of %s: %v", archive, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d of %s", m.Version, archive)
+	}
+	return m, nil
+}
+
+// isLegacyArchive returns true if the archive was written by tar czf before
+// the manifests were introduced, i.e. it is a .tgz without a manifest
+func isLegacyArchive(archive string) bool {
+	if !strings.HasSuffix(archive, legacyArchiveExtension) {
+		return false
+	}
+	_, err := os.Stat(manifestPath(archive))
+	return os.IsNotExist(err)
+}
+
+// verifyArchive reads the manifest of the archive and checks the size and
+// the checksum of the archive against it. A legacy archive has nothing to
+// be checked against, the manifest returned for it only has its compression.
+func verifyArchive(archive string) (*manifest, error) {
+	if isLegacyArchive(archive) {
+		if _, err := os.Stat(archive); err != nil {
+			return nil, err
+		}
+		glog.Warningf("Archive %s has no manifest, it is not verified", archive)
+		return &manifest{Version: manifestVersion, Compression: compressionOfArchive(archive)}, nil
+	}
+	m, err := readManifest(archive)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	if size != m.ArchiveSize {
+		return nil, fmt.Errorf("size mismatch of %s, expected %d got %d", archive, m.ArchiveSize, size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != m.ArchiveSHA256 {
+		return nil, fmt.Errorf("checksum mismatch of %s, expected %s got %s", archive, m.ArchiveSHA256, sum)
+	}
+	return m, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const nodeNameField = "metadata.name"
+
+// nodeAffinityMatches returns whether a volume with the node affinity is
+// reachable from the node with the name and the labels
+func nodeAffinityMatches(affinity *v1.VolumeNodeAffinity, nodeName string, nodeLabels map[string]string) (bool, error) {
+	if affinity == nil || affinity.Required == nil {
+		return true, nil
+	}
+	nodeFields := labels.Set{nodeNameField: nodeName}
+	for _, term := range affinity.Required.NodeSelectorTerms {
+		// an empty term matches no node
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		labelsMatch, err := requirementsMatch(term.MatchExpressions, labels.Set(nodeLabels))
+		if err != nil {
+			return false, err
+		}
+		fieldsMatch, err := requirementsMatch(term.MatchFields, nodeFields)
+		if err != nil {
+			return false, err
+		}
+		if labelsMatch && fieldsMatch {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func requirementsMatch(requirements []v1.NodeSelectorRequirement, set labels.Set) (bool, error) {
+	selector := labels.NewSelector()
+	for _, r := range requirements {
+		var op selection.Operator
+		switch r.Operator {
+		case v1.NodeSelectorOpIn:
+			op = selection.In
+		case v1.NodeSelectorOpNotIn:
+			op = selection.NotIn
+		case v1.NodeSelectorOpExists:
+			op = selection.Exists
+		case v1.NodeSelectorOpDoesNotExist:
+			op = selection.DoesNotExist
+		case v1.NodeSelectorOpGt:
+			op = selection.GreaterThan
+		case v1.NodeSelectorOpLt:
+			op = selection.LessThan
+		default:
+			return false, fmt.Errorf("invalid node selector operator %q", r.Operator)
+		}
+		requirement, err := labels.NewRequirement(r.Key, op, r.Values)
+		if err != nil {
+			return false, err
+		}
+		selector = selector.Add(*requirement)
+	}
+	return selector.Matches(set), nil
+}
@@ -0,0 +1,47 @@
+package hostpath
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestNodeAffinityMatches(t *testing.T) {
+	term := func(key string, op v1.NodeSelectorOperator, values ...string) v1.NodeSelectorTerm {
+		return v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: key, Operator: op, Values: values}}}
+	}
+	affinity := func(terms ...v1.NodeSelectorTerm) *v1.VolumeNodeAffinity {
+		return &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: terms}}
+	}
+	nodeLabels := map[string]string{v1.LabelHostname: "host-1", "topology.kubernetes.io/zone": "zone1"}
+	cases := map[string]struct {
+		affinity    *v1.VolumeNodeAffinity
+		expectMatch bool
+		expectErr   bool
+	}{
+		"No affinity":         {nil, true, false},
+		"Pinned to the node":  {affinity(term(v1.LabelHostname, v1.NodeSelectorOpIn, "host-1")), true, false},
+		"Pinned to the name":  {affinity(term(v1.LabelHostname, v1.NodeSelectorOpIn, "node1")), false, false},
+		"Pinned to another":   {affinity(term(v1.LabelHostname, v1.NodeSelectorOpIn, "host-2")), false, false},
+		"Second term matches": {affinity(term(v1.LabelHostname, v1.NodeSelectorOpIn, "host-2"), term(v1.LabelHostname, v1.NodeSelectorOpIn, "host-1")), true, false},
+		"NotIn":               {affinity(term(v1.LabelHostname, v1.NodeSelectorOpNotIn, "host-1")), false, false},
+		"Zone label":          {affinity(term("topology.kubernetes.io/zone", v1.NodeSelectorOpIn, "zone1")), true, false},
+		"Unknown label":       {affinity(term("topology.kubernetes.io/region", v1.NodeSelectorOpIn, "region1")), false, false},
+		"Empty term":          {affinity(v1.NodeSelectorTerm{}), false, false},
+		"Match fields": {affinity(v1.NodeSelectorTerm{MatchFields: []v1.NodeSelectorRequirement{
+			{Key: "metadata.name", Operator: v1.NodeSelectorOpIn, Values: []string{"node1"}},
+		}}), true, false},
+		"Invalid operator": {affinity(term(v1.LabelHostname, "Near", "host-1")), false, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			match, err := nodeAffinityMatches(tc.affinity, "node1", nodeLabels)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if match != tc.expectMatch {
+				t.Errorf("Expected match %v, got %v", tc.expectMatch, match)
+			}
+		})
+	}
+}
@@ -17,15 +17,18 @@ limitations under the License.
 package hostpath
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/golang/glog"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
 
 	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
@@ -33,18 +36,101 @@ import (
 )
 
 const (
-	depot        = "/tmp/"
-	restorePoint = "/tmp/restore/"
+	defaultDepot        = "/tmp/"
+	defaultRestorePoint = "/tmp/restore/"
+	defaultCompression  = CompressionGzip
+	defaultFormat       = FormatArchive
+
+	// DepotENVK is the environment variable holding the directory the
+	// snapshot archives are written to
+	DepotENVK = "OPENEBS_IO_HOSTPATH_SNAPSHOT_DEPOT"
+	// RestorePointENVK is the environment variable holding the directory the
+	// snapshots are restored to
+	RestorePointENVK = "OPENEBS_IO_HOSTPATH_SNAPSHOT_RESTORE_DIR"
+	// CompressionENVK is the environment variable holding the default
+	// compression of the snapshot archives
+	CompressionENVK = "OPENEBS_IO_HOSTPATH_SNAPSHOT_COMPRESSION"
+	// FormatENVK is the environment variable holding the default format of
+	// the snapshots
+	FormatENVK = "OPENEBS_IO_HOSTPATH_SNAPSHOT_FORMAT"
+	// EncryptionSecretENVK is the environment variable holding the default
+	// encryption secret of the snapshots as namespace/name, the snapshots
+	// are not encrypted by default
+	EncryptionSecretENVK = "OPENEBS_IO_HOSTPATH_SNAPSHOT_ENCRYPTION_SECRET"
+
+	// CompressionAnnotation on a VolumeSnapshot overrides the default
+	// compression of its archive, one of none, gzip or zstd
+	CompressionAnnotation = "hostpath.snapshot.openebs.io/compression"
+	// FormatAnnotation on a VolumeSnapshot overrides the default format of
+	// the snapshot, one of archive or chunks. The snapshot API has no
+	// snapshot classes, so the format is chosen per VolumeSnapshot.
+	FormatAnnotation = "hostpath.snapshot.openebs.io/format"
+	// EncryptionSecretAnnotation on a VolumeSnapshot names the secret
+	// holding the keys its archive is encrypted with, as name in the
+	// namespace of the snapshot or as namespace/name
+	EncryptionSecretAnnotation = "hostpath.snapshot.openebs.io/encryption-secret"
 )
 
 type hostPathPlugin struct {
+	// nodeName is the node the archives are written to and restored on
+	nodeName     string
+	depot        string
+	restorePoint string
+	compression  Compression
+	format       Format
+	chunks       *chunkStore
+	// encryptionSecret is the default encryption secret, as namespace/name
+	encryptionSecret string
+	kubeClient       kubernetes.Interface
+
+	// archives tracks the archives being written by this process, keyed
+	// by the path of the archive
+	mutex    sync.Mutex
+	archives map[string]*archiveProgress
 }
 
-var _ volume.Plugin = &hostPathPlugin{}
+var _ volume.PluginV2 = &hostPathPlugin{}
+var _ volume.NodeAffinityPlugin = &hostPathPlugin{}
+var _ volume.KubeClientPlugin = &hostPathPlugin{}
+
+// RegisterPlugin registers the volume plugin, the objects of the cluster
+// are read with the context of the calls
+func RegisterPlugin() volume.PluginV2 {
+	h := &hostPathPlugin{
+		nodeName:     volume.NodeName(),
+		depot:        getEnv(DepotENVK, defaultDepot),
+		restorePoint: getEnv(RestorePointENVK, defaultRestorePoint),
+		compression:  defaultCompression,
+		format:       defaultFormat,
+		archives:     make(map[string]*archiveProgress),
 
-// RegisterPlugin registers the volume plugin
-func RegisterPlugin() volume.Plugin {
-	return &hostPathPlugin{}
+		encryptionSecret: os.Getenv(EncryptionSecretENVK),
+	}
+	h.chunks = newChunkStore(h.depot)
+	if value := os.Getenv(CompressionENVK); value != "" {
+		compression, err := parseCompression(value)
+		if err != nil {
+			glog.Errorf("Ignoring %s: %v", CompressionENVK, err)
+		} else {
+			h.compression = compression
+		}
+	}
+	if value := os.Getenv(FormatENVK); value != "" {
+		format, err := parseFormat(value)
+		if err != nil {
+			glog.Errorf("Ignoring %s: %v", FormatENVK, err)
+		} else {
+			h.format = format
+		}
+	}
+	return h
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
 }
 
 // GetPluginName gets the name of the volume plugin
@@ -55,112 +141,377 @@ func GetPluginName() string {
 func (h *hostPathPlugin) Init(_ cloudprovider.Interface) {
 }
 
-func (h *hostPathPlugin) SnapshotCreate(
+// Capabilities returns the optional calls the plugin supports, the snapshots can not be found by their tags
+func (h *hostPathPlugin) Capabilities() volume.Capabilities {
+	capabilities := volume.AllCapabilities
+	capabilities.FindSnapshot = false
+	return capabilities
+}
+
+// SetKubeClient sets the client the encryption secrets and the labels of the
+// node are read with
+func (h *hostPathPlugin) SetKubeClient(client kubernetes.Interface) {
+	h.kubeClient = client
+}
+
+// encryptionKeys reads the keys of the encryption secret, given as
+// namespace/name
+func (h *hostPathPlugin) encryptionKeys(ctx context.Context, secret string) (*EncryptionKeys, error) {
+	if h.kubeClient == nil {
+		return nil, fmt.Errorf("can not read encryption secret %s, no kubernetes client", secret)
+	}
+	parts := strings.Split(secret, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid encryption secret %q, expected namespace/name", secret)
+	}
+	s, err := h.kubeClient.CoreV1().Secrets(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption secret %s: %v", secret, err)
+	}
+	return ParseEncryptionKeys(s)
+}
+
+// snapshotEncryptionSecret returns the encryption secret of the snapshot as
+// namespace/name, empty if the snapshot is not encrypted
+func (h *hostPathPlugin) snapshotEncryptionSecret(snapshot *crdv1.VolumeSnapshot) string {
+	value, ok := snapshot.Metadata.Annotations[EncryptionSecretAnnotation]
+	if !ok {
+		return h.encryptionSecret
+	}
+	if value != "" && !strings.Contains(value, "/") {
+		return snapshot.Metadata.Namespace + "/" + value
+	}
+	return value
+}
+
+// SnapshotCreate starts archiving the volume in the background, the
+// progress is reported by DescribeSnapshot
+func (h *hostPathPlugin) SnapshotCreate(ctx context.Context, snapshot *crdv1.VolumeSnapshot, pv *v1.PersistentVolume, _ map[string]string) (volume.SnapshotResult, error) {
+	return volume.NewSnapshotResult(h.snapshotCreate(ctx, snapshot, pv))
+}
+
+func (h *hostPathPlugin) snapshotCreate(
+	ctx context.Context,
 	snapshot *crdv1.VolumeSnapshot,
 	pv *v1.PersistentVolume,
-	tags *map[string]string,
 ) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
 	spec := &pv.Spec
 	if spec == nil || spec.HostPath == nil {
 		return nil, nil, fmt.Errorf("invalid PV spec %v", spec)
 	}
-	path := spec.HostPath.Path
-	file := depot + string(uuid.NewUUID()) + ".tgz"
-	cmdline := []string{"tar", "czf", file, "-C", path, "."}
-	cmd := exec.Command(cmdline[0], cmdline[1:]...)
-	out, err := cmd.CombinedOutput()
-	cond := []crdv1.VolumeSnapshotCondition{}
-	if err == nil {
-		cond = []crdv1.VolumeSnapshotCondition{
-			{
-				Status:             v1.ConditionTrue,
-				Message:            "Snapshot created successfully",
-				LastTransitionTime: metav1.Now(),
-				Type:               crdv1.VolumeSnapshotConditionReady,
-			},
+	// the directory of the volume is only found on the nodes it is pinned to
+	if spec.NodeAffinity != nil {
+		if h.nodeName == "" {
+			return nil, nil, fmt.Errorf("can not snapshot %s with node affinity, %s is not set", pv.Name, volume.NodeNameENVK)
 		}
-	} else {
-		glog.V(2).Infof("failed to execute %q: %v", strings.Join(cmdline, " "), err)
-		glog.V(3).Infof("output: %s", string(out))
-		cond = []crdv1.VolumeSnapshotCondition{
-			{
-				Status:             v1.ConditionTrue,
-				Message:            fmt.Sprintf("Failed to create the snapshot: %v", err),
-				LastTransitionTime: metav1.Now(),
-				Type:               crdv1.VolumeSnapshotConditionError,
-			},
+		nodeLabels, err := volume.NodeLabels(ctx, h.kubeClient, h.nodeName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can not snapshot %s with node affinity: %v", pv.Name, err)
 		}
+		ok, err := nodeAffinityMatches(spec.NodeAffinity, h.nodeName, nodeLabels)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid node affinity of %s: %v", pv.Name, err)
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("can not snapshot %s on node %s, it does not match the node affinity of the volume", pv.Name, h.nodeName)
+		}
+	}
+	compression := h.compression
+	if value, ok := snapshot.Metadata.Annotations[CompressionAnnotation]; ok {
+		var err error
+		if compression, err = parseCompression(value); err != nil {
+			return nil, nil, err
+		}
+	}
+	format := h.format
+	if value, ok := snapshot.Metadata.Annotations[FormatAnnotation]; ok {
+		var err error
+		if format, err = parseFormat(value); err != nil {
+			return nil, nil, err
+		}
+	}
+	secret := h.snapshotEncryptionSecret(snapshot)
+	var keys *EncryptionKeys
+	if secret != "" {
+		if format != FormatArchive {
+			return nil, nil, fmt.Errorf("encryption is only supported by the %s format", FormatArchive)
+		}
+		var err error
+		if keys, err = h.encryptionKeys(ctx, secret); err != nil {
+			return nil, nil, err
+		}
+	}
+	path := spec.HostPath.Path
+	total, err := dataSize(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if err := os.MkdirAll(h.depot, 0750); err != nil {
+		return nil, nil, err
+	}
+
+	var file string
+	switch format {
+	case FormatChunks:
+		file = filepath.Join(h.depot, string(uuid.NewUUID())+chunkManifestSuffix)
+	default:
+		file = filepath.Join(h.depot, string(uuid.NewUUID())+archiveExtension(compression))
+	}
+	h.startSnapshot(path, file, total, compression, keys)
+
+	cond := []crdv1.VolumeSnapshotCondition{
+		{
+			Status:             v1.ConditionTrue,
+			Message:            "Snapshot is being written",
+			LastTransitionTime: metav1.Now(),
+			Type:               crdv1.VolumeSnapshotConditionPending,
+		},
 	}
 	res := &crdv1.VolumeSnapshotDataSource{
 		HostPath: &crdv1.HostPathVolumeSnapshotSource{
-			Path: file,
+			Path:     file,
+			NodeName: h.nodeName,
 		},
 	}
-	return res, &cond, err
+	if keys != nil {
+		res.HostPath.EncryptionSecret = secret
+		res.HostPath.EncryptionKeyID = keys.Active
+	}
+	return res, &cond, nil
+}
+
+// startSnapshot writes the snapshot of the directory path to file in the
+// background, the format and compression of the snapshot are the ones of
+// the name of file. Its progress is tracked until it is written.
+func (h *hostPathPlugin) startSnapshot(path, file string, total int64, compression Compression, keys *EncryptionKeys) {
+	var create func(*archiveProgress) error
+	if isChunkSnapshot(file) {
+		create = func(progress *archiveProgress) error {
+			snapshot, err := h.chunks.createSnapshot(path, file, progress)
+			if err == nil {
+				glog.Infof("Stored %s as %s, %d files", path, file, len(snapshot.Entries))
+			}
+			return err
+		}
+	} else {
+		create = func(progress *archiveProgress) error {
+			var key []byte
+			var encryption *manifestEncryption
+			if keys != nil {
+				var err error
+				if key, encryption, err = keys.newDataKey(); err != nil {
+					return err
+				}
+			}
+			m, err := createArchive(path, file, compression, key, progress)
+			if err == nil {
+				m.Encryption = encryption
+				err = writeManifest(file, m)
+			}
+			if err == nil {
+				glog.Infof("Archived %s to %s, %d bytes, sha256 %s", path, file, m.ArchiveSize, m.ArchiveSHA256)
+			}
+			return err
+		}
+	}
+
+	progress := &archiveProgress{total: total}
+	h.mutex.Lock()
+	h.archives[file] = progress
+	h.mutex.Unlock()
+	go func() {
+		err := create(progress)
+		if err != nil {
+			glog.Errorf("Failed to snapshot %s to %s: %v", path, file, err)
+		}
+		// a written snapshot is found through its manifest, only the failed
+		// ones are tracked further to report their error
+		if err == nil {
+			h.mutex.Lock()
+			if h.archives[file] == progress {
+				delete(h.archives, file)
+			}
+			h.mutex.Unlock()
+		}
+		progress.finish(err)
+	}()
 }
 
-func (h *hostPathPlugin) SnapshotDelete(src *crdv1.VolumeSnapshotDataSource, _ *v1.PersistentVolume) error {
+// snapshotWritten returns true if the snapshot at path is written, the
+// manifest of an archive is written once the archive is
+func snapshotWritten(path string) (bool, error) {
+	if !isChunkSnapshot(path) {
+		path = manifestPath(path)
+	}
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// removePartialFiles removes the files left behind by the interrupted write
+// of the snapshot at path
+func removePartialFiles(path string) {
+	for _, partial := range []string{path + partialSuffix, manifestPath(path) + partialSuffix} {
+		if err := os.Remove(partial); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("Failed to remove %s: %v", partial, err)
+		}
+	}
+}
+
+// restartSnapshot writes again the snapshot whose write was interrupted,
+// e.g. by a restart of the process, from the PV of the snapshot
+func (h *hostPathPlugin) restartSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) error {
+	src := snapshotData.Spec.HostPath
+	if src.NodeName != "" && src.NodeName != h.nodeName {
+		return fmt.Errorf("snapshot %s is written on node %s", src.Path, src.NodeName)
+	}
+	if snapshotData.Spec.PersistentVolumeRef == nil {
+		return fmt.Errorf("snapshot %s has no PV", src.Path)
+	}
+	if h.kubeClient == nil {
+		return fmt.Errorf("can not read PV %s, no kubernetes client", snapshotData.Spec.PersistentVolumeRef.Name)
+	}
+	pv, err := h.kubeClient.CoreV1().PersistentVolumes().Get(ctx, snapshotData.Spec.PersistentVolumeRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pv.Spec.HostPath == nil {
+		return fmt.Errorf("PV %s is not a hostPath volume", pv.Name)
+	}
+	total, err := dataSize(pv.Spec.HostPath.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", pv.Spec.HostPath.Path, err)
+	}
+	var keys *EncryptionKeys
+	if src.EncryptionSecret != "" {
+		if keys, err = h.encryptionKeys(ctx, src.EncryptionSecret); err != nil {
+			return err
+		}
+	}
+	removePartialFiles(src.Path)
+	glog.Infof("Restarting the interrupted snapshot of %s to %s", pv.Spec.HostPath.Path, src.Path)
+	h.startSnapshot(pv.Spec.HostPath.Path, src.Path, total, compressionOfArchive(src.Path), keys)
+	return nil
+}
+
+func (h *hostPathPlugin) SnapshotDelete(_ context.Context, src *crdv1.VolumeSnapshotDataSource, _ *v1.PersistentVolume) error {
 	if src == nil || src.HostPath == nil {
 		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", src)
 	}
 	path := src.HostPath.Path
+	h.mutex.Lock()
+	delete(h.archives, path)
+	h.mutex.Unlock()
+	removePartialFiles(path)
+	if isChunkSnapshot(path) {
+		return h.chunks.deleteSnapshot(path)
+	}
+	if err := os.Remove(manifestPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return os.Remove(path)
 }
 
-func (h *hostPathPlugin) DescribeSnapshot(snapshotData *crdv1.VolumeSnapshotData) (snapConditions *[]crdv1.VolumeSnapshotCondition, isCompleted bool, err error) {
+// DescribeSnapshot reports the progress of an archive being written by this
+// process, once written the archive is verified against its manifest. The
+// write of an archive interrupted by a restart of the process is restarted,
+// a legacy archive has no manifest but is written already.
+func (h *hostPathPlugin) DescribeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (volume.DescribeResult, error) {
+	return volume.NewDescribeResult(h.describeSnapshot(ctx, snapshotData))
+}
+
+func (h *hostPathPlugin) describeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (*[]crdv1.VolumeSnapshotCondition, bool, error) {
 	if snapshotData == nil || snapshotData.Spec.HostPath == nil {
 		return nil, false, fmt.Errorf("failed to retrieve Snapshot spec")
 	}
 	path := snapshotData.Spec.HostPath.Path
-	if _, err := os.Stat(path); err != nil {
-		return nil, false, err
-	}
-	if len(snapshotData.Status.Conditions) == 0 {
-		return nil, false, fmt.Errorf("No status condtions in VoluemSnapshotData for hostpath snapshot type")
-	}
-	lastCondIdx := len(snapshotData.Status.Conditions) - 1
-	retCondType := crdv1.VolumeSnapshotConditionError
-	switch snapshotData.Status.Conditions[lastCondIdx].Type {
-	case crdv1.VolumeSnapshotDataConditionReady:
-		retCondType = crdv1.VolumeSnapshotConditionReady
-	case crdv1.VolumeSnapshotDataConditionPending:
-		retCondType = crdv1.VolumeSnapshotConditionPending
-		// Error othewise
-	}
-	retCond := []crdv1.VolumeSnapshotCondition{
+
+	h.mutex.Lock()
+	progress, ok := h.archives[path]
+	h.mutex.Unlock()
+	if ok {
+		finished, message, err := progress.status()
+		if !finished {
+			return newConditions(crdv1.VolumeSnapshotConditionPending, message), false, nil
+		}
+		if err != nil {
+			return newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Failed to create the snapshot: %v", err)), true, nil
+		}
+	} else if written, err := snapshotWritten(path); err == nil && !written && !isLegacyArchive(path) {
+		if err := h.restartSnapshot(ctx, snapshotData); err != nil {
+			return newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Failed to restart the interrupted snapshot: %v", err)), true, nil
+		}
+		return newConditions(crdv1.VolumeSnapshotConditionPending, "Snapshot is being written again after an interruption"), false, nil
+	}
+
+	message, err := h.verifySnapshot(path)
+	if err != nil {
+		return newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Failed to verify the snapshot: %v", err)), true, nil
+	}
+	return newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully, "+message), true, nil
+}
+
+// verifySnapshot checks the snapshot against its manifest and returns a
+// summary of it
+func (h *hostPathPlugin) verifySnapshot(path string) (string, error) {
+	if isChunkSnapshot(path) {
+		snapshot, chunks, err := h.chunks.verifySnapshot(path)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d files, %d chunks", len(snapshot.Entries), chunks), nil
+	}
+	m, err := verifyArchive(path)
+	if err != nil {
+		return "", err
+	}
+	if isLegacyArchive(path) {
+		return "legacy archive without manifest, not verified", nil
+	}
+	if m.Encryption != nil {
+		return fmt.Sprintf("encrypted with key %s, sha256 %s", m.Encryption.KeyID, m.ArchiveSHA256), nil
+	}
+	return fmt.Sprintf("%d files, sha256 %s", len(m.Files), m.ArchiveSHA256), nil
+}
+
+func newConditions(conditionType crdv1.VolumeSnapshotConditionType, message string) *[]crdv1.VolumeSnapshotCondition {
+	return &[]crdv1.VolumeSnapshotCondition{
 		{
-			Status:             snapshotData.Status.Conditions[lastCondIdx].Status,
-			Message:            snapshotData.Status.Conditions[lastCondIdx].Message,
-			LastTransitionTime: snapshotData.Status.Conditions[lastCondIdx].LastTransitionTime,
-			Type:               retCondType,
+			Status:             v1.ConditionTrue,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+			Type:               conditionType,
 		},
 	}
-	return &retCond, true, nil
 }
 
 // FindSnapshot finds a VolumeSnapshot by matching metadata
-func (h *hostPathPlugin) FindSnapshot(tags *map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
-	glog.Infof("FindSnapshot by tags: %#v", *tags)
+func (h *hostPathPlugin) FindSnapshot(_ context.Context, _ map[string]string) (volume.SnapshotResult, error) {
+	return volume.SnapshotResult{}, volume.NewUnsupportedError("FindSnapshot is not supported by the %s plugin", GetPluginName())
+}
 
-	// TODO: Implement FindSnapshot
-	return nil, nil, fmt.Errorf("Snapshot not found")
+func (h *hostPathPlugin) SnapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData, _ *v1.PersistentVolumeClaim, _ string, _ map[string]string) (volume.RestoreResult, error) {
+	return volume.NewRestoreResult(h.snapshotRestore(ctx, snapshotData))
 }
 
-func (h *hostPathPlugin) SnapshotRestore(snapshotData *crdv1.VolumeSnapshotData, _ *v1.PersistentVolumeClaim, _ string, _ map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
+func (h *hostPathPlugin) snapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (*v1.PersistentVolumeSource, map[string]string, error) {
 	// retrieve VolumeSnapshotDataSource
 	if snapshotData == nil || snapshotData.Spec.HostPath == nil {
 		return nil, nil, fmt.Errorf("failed to retrieve Snapshot spec")
 	}
 	// restore snapshot to a PV
 	snapID := snapshotData.Spec.HostPath.Path
-	dir := restorePoint + string(uuid.NewUUID())
-	os.MkdirAll(dir, 0750)
-	cmdline := []string{"tar", "xzf", snapID, "-C", dir, "--strip-components=1"}
-	cmd := exec.Command(cmdline[0], cmdline[1:]...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		glog.V(2).Infof("failed to execute %q: %v", strings.Join(cmdline, " "), err)
-		glog.V(3).Infof("output: %s", string(out))
+	if node := snapshotData.Spec.HostPath.NodeName; node != "" && node != h.nodeName {
+		return nil, nil, fmt.Errorf("snapshot %s is stored on node %s, it can not be restored on node %q", snapID, node, h.nodeName)
+	}
+	dir := filepath.Join(h.restorePoint, string(uuid.NewUUID()))
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, nil, err
+	}
+	if err := h.restoreSnapshot(ctx, snapshotData.Spec.HostPath, dir); err != nil {
+		os.RemoveAll(dir)
 		return nil, nil, fmt.Errorf("failed to restore %s to %s: %v", snapID, dir, err)
 	}
 	pv := &v1.PersistentVolumeSource{
@@ -171,7 +522,47 @@ func (h *hostPathPlugin) SnapshotRestore(snapshotData *crdv1.VolumeSnapshotData,
 	return pv, nil, nil
 }
 
-func (h *hostPathPlugin) VolumeDelete(pv *v1.PersistentVolume) error {
+// restoreSnapshot restores the snapshot into dir, the chunks and the content
+// of the archives are checked against the manifest while restoring. The
+// legacy archives are extracted without any check.
+func (h *hostPathPlugin) restoreSnapshot(ctx context.Context, src *crdv1.HostPathVolumeSnapshotSource, dir string) error {
+	path := src.Path
+	if isChunkSnapshot(path) {
+		return h.chunks.restoreSnapshot(path, dir)
+	}
+	m, err := verifyArchive(path)
+	if err != nil {
+		return err
+	}
+	var key []byte
+	if m.Encryption != nil {
+		if src.EncryptionSecret == "" {
+			return fmt.Errorf("archive is encrypted with key %s, the snapshot has no encryption secret", m.Encryption.KeyID)
+		}
+		keys, err := h.encryptionKeys(ctx, src.EncryptionSecret)
+		if err != nil {
+			return err
+		}
+		// the manifest names the key the archive is encrypted with, the
+		// snapshot data lags behind until the rewrap of the archive is
+		// recorded
+		if key, err = keys.unwrap(m.Encryption); err != nil {
+			return err
+		}
+	}
+	return extractArchive(path, dir, m, key)
+}
+
+// RestoreNodeAffinity pins the restored volumes to the node the snapshot is
+// stored on, the snapshot is restored to the local file system of the node
+func (h *hostPathPlugin) RestoreNodeAffinity(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (*v1.VolumeNodeAffinity, error) {
+	if snapshotData == nil || snapshotData.Spec.HostPath == nil || snapshotData.Spec.HostPath.NodeName == "" {
+		return nil, nil
+	}
+	return volume.HostnameNodeAffinity(ctx, h.kubeClient, snapshotData.Spec.HostPath.NodeName)
+}
+
+func (h *hostPathPlugin) VolumeDelete(_ context.Context, pv *v1.PersistentVolume) error {
 	if pv == nil || pv.Spec.HostPath == nil {
 		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", pv)
 	}
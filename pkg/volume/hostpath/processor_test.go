@@ -0,0 +1,162 @@
+package hostpath
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+)
+
+func TestDescribeSnapshotRestartsInterruptedSnapshot(t *testing.T) {
+	root, err := ioutil.TempDir("", "hostpath-restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	src := filepath.Join(root, "src")
+	depot := filepath.Join(root, "depot")
+	for _, dir := range []string{src, depot} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: src}},
+		},
+	}
+
+	cases := map[string]string{
+		"Archive": filepath.Join(depot, "snap.tar.gz"),
+		"Chunks":  filepath.Join(depot, "snap"+chunkManifestSuffix),
+	}
+	for name, path := range cases {
+		t.Run(name, func(t *testing.T) {
+			// the partial file left behind by a restart of the process
+			if err := ioutil.WriteFile(path+partialSuffix, []byte("partial"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			h := &hostPathPlugin{
+				depot:      depot,
+				chunks:     newChunkStore(depot),
+				kubeClient: fake.NewSimpleClientset(pv),
+				archives:   make(map[string]*archiveProgress),
+			}
+			snapshotData := &crdv1.VolumeSnapshotData{
+				Spec: crdv1.VolumeSnapshotDataSpec{
+					VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+						HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: path},
+					},
+					PersistentVolumeRef: &v1.ObjectReference{Name: pv.Name},
+				},
+			}
+
+			result, err := h.DescribeSnapshot(context.Background(), snapshotData)
+			if err != nil || result.Completed || result.Conditions[0].Type != crdv1.VolumeSnapshotConditionPending {
+				t.Fatalf("Expected the snapshot to be written again, got %v, %v", result, err)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for !result.Completed && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+				result, err = h.DescribeSnapshot(context.Background(), snapshotData)
+			}
+			if err != nil || result.Conditions[0].Type != crdv1.VolumeSnapshotConditionReady {
+				t.Fatalf("Expected the snapshot to be ready, got %v, %v", result, err)
+			}
+			if _, err := os.Stat(path + partialSuffix); !os.IsNotExist(err) {
+				t.Errorf("Expected the partial file to be removed, got %v", err)
+			}
+			h.mutex.Lock()
+			tracked := len(h.archives)
+			h.mutex.Unlock()
+			if tracked != 0 {
+				t.Errorf("Expected the written snapshot not to be tracked, got %d snapshots", tracked)
+			}
+		})
+	}
+}
+
+// writeLegacyArchive writes the archive of tar czf archive -C dir . holding
+// a single file
+func writeLegacyArchive(t *testing.T, archive, name, content string) {
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	headers := []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))},
+	}
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRestoreLegacyArchive(t *testing.T) {
+	root, err := ioutil.TempDir("", "hostpath-legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	archive := filepath.Join(root, "snap.tgz")
+	writeLegacyArchive(t, archive, "file", "content")
+
+	h := &hostPathPlugin{
+		depot:        root,
+		restorePoint: filepath.Join(root, "restore"),
+		chunks:       newChunkStore(root),
+		archives:     make(map[string]*archiveProgress),
+	}
+	snapshotData := &crdv1.VolumeSnapshotData{
+		Spec: crdv1.VolumeSnapshotDataSpec{
+			VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+				HostPath: &crdv1.HostPathVolumeSnapshotSource{Path: archive},
+			},
+		},
+	}
+	// the legacy archives are written already, they are not written again
+	result, err := h.DescribeSnapshot(context.Background(), snapshotData)
+	if err != nil || !result.Completed || result.Conditions[0].Type != crdv1.VolumeSnapshotConditionReady {
+		t.Fatalf("Expected the legacy archive to be ready, got %v, %v", result, err)
+	}
+	if _, err := os.Stat(manifestPath(archive)); !os.IsNotExist(err) {
+		t.Errorf("Expected the legacy archive not to be written again, got %v", err)
+	}
+
+	restored, err := h.SnapshotRestore(context.Background(), snapshotData, nil, "pv", nil)
+	if err != nil {
+		t.Fatalf("Expected the legacy archive to be restored, got %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(restored.Source.HostPath.Path, "file"))
+	if err != nil || string(content) != "content" {
+		t.Errorf("Expected the file to be restored, got %q, %v", content, err)
+	}
+}
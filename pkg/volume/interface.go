@@ -17,7 +17,10 @@ limitations under the License.
 package volume
 
 import (
+	"context"
+
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 
 	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
@@ -43,3 +46,98 @@ type Plugin interface {
 	// TODO in the future pass kubernetes client for certain volumes (e.g. rbd) so they can access storage class to retrieve secret
 	VolumeDelete(pv *v1.PersistentVolume) error
 }
+
+// PluginV2 is the volume plugin interface taking a context on every call,
+// the calls stop when the context is done. The calls fail with the errors
+// of NewNotFoundError, NewUnsupportedError and NewTransientError when the
+// reason matters to the caller.
+type PluginV2 interface {
+	// Init inits volume plugin
+	Init(cloudprovider.Interface)
+	// Capabilities returns the optional calls the plugin supports
+	Capabilities() Capabilities
+	// SnapshotCreate creates a snapshot of the PV, tagged with the tags
+	SnapshotCreate(ctx context.Context, snapshot *crdv1.VolumeSnapshot, pv *v1.PersistentVolume, tags map[string]string) (SnapshotResult, error)
+	// SnapshotDelete deletes a snapshot, the PV is nil if it is deleted
+	// already
+	SnapshotDelete(ctx context.Context, source *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error
+	// SnapshotRestore restores the snapshot into a volume named pvName
+	SnapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (RestoreResult, error)
+	// DescribeSnapshot returns the status of a snapshot being created
+	DescribeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (DescribeResult, error)
+	// FindSnapshot finds the snapshot tagged with the tags, it fails with a
+	// NotFound error if there is none
+	FindSnapshot(ctx context.Context, tags map[string]string) (SnapshotResult, error)
+	// VolumeDelete deletes a PV restored by the plugin
+	VolumeDelete(ctx context.Context, pv *v1.PersistentVolume) error
+}
+
+// Capabilities are the optional calls of a volume plugin, the calls a
+// plugin does not support are skipped by the snapshotter and the
+// provisioner. SnapshotCreate and SnapshotDelete are always supported.
+type Capabilities struct {
+	// FindSnapshot finds the snapshots by their tags, so that a snapshot
+	// whose VolumeSnapshotData was not created is not taken twice
+	FindSnapshot bool
+	// SnapshotRestore restores the snapshots into volumes
+	SnapshotRestore bool
+	// DescribeSnapshot describes the snapshots being created, a plugin that
+	// does not support it returns the final conditions from SnapshotCreate
+	DescribeSnapshot bool
+	// VolumeDelete deletes the restored volumes
+	VolumeDelete bool
+}
+
+// AllCapabilities are the capabilities of a plugin supporting every call
+var AllCapabilities = Capabilities{
+	FindSnapshot:     true,
+	SnapshotRestore:  true,
+	DescribeSnapshot: true,
+	VolumeDelete:     true,
+}
+
+// SnapshotResult is the snapshot returned by SnapshotCreate and
+// FindSnapshot
+type SnapshotResult struct {
+	Source     crdv1.VolumeSnapshotDataSource
+	Conditions []crdv1.VolumeSnapshotCondition
+}
+
+// RestoreResult is the volume returned by SnapshotRestore
+type RestoreResult struct {
+	Source v1.PersistentVolumeSource
+	// Labels are set on the restored PV
+	Labels map[string]string
+	// NodeAffinity of the restored PV, the one of RestoreNodeAffinity is
+	// used if nil
+	NodeAffinity *v1.VolumeNodeAffinity
+}
+
+// DescribeResult is the status returned by DescribeSnapshot
+type DescribeResult struct {
+	Conditions []crdv1.VolumeSnapshotCondition
+	// Completed is true once the snapshot is ready or has failed
+	Completed bool
+}
+
+// CapabilitiesPlugin is implemented by the Plugin implementations that do
+// not support every call, see PluginV2
+type CapabilitiesPlugin interface {
+	// Capabilities returns the optional calls the plugin supports
+	Capabilities() Capabilities
+}
+
+// NodeAffinityPlugin is implemented by the volume plugins whose snapshots
+// can only be restored to volumes that are reachable from some nodes
+type NodeAffinityPlugin interface {
+	// RestoreNodeAffinity returns the node affinity of the volumes restored
+	// from the snapshot, nil if they are reachable from every node
+	RestoreNodeAffinity(context.Context, *crdv1.VolumeSnapshotData) (*v1.VolumeNodeAffinity, error)
+}
+
+// KubeClientPlugin is implemented by the volume plugins that read objects
+// from the cluster, e.g. the secrets holding their keys
+type KubeClientPlugin interface {
+	// SetKubeClient sets the client of the cluster
+	SetKubeClient(kubernetes.Interface)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// lvsFields are the fields of a logical volume listed by lvs
+	lvsFields    = "vg_name,lv_name,lv_path,lv_dm_path,pool_lv,lv_tags"
+	lvsSeparator = "|"
+)
+
+var errNotFound = errors.New("logical volume not found")
+
+// commandRunner runs the LVM and util-linux commands, the tests replace it
+// with a fake
+type commandRunner interface {
+	// Run runs the command name with args and returns its standard output
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("%s %s failed: %v, %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// logicalVolume is a logical volume as listed by lvs
+type logicalVolume struct {
+	VGName string
+	LVName string
+	Path   string
+	DMPath string
+	Pool   string
+	Tags   []string
+}
+
+// name returns the name of the logical volume as vg/lv
+func (lv *logicalVolume) name() string {
+	return lv.VGName + "/" + lv.LVName
+}
+
+// tag returns the value of the tag key=value, empty if it is not set
+func (lv *logicalVolume) tag(key string) string {
+	for _, tag := range lv.Tags {
+		if strings.HasPrefix(tag, key+"=") {
+			return strings.TrimPrefix(tag, key+"=")
+		}
+	}
+	return ""
+}
+
+// listLogicalVolumes lists the logical volumes, all of them if names is
+// empty
+func listLogicalVolumes(runner commandRunner, names ...string) ([]logicalVolume, error) {
+	args := append([]string{"--noheadings", "--separator", lvsSeparator, "-o", lvsFields}, names...)
+	out, err := runner.Run("lvs", args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "Failed to find logical volume") {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+	var lvs []logicalVolume
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, lvsSeparator)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid output of lvs: %q", line)
+		}
+		lv := logicalVolume{
+			VGName: fields[0],
+			LVName: fields[1],
+			Path:   fields[2],
+			DMPath: fields[3],
+			Pool:   fields[4],
+		}
+		if fields[5] != "" {
+			lv.Tags = strings.Split(fields[5], ",")
+		}
+		lvs = append(lvs, lv)
+	}
+	return lvs, nil
+}
+
+// getLogicalVolume returns the logical volume named vg/lv
+func getLogicalVolume(runner commandRunner, name string) (*logicalVolume, error) {
+	lvs, err := listLogicalVolumes(runner, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(lvs) == 0 {
+		return nil, errNotFound
+	}
+	return &lvs[0], nil
+}
+
+// logicalVolumeByDevice returns the logical volume of the device
+func logicalVolumeByDevice(runner commandRunner, device string) (*logicalVolume, error) {
+	lvs, err := listLogicalVolumes(runner)
+	if err != nil {
+		return nil, err
+	}
+	for i := range lvs {
+		if lvs[i].Path == device || lvs[i].DMPath == device {
+			return &lvs[i], nil
+		}
+	}
+	return nil, errNotFound
+}
+
+// mountSource returns the device mounted at path
+func mountSource(runner commandRunner, path string) (string, error) {
+	out, err := runner.Run("findmnt", "-n", "-o", "SOURCE", "--target", path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// filesystemType returns the type of the file system on the device, empty
+// if the device holds no file system
+func filesystemType(runner commandRunner, device string) (string, error) {
+	out, err := runner.Run("blkid", "-o", "value", "-s", "TYPE", device)
+	if err != nil {
+		// blkid exits with 2 if the device holds nothing it can identify
+		if strings.Contains(err.Error(), "exit status 2") {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lvm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pborman/uuid"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+const (
+	// LogicalVolumeAnnotation on a local PV names the thin logical volume
+	// backing it as vg/lv, it is looked up by the path of the PV otherwise
+	LogicalVolumeAnnotation = "lvm.snapshot.openebs.io/logical-volume"
+
+	// uidTag is the LV tag holding the UID of the VolumeSnapshot a snapshot
+	// is created for
+	uidTag = "openebs.io/snapshot-uid"
+	// cloneOfTag is the LV tag holding the snapshot a volume is restored
+	// from by SnapshotRestore
+	cloneOfTag = "openebs.io/clone-of"
+
+	createdForUIDTag = "kubernetes.io/created-for/uid"
+	snapshotPrefix   = "k8s-snap-"
+)
+
+type lvmPlugin struct {
+	runner commandRunner
+	// nodeName is the node the volume groups the plugin snapshots are on
+	nodeName string
+	// kubeClient reads the hostname label of the node
+	kubeClient kubernetes.Interface
+}
+
+var _ volume.Plugin = &lvmPlugin{}
+var _ volume.NodeAffinityPlugin = &lvmPlugin{}
+var _ volume.KubeClientPlugin = &lvmPlugin{}
+
+// RegisterPlugin registers the volume plugin
+func RegisterPlugin() volume.Plugin {
+	return &lvmPlugin{
+		runner:   execRunner{},
+		nodeName: volume.NodeName(),
+	}
+}
+
+// GetPluginName gets the name of the volume plugin
+func GetPluginName() string {
+	return "lvm"
+}
+
+func (l *lvmPlugin) Init(_ cloudprovider.Interface) {
+}
+
+// SetKubeClient sets the client the hostname label of the node is read with
+func (l *lvmPlugin) SetKubeClient(client kubernetes.Interface) {
+	l.kubeClient = client
+}
+
+// logicalVolume returns the logical volume backing the local PV, it is
+// either named by the annotation of the PV, the device of a block PV or the
+// device mounted at the path of the PV
+func (l *lvmPlugin) logicalVolume(pv *v1.PersistentVolume) (*logicalVolume, error) {
+	if name := pv.Annotations[LogicalVolumeAnnotation]; name != "" {
+		return getLogicalVolume(l.runner, name)
+	}
+	if pv.Spec.Local == nil {
+		return nil, fmt.Errorf("PV %s is not a local volume", pv.Name)
+	}
+	device := pv.Spec.Local.Path
+	if !strings.HasPrefix(device, "/dev/") {
+		var err error
+		if device, err = mountSource(l.runner, device); err != nil {
+			return nil, err
+		}
+	}
+	return logicalVolumeByDevice(l.runner, device)
+}
+
+// SnapshotCreate creates a thin snapshot of the logical volume of the PV,
+// the UID of the VolumeSnapshot is stored in a tag for FindSnapshot
+func (l *lvmPlugin) SnapshotCreate(
+	snapshot *crdv1.VolumeSnapshot,
+	pv *v1.PersistentVolume,
+	tags *map[string]string,
+) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	lv, err := l.logicalVolume(pv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find the logical volume of PV %s: %v", pv.Name, err)
+	}
+	if lv.Pool == "" {
+		return nil, nil, fmt.Errorf("logical volume %s of PV %s is not thin provisioned", lv.name(), pv.Name)
+	}
+	fsType, err := filesystemType(l.runner, lv.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the file system of %s: %v", lv.name(), err)
+	}
+
+	name := snapshotPrefix + uuid.New()
+	args := []string{"-s", "-n", name}
+	if tags != nil && (*tags)[createdForUIDTag] != "" {
+		args = append(args, "--addtag", uidTag+"="+(*tags)[createdForUIDTag])
+	}
+	if _, err := l.runner.Run("lvcreate", append(args, lv.name())...); err != nil {
+		glog.Errorf("failed to create snapshot of logical volume %v: %v", lv.name(), err)
+		return nil, nil, fmt.Errorf("failed to create snapshot of logical volume %s: %v", lv.name(), err)
+	}
+	snapshotName := lv.VGName + "/" + name
+	glog.V(1).Infof("snapshot %v created successfully", snapshotName)
+
+	res := &crdv1.VolumeSnapshotDataSource{
+		LVMSnapshot: &crdv1.LVMVolumeSnapshotSource{
+			Snapshot: snapshotName,
+			NodeName: l.nodeName,
+			FSType:   fsType,
+		},
+	}
+	return res, newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"), nil
+}
+
+func newConditions(conditionType crdv1.VolumeSnapshotConditionType, message string) *[]crdv1.VolumeSnapshotCondition {
+	return &[]crdv1.VolumeSnapshotCondition{
+		{
+			Status:             v1.ConditionTrue,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+			Type:               conditionType,
+		},
+	}
+}
+
+// SnapshotDelete removes the snapshot, the volumes restored from it are
+// thin snapshots of their own and are not affected
+func (l *lvmPlugin) SnapshotDelete(src *crdv1.VolumeSnapshotDataSource, _ *v1.PersistentVolume) error {
+	if src == nil || src.LVMSnapshot == nil {
+		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", src)
+	}
+	return l.remove(src.LVMSnapshot.Snapshot)
+}
+
+// remove removes the logical volume, a logical volume that does not exist
+// is removed already
+func (l *lvmPlugin) remove(name string) error {
+	if _, err := getLogicalVolume(l.runner, name); err == errNotFound {
+		glog.V(1).Infof("logical volume %v is already removed", name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := l.runner.Run("lvremove", "-y", name); err != nil {
+		return fmt.Errorf("failed to remove logical volume %s: %v", name, err)
+	}
+	glog.V(1).Infof("logical volume %v removed successfully", name)
+	return nil
+}
+
+// DescribeSnapshot reports whether the snapshot exists, thin snapshots are
+// complete once created
+func (l *lvmPlugin) DescribeSnapshot(snapshotData *crdv1.VolumeSnapshotData) (snapConditions *[]crdv1.VolumeSnapshotCondition, isCompleted bool, err error) {
+	if snapshotData == nil || snapshotData.Spec.LVMSnapshot == nil {
+		return nil, false, fmt.Errorf("failed to retrieve Snapshot spec")
+	}
+	snapshot := snapshotData.Spec.LVMSnapshot.Snapshot
+	_, err = getLogicalVolume(l.runner, snapshot)
+	if err == errNotFound {
+		return newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Snapshot %s not found", snapshot)), true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"), true, nil
+}
+
+// FindSnapshot finds the snapshot created for the VolumeSnapshot by the UID
+// stored in its tags
+func (l *lvmPlugin) FindSnapshot(tags *map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	glog.Infof("FindSnapshot by tags: %#v", *tags)
+	uid := (*tags)[createdForUIDTag]
+	if uid == "" {
+		return nil, nil, fmt.Errorf("Snapshot not found, no %s tag", createdForUIDTag)
+	}
+	lvs, err := listLogicalVolumes(l.runner)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range lvs {
+		if lvs[i].tag(uidTag) != uid {
+			continue
+		}
+		res := &crdv1.VolumeSnapshotDataSource{
+			LVMSnapshot: &crdv1.LVMVolumeSnapshotSource{
+				Snapshot: lvs[i].name(),
+				NodeName: l.nodeName,
+			},
+		}
+		if res.LVMSnapshot.FSType, err = filesystemType(l.runner, lvs[i].Path); err != nil {
+			return nil, nil, err
+		}
+		return res, newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"), nil
+	}
+	return nil, nil, fmt.Errorf("Snapshot not found")
+}
+
+// SnapshotRestore creates a writable thin snapshot of the snapshot named
+// after the PV and activates it. A volume that fails to activate is removed
+// again.
+func (l *lvmPlugin) SnapshotRestore(snapshotData *crdv1.VolumeSnapshotData, _ *v1.PersistentVolumeClaim, pvName string, _ map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
+	if snapshotData == nil || snapshotData.Spec.LVMSnapshot == nil {
+		return nil, nil, fmt.Errorf("failed to retrieve Snapshot spec")
+	}
+	src := snapshotData.Spec.LVMSnapshot
+	if src.NodeName != "" && src.NodeName != l.nodeName {
+		return nil, nil, fmt.Errorf("snapshot %s is stored on node %s, it can not be restored on node %q", src.Snapshot, src.NodeName, l.nodeName)
+	}
+	parts := strings.SplitN(src.Snapshot, "/", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid snapshot name %q", src.Snapshot)
+	}
+	if pvName == "" {
+		return nil, nil, fmt.Errorf("failed to restore %s, no volume name", src.Snapshot)
+	}
+	restored := parts[0] + "/" + pvName
+
+	if _, err := l.runner.Run("lvcreate", "-s", "-n", pvName, "--addtag", cloneOfTag+"="+src.Snapshot, src.Snapshot); err != nil {
+		glog.Errorf("snapshot :%v restore failed, err:%v", src.Snapshot, err)
+		return nil, nil, fmt.Errorf("failed to restore %s to %s: %v", src.Snapshot, restored, err)
+	}
+	// thin snapshots are skipped by the activation by default
+	lv, err := getLogicalVolume(l.runner, restored)
+	if err == nil {
+		_, err = l.runner.Run("lvchange", "-ay", "-K", restored)
+	}
+	if err != nil {
+		if _, removeErr := l.runner.Run("lvremove", "-y", restored); removeErr != nil {
+			glog.Errorf("failed to remove %v after a failed restore, err: %v", restored, removeErr)
+		}
+		return nil, nil, fmt.Errorf("failed to activate %s: %v", restored, err)
+	}
+	glog.V(1).Infof("snapshot %v restored successfully to %v", src.Snapshot, restored)
+
+	pv := &v1.PersistentVolumeSource{
+		Local: &v1.LocalVolumeSource{
+			Path: lv.Path,
+		},
+	}
+	if src.FSType != "" {
+		pv.Local.FSType = &src.FSType
+	}
+	return pv, nil, nil
+}
+
+// RestoreNodeAffinity pins the restored volumes to the node the volume
+// group of the snapshot is on
+func (l *lvmPlugin) RestoreNodeAffinity(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (*v1.VolumeNodeAffinity, error) {
+	if snapshotData == nil || snapshotData.Spec.LVMSnapshot == nil || snapshotData.Spec.LVMSnapshot.NodeName == "" {
+		return nil, nil
+	}
+	return volume.HostnameNodeAffinity(ctx, l.kubeClient, snapshotData.Spec.LVMSnapshot.NodeName)
+}
+
+// VolumeDelete removes a volume created by SnapshotRestore
+func (l *lvmPlugin) VolumeDelete(pv *v1.PersistentVolume) error {
+	if pv == nil || pv.Spec.Local == nil {
+		return fmt.Errorf("invalid PV: %v", pv)
+	}
+	lv, err := l.logicalVolume(pv)
+	if err == errNotFound {
+		glog.V(1).Infof("logical volume of PV %v is already removed", pv.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	origin := lv.tag(cloneOfTag)
+	if origin == "" {
+		glog.Warningf("Not removing logical volume %v of PV %v, it is not restored from a snapshot", lv.name(), pv.Name)
+		return nil
+	}
+	if _, err := l.runner.Run("lvremove", "-y", lv.name()); err != nil {
+		return fmt.Errorf("failed to remove logical volume %s: %v", lv.name(), err)
+	}
+	glog.V(1).Infof("logical volume %v restored from %v removed successfully", lv.name(), origin)
+	return nil
+}
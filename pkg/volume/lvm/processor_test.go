@@ -0,0 +1,200 @@
+package lvm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeRunner returns the output registered for the command line, or fails
+// with the registered error message, and records the commands it runs
+type fakeRunner struct {
+	outputs map[string]string
+	errors  map[string]string
+	calls   []string
+}
+
+func (f *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, cmd)
+	if message, ok := f.errors[cmd]; ok {
+		return nil, fmt.Errorf("%s failed: exit status 5, %s", cmd, message)
+	}
+	return []byte(f.outputs[cmd]), nil
+}
+
+const (
+	lvsAll = "lvs --noheadings --separator | -o " + lvsFields
+	// data is a thin volume mounted at /mnt/data, thick is not thin
+	// provisioned and k8s-snap-a is a snapshot of data
+	lvsOutput = `  vg|pool||||
+  vg|data|/dev/vg/data|/dev/mapper/vg-data|pool|
+  vg|thick|/dev/vg/thick|/dev/mapper/vg-thick||
+  vg|k8s-snap-a|/dev/vg/k8s-snap-a|/dev/mapper/vg-k8s--snap--a|pool|openebs.io/snapshot-uid=uid-1
+  vg|pv2|/dev/vg/pv2|/dev/mapper/vg-pv2|pool|openebs.io/clone-of=vg/k8s-snap-a
+`
+)
+
+func localPV(path string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				Local: &v1.LocalVolumeSource{Path: path},
+			},
+		},
+	}
+}
+
+func TestSnapshotCreate(t *testing.T) {
+	tests := map[string]struct {
+		path   string
+		origin string
+	}{
+		"mounted volume": {path: "/mnt/data", origin: "vg/data"},
+		"block volume":   {path: "/dev/vg/data", origin: "vg/data"},
+		"thick volume":   {path: "/dev/vg/thick"},
+		"no volume":      {path: "/dev/sdb"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{outputs: map[string]string{
+				lvsAll: lvsOutput,
+				"findmnt -n -o SOURCE --target /mnt/data": "/dev/mapper/vg-data\n",
+				"blkid -o value -s TYPE /dev/vg/data":     "ext4\n",
+			}}
+			plugin := &lvmPlugin{runner: runner, nodeName: "node1"}
+			tags := map[string]string{createdForUIDTag: "uid-2"}
+			src, _, err := plugin.SnapshotCreate(&crdv1.VolumeSnapshot{}, localPV(test.path), &tags)
+			if test.origin == "" {
+				if err == nil {
+					t.Errorf("Expected snapshot to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			snapshot := src.LVMSnapshot.Snapshot
+			if !strings.HasPrefix(snapshot, "vg/"+snapshotPrefix) || src.LVMSnapshot.FSType != "ext4" || src.LVMSnapshot.NodeName != "node1" {
+				t.Errorf("Unexpected snapshot %+v", src.LVMSnapshot)
+			}
+			expected := fmt.Sprintf("lvcreate -s -n %s --addtag %s=uid-2 %s", strings.TrimPrefix(snapshot, "vg/"), uidTag, test.origin)
+			if last := runner.calls[len(runner.calls)-1]; last != expected {
+				t.Errorf("Expected command %q, got %q", expected, last)
+			}
+		})
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{lvsAll: lvsOutput}}
+	plugin := &lvmPlugin{runner: runner}
+	src, _, err := plugin.FindSnapshot(&map[string]string{createdForUIDTag: "uid-1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if src.LVMSnapshot.Snapshot != "vg/k8s-snap-a" {
+		t.Errorf("Unexpected snapshot %s", src.LVMSnapshot.Snapshot)
+	}
+	if _, _, err := plugin.FindSnapshot(&map[string]string{createdForUIDTag: "uid-3"}); err == nil {
+		t.Errorf("Expected snapshot of uid-3 not to be found")
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	const (
+		create   = "lvcreate -s -n pv2 --addtag openebs.io/clone-of=vg/k8s-snap-a vg/k8s-snap-a"
+		get      = "lvs --noheadings --separator | -o " + lvsFields + " vg/pv2"
+		activate = "lvchange -ay -K vg/pv2"
+		remove   = "lvremove -y vg/pv2"
+	)
+	fsType := "xfs"
+	tests := map[string]struct {
+		errors   map[string]string
+		calls    []string
+		expected *v1.PersistentVolumeSource
+	}{
+		"restored": {
+			calls:    []string{create, get, activate},
+			expected: &v1.PersistentVolumeSource{Local: &v1.LocalVolumeSource{Path: "/dev/vg/pv2", FSType: &fsType}},
+		},
+		"create failed": {
+			errors: map[string]string{create: "Insufficient free space"},
+			calls:  []string{create},
+		},
+		"activation failed": {
+			errors: map[string]string{activate: "Failed to activate"},
+			calls:  []string{create, get, activate, remove},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{
+				outputs: map[string]string{get: "  vg|pv2|/dev/vg/pv2|/dev/mapper/vg-pv2|pool|openebs.io/clone-of=vg/k8s-snap-a\n"},
+				errors:  test.errors,
+			}
+			plugin := &lvmPlugin{runner: runner, nodeName: "node1"}
+			data := &crdv1.VolumeSnapshotData{
+				Spec: crdv1.VolumeSnapshotDataSpec{
+					VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+						LVMSnapshot: &crdv1.LVMVolumeSnapshotSource{Snapshot: "vg/k8s-snap-a", NodeName: "node1", FSType: fsType},
+					},
+				},
+			}
+			src, _, err := plugin.SnapshotRestore(data, nil, "pv2", nil)
+			if !reflect.DeepEqual(runner.calls, test.calls) {
+				t.Errorf("Expected commands %q, got %q", test.calls, runner.calls)
+			}
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("Expected restore to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(src, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, src)
+			}
+		})
+	}
+}
+
+func TestVolumeDelete(t *testing.T) {
+	tests := map[string]struct {
+		path  string
+		calls []string
+	}{
+		"restored volume": {
+			path:  "/dev/vg/pv2",
+			calls: []string{lvsAll, "lvremove -y vg/pv2"},
+		},
+		"not restored": {
+			path:  "/dev/vg/data",
+			calls: []string{lvsAll},
+		},
+		"already removed": {
+			path:  "/dev/vg/pv3",
+			calls: []string{lvsAll},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{outputs: map[string]string{lvsAll: lvsOutput}}
+			plugin := &lvmPlugin{runner: runner}
+			if err := plugin.VolumeDelete(localPV(test.path)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(runner.calls, test.calls) {
+				t.Errorf("Expected commands %q, got %q", test.calls, runner.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "openebs"
+	metricsSubsystem = "volume_plugin"
+
+	// OperationCreate, OperationDelete, OperationRestore, OperationDescribe,
+	// OperationFind and OperationVolumeDelete are the operations of the
+	// metrics and the spans, the calls of SnapshotCreate, SnapshotDelete,
+	// SnapshotRestore, DescribeSnapshot, FindSnapshot and VolumeDelete
+	OperationCreate       = "create"
+	OperationDelete       = "delete"
+	OperationRestore      = "restore"
+	OperationDescribe     = "describe"
+	OperationFind         = "find"
+	OperationVolumeDelete = "volume_delete"
+
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+var (
+	operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "operations_total",
+		Help:      "Number of calls of the volume plugins by plugin, operation and result.",
+	}, []string{"plugin", "operation", "result"})
+
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the calls of the volume plugins by plugin and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"plugin", "operation"})
+)
+
+func init() {
+	prometheus.MustRegister(operations, operationDuration)
+}
+
+// observeOperation records the call of the operation of the plugin started
+// at start, err is the error the call returned
+func observeOperation(plugin, operation string, start time.Time, err error) {
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	}
+	operations.WithLabelValues(plugin, operation, result).Inc()
+	operationDuration.WithLabelValues(plugin, operation).Observe(time.Since(start).Seconds())
+}
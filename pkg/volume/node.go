@@ -0,0 +1,82 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodeNameENVK is the environment variable holding the name of the node the
+// plugins storing their snapshots on the node run on, usually set through
+// the downward API
+const NodeNameENVK = "NODE_NAME"
+
+// NodeName returns the name of the node the plugin runs on, empty if
+// NodeNameENVK is not set
+func NodeName() string {
+	return os.Getenv(NodeNameENVK)
+}
+
+// NodeLabels returns the labels of the node. The hostname label defaults to
+// the name of the node, as for the topology of the provisioned volumes.
+func NodeLabels(ctx context.Context, client kubernetes.Interface, nodeName string) (map[string]string, error) {
+	if client == nil {
+		return nil, fmt.Errorf("can not read node %s, no kubernetes client", nodeName)
+	}
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %v", nodeName, err)
+	}
+	labels := make(map[string]string, len(node.Labels)+1)
+	for key, value := range node.Labels {
+		labels[key] = value
+	}
+	if _, ok := labels[v1.LabelHostname]; !ok {
+		labels[v1.LabelHostname] = nodeName
+	}
+	return labels, nil
+}
+
+// HostnameNodeAffinity pins a volume to the node, through the hostname label
+// of the node which may differ from its name
+func HostnameNodeAffinity(ctx context.Context, client kubernetes.Interface, nodeName string) (*v1.VolumeNodeAffinity, error) {
+	labels, err := NodeLabels(ctx, client, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.VolumeNodeAffinity{
+		Required: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{
+							Key:      v1.LabelHostname,
+							Operator: v1.NodeSelectorOpIn,
+							Values:   []string{labels[v1.LabelHostname]},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
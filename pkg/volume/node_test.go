@@ -0,0 +1,44 @@
+package volume
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHostnameNodeAffinity(t *testing.T) {
+	node := func(name string, labels map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	tests := map[string]struct {
+		client   kubernetes.Interface
+		hostname string
+	}{
+		"hostname label":    {client: fake.NewSimpleClientset(node("node1", map[string]string{v1.LabelHostname: "host-1"})), hostname: "host-1"},
+		"no hostname label": {client: fake.NewSimpleClientset(node("node1", nil)), hostname: "node1"},
+		"no node":           {client: fake.NewSimpleClientset()},
+		"no client":         {},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			affinity, err := HostnameNodeAffinity(context.Background(), test.client, "node1")
+			if test.hostname == "" {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			requirement := affinity.Required.NodeSelectorTerms[0].MatchExpressions[0]
+			if requirement.Key != v1.LabelHostname || len(requirement.Values) != 1 || requirement.Values[0] != test.hostname {
+				t.Errorf("Expected to be pinned to %s, got %+v", test.hostname, requirement)
+			}
+		})
+	}
+}
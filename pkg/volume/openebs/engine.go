@@ -0,0 +1,288 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openebs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/provisioner"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+	mvol_v1alpha1 "github.com/openebs/openebs-k8s-provisioner/pkg/volume/v1alpha1"
+)
+
+const (
+	// CASTypesENVK is the environment variable holding the comma separated
+	// cas types the snapshots are enabled for
+	CASTypesENVK = "OPENEBS_IO_SNAPSHOT_CAS_TYPES"
+
+	defaultCASTypes = "jiva,cstor"
+)
+
+// Engine snapshots the volumes of a cas type, the calls stop when their
+// context is done
+type Engine interface {
+	// SnapshotCreate creates the snapshot named snapshotName of the PV
+	SnapshotCreate(ctx context.Context, pv *v1.PersistentVolume, snapshotName string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error)
+	// SnapshotDelete deletes the snapshot of the PV
+	SnapshotDelete(ctx context.Context, src *crdv1.OpenEBSVolumeSnapshotSource, pv *v1.PersistentVolume) error
+	// DescribeSnapshot returns the conditions of the snapshot
+	DescribeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (*[]crdv1.VolumeSnapshotCondition, bool, error)
+	// SnapshotRestore creates the volume pvName for the PVC from the
+	// snapshot
+	SnapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string) (*v1.PersistentVolumeSource, map[string]string, error)
+	// FindSnapshot finds the snapshot by its tags
+	FindSnapshot(ctx context.Context, tags map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error)
+	// Capabilities returns the optional calls the engine supports
+	Capabilities() volume.Capabilities
+}
+
+var (
+	enginesMutex sync.RWMutex
+	engines      = map[string]Engine{
+		"jiva":  &mayaEngine{casType: "jiva"},
+		"cstor": &mayaEngine{casType: "cstor"},
+	}
+)
+
+// RegisterEngine registers the snapshot engine of a cas type, the cas type
+// still has to be enabled
+func RegisterEngine(casType string, engine Engine) {
+	enginesMutex.Lock()
+	defer enginesMutex.Unlock()
+	engines[casType] = engine
+}
+
+func getEngine(casType string) (Engine, bool) {
+	enginesMutex.RLock()
+	defer enginesMutex.RUnlock()
+	engine, ok := engines[casType]
+	return engine, ok
+}
+
+// enabledCASTypes returns the cas types of the CASTypesENVK environment
+// variable, jiva and cstor if it is not set
+func enabledCASTypes() map[string]bool {
+	value, ok := os.LookupEnv(CASTypesENVK)
+	if !ok {
+		value = defaultCASTypes
+	}
+	casTypes := make(map[string]bool)
+	for _, casType := range strings.Split(value, ",") {
+		if casType = strings.TrimSpace(casType); casType == "" {
+			continue
+		}
+		if _, ok := getEngine(casType); !ok {
+			glog.Errorf("Ignoring cas type %s of %s, there is no snapshot engine for it", casType, CASTypesENVK)
+			continue
+		}
+		casTypes[casType] = true
+	}
+	return casTypes
+}
+
+// casTypeOfPV returns the cas type of the PV. It is an annotation of the
+// provisioned PVs and a label of the restored ones.
+func casTypeOfPV(pv *v1.PersistentVolume) (string, error) {
+	if casType := pv.Annotations[string(v1alpha1.CASTypeKey)]; casType != "" {
+		return casType, nil
+	}
+	if casType := pv.Labels[string(v1alpha1.CASTypeKey)]; casType != "" {
+		return casType, nil
+	}
+	return "", fmt.Errorf("PV %s has no %s annotation, can not tell the snapshot engine of the volume", pv.Name, v1alpha1.CASTypeKey)
+}
+
+// mayaEngine snapshots the volumes through maya-apiserver, its requests are
+// made with the context of the calls
+type mayaEngine struct {
+	casType string
+}
+
+var _ Engine = &mayaEngine{}
+
+// casVolume returns the client of maya-apiserver making its requests with
+// ctx
+func (m *mayaEngine) casVolume(ctx context.Context) mvol_v1alpha1.CASVolume {
+	return mvol_v1alpha1.CASVolume{}.WithContext(ctx)
+}
+
+// Capabilities returns the optional calls the engine supports, the maya
+// snapshots can not be found by their tags
+func (m *mayaEngine) Capabilities() volume.Capabilities {
+	capabilities := volume.AllCapabilities
+	capabilities.FindSnapshot = false
+	return capabilities
+}
+
+// snapshotVolume returns the name of the volume of the snapshot and the
+// namespace of its claim, recorded in the snapshot or else read from the PV.
+// The PV of a final snapshot is deleted.
+func snapshotVolume(src *crdv1.OpenEBSVolumeSnapshotSource, pv *v1.PersistentVolume) (string, string, error) {
+	if src.VolumeName != "" && src.Namespace != "" {
+		return src.VolumeName, src.Namespace, nil
+	}
+	if pv == nil || pv.Spec.ClaimRef == nil {
+		return "", "", fmt.Errorf("snapshot %s records no volume and its PV has no claim", src.SnapshotID)
+	}
+	return pv.Name, pv.Spec.ClaimRef.Namespace, nil
+}
+
+func (m *mayaEngine) SnapshotCreate(ctx context.Context, pv *v1.PersistentVolume, snapshotName string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	if pv.Spec.ClaimRef == nil {
+		return nil, nil, fmt.Errorf("PV %s has no claim", pv.Name)
+	}
+	namespace := pv.Spec.ClaimRef.Namespace
+	_, err := m.casVolume(ctx).CreateSnapshot(m.casType, pv.Name, snapshotName, namespace)
+	if err != nil {
+		glog.Errorf("failed to create snapshot for volume :%v, err: %v", pv.Name, err)
+		return nil, nil, err
+	}
+
+	glog.V(1).Infof("snapshot %v created successfully", snapshotName)
+
+	cond := []crdv1.VolumeSnapshotCondition{
+		{
+			Status:             v1.ConditionTrue,
+			Message:            "Snapshot created successfully",
+			LastTransitionTime: metav1.Now(),
+			Type:               crdv1.VolumeSnapshotConditionReady,
+		},
+	}
+
+	sizeResource := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	res := &crdv1.VolumeSnapshotDataSource{
+		OpenEBSSnapshot: &crdv1.OpenEBSVolumeSnapshotSource{
+			SnapshotID: snapshotName,
+			Capacity:   sizeResource.String(),
+			CASType:    m.casType,
+			VolumeName: pv.Name,
+			Namespace:  namespace,
+		},
+	}
+	return res, &cond, nil
+}
+
+func (m *mayaEngine) SnapshotDelete(ctx context.Context, src *crdv1.OpenEBSVolumeSnapshotSource, pv *v1.PersistentVolume) error {
+	volumeName, namespace, err := snapshotVolume(src, pv)
+	if err != nil {
+		glog.Errorf("failed to delete snapshot %v: %v", src.SnapshotID, err)
+		return err
+	}
+	_, err = m.casVolume(ctx).DeleteSnapshot(m.casType, volumeName, src.SnapshotID, namespace)
+	if err != nil {
+		glog.Errorf("failed to delete snapshot for volume :%v, err: %v", volumeName, err)
+		return err
+	}
+	glog.V(1).Infof("snapshot deleted :%v successfully", src.SnapshotID)
+
+	if src.DeleteVolume {
+		if err := m.casVolume(ctx).DeleteVolume(volumeName, namespace); err != nil {
+			glog.Errorf("failed to delete volume %v of snapshot %v, err: %v", volumeName, src.SnapshotID, err)
+			return err
+		}
+		glog.V(1).Infof("volume %v of snapshot %v deleted", volumeName, src.SnapshotID)
+	}
+	return nil
+}
+
+func (m *mayaEngine) DescribeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (*[]crdv1.VolumeSnapshotCondition, bool, error) {
+	snapshotID := snapshotData.Spec.OpenEBSSnapshot.SnapshotID
+	glog.V(1).Infof("received describe request on snapshot:%v", snapshotID)
+
+	// TODO implement snapshot-info based on snapshotID
+	resp, err := m.casVolume(ctx).SnapshotInfo(snapshotData.Spec.PersistentVolumeRef.Name, snapshotID)
+
+	if err != nil {
+		glog.Errorf("failed to describe snapshot:%v", snapshotID)
+	}
+
+	glog.V(1).Infof("snapshot details:%v", string(resp))
+
+	if len(snapshotData.Status.Conditions) == 0 {
+		return nil, false, fmt.Errorf("No status condtions in VoluemSnapshotData for openebs snapshot type")
+	}
+
+	lastCondIdx := len(snapshotData.Status.Conditions) - 1
+	retCondType := crdv1.VolumeSnapshotConditionError
+
+	switch snapshotData.Status.Conditions[lastCondIdx].Type {
+	case crdv1.VolumeSnapshotDataConditionReady:
+		retCondType = crdv1.VolumeSnapshotConditionReady
+	case crdv1.VolumeSnapshotDataConditionPending:
+		retCondType = crdv1.VolumeSnapshotConditionPending
+		// Error out.
+	}
+	retCond := []crdv1.VolumeSnapshotCondition{
+		{
+			Status:             snapshotData.Status.Conditions[lastCondIdx].Status,
+			Message:            snapshotData.Status.Conditions[lastCondIdx].Message,
+			LastTransitionTime: snapshotData.Status.Conditions[lastCondIdx].LastTransitionTime,
+			Type:               retCondType,
+		},
+	}
+	return &retCond, true, nil
+}
+
+func (m *mayaEngine) SnapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string) (*v1.PersistentVolumeSource, map[string]string, error) {
+	// restore snapshot to a PV
+	var newVolume v1alpha1.CASVolume
+
+	volumeSpec, class := CreateCloneVolumeSpec(ctx, snapshotData, pvc, pvName)
+
+	err := m.casVolume(ctx).CreateVolume(volumeSpec)
+	if err != nil {
+		glog.Errorf("Error creating volume: %v", err)
+		return nil, nil, err
+	}
+	err = m.casVolume(ctx).ReadVolume(pvName, pvc.Namespace, class, &newVolume)
+	if err != nil {
+		glog.Errorf("snapshot :%v restore failed, err:%v", snapshotData.Spec.OpenEBSSnapshot.SnapshotID, err)
+		return nil, nil, fmt.Errorf("failed to restore %s, err: %v", snapshotData.Spec.OpenEBSSnapshot.SnapshotID, err)
+	}
+
+	glog.V(1).Infof("snapshot restored successfully to: %v", snapshotData.Spec.OpenEBSSnapshot.SnapshotID)
+
+	vollabels := make(map[string]string)
+	vollabels = provisioner.Setlink(vollabels, pvName)
+	vollabels[string(v1alpha1.CASTypeKey)] = newVolume.Spec.CasType
+	vollabels[string(v1alpha1.StorageClassKey)] = class
+
+	pv := &v1.PersistentVolumeSource{
+		ISCSI: &v1.ISCSIPersistentVolumeSource{
+			TargetPortal: newVolume.Spec.TargetPortal,
+			IQN:          newVolume.Spec.Iqn,
+			Lun:          0,
+			FSType:       newVolume.Spec.FSType,
+			ReadOnly:     false,
+		},
+	}
+	return pv, vollabels, nil
+}
+
+func (m *mayaEngine) FindSnapshot(ctx context.Context, tags map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	return nil, nil, volume.NewUnsupportedError("FindSnapshot is not supported by the %s engine", m.casType)
+}
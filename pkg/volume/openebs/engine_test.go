@@ -0,0 +1,258 @@
+package openebs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+// fakeEngine records the snapshots it is asked to create and delete, and
+// the context of the last call
+type fakeEngine struct {
+	created []string
+	deleted []string
+	ctx     context.Context
+}
+
+func (f *fakeEngine) SnapshotCreate(ctx context.Context, pv *v1.PersistentVolume, snapshotName string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	f.created = append(f.created, pv.Name)
+	f.ctx = ctx
+	return &crdv1.VolumeSnapshotDataSource{
+		OpenEBSSnapshot: &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: snapshotName, CASType: "fake"},
+	}, nil, nil
+}
+
+func (f *fakeEngine) SnapshotDelete(ctx context.Context, src *crdv1.OpenEBSVolumeSnapshotSource, pv *v1.PersistentVolume) error {
+	f.deleted = append(f.deleted, src.SnapshotID)
+	f.ctx = ctx
+	return nil
+}
+
+func (f *fakeEngine) DescribeSnapshot(context.Context, *crdv1.VolumeSnapshotData) (*[]crdv1.VolumeSnapshotCondition, bool, error) {
+	return nil, true, nil
+}
+
+func (f *fakeEngine) SnapshotRestore(context.Context, *crdv1.VolumeSnapshotData, *v1.PersistentVolumeClaim, string) (*v1.PersistentVolumeSource, map[string]string, error) {
+	return &v1.PersistentVolumeSource{}, nil, nil
+}
+
+func (f *fakeEngine) FindSnapshot(context.Context, map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	return nil, nil, volume.NewNotFoundError("not found")
+}
+
+func (f *fakeEngine) Capabilities() volume.Capabilities {
+	return volume.AllCapabilities
+}
+
+func fakeISCSIPV(annotations, labels map[string]string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1", Annotations: annotations, Labels: labels},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{ISCSI: &v1.ISCSIPersistentVolumeSource{}},
+			ClaimRef:               &v1.ObjectReference{Namespace: "default"},
+		},
+	}
+}
+
+func TestSnapshotCreateEngine(t *testing.T) {
+	engine := &fakeEngine{}
+	RegisterEngine("fake", engine)
+	defer func() {
+		enginesMutex.Lock()
+		delete(engines, "fake")
+		enginesMutex.Unlock()
+	}()
+	plugin := &openEBSPlugin{casTypes: map[string]bool{"fake": true, "cstor": true}}
+	tags := map[string]string{"kubernetes.io/created-for/name": "snap1"}
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "call")
+
+	tests := map[string]struct {
+		pv          *v1.PersistentVolume
+		created     bool
+		unsupported bool
+	}{
+		"annotated":   {pv: fakeISCSIPV(map[string]string{"openebs.io/cas-type": "fake"}, nil), created: true},
+		"restored":    {pv: fakeISCSIPV(nil, map[string]string{"openebs.io/cas-type": "fake"}), created: true},
+		"unannotated": {pv: fakeISCSIPV(nil, nil)},
+		"disabled":    {pv: fakeISCSIPV(map[string]string{"openebs.io/cas-type": "jiva"}, nil), unsupported: true},
+		"unknown":     {pv: fakeISCSIPV(map[string]string{"openebs.io/cas-type": "other"}, nil), unsupported: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			engine.created = nil
+			result, err := plugin.SnapshotCreate(ctx, &crdv1.VolumeSnapshot{}, test.pv, tags)
+			if test.created {
+				if err != nil || result.Source.OpenEBSSnapshot == nil || len(engine.created) != 1 {
+					t.Errorf("Expected the snapshot to be created by the engine, got %v, %v", result, err)
+				}
+				if engine.ctx != ctx {
+					t.Errorf("Expected the engine to be called with the context of the call")
+				}
+				return
+			}
+			if err == nil || len(engine.created) != 0 {
+				t.Errorf("Expected the snapshot not to be created, got %v", result)
+			}
+			if volume.IsUnsupported(err) != test.unsupported {
+				t.Errorf("Expected unsupported %v, got %v", test.unsupported, err)
+			}
+		})
+	}
+
+	// the final snapshots are taken by the engine of the PV
+	engine.created = nil
+	source, err := plugin.TakeFinalSnapshot(ctx, fakeISCSIPV(map[string]string{"openebs.io/cas-type": "fake"}, nil), "final-pv1")
+	if err != nil || source.SnapshotID != "final-pv1" || len(engine.created) != 1 {
+		t.Errorf("Expected the final snapshot to be taken by the engine, got %v, %v", source, err)
+	}
+	if _, err := plugin.TakeFinalSnapshot(ctx, fakeISCSIPV(map[string]string{"openebs.io/cas-type": "jiva"}, nil), "final-pv1"); !volume.IsUnsupported(err) {
+		t.Errorf("Expected the final snapshot of a disabled cas type to be unsupported, got %v", err)
+	}
+
+	// the cas type of the snapshot selects the engine deleting it
+	src := &crdv1.VolumeSnapshotDataSource{OpenEBSSnapshot: &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "snap1", CASType: "fake"}}
+	if err := plugin.SnapshotDelete(ctx, src, fakeISCSIPV(nil, nil)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(engine.deleted, []string{"snap1"}) {
+		t.Errorf("Expected snap1 to be deleted by the engine, got %v", engine.deleted)
+	}
+}
+
+func TestEnabledCASTypes(t *testing.T) {
+	defer os.Unsetenv(CASTypesENVK)
+	tests := map[string]struct {
+		value    *string
+		casTypes map[string]bool
+	}{
+		"default":  {casTypes: map[string]bool{"jiva": true, "cstor": true}},
+		"cstor":    {value: stringPtr("cstor"), casTypes: map[string]bool{"cstor": true}},
+		"unknown":  {value: stringPtr(" cstor , other"), casTypes: map[string]bool{"cstor": true}},
+		"disabled": {value: stringPtr(""), casTypes: map[string]bool{}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			os.Unsetenv(CASTypesENVK)
+			if test.value != nil {
+				os.Setenv(CASTypesENVK, *test.value)
+			}
+			if casTypes := enabledCASTypes(); !reflect.DeepEqual(casTypes, test.casTypes) {
+				t.Errorf("Expected %v, got %v", test.casTypes, casTypes)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestVolumeDeleteCanceled(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+	os.Setenv("MAPI_ADDR", ts.URL)
+	defer os.Unsetenv("MAPI_ADDR")
+
+	plugin := &openEBSPlugin{casTypes: map[string]bool{"cstor": true}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := plugin.VolumeDelete(ctx, fakeISCSIPV(nil, nil)); err == nil {
+		t.Errorf("Expected the canceled deletion to fail")
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to maya-apiserver, got %d", requests)
+	}
+	if err := plugin.VolumeDelete(context.Background(), fakeISCSIPV(nil, nil)); err != nil || requests != 1 {
+		t.Errorf("Expected the volume to be deleted, got %d requests: %v", requests, err)
+	}
+}
+
+func TestMayaSnapshotDelete(t *testing.T) {
+	var query, deletedVolume string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/latest/volumes/") {
+			deletedVolume = r.Header.Get("namespace") + "/" + strings.TrimPrefix(r.URL.Path, "/latest/volumes/")
+			return
+		}
+		query = r.URL.Query().Get("namespace") + "/" + r.URL.Query().Get("volume")
+	}))
+	defer ts.Close()
+	os.Setenv("MAPI_ADDR", ts.URL)
+	defer os.Unsetenv("MAPI_ADDR")
+
+	// the PV of a final snapshot is deleted, it is not found
+	deleted := &v1.PersistentVolume{}
+	tests := map[string]struct {
+		src        *crdv1.OpenEBSVolumeSnapshotSource
+		pv         *v1.PersistentVolume
+		wantQuery  string
+		wantVolume string
+		wantErr    bool
+	}{
+		"recorded volume": {
+			src:       &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "final-pv1", VolumeName: "pv1", Namespace: "ns1"},
+			wantQuery: "ns1/pv1",
+		},
+		"recorded volume and deleted PV": {
+			src:       &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "final-pv1", VolumeName: "pv1", Namespace: "ns1"},
+			pv:        deleted,
+			wantQuery: "ns1/pv1",
+		},
+		"final snapshot": {
+			src:        &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "final-pv1", VolumeName: "pv1", Namespace: "ns1", DeleteVolume: true},
+			pv:         deleted,
+			wantQuery:  "ns1/pv1",
+			wantVolume: "ns1/pv1",
+		},
+		"volume of the PV": {
+			src:       &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "snap1"},
+			pv:        fakeISCSIPV(nil, nil),
+			wantQuery: "default/pv1",
+		},
+		"no volume and no PV": {
+			src:     &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "snap1"},
+			wantErr: true,
+		},
+		"no volume and deleted PV": {
+			src:     &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "snap1"},
+			pv:      deleted,
+			wantErr: true,
+		},
+	}
+	engine := &mayaEngine{casType: "cstor"}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			query, deletedVolume = "", ""
+			err := engine.SnapshotDelete(context.Background(), test.src, test.pv)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("SnapshotDelete() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if query != test.wantQuery {
+				t.Errorf("Expected the snapshot of %q to be deleted, got %q", test.wantQuery, query)
+			}
+			if deletedVolume != test.wantVolume {
+				t.Errorf("Expected volume %q to be deleted, got %q", test.wantVolume, deletedVolume)
+			}
+		})
+	}
+
+	plugin := &openEBSPlugin{casTypes: map[string]bool{"cstor": true}}
+	src := &crdv1.VolumeSnapshotDataSource{OpenEBSSnapshot: &crdv1.OpenEBSVolumeSnapshotSource{SnapshotID: "snap1"}}
+	if err := plugin.SnapshotDelete(context.Background(), src, nil); err == nil {
+		t.Errorf("Expected the deletion of a snapshot without cas type and PV to fail")
+	}
+}
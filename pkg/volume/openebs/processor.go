@@ -38,24 +38,25 @@ const (
 	openEBSPersistentDiskPluginName = "openebs"
 )
 
-var (
-	// SnapSupportedCASType is a map of supported volume type for snapshot
-	// operations.
-	SnapSupportedCASType = map[string]bool{
-		"jiva":  true,
-		"cstor": true,
-	}
-)
-
 type openEBSPlugin struct {
-	mvol_v1alpha1.CASVolume
+	// casTypes are the cas types the snapshots are enabled for
+	casTypes map[string]bool
 }
 
-var _ volume.Plugin = &openEBSPlugin{}
+var _ volume.PluginV2 = &openEBSPlugin{}
+var _ provisioner.FinalSnapshotter = &openEBSPlugin{}
 
-// RegisterPlugin registers the volume plugin
-func RegisterPlugin() volume.Plugin {
-	return &openEBSPlugin{}
+// RegisterPlugin registers the volume plugin, its requests to
+// maya-apiserver are made with the context of the calls
+func RegisterPlugin() volume.PluginV2 {
+	return &openEBSPlugin{casTypes: enabledCASTypes()}
+}
+
+// NewFinalSnapshotter returns the FinalSnapshotter of the openebs
+// provisioner, the final snapshots are taken by the engines of the enabled
+// cas types
+func NewFinalSnapshotter() provisioner.FinalSnapshotter {
+	return &openEBSPlugin{casTypes: enabledCASTypes()}
 }
 
 func init() {
@@ -71,62 +72,92 @@ func GetPluginName() string {
 func (h *openEBSPlugin) Init(_ cloudprovider.Interface) {
 }
 
-func (h *openEBSPlugin) SnapshotCreate(snapshot *crdv1.VolumeSnapshot, pv *v1.PersistentVolume, tags *map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
-	spec := &pv.Spec
-	if spec == nil || spec.ISCSI == nil {
-		return nil, nil, fmt.Errorf("invalid PV spec %v", spec)
+// Capabilities returns the optional calls the plugin supports, the
+// snapshots can be found by their tags if an enabled engine can find them
+func (h *openEBSPlugin) Capabilities() volume.Capabilities {
+	capabilities := volume.AllCapabilities
+	capabilities.FindSnapshot = false
+	for casType := range h.casTypes {
+		if engine, ok := getEngine(casType); ok && engine.Capabilities().FindSnapshot {
+			capabilities.FindSnapshot = true
+		}
 	}
+	return capabilities
+}
 
-	snapObj := (*tags)["kubernetes.io/created-for/name"]
-	snapshotName := createSnapshotName(pv.Name, snapObj)
-
-	casType := pv.Annotations["openebs.io/cas-type"]
-	if casType == "" {
-		casType = "jiva"
+// engine returns the snapshot engine of the cas type
+func (h *openEBSPlugin) engine(casType string) (Engine, error) {
+	if !h.casTypes[casType] {
+		return nil, volume.NewUnsupportedError("snapshots of cas type %q are not enabled, the enabled cas types are set by %s", casType, CASTypesENVK)
 	}
-
-	ok := SnapSupportedCASType[casType]
+	engine, ok := getEngine(casType)
 	if !ok {
-		return nil, nil, fmt.Errorf("aborting create snapshot operation as specified volume type (%s) does not support snapshots", casType)
+		return nil, volume.NewUnsupportedError("no snapshot engine for cas type %q", casType)
 	}
-	_, err := h.CreateSnapshot(casType, pv.Name, snapshotName, pv.Spec.ClaimRef.Namespace)
+	return engine, nil
+}
+
+func (h *openEBSPlugin) engineOfPV(pv *v1.PersistentVolume) (Engine, error) {
+	casType, err := casTypeOfPV(pv)
 	if err != nil {
-		glog.Errorf("failed to create snapshot for volume :%v, err: %v", pv.Name, err)
-		return nil, nil, err
+		return nil, err
 	}
+	return h.engine(casType)
+}
 
-	glog.V(1).Info("snapshot %v created successfully", snapshotName)
-
-	cond := []crdv1.VolumeSnapshotCondition{}
-	if err == nil {
-		cond = []crdv1.VolumeSnapshotCondition{
-			{
-				Status:             v1.ConditionTrue,
-				Message:            "Snapshot created successfully",
-				LastTransitionTime: metav1.Now(),
-				Type:               crdv1.VolumeSnapshotConditionReady,
-			},
+// engineOfSnapshot returns the snapshot engine of the snapshot, the cas type
+// of the snapshots taken before it was recorded is the one of their PV
+func (h *openEBSPlugin) engineOfSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (Engine, error) {
+	casType := snapshotData.Spec.OpenEBSSnapshot.CASType
+	if casType == "" {
+		if snapshotData.Spec.PersistentVolumeRef == nil {
+			return nil, fmt.Errorf("snapshot %s has no cas type and no PV", snapshotData.Spec.OpenEBSSnapshot.SnapshotID)
 		}
-	} else {
-		glog.V(2).Infof("failed to create snapshot, err: %v", err)
-		cond = []crdv1.VolumeSnapshotCondition{
-			{
-				Status:             v1.ConditionTrue,
-				Message:            fmt.Sprintf("Failed to create the snapshot: %v", err),
-				LastTransitionTime: metav1.Now(),
-				Type:               crdv1.VolumeSnapshotConditionError,
-			},
+		pv, err := getPV(ctx, snapshotData.Spec.PersistentVolumeRef.Name)
+		if err != nil {
+			return nil, err
+		}
+		if casType, err = casTypeOfPV(pv); err != nil {
+			return nil, err
 		}
 	}
+	return h.engine(casType)
+}
 
-	sizeResource := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
-	res := &crdv1.VolumeSnapshotDataSource{
-		OpenEBSSnapshot: &crdv1.OpenEBSVolumeSnapshotSource{
-			SnapshotID: snapshotName,
-			Capacity:   sizeResource.String(),
-		},
+func (h *openEBSPlugin) SnapshotCreate(ctx context.Context, snapshot *crdv1.VolumeSnapshot, pv *v1.PersistentVolume, tags map[string]string) (volume.SnapshotResult, error) {
+	spec := &pv.Spec
+	if spec == nil || spec.ISCSI == nil {
+		return volume.SnapshotResult{}, fmt.Errorf("invalid PV spec %v", spec)
+	}
+
+	engine, err := h.engineOfPV(pv)
+	if err != nil {
+		glog.Errorf("aborting create snapshot operation of volume %s: %v", pv.Name, err)
+		return volume.SnapshotResult{}, err
+	}
+
+	snapObj := tags["kubernetes.io/created-for/name"]
+	snapshotName := createSnapshotName(pv.Name, snapObj)
+	return volume.NewSnapshotResult(engine.SnapshotCreate(ctx, pv, snapshotName))
+}
+
+// TakeFinalSnapshot takes the snapshot snapshotName of the PV before it is
+// deleted, through the engine of the cas type of the PV
+func (h *openEBSPlugin) TakeFinalSnapshot(ctx context.Context, pv *v1.PersistentVolume, snapshotName string) (*crdv1.OpenEBSVolumeSnapshotSource, error) {
+	engine, err := h.engineOfPV(pv)
+	if err != nil {
+		return nil, err
+	}
+	ctx, done := volume.StartOperation(ctx, GetPluginName(), volume.OperationCreate)
+	source, _, err := engine.SnapshotCreate(ctx, pv, snapshotName)
+	done(err)
+	if err != nil {
+		return nil, err
 	}
-	return res, &cond, err
+	if source == nil || source.OpenEBSSnapshot == nil {
+		return nil, fmt.Errorf("no openebs snapshot returned for volume %s", pv.Name)
+	}
+	return source.OpenEBSSnapshot, nil
 }
 
 func createSnapshotName(pvName string, snapObj string) string {
@@ -135,137 +166,88 @@ func createSnapshotName(pvName string, snapObj string) string {
 	return name
 }
 
-func (h *openEBSPlugin) SnapshotDelete(src *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error {
+func (h *openEBSPlugin) SnapshotDelete(ctx context.Context, src *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error {
 	if src == nil || src.OpenEBSSnapshot == nil {
 		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", src)
 	}
 
-	casType := pv.Annotations["openebs.io/cas-type"]
-	if casType == "" {
-		casType = "jiva"
+	var engine Engine
+	var err error
+	if casType := src.OpenEBSSnapshot.CASType; casType != "" {
+		engine, err = h.engine(casType)
+	} else if pv != nil {
+		engine, err = h.engineOfPV(pv)
+	} else {
+		err = fmt.Errorf("snapshot has no cas type and no PV")
 	}
-
-	_, err := h.DeleteSnapshot(casType, pv.Name, src.OpenEBSSnapshot.SnapshotID, pv.Spec.ClaimRef.Namespace)
 	if err != nil {
-		glog.Errorf("failed to create snapshot for volume :%v, err: %v", pv.Name, err)
+		glog.Errorf("failed to delete snapshot %s: %v", src.OpenEBSSnapshot.SnapshotID, err)
 		return err
 	}
-
-	glog.V(1).Infof("snapshot deleted :%v successfully", src.OpenEBSSnapshot.SnapshotID)
-	return err
+	return engine.SnapshotDelete(ctx, src.OpenEBSSnapshot, pv)
 }
 
-func (h *openEBSPlugin) DescribeSnapshot(snapshotData *crdv1.VolumeSnapshotData) (snapConditions *[]crdv1.VolumeSnapshotCondition, isCompleted bool, err error) {
+func (h *openEBSPlugin) DescribeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (volume.DescribeResult, error) {
 	if snapshotData == nil || snapshotData.Spec.OpenEBSSnapshot == nil {
-		return nil, false, fmt.Errorf("failed to retrieve Snapshot spec")
+		return volume.DescribeResult{}, fmt.Errorf("failed to retrieve Snapshot spec")
 	}
-
-	snapshotID := snapshotData.Spec.OpenEBSSnapshot.SnapshotID
-	glog.V(1).Infof("received describe request on snapshot:%v", snapshotID)
-
-	// TODO implement snapshot-info based on snapshotID
-	resp, err := h.SnapshotInfo(snapshotData.Spec.PersistentVolumeRef.Name, snapshotID)
-
+	engine, err := h.engineOfSnapshot(ctx, snapshotData)
 	if err != nil {
-		glog.Errorf("failed to describe snapshot:%v", snapshotID)
-	}
-
-	glog.V(1).Infof("snapshot details:%v", string(resp))
-
-	if len(snapshotData.Status.Conditions) == 0 {
-		return nil, false, fmt.Errorf("No status condtions in VoluemSnapshotData for openebs snapshot type")
+		return volume.DescribeResult{}, err
 	}
+	return volume.NewDescribeResult(engine.DescribeSnapshot(ctx, snapshotData))
+}
 
-	lastCondIdx := len(snapshotData.Status.Conditions) - 1
-	retCondType := crdv1.VolumeSnapshotConditionError
-
-	switch snapshotData.Status.Conditions[lastCondIdx].Type {
-	case crdv1.VolumeSnapshotDataConditionReady:
-		retCondType = crdv1.VolumeSnapshotConditionReady
-	case crdv1.VolumeSnapshotDataConditionPending:
-		retCondType = crdv1.VolumeSnapshotConditionPending
-		// Error out.
+// FindSnapshot finds a VolumeSnapshot by matching metadata, the tags do not
+// tell the cas type so every enabled engine able to find snapshots is asked
+func (h *openEBSPlugin) FindSnapshot(ctx context.Context, tags map[string]string) (volume.SnapshotResult, error) {
+	if !h.Capabilities().FindSnapshot {
+		return volume.SnapshotResult{}, volume.NewUnsupportedError("FindSnapshot is not supported by the %s plugin", GetPluginName())
 	}
-	retCond := []crdv1.VolumeSnapshotCondition{
-		{
-			Status:             snapshotData.Status.Conditions[lastCondIdx].Status,
-			Message:            snapshotData.Status.Conditions[lastCondIdx].Message,
-			LastTransitionTime: snapshotData.Status.Conditions[lastCondIdx].LastTransitionTime,
-			Type:               retCondType,
-		},
+	for casType := range h.casTypes {
+		engine, ok := getEngine(casType)
+		if !ok || !engine.Capabilities().FindSnapshot {
+			continue
+		}
+		result, err := volume.NewSnapshotResult(engine.FindSnapshot(ctx, tags))
+		if volume.IsNotFound(err) {
+			continue
+		}
+		return result, err
 	}
-	return &retCond, true, nil
-}
-
-// FindSnapshot finds a VolumeSnapshot by matching metadata
-func (h *openEBSPlugin) FindSnapshot(tags *map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
-	glog.Infof("FindSnapshot by tags: %#v", *tags)
-
-	// TODO: Implement FindSnapshot
-	return nil, nil, fmt.Errorf("Snapshot not found")
+	return volume.SnapshotResult{}, volume.NewNotFoundError("snapshot not found by the %s plugin", GetPluginName())
 }
 
 // SnapshotRestore restore to any created snapshot
-func (h *openEBSPlugin) SnapshotRestore(snapshotData *crdv1.VolumeSnapshotData,
+func (h *openEBSPlugin) SnapshotRestore(ctx context.Context,
+	snapshotData *crdv1.VolumeSnapshotData,
 	pvc *v1.PersistentVolumeClaim,
 	pvName string,
 	parameters map[string]string,
-) (*v1.PersistentVolumeSource, map[string]string, error) {
+) (volume.RestoreResult, error) {
 
 	if snapshotData == nil || snapshotData.Spec.OpenEBSSnapshot == nil {
-		return nil, nil, fmt.Errorf("Invalid Snapshot spec")
+		return volume.RestoreResult{}, fmt.Errorf("Invalid Snapshot spec")
 	}
 	if pvc == nil {
-		return nil, nil, fmt.Errorf("Invalid PVC spec")
-	}
-
-	// restore snapshot to a PV
-	var newVolume v1alpha1.CASVolume
-	var openebsVol mvol_v1alpha1.CASVolume
-
-	volumeSpec, class := CreateCloneVolumeSpec(snapshotData, pvc, pvName)
-
-	err := openebsVol.CreateVolume(volumeSpec)
-	if err != nil {
-		glog.Errorf("Error creating volume: %v", err)
-		return nil, nil, err
-	}
-	err = openebsVol.ReadVolume(pvName, pvc.Namespace, class, &newVolume)
-	if err != nil {
-		glog.Errorf("Error getting volume details: %v", err)
-		return nil, nil, err
+		return volume.RestoreResult{}, fmt.Errorf("Invalid PVC spec")
 	}
-
+	engine, err := h.engineOfSnapshot(ctx, snapshotData)
 	if err != nil {
-		glog.Errorf("snapshot :%v restore failed, err:%v", snapshotData.Spec.OpenEBSSnapshot.SnapshotID, err)
-		return nil, nil, fmt.Errorf("failed to restore %s, err: %v", snapshotData.Spec.OpenEBSSnapshot.SnapshotID, err)
-	}
-
-	glog.V(1).Infof("snapshot restored successfully to: %v", snapshotData.Spec.OpenEBSSnapshot.SnapshotID)
-
-	vollabels := make(map[string]string)
-	vollabels = provisioner.Setlink(vollabels, pvName)
-	vollabels[string(v1alpha1.CASTypeKey)] = newVolume.Spec.CasType
-	vollabels[string(v1alpha1.StorageClassKey)] = class
-
-	pv := &v1.PersistentVolumeSource{
-		ISCSI: &v1.ISCSIPersistentVolumeSource{
-			TargetPortal: newVolume.Spec.TargetPortal,
-			IQN:          newVolume.Spec.Iqn,
-			Lun:          0,
-			FSType:       newVolume.Spec.FSType,
-			ReadOnly:     false,
-		},
+		return volume.RestoreResult{}, err
 	}
-	return pv, vollabels, nil
+	return volume.NewRestoreResult(engine.SnapshotRestore(ctx, snapshotData, pvc, pvName))
 }
 
 // VolumeDelete deletes the persistent volume
-func (h *openEBSPlugin) VolumeDelete(pv *v1.PersistentVolume) error {
+func (h *openEBSPlugin) VolumeDelete(ctx context.Context, pv *v1.PersistentVolume) error {
 	if pv == nil || pv.Spec.ISCSI == nil {
 		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", pv)
 	}
-	var openebsVol mvol_v1alpha1.CASVolume
+	if pv.Spec.ClaimRef == nil {
+		return fmt.Errorf("PV %s has no claim", pv.Name)
+	}
+	openebsVol := mvol_v1alpha1.CASVolume{}.WithContext(ctx)
 
 	err := openebsVol.DeleteVolume(pv.Name, pv.Spec.ClaimRef.Namespace)
 	if err != nil {
@@ -306,12 +288,8 @@ func GetPersistentVolumeClass(volume *v1.PersistentVolume) string {
 }
 
 // GetPersistentClass returns StoragClassName
-func GetStorageClass(pvName string) (string, error) {
-	client, err := GetK8sClient()
-	if err != nil {
-		return "", err
-	}
-	volume, err := client.CoreV1().PersistentVolumes().Get(context.TODO(), pvName, metav1.GetOptions{})
+func GetStorageClass(ctx context.Context, pvName string) (string, error) {
+	volume, err := getPV(ctx, pvName)
 	if err != nil {
 		return "", err
 	}
@@ -319,6 +297,14 @@ func GetStorageClass(pvName string) (string, error) {
 	return GetPersistentVolumeClass(volume), nil
 }
 
+func getPV(ctx context.Context, pvName string) (*v1.PersistentVolume, error) {
+	client, err := GetK8sClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+}
+
 // GetNameAndNameSpaceFromSnapshotName retrieves the namespace and
 // the short name of a snapshot from its full name, for exmaple
 // "test-ns/snap1"
@@ -331,7 +317,8 @@ func GetNameAndNameSpaceFromSnapshotName(name string) (string, string, error) {
 }
 
 // CreateVolumeSpec constructs the volumeSpec for volume create request
-func CreateCloneVolumeSpec(snapshotData *crdv1.VolumeSnapshotData,
+func CreateCloneVolumeSpec(ctx context.Context,
+	snapshotData *crdv1.VolumeSnapshotData,
 	pvc *v1.PersistentVolumeClaim,
 	pvName string,
 ) (vol v1alpha1.CASVolume,
@@ -349,16 +336,21 @@ func CreateCloneVolumeSpec(snapshotData *crdv1.VolumeSnapshotData,
 	// Get the source PV storage class name which will be passed
 	// to maya-apiserver to extract volume policy while restoring snapshot as
 	// new volume.
-	pvRefStorageClass, err := GetStorageClass(pvRefName)
+	pvRefStorageClass, err := GetStorageClass(ctx, pvRefName)
 	if err != nil {
 		glog.Errorf("Error getting volume details: %v", err)
 	}
+	// The PV of a final snapshot is deleted, the snapshot records its
+	// storage class
+	if len(pvRefStorageClass) == 0 {
+		pvRefStorageClass = snapshotData.Metadata.Annotations[crdv1.FinalSnapshotStorageClassAnnotation]
+	}
+	casVolume.Labels = mapLabels
 	if len(pvRefStorageClass) == 0 {
 		glog.Errorf("Volume has no storage class specified")
 	} else {
 
 		mapLabels[string("openebs.io/storageclass")] = pvRefStorageClass
-		casVolume.Labels = mapLabels
 	}
 	glog.Infof("Using the Storage Class %s for dynamic provisioning", pvRefStorageClass)
 
@@ -0,0 +1,41 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/openebs/openebs-k8s-provisioner/pkg/tracing"
+)
+
+// StartOperation starts the call of the operation of the plugin in a span,
+// a child of the span of ctx, to be passed the returned context. The
+// returned function ends the span and records the call in the metrics, it
+// is passed the error the call returned.
+func StartOperation(ctx context.Context, plugin, operation string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "plugin."+operation,
+		attribute.String("plugin", plugin),
+		attribute.String("operation", operation))
+	return ctx, func(err error) {
+		observeOperation(plugin, operation, start, err)
+		tracing.End(span, err)
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+// The VolumePlugin service mirrors volume.PluginV2. Its messages carry the
+// objects of the Kubernetes and snapshot APIs as they are, so they are
+// encoded in JSON rather than protobuf. A plugin serves the service and the
+// standard gRPC health service on a unix socket.
+const (
+	// APIVersion is the version of the VolumePlugin service, a change
+	// breaking the plugins gets a new version and a new service name
+	APIVersion = "v1"
+	// ServiceName is the full name of the VolumePlugin service
+	ServiceName = "openebs.snapshot." + APIVersion + ".VolumePlugin"
+
+	codecName = "json"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes the messages of the VolumePlugin service
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+// PluginInfoRequest is the request of GetPluginInfo
+type PluginInfoRequest struct{}
+
+// PluginInfoResponse is the response of GetPluginInfo
+type PluginInfoResponse struct {
+	// Name is the name the plugin is registered with
+	Name string `json:"name"`
+	// Version is the version of the plugin itself
+	Version      string             `json:"version,omitempty"`
+	Capabilities PluginCapabilities `json:"capabilities"`
+}
+
+// PluginCapabilities are the optional calls the plugin supports, see
+// volume.Capabilities
+type PluginCapabilities struct {
+	FindSnapshot     bool `json:"findSnapshot,omitempty"`
+	SnapshotRestore  bool `json:"snapshotRestore,omitempty"`
+	DescribeSnapshot bool `json:"describeSnapshot,omitempty"`
+	VolumeDelete     bool `json:"volumeDelete,omitempty"`
+}
+
+// SnapshotCreateRequest is the request of SnapshotCreate
+type SnapshotCreateRequest struct {
+	Snapshot         *crdv1.VolumeSnapshot `json:"snapshot"`
+	PersistentVolume *v1.PersistentVolume  `json:"persistentVolume"`
+	Tags             map[string]string     `json:"tags,omitempty"`
+}
+
+// SnapshotResponse is the response of SnapshotCreate and FindSnapshot
+type SnapshotResponse struct {
+	Source     crdv1.VolumeSnapshotDataSource  `json:"source"`
+	Conditions []crdv1.VolumeSnapshotCondition `json:"conditions,omitempty"`
+}
+
+// SnapshotDeleteRequest is the request of SnapshotDelete
+type SnapshotDeleteRequest struct {
+	Source           *crdv1.VolumeSnapshotDataSource `json:"source"`
+	PersistentVolume *v1.PersistentVolume            `json:"persistentVolume,omitempty"`
+}
+
+// SnapshotRestoreRequest is the request of SnapshotRestore
+type SnapshotRestoreRequest struct {
+	SnapshotData *crdv1.VolumeSnapshotData `json:"snapshotData"`
+	Claim        *v1.PersistentVolumeClaim `json:"claim"`
+	PVName       string                    `json:"pvName"`
+	Parameters   map[string]string         `json:"parameters,omitempty"`
+}
+
+// SnapshotRestoreResponse is the response of SnapshotRestore
+type SnapshotRestoreResponse struct {
+	Source v1.PersistentVolumeSource `json:"source"`
+	// Labels are set on the restored PV
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// DescribeSnapshotRequest is the request of DescribeSnapshot
+type DescribeSnapshotRequest struct {
+	SnapshotData *crdv1.VolumeSnapshotData `json:"snapshotData"`
+}
+
+// DescribeSnapshotResponse is the response of DescribeSnapshot
+type DescribeSnapshotResponse struct {
+	Conditions []crdv1.VolumeSnapshotCondition `json:"conditions,omitempty"`
+	Completed  bool                            `json:"completed"`
+}
+
+// FindSnapshotRequest is the request of FindSnapshot
+type FindSnapshotRequest struct {
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// VolumeDeleteRequest is the request of VolumeDelete
+type VolumeDeleteRequest struct {
+	PersistentVolume *v1.PersistentVolume `json:"persistentVolume"`
+}
+
+// Empty is the response of the calls returning nothing but an error
+type Empty struct{}
+
+// volumePluginServer is implemented by the server of the VolumePlugin
+// service
+type volumePluginServer interface {
+	GetPluginInfo(context.Context, *PluginInfoRequest) (*PluginInfoResponse, error)
+	SnapshotCreate(context.Context, *SnapshotCreateRequest) (*SnapshotResponse, error)
+	SnapshotDelete(context.Context, *SnapshotDeleteRequest) (*Empty, error)
+	SnapshotRestore(context.Context, *SnapshotRestoreRequest) (*SnapshotRestoreResponse, error)
+	DescribeSnapshot(context.Context, *DescribeSnapshotRequest) (*DescribeSnapshotResponse, error)
+	FindSnapshot(context.Context, *FindSnapshotRequest) (*SnapshotResponse, error)
+	VolumeDelete(context.Context, *VolumeDeleteRequest) (*Empty, error)
+}
+
+// unaryMethod describes a method of the VolumePlugin service, newRequest
+// returns the request the call is decoded into
+func unaryMethod(name string, newRequest func() interface{}, call func(volumePluginServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(volumePluginServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*volumePluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("GetPluginInfo", func() interface{} { return &PluginInfoRequest{} },
+			func(s volumePluginServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.GetPluginInfo(ctx, req.(*PluginInfoRequest))
+			}),
+		unaryMethod("SnapshotCreate", func() interface{} { return &SnapshotCreateRequest{} },
+			func(s volumePluginServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.SnapshotCreate(ctx, req.(*SnapshotCreateRequest))
+			}),
+		unaryMethod("SnapshotDelete", func() interface{} { return &SnapshotDeleteRequest{} },
+			func(s volumePluginServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.SnapshotDelete(ctx, req.(*SnapshotDeleteRequest))
+			}),
+		unaryMethod("SnapshotRestore", func() interface{} { return &SnapshotRestoreRequest{} },
+			func(s volumePluginServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.SnapshotRestore(ctx, req.(*SnapshotRestoreRequest))
+			}),
+		unaryMethod("DescribeSnapshot", func() interface{} { return &DescribeSnapshotRequest{} },
+			func(s volumePluginServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.DescribeSnapshot(ctx, req.(*DescribeSnapshotRequest))
+			}),
+		unaryMethod("FindSnapshot", func() interface{} { return &FindSnapshotRequest{} },
+			func(s volumePluginServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.FindSnapshot(ctx, req.(*FindSnapshotRequest))
+			}),
+		unaryMethod("VolumeDelete", func() interface{} { return &VolumeDeleteRequest{} },
+			func(s volumePluginServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.VolumeDelete(ctx, req.(*VolumeDeleteRequest))
+			}),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// invoke calls the method of the VolumePlugin service
+func invoke(ctx context.Context, conn *grpc.ClientConn, method string, req, rsp interface{}) error {
+	return conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, rsp, grpc.CallContentSubtype(codecName))
+}
+
+// The reasons of the plugin errors are sent as status codes
+var reasonCodes = map[volume.ErrorReason]codes.Code{
+	volume.ReasonNotFound:    codes.NotFound,
+	volume.ReasonUnsupported: codes.Unimplemented,
+	volume.ReasonTransient:   codes.Unavailable,
+}
+
+// toStatus returns the error of the plugin as a status error
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	code, ok := reasonCodes[volume.ReasonForError(err)]
+	if !ok {
+		code = status.FromContextError(err).Code()
+	}
+	return status.Error(code, err.Error())
+}
+
+// fromStatus returns the status error as the error of the plugin. The
+// calls that timed out or did not reach the plugin are transient errors.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch s.Code() {
+	case codes.NotFound:
+		return volume.NewNotFoundError("%s", s.Message())
+	case codes.Unimplemented:
+		return volume.NewUnsupportedError("%s", s.Message())
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return volume.NewTransientError("%s", s.Message())
+	case codes.Canceled:
+		return context.Canceled
+	}
+	return errors.New(s.Message())
+}
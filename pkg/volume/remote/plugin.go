@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+const (
+	dialTimeout         = 10 * time.Second
+	callTimeout         = 5 * time.Minute
+	healthCheckTimeout  = 5 * time.Second
+	healthCheckInterval = 30 * time.Second
+)
+
+// remotePlugin is a volume plugin served by another process
+type remotePlugin struct {
+	name   string
+	socket string
+	conn   *grpc.ClientConn
+	health healthpb.HealthClient
+
+	capabilities volume.Capabilities
+
+	mutex   sync.RWMutex
+	serving bool
+}
+
+var _ volume.PluginV2 = &remotePlugin{}
+
+// dial connects to the plugin serving on the socket and gets its name
+func dial(socket string) (*remotePlugin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, socket,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", address)
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to volume plugin at %s: %v", socket, err)
+	}
+	info := &PluginInfoResponse{}
+	if err := invoke(ctx, conn, "GetPluginInfo", &PluginInfoRequest{}, info); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to get the name of volume plugin at %s: %v", socket, err)
+	}
+	if info.Name == "" {
+		conn.Close()
+		return nil, fmt.Errorf("volume plugin at %s has no name", socket)
+	}
+	p := &remotePlugin{
+		name:   info.Name,
+		socket: socket,
+		conn:   conn,
+		health: healthpb.NewHealthClient(conn),
+		capabilities: volume.Capabilities{
+			FindSnapshot:     info.Capabilities.FindSnapshot,
+			SnapshotRestore:  info.Capabilities.SnapshotRestore,
+			DescribeSnapshot: info.Capabilities.DescribeSnapshot,
+			VolumeDelete:     info.Capabilities.VolumeDelete,
+		},
+	}
+	p.checkHealth()
+	glog.Infof("Connected to volume plugin %s %s at %s", info.Name, info.Version, socket)
+	return p, nil
+}
+
+// checkHealth updates the status of the plugin, the calls to a plugin that
+// is not serving fail without reaching it
+func (p *remotePlugin) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	rsp, err := p.health.Check(ctx, &healthpb.HealthCheckRequest{Service: ServiceName})
+	serving := err == nil && rsp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if serving != p.serving {
+		if serving {
+			glog.Infof("Volume plugin %s is serving", p.name)
+		} else if err != nil {
+			glog.Errorf("Volume plugin %s is not serving: %v", p.name, err)
+		} else {
+			glog.Errorf("Volume plugin %s is not serving: %s", p.name, rsp.GetStatus())
+		}
+	}
+	p.serving = serving
+}
+
+// call calls the method of the plugin, the calls without a deadline time
+// out after callTimeout
+func (p *remotePlugin) call(ctx context.Context, method string, req, rsp interface{}) error {
+	p.mutex.RLock()
+	serving := p.serving
+	p.mutex.RUnlock()
+	if !serving {
+		return volume.NewTransientError("volume plugin %s at %s is not serving", p.name, p.socket)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+	}
+	return fromStatus(invoke(ctx, p.conn, method, req, rsp))
+}
+
+func (p *remotePlugin) Init(_ cloudprovider.Interface) {
+}
+
+func (p *remotePlugin) Capabilities() volume.Capabilities {
+	return p.capabilities
+}
+
+func (p *remotePlugin) SnapshotCreate(ctx context.Context, snapshot *crdv1.VolumeSnapshot, pv *v1.PersistentVolume, tags map[string]string) (volume.SnapshotResult, error) {
+	req := &SnapshotCreateRequest{Snapshot: snapshot, PersistentVolume: pv, Tags: tags}
+	rsp := &SnapshotResponse{}
+	if err := p.call(ctx, "SnapshotCreate", req, rsp); err != nil {
+		return volume.SnapshotResult{}, err
+	}
+	return volume.SnapshotResult{Source: rsp.Source, Conditions: rsp.Conditions}, nil
+}
+
+func (p *remotePlugin) SnapshotDelete(ctx context.Context, src *crdv1.VolumeSnapshotDataSource, pv *v1.PersistentVolume) error {
+	return p.call(ctx, "SnapshotDelete", &SnapshotDeleteRequest{Source: src, PersistentVolume: pv}, &Empty{})
+}
+
+func (p *remotePlugin) SnapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (volume.RestoreResult, error) {
+	req := &SnapshotRestoreRequest{
+		SnapshotData: snapshotData,
+		Claim:        pvc,
+		PVName:       pvName,
+		Parameters:   parameters,
+	}
+	rsp := &SnapshotRestoreResponse{}
+	if err := p.call(ctx, "SnapshotRestore", req, rsp); err != nil {
+		return volume.RestoreResult{}, err
+	}
+	return volume.RestoreResult{Source: rsp.Source, Labels: rsp.Labels}, nil
+}
+
+func (p *remotePlugin) DescribeSnapshot(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (volume.DescribeResult, error) {
+	rsp := &DescribeSnapshotResponse{}
+	if err := p.call(ctx, "DescribeSnapshot", &DescribeSnapshotRequest{SnapshotData: snapshotData}, rsp); err != nil {
+		return volume.DescribeResult{}, err
+	}
+	return volume.DescribeResult{Conditions: rsp.Conditions, Completed: rsp.Completed}, nil
+}
+
+func (p *remotePlugin) FindSnapshot(ctx context.Context, tags map[string]string) (volume.SnapshotResult, error) {
+	rsp := &SnapshotResponse{}
+	if err := p.call(ctx, "FindSnapshot", &FindSnapshotRequest{Tags: tags}, rsp); err != nil {
+		return volume.SnapshotResult{}, err
+	}
+	return volume.SnapshotResult{Source: rsp.Source, Conditions: rsp.Conditions}, nil
+}
+
+func (p *remotePlugin) VolumeDelete(ctx context.Context, pv *v1.PersistentVolume) error {
+	return p.call(ctx, "VolumeDelete", &VolumeDeleteRequest{PersistentVolume: pv}, &Empty{})
+}
+
+// RegisterPlugins connects to the plugins serving on the sockets of the
+// directory and adds them to the plugins by the names they advertise. The
+// sockets that can not be connected to are skipped, and so are the plugins
+// whose name is registered already.
+func RegisterPlugins(dir string, plugins map[string]volume.PluginV2) {
+	if dir == "" {
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		glog.Errorf("Failed to read volume plugin directory %s: %v", dir, err)
+		return
+	}
+	for _, file := range files {
+		if file.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+		socket := filepath.Join(dir, file.Name())
+		p, err := dial(socket)
+		if err != nil {
+			glog.Errorf("Skipping volume plugin socket: %v", err)
+			continue
+		}
+		if _, ok := plugins[p.name]; ok {
+			glog.Errorf("Skipping volume plugin %s at %s, a plugin with the same name is registered already", p.name, socket)
+			p.conn.Close()
+			continue
+		}
+		go wait.Forever(p.checkHealth, healthCheckInterval)
+		plugins[p.name] = p
+	}
+}
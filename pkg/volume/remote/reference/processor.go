@@ -0,0 +1,254 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reference is the reference out-of-process volume plugin. It
+// snapshots hostPath PVs by copying their directory, it is meant to show how
+// a plugin is written rather than to be used in production.
+package reference
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+const (
+	uidTag         = "kubernetes.io/created-for/uid"
+	snapshotPrefix = "snapshot-"
+	// sourceAttribute is the attribute holding the path of the snapshotted
+	// volume
+	sourceAttribute = "source"
+)
+
+type referencePlugin struct {
+	name        string
+	snapshotDir string
+	restoreDir  string
+}
+
+var _ volume.PluginV2 = &referencePlugin{}
+
+// NewPlugin returns the plugin registered as name, it keeps the snapshots
+// in snapshotDir and restores them to restoreDir
+func NewPlugin(name, snapshotDir, restoreDir string) volume.PluginV2 {
+	return &referencePlugin{
+		name:        name,
+		snapshotDir: snapshotDir,
+		restoreDir:  restoreDir,
+	}
+}
+
+// Check returns an error if the directories of the plugin can not be used
+func Check(snapshotDir, restoreDir string) error {
+	for _, dir := range []string{snapshotDir, restoreDir} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+func (p *referencePlugin) Init(_ cloudprovider.Interface) {
+}
+
+// Capabilities returns the optional calls the plugin supports, all of them
+func (p *referencePlugin) Capabilities() volume.Capabilities {
+	return volume.AllCapabilities
+}
+
+// copyDir copies the directory src to dst. The copy is made next to dst and
+// renamed, so that dst is complete if it exists. The copy is killed when the
+// context is done.
+func copyDir(ctx context.Context, src, dst string) error {
+	tmp := dst + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if out, err := exec.CommandContext(ctx, "cp", "-a", src+"/.", tmp).CombinedOutput(); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to copy %s: %v, %s", src, err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(tmp, dst)
+}
+
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// snapshotPath returns the directory of the snapshot
+func (p *referencePlugin) snapshotPath(src *crdv1.VolumeSnapshotDataSource) (string, error) {
+	if src == nil || src.PluginSnapshot == nil || src.PluginSnapshot.Plugin != p.name {
+		return "", fmt.Errorf("invalid VolumeSnapshotDataSource: %v", src)
+	}
+	id := src.PluginSnapshot.SnapshotID
+	if !strings.HasPrefix(id, snapshotPrefix) || filepath.Base(id) != id {
+		return "", fmt.Errorf("invalid snapshot ID %q", id)
+	}
+	return filepath.Join(p.snapshotDir, id), nil
+}
+
+// result returns the snapshot ready
+func (p *referencePlugin) result(id, path string) volume.SnapshotResult {
+	return volume.SnapshotResult{
+		Source: crdv1.VolumeSnapshotDataSource{
+			PluginSnapshot: &crdv1.PluginVolumeSnapshotSource{
+				Plugin:     p.name,
+				SnapshotID: id,
+				Attributes: map[string]string{sourceAttribute: path},
+			},
+		},
+		Conditions: newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"),
+	}
+}
+
+func newConditions(conditionType crdv1.VolumeSnapshotConditionType, message string) []crdv1.VolumeSnapshotCondition {
+	return []crdv1.VolumeSnapshotCondition{
+		{
+			Status:             v1.ConditionTrue,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+			Type:               conditionType,
+		},
+	}
+}
+
+// SnapshotCreate copies the directory of the hostPath PV
+func (p *referencePlugin) SnapshotCreate(ctx context.Context, snapshot *crdv1.VolumeSnapshot, pv *v1.PersistentVolume, tags map[string]string) (volume.SnapshotResult, error) {
+	if pv == nil || pv.Spec.HostPath == nil {
+		return volume.SnapshotResult{}, volume.NewUnsupportedError("only hostPath PVs can be snapshotted, got %v", pv)
+	}
+	uid := string(snapshot.Metadata.UID)
+	if tags[uidTag] != "" {
+		uid = tags[uidTag]
+	}
+	id := snapshotPrefix + uid
+	dst := filepath.Join(p.snapshotDir, id)
+	found, err := exists(dst)
+	if err != nil {
+		return volume.SnapshotResult{}, err
+	}
+	if !found {
+		if err := copyDir(ctx, pv.Spec.HostPath.Path, dst); err != nil {
+			return volume.SnapshotResult{}, err
+		}
+	}
+	glog.V(1).Infof("snapshot %s of %s created", id, pv.Spec.HostPath.Path)
+	return p.result(id, pv.Spec.HostPath.Path), nil
+}
+
+// SnapshotDelete removes the copy of the snapshot
+func (p *referencePlugin) SnapshotDelete(_ context.Context, src *crdv1.VolumeSnapshotDataSource, _ *v1.PersistentVolume) error {
+	path, err := p.snapshotPath(src)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// DescribeSnapshot returns the snapshot ready if its copy exists
+func (p *referencePlugin) DescribeSnapshot(_ context.Context, snapshotData *crdv1.VolumeSnapshotData) (volume.DescribeResult, error) {
+	if snapshotData == nil {
+		return volume.DescribeResult{}, fmt.Errorf("failed to retrieve Snapshot spec")
+	}
+	path, err := p.snapshotPath(&snapshotData.Spec.VolumeSnapshotDataSource)
+	if err != nil {
+		return volume.DescribeResult{}, err
+	}
+	found, err := exists(path)
+	if err != nil {
+		return volume.DescribeResult{}, err
+	}
+	if !found {
+		return volume.DescribeResult{}, volume.NewNotFoundError("snapshot %s not found", path)
+	}
+	return volume.DescribeResult{
+		Conditions: newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"),
+		Completed:  true,
+	}, nil
+}
+
+// FindSnapshot finds the copy of the snapshot by the UID tag
+func (p *referencePlugin) FindSnapshot(_ context.Context, tags map[string]string) (volume.SnapshotResult, error) {
+	if tags[uidTag] == "" {
+		return volume.SnapshotResult{}, volume.NewNotFoundError("no %s tag", uidTag)
+	}
+	id := snapshotPrefix + tags[uidTag]
+	found, err := exists(filepath.Join(p.snapshotDir, id))
+	if err != nil {
+		return volume.SnapshotResult{}, err
+	}
+	if !found {
+		return volume.SnapshotResult{}, volume.NewNotFoundError("snapshot %s not found", id)
+	}
+	return p.result(id, ""), nil
+}
+
+// SnapshotRestore copies the snapshot to a directory named after the PV
+func (p *referencePlugin) SnapshotRestore(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData, _ *v1.PersistentVolumeClaim, pvName string, _ map[string]string) (volume.RestoreResult, error) {
+	if snapshotData == nil {
+		return volume.RestoreResult{}, fmt.Errorf("failed to retrieve Snapshot spec")
+	}
+	path, err := p.snapshotPath(&snapshotData.Spec.VolumeSnapshotDataSource)
+	if err != nil {
+		return volume.RestoreResult{}, err
+	}
+	if filepath.Base(pvName) != pvName {
+		return volume.RestoreResult{}, fmt.Errorf("invalid PV name %q", pvName)
+	}
+	dst := filepath.Join(p.restoreDir, pvName)
+	if err := copyDir(ctx, path, dst); err != nil {
+		return volume.RestoreResult{}, err
+	}
+	glog.V(1).Infof("snapshot %s restored to %s", path, dst)
+	return volume.RestoreResult{
+		Source: v1.PersistentVolumeSource{
+			HostPath: &v1.HostPathVolumeSource{Path: dst},
+		},
+		Labels: map[string]string{crdv1.PluginAnnotation: p.name},
+	}, nil
+}
+
+// VolumeDelete removes the directory of a PV restored by the plugin
+func (p *referencePlugin) VolumeDelete(_ context.Context, pv *v1.PersistentVolume) error {
+	if pv == nil || pv.Spec.HostPath == nil {
+		return fmt.Errorf("invalid PV: %v", pv)
+	}
+	path := filepath.Clean(pv.Spec.HostPath.Path)
+	if filepath.Dir(path) != filepath.Clean(p.restoreDir) {
+		glog.Infof("PV %s was not restored by plugin %s, leaving %s", pv.Name, p.name, path)
+		return nil
+	}
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,99 @@
+package reference
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+func TestSnapshotLifecycle(t *testing.T) {
+	root, err := ioutil.TempDir("", "reference")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+	volumeDir := filepath.Join(root, "volume")
+	snapshotDir := filepath.Join(root, "snapshots")
+	restoreDir := filepath.Join(root, "restored")
+	for _, dir := range []string{volumeDir, snapshotDir, restoreDir} {
+		if err := os.Mkdir(dir, 0750); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := Check(snapshotDir, restoreDir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(volumeDir, "data"), []byte("data"), 0640); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	ctx := context.Background()
+	plugin := NewPlugin("reference", snapshotDir, restoreDir)
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: volumeDir},
+			},
+		},
+	}
+	tags := map[string]string{uidTag: "uid-1"}
+	snapshot, err := plugin.SnapshotCreate(ctx, &crdv1.VolumeSnapshot{}, pv, tags)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	src := &snapshot.Source
+	if src.PluginSnapshot.Plugin != "reference" || src.PluginSnapshot.SnapshotID != "snapshot-uid-1" {
+		t.Errorf("Unexpected snapshot %+v", src.PluginSnapshot)
+	}
+	if found, err := plugin.FindSnapshot(ctx, tags); err != nil || found.Source.PluginSnapshot.SnapshotID != "snapshot-uid-1" {
+		t.Errorf("Expected snapshot to be found, got %v", err)
+	}
+
+	// the snapshot is not changed by later writes to the volume
+	if err := ioutil.WriteFile(filepath.Join(volumeDir, "data"), []byte("changed"), 0640); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	data := &crdv1.VolumeSnapshotData{Spec: crdv1.VolumeSnapshotDataSpec{VolumeSnapshotDataSource: *src}}
+	restored, err := plugin.SnapshotRestore(ctx, data, &v1.PersistentVolumeClaim{}, "pv2", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(restored.Source.HostPath.Path, "data"))
+	if err != nil || string(content) != "data" {
+		t.Errorf("Expected snapshot content to be restored, got %q, %v", content, err)
+	}
+	if restored.Labels[crdv1.PluginAnnotation] != "reference" {
+		t.Errorf("Expected restored PV to be labelled, got %v", restored.Labels)
+	}
+
+	// only the restored volumes are deleted
+	if err := plugin.VolumeDelete(ctx, pv); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(volumeDir); err != nil {
+		t.Errorf("Expected volume not to be deleted, got %v", err)
+	}
+	restoredPV := &v1.PersistentVolume{Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: restored.Source}}
+	if err := plugin.VolumeDelete(ctx, restoredPV); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(restored.Source.HostPath.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected restored volume to be deleted, got %v", err)
+	}
+
+	if err := plugin.SnapshotDelete(ctx, src, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := plugin.DescribeSnapshot(ctx, data); !volume.IsNotFound(err) {
+		t.Errorf("Expected snapshot to be deleted, got %v", err)
+	}
+}
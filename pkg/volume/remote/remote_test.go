@@ -0,0 +1,211 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+// fakePlugin returns the snapshot source and fails with err if set, it
+// records the PVs it deletes
+type fakePlugin struct {
+	err     error
+	deleted []string
+}
+
+var fakeSource = crdv1.VolumeSnapshotDataSource{
+	PluginSnapshot: &crdv1.PluginVolumeSnapshotSource{
+		Plugin:     "fake",
+		SnapshotID: "snap-1",
+		Attributes: map[string]string{"pool": "pool1"},
+	},
+}
+
+func (f *fakePlugin) Init(cloudprovider.Interface) {}
+
+func (f *fakePlugin) Capabilities() volume.Capabilities {
+	capabilities := volume.AllCapabilities
+	capabilities.FindSnapshot = false
+	return capabilities
+}
+
+func (f *fakePlugin) SnapshotCreate(_ context.Context, snapshot *crdv1.VolumeSnapshot, pv *v1.PersistentVolume, tags map[string]string) (volume.SnapshotResult, error) {
+	if f.err != nil {
+		return volume.SnapshotResult{}, f.err
+	}
+	if tags["uid"] != string(snapshot.Metadata.UID) || pv.Name != "pv1" {
+		return volume.SnapshotResult{}, fmt.Errorf("unexpected request %v %v %v", snapshot.Metadata.UID, pv.Name, tags)
+	}
+	conditions := []crdv1.VolumeSnapshotCondition{{Type: crdv1.VolumeSnapshotConditionReady, Status: v1.ConditionTrue}}
+	return volume.SnapshotResult{Source: fakeSource, Conditions: conditions}, nil
+}
+
+func (f *fakePlugin) SnapshotDelete(context.Context, *crdv1.VolumeSnapshotDataSource, *v1.PersistentVolume) error {
+	return f.err
+}
+
+func (f *fakePlugin) SnapshotRestore(_ context.Context, snapshotData *crdv1.VolumeSnapshotData, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (volume.RestoreResult, error) {
+	if f.err != nil {
+		return volume.RestoreResult{}, f.err
+	}
+	path := filepath.Join("/restored", parameters["pool"], pvName)
+	return volume.RestoreResult{
+		Source: v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: path}},
+		Labels: map[string]string{crdv1.PluginAnnotation: "fake"},
+	}, nil
+}
+
+func (f *fakePlugin) DescribeSnapshot(ctx context.Context, _ *crdv1.VolumeSnapshotData) (volume.DescribeResult, error) {
+	if f.err != nil {
+		return volume.DescribeResult{}, f.err
+	}
+	conditions := []crdv1.VolumeSnapshotCondition{{Type: crdv1.VolumeSnapshotConditionPending, Status: v1.ConditionTrue}}
+	return volume.DescribeResult{Conditions: conditions}, nil
+}
+
+func (f *fakePlugin) FindSnapshot(context.Context, map[string]string) (volume.SnapshotResult, error) {
+	return volume.SnapshotResult{}, volume.NewUnsupportedError("FindSnapshot is not supported")
+}
+
+func (f *fakePlugin) VolumeDelete(_ context.Context, pv *v1.PersistentVolume) error {
+	f.deleted = append(f.deleted, pv.Name)
+	return f.err
+}
+
+// serve serves the plugin as name on a socket of dir
+func serve(t *testing.T, dir, name string, plugin volume.PluginV2) *Server {
+	listener, err := net.Listen("unix", filepath.Join(dir, name+".sock"))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := NewServer(name, "test", plugin)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return server
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestRegisterPlugins(t *testing.T) {
+	dir := tempDir(t)
+	serve(t, dir, "fake", &fakePlugin{})
+	serve(t, dir, "hostPath", &fakePlugin{})
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a socket"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	// a socket nothing is listening on
+	listener, err := net.Listen("unix", filepath.Join(dir, "stale.sock"))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	builtin := &fakePlugin{}
+	plugins := map[string]volume.PluginV2{"hostPath": builtin}
+	RegisterPlugins(dir, plugins)
+	if len(plugins) != 2 || plugins["hostPath"] != builtin {
+		t.Fatalf("Expected fake to be registered next to the built-in plugin, got %v", plugins)
+	}
+	if _, ok := plugins["fake"].(*remotePlugin); !ok {
+		t.Errorf("Expected fake to be a remote plugin, got %T", plugins["fake"])
+	}
+}
+
+func TestRemotePlugin(t *testing.T) {
+	dir := tempDir(t)
+	fake := &fakePlugin{}
+	server := serve(t, dir, "fake", fake)
+	p, err := dial(filepath.Join(dir, "fake.sock"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.Capabilities() != fake.Capabilities() {
+		t.Errorf("Expected capabilities %+v, got %+v", fake.Capabilities(), p.Capabilities())
+	}
+
+	ctx := context.Background()
+	snapshot := &crdv1.VolumeSnapshot{Metadata: metav1.ObjectMeta{UID: "uid-1"}}
+	pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv1"}}
+	created, err := p.SnapshotCreate(ctx, snapshot, pv, map[string]string{"uid": "uid-1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(created.Source, fakeSource) || created.Conditions[0].Type != crdv1.VolumeSnapshotConditionReady {
+		t.Errorf("Unexpected snapshot %+v", created)
+	}
+
+	data := &crdv1.VolumeSnapshotData{Spec: crdv1.VolumeSnapshotDataSpec{VolumeSnapshotDataSource: fakeSource}}
+	restored, err := p.SnapshotRestore(ctx, data, &v1.PersistentVolumeClaim{}, "pv2", map[string]string{"pool": "pool1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restored.Source.HostPath.Path != "/restored/pool1/pv2" || restored.Labels[crdv1.PluginAnnotation] != "fake" {
+		t.Errorf("Unexpected restored volume %+v", restored)
+	}
+
+	if described, err := p.DescribeSnapshot(ctx, data); err != nil || described.Completed {
+		t.Errorf("Expected snapshot to be pending, got %+v %v", described, err)
+	}
+	if err := p.VolumeDelete(ctx, &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv2"}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(fake.deleted, []string{"pv2"}) {
+		t.Errorf("Expected pv2 to be deleted, got %v", fake.deleted)
+	}
+
+	// the errors of the plugin keep their message and their reason
+	if _, err := p.FindSnapshot(ctx, map[string]string{}); !volume.IsUnsupported(err) {
+		t.Errorf("Expected unsupported error, got %v", err)
+	}
+	tests := map[string]struct {
+		err    error
+		reason volume.ErrorReason
+	}{
+		"not found": {err: volume.NewNotFoundError("snap-1 not found"), reason: volume.ReasonNotFound},
+		"transient": {err: volume.NewTransientError("pool pool1 is busy"), reason: volume.ReasonTransient},
+		"other":     {err: fmt.Errorf("pool pool1 is offline")},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake.err = test.err
+			err := p.SnapshotDelete(ctx, &fakeSource, nil)
+			if err == nil || err.Error() != test.err.Error() || volume.ReasonForError(err) != test.reason {
+				t.Errorf("Expected %q with reason %q, got %v", test.err, test.reason, err)
+			}
+		})
+	}
+	fake.err = nil
+
+	// the calls fail without reaching a plugin that is not serving
+	server.SetServing(false)
+	p.checkHealth()
+	if err := p.VolumeDelete(ctx, pv); !volume.IsTransient(err) || !strings.Contains(err.Error(), "not serving") {
+		t.Errorf("Expected plugin not to be serving, got %v", err)
+	}
+	server.SetServing(true)
+	p.checkHealth()
+	if err := p.VolumeDelete(ctx, pv); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+// Server serves a volume plugin to the snapshot controller and the snapshot
+// provisioner
+type Server struct {
+	name    string
+	version string
+	plugin  volume.PluginV2
+	server  *grpc.Server
+	health  *health.Server
+}
+
+var _ volumePluginServer = &Server{}
+
+// NewServer returns the server of the plugin, registered as name. The
+// server is serving until SetServing says otherwise.
+func NewServer(name, version string, plugin volume.PluginV2) *Server {
+	s := &Server{
+		name:    name,
+		version: version,
+		plugin:  plugin,
+		server:  grpc.NewServer(),
+		health:  health.NewServer(),
+	}
+	s.server.RegisterService(&serviceDesc, s)
+	healthpb.RegisterHealthServer(s.server, s.health)
+	s.SetServing(true)
+	return s
+}
+
+// SetServing sets the status returned by the health checks
+func (s *Server) SetServing(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus("", status)
+	s.health.SetServingStatus(ServiceName, status)
+}
+
+// ListenAndServe serves the plugin on the unix socket, an existing socket
+// is replaced
+func (s *Server) ListenAndServe(socket string) error {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove socket %s: %v", socket, err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", socket, err)
+	}
+	glog.Infof("Serving volume plugin %s on %s", s.name, socket)
+	return s.Serve(listener)
+}
+
+// Serve serves the plugin on the listener
+func (s *Server) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Stop stops the server, the calls in progress are completed
+func (s *Server) Stop() {
+	s.health.Shutdown()
+	s.server.GracefulStop()
+}
+
+// GetPluginInfo returns the name, the version and the capabilities of the
+// plugin
+func (s *Server) GetPluginInfo(_ context.Context, _ *PluginInfoRequest) (*PluginInfoResponse, error) {
+	capabilities := s.plugin.Capabilities()
+	return &PluginInfoResponse{
+		Name:    s.name,
+		Version: s.version,
+		Capabilities: PluginCapabilities{
+			FindSnapshot:     capabilities.FindSnapshot,
+			SnapshotRestore:  capabilities.SnapshotRestore,
+			DescribeSnapshot: capabilities.DescribeSnapshot,
+			VolumeDelete:     capabilities.VolumeDelete,
+		},
+	}, nil
+}
+
+// SnapshotCreate calls SnapshotCreate of the plugin
+func (s *Server) SnapshotCreate(ctx context.Context, req *SnapshotCreateRequest) (*SnapshotResponse, error) {
+	result, err := s.plugin.SnapshotCreate(ctx, req.Snapshot, req.PersistentVolume, req.Tags)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &SnapshotResponse{Source: result.Source, Conditions: result.Conditions}, nil
+}
+
+// SnapshotDelete calls SnapshotDelete of the plugin
+func (s *Server) SnapshotDelete(ctx context.Context, req *SnapshotDeleteRequest) (*Empty, error) {
+	if err := s.plugin.SnapshotDelete(ctx, req.Source, req.PersistentVolume); err != nil {
+		return nil, toStatus(err)
+	}
+	return &Empty{}, nil
+}
+
+// SnapshotRestore calls SnapshotRestore of the plugin
+func (s *Server) SnapshotRestore(ctx context.Context, req *SnapshotRestoreRequest) (*SnapshotRestoreResponse, error) {
+	result, err := s.plugin.SnapshotRestore(ctx, req.SnapshotData, req.Claim, req.PVName, req.Parameters)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &SnapshotRestoreResponse{Source: result.Source, Labels: result.Labels}, nil
+}
+
+// DescribeSnapshot calls DescribeSnapshot of the plugin
+func (s *Server) DescribeSnapshot(ctx context.Context, req *DescribeSnapshotRequest) (*DescribeSnapshotResponse, error) {
+	result, err := s.plugin.DescribeSnapshot(ctx, req.SnapshotData)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &DescribeSnapshotResponse{Conditions: result.Conditions, Completed: result.Completed}, nil
+}
+
+// FindSnapshot calls FindSnapshot of the plugin
+func (s *Server) FindSnapshot(ctx context.Context, req *FindSnapshotRequest) (*SnapshotResponse, error) {
+	result, err := s.plugin.FindSnapshot(ctx, req.Tags)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &SnapshotResponse{Source: result.Source, Conditions: result.Conditions}, nil
+}
+
+// VolumeDelete calls VolumeDelete of the plugin
+func (s *Server) VolumeDelete(ctx context.Context, req *VolumeDeleteRequest) (*Empty, error) {
+	if err := s.plugin.VolumeDelete(ctx, req.PersistentVolume); err != nil {
+		return nil, toStatus(err)
+	}
+	return &Empty{}, nil
+}
@@ -52,13 +52,11 @@ func (v CASVolume) CreateSnapshot(castype, volName, snapName, namespace string)
 
 	glog.Infof("snapshot Spec Created:\n%v\n", string(snapBytes))
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(snapBytes))
+	req, err := http.NewRequestWithContext(v.context(), "POST", url, bytes.NewBuffer(snapBytes))
 
 	req.Header.Add("Content-Type", "application/json")
 
-	c := &http.Client{
-		Timeout: timeout,
-	}
+	c := v.httpClient(timeout)
 	resp, err := c.Do(req)
 	if err != nil {
 		glog.Errorf("Error when connecting maya-apiserver %v", err)
@@ -97,16 +95,14 @@ func (v CASVolume) ListSnapshot(volName string, snapname string, namespace strin
 
 	glog.V(2).Infof("[DEBUG] Get details for Volume :%v", string(volName))
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(v.context(), "GET", url, nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("namespace", namespace)
 
-	c := &http.Client{
-		Timeout: timeout,
-	}
+	c := v.httpClient(timeout)
 	resp, err := c.Do(req)
 	if err != nil {
 		glog.Errorf("Error when connecting to maya-apiserver %v", err)
@@ -130,6 +126,43 @@ func (v CASVolume) ListSnapshot(volName string, snapname string, namespace strin
 	return json.NewDecoder(resp.Body).Decode(obj)
 }
 
+// ListSnapshots to get the list of CAS snapshots through a API call to
+// m-apiserver, snapshots of all namespaces are listed if the namespace is empty
+func (v CASVolume) ListSnapshots(namespace string, obj interface{}) error {
+
+	addr := os.Getenv("MAPI_ADDR")
+	if addr == "" {
+		err := errors.New("MAPI_ADDR environment variable not set")
+		return err
+	}
+	url := addr + "/latest/snapshots/"
+
+	req, err := http.NewRequestWithContext(v.context(), "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("namespace", namespace)
+
+	c := v.httpClient(timeout)
+	resp, err := c.Do(req)
+	if err != nil {
+		glog.Errorf("Error when connecting to maya-apiserver %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		glog.Errorf("Unable to read response from maya-apiserver %v", err)
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", http.StatusText(resp.StatusCode), string(data))
+	}
+	glog.V(2).Info("snapshot list Successfully Retrieved")
+	return json.Unmarshal(data, obj)
+}
+
 // // RevertSnapshot revert a snapshot of volume by invoking the API call to m-apiserver
 // func (v CASVolume) RevertSnapshot(volName string, snapName string) (string, error) {
 // 	addr := os.Getenv("MAPI_ADDR")
@@ -194,7 +227,7 @@ func (v CASVolume) DeleteSnapshot(castype, volName, snapName, namespace string)
 
 	url := addr + "/latest/snapshots/" + snapName
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(v.context(), "DELETE", url, nil)
 
 	glog.Infof("Deleting snapshot %s of %s volume %s in namespace %s", snapName, castype, volName, namespace)
 	// Add query params
@@ -206,9 +239,7 @@ func (v CASVolume) DeleteSnapshot(castype, volName, snapName, namespace string)
 	// Add query params to req
 	req.URL.RawQuery = q.Encode()
 
-	c := &http.Client{
-		Timeout: timeout,
-	}
+	c := v.httpClient(timeout)
 	resp, err := c.Do(req)
 	if err != nil {
 		glog.Errorf("Error when connecting maya-apiserver %v", err)
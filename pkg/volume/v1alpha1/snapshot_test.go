@@ -38,7 +38,7 @@ var (
 )
 
 func TestCreateSnapshot(t *testing.T) {
-	tests := map[string]struct {
+	tests := map[string]*struct {
 		volumeName  string
 		snapName    string
 		fakeHandler utiltesting.FakeHandler
@@ -138,7 +138,7 @@ func TestCreateSnapshot(t *testing.T) {
 }
 
 func TestListSnapshot(t *testing.T) {
-	tests := map[string]struct {
+	tests := map[string]*struct {
 		volumeName  string
 		fakeHandler utiltesting.FakeHandler
 		err         error
@@ -29,12 +29,16 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/openebs/openebs-k8s-provisioner/pkg/apis/openebs.io/v1alpha1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/tracing"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	timeout = 60 * time.Second
+	// reachableTimeout is the timeout of the reachability check of
+	// m-apiserver, the readiness probes time out sooner than timeout
+	reachableTimeout = 5 * time.Second
 )
 
 // CASVolumeInterface Interface CAS volume operations
@@ -60,7 +64,35 @@ type Deleter interface {
 }
 
 //CASVolume struct
-type CASVolume struct{}
+type CASVolume struct {
+	// ctx is the context of the requests to m-apiserver
+	ctx context.Context
+}
+
+// WithContext returns a copy of v whose requests to m-apiserver are made
+// with ctx, they are traced as children of the span of ctx. A nil ctx is
+// context.Background().
+func (v CASVolume) WithContext(ctx context.Context) CASVolume {
+	v.ctx = ctx
+	return v
+}
+
+// context returns the context of the requests to m-apiserver
+func (v CASVolume) context() context.Context {
+	if v.ctx == nil {
+		return context.Background()
+	}
+	return v.ctx
+}
+
+// httpClient returns the client of the requests to m-apiserver, it traces
+// them and propagates their trace context to m-apiserver
+func (v CASVolume) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: tracing.NewTransport(nil),
+	}
+}
 
 //GetMayaClusterIP returns maya-apiserver IP address
 func (v CASVolume) GetMayaClusterIP(client kubernetes.Interface) (string, error) {
@@ -105,13 +137,11 @@ func (v CASVolume) CreateVolume(vol v1alpha1.CASVolume) error {
 
 	glog.Infof("CAS Volume Spec Created:\n%v\n", string(jsonValue))
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonValue))
+	req, err := http.NewRequestWithContext(v.context(), "POST", url, bytes.NewBuffer(jsonValue))
 
 	req.Header.Add("Content-Type", "application/json")
 
-	c := &http.Client{
-		Timeout: timeout,
-	}
+	c := v.httpClient(timeout)
 	resp, err := c.Do(req)
 	if err != nil {
 		glog.Errorf("Error when connecting maya-apiserver %v", err)
@@ -146,7 +176,7 @@ func (v CASVolume) ReadVolume(vname, namespace, storageclass string, obj interfa
 
 	glog.V(2).Infof("[DEBUG] Get details for Volume :%v", string(vname))
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(v.context(), "GET", url, nil)
 	if err != nil {
 		return err
 	}
@@ -162,9 +192,7 @@ func (v CASVolume) ReadVolume(vname, namespace, storageclass string, obj interfa
 	req.Header.Set(string(v1alpha1.StorageClassHeaderKey), storageclass)
 	req.Header.Set(string(v1alpha1.IsPatchJivaReplicaNodeAffinityHeader), patchJivaReplicaWithNodeAffinity)
 
-	c := &http.Client{
-		Timeout: timeout,
-	}
+	c := v.httpClient(timeout)
 	resp, err := c.Do(req)
 	if err != nil {
 		glog.Errorf("Error when connecting to maya-apiserver %v", err)
@@ -181,6 +209,41 @@ func (v CASVolume) ReadVolume(vname, namespace, storageclass string, obj interfa
 	return json.NewDecoder(resp.Body).Decode(obj)
 }
 
+// ListVolumes to get the list of CAS volumes through a API call to m-apiserver,
+// volumes of all namespaces are listed if the namespace is empty
+func (v CASVolume) ListVolumes(namespace string, obj interface{}) error {
+
+	addr := os.Getenv("MAPI_ADDR")
+	if addr == "" {
+		err := errors.New("MAPI_ADDR environment variable not set")
+		return err
+	}
+	url := addr + "/latest/volumes/"
+
+	req, err := http.NewRequestWithContext(v.context(), "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("namespace", namespace)
+
+	c := v.httpClient(timeout)
+	resp, err := c.Do(req)
+	if err != nil {
+		glog.Errorf("Error when connecting to maya-apiserver %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	code := resp.StatusCode
+	if code != http.StatusOK {
+		glog.Errorf("HTTP Status error from maya-apiserver: %v\n", http.StatusText(code))
+		return errors.New(http.StatusText(code))
+	}
+	glog.V(2).Info("volume list Successfully Retrieved")
+	return json.NewDecoder(resp.Body).Decode(obj)
+}
+
 // DeleteVolume to get delete CAS volume through a API call to m-apiserver
 func (v CASVolume) DeleteVolume(vname string, namespace string) error {
 
@@ -190,16 +253,14 @@ func (v CASVolume) DeleteVolume(vname string, namespace string) error {
 		return err
 	}
 	url := addr + "/latest/volumes/" + vname
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(v.context(), "DELETE", url, nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("namespace", namespace)
 
-	c := &http.Client{
-		Timeout: timeout,
-	}
+	c := v.httpClient(timeout)
 	resp, err := c.Do(req)
 	if err != nil {
 		glog.Errorf("Error when connecting to maya-apiserver  %v", err)
@@ -214,3 +275,24 @@ func (v CASVolume) DeleteVolume(vname string, namespace string) error {
 	glog.Info("volume Deleted Successfully initiated")
 	return nil
 }
+
+// Reachable returns nil if m-apiserver answers at MAPI_ADDR, the status of
+// the response does not matter
+func (v CASVolume) Reachable() error {
+
+	addr := os.Getenv("MAPI_ADDR")
+	if addr == "" {
+		err := errors.New("MAPI_ADDR environment variable not set")
+		return err
+	}
+
+	c := &http.Client{
+		Timeout: reachableTimeout,
+	}
+	resp, err := c.Get(addr + "/latest/meta-data/")
+	if err != nil {
+		return fmt.Errorf("maya-apiserver at %s is not reachable: %v", addr, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,52 @@
+package v1alpha1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/openebs/openebs-k8s-provisioner/pkg/tracing"
+)
+
+func TestWithContextTracesRequests(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer ts.Close()
+	os.Setenv("MAPI_ADDR", ts.URL)
+	defer os.Unsetenv("MAPI_ADDR")
+
+	ctx, span := tracing.Start(context.Background(), "Delete")
+	err := CASVolume{}.WithContext(ctx).DeleteVolume("testvol", "default")
+	tracing.End(span, err)
+	if err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want the request and its parent", len(spans))
+	}
+	request := spans[0]
+	if request.Name != "HTTP DELETE" {
+		t.Errorf("span name = %q, want %q", request.Name, "HTTP DELETE")
+	}
+	if request.Parent.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("span parent = %v, want %v", request.Parent.SpanID(), span.SpanContext().SpanID())
+	}
+	if traceparent == "" {
+		t.Errorf("the trace context is not propagated to maya-apiserver")
+	}
+}
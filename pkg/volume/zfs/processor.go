@@ -0,0 +1,315 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pborman/uuid"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/cloudprovider"
+	"github.com/openebs/openebs-k8s-provisioner/pkg/volume"
+)
+
+const (
+	// ParentDatasetParameter is the storage class parameter naming the
+	// dataset the clones restored from snapshots are created in, they are
+	// created next to the dataset of the snapshot by default
+	ParentDatasetParameter = "parentDataset"
+
+	// uidProperty is the user property holding the UID of the VolumeSnapshot
+	// a snapshot is created for
+	uidProperty = "io.openebs.snapshot:uid"
+	// localProperty is the user property set to true on the snapshots of
+	// local PVs, the snapshots found by FindSnapshot are restored to PVs of
+	// the same kind
+	localProperty = "io.openebs.snapshot:local"
+	// cloneOfProperty is the user property holding the snapshot a dataset is
+	// cloned from by SnapshotRestore
+	cloneOfProperty = "io.openebs.snapshot:clone-of"
+
+	uidTag         = "kubernetes.io/created-for/uid"
+	snapshotPrefix = "k8s-"
+)
+
+type zfsPlugin struct {
+	runner commandRunner
+	// nodeName is the node the pools the plugin snapshots are on
+	nodeName string
+	// kubeClient reads the hostname label of the node
+	kubeClient kubernetes.Interface
+}
+
+var _ volume.Plugin = &zfsPlugin{}
+var _ volume.NodeAffinityPlugin = &zfsPlugin{}
+var _ volume.KubeClientPlugin = &zfsPlugin{}
+
+// RegisterPlugin registers the volume plugin
+func RegisterPlugin() volume.Plugin {
+	return &zfsPlugin{
+		runner:   execRunner{binary: zfsBinary},
+		nodeName: volume.NodeName(),
+	}
+}
+
+// GetPluginName gets the name of the volume plugin
+func GetPluginName() string {
+	return "zfs"
+}
+
+func (z *zfsPlugin) Init(_ cloudprovider.Interface) {
+}
+
+// SetKubeClient sets the client the hostname label of the node is read with
+func (z *zfsPlugin) SetKubeClient(client kubernetes.Interface) {
+	z.kubeClient = client
+}
+
+// dataset returns the dataset backing the PV, it is either named by the
+// annotation of the PV or mounted at the path of the PV. It fails with
+// errNotFound if no dataset is mounted there.
+func (z *zfsPlugin) dataset(pv *v1.PersistentVolume) (string, error) {
+	if dataset := pv.Annotations[crdv1.ZFSDatasetAnnotation]; dataset != "" {
+		return dataset, nil
+	}
+	var path string
+	switch {
+	case pv.Spec.Local != nil:
+		path = pv.Spec.Local.Path
+	case pv.Spec.HostPath != nil:
+		path = pv.Spec.HostPath.Path
+	default:
+		return "", fmt.Errorf("PV %s is neither a local nor a hostPath volume", pv.Name)
+	}
+	return datasetByMountpoint(z.runner, path)
+}
+
+// SnapshotCreate takes a ZFS snapshot of the dataset of the PV, the UID of
+// the VolumeSnapshot and the kind of the PV are stored in user properties
+// for FindSnapshot
+func (z *zfsPlugin) SnapshotCreate(
+	snapshot *crdv1.VolumeSnapshot,
+	pv *v1.PersistentVolume,
+	tags *map[string]string,
+) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	dataset, err := z.dataset(pv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find the dataset of PV %s: %v", pv.Name, err)
+	}
+	name := dataset + "@" + snapshotPrefix + uuid.New()
+	args := []string{"snapshot"}
+	if tags != nil && (*tags)[uidTag] != "" {
+		args = append(args, "-o", uidProperty+"="+(*tags)[uidTag])
+	}
+	if pv.Spec.Local != nil {
+		args = append(args, "-o", localProperty+"=true")
+	}
+	if _, err := run(z.runner, append(args, name)...); err != nil {
+		glog.Errorf("failed to create snapshot of dataset %v: %v", dataset, err)
+		return nil, nil, fmt.Errorf("failed to create snapshot of dataset %s: %v", dataset, err)
+	}
+	glog.V(1).Infof("snapshot %v created successfully", name)
+
+	res := &crdv1.VolumeSnapshotDataSource{
+		ZFSSnapshot: &crdv1.ZFSVolumeSnapshotSource{
+			Snapshot: name,
+			NodeName: z.nodeName,
+			Local:    pv.Spec.Local != nil,
+		},
+	}
+	return res, newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"), nil
+}
+
+func newConditions(conditionType crdv1.VolumeSnapshotConditionType, message string) *[]crdv1.VolumeSnapshotCondition {
+	return &[]crdv1.VolumeSnapshotCondition{
+		{
+			Status:             v1.ConditionTrue,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+			Type:               conditionType,
+		},
+	}
+}
+
+// SnapshotDelete destroys the snapshot, the destruction of a snapshot with
+// clones is deferred until its last clone is destroyed
+func (z *zfsPlugin) SnapshotDelete(src *crdv1.VolumeSnapshotDataSource, _ *v1.PersistentVolume) error {
+	if src == nil || src.ZFSSnapshot == nil {
+		return fmt.Errorf("invalid VolumeSnapshotDataSource: %v", src)
+	}
+	snapshot := src.ZFSSnapshot.Snapshot
+	if _, _, err := splitSnapshot(snapshot); err != nil {
+		return err
+	}
+	_, err := run(z.runner, "destroy", "-d", snapshot)
+	if err == errNotFound {
+		glog.V(1).Infof("snapshot %v is already destroyed", snapshot)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to destroy snapshot %s: %v", snapshot, err)
+	}
+	glog.V(1).Infof("snapshot %v destroyed successfully", snapshot)
+	return nil
+}
+
+// DescribeSnapshot reports whether the snapshot exists, ZFS snapshots are
+// complete once created
+func (z *zfsPlugin) DescribeSnapshot(snapshotData *crdv1.VolumeSnapshotData) (snapConditions *[]crdv1.VolumeSnapshotCondition, isCompleted bool, err error) {
+	if snapshotData == nil || snapshotData.Spec.ZFSSnapshot == nil {
+		return nil, false, fmt.Errorf("failed to retrieve Snapshot spec")
+	}
+	snapshot := snapshotData.Spec.ZFSSnapshot.Snapshot
+	_, err = list(z.runner, "-t", "snapshot", "-o", "name", snapshot)
+	if err == errNotFound {
+		return newConditions(crdv1.VolumeSnapshotConditionError, fmt.Sprintf("Snapshot %s not found", snapshot)), true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"), true, nil
+}
+
+// FindSnapshot finds the snapshot created for the VolumeSnapshot by the UID
+// stored in its user property
+func (z *zfsPlugin) FindSnapshot(tags *map[string]string) (*crdv1.VolumeSnapshotDataSource, *[]crdv1.VolumeSnapshotCondition, error) {
+	glog.Infof("FindSnapshot by tags: %#v", *tags)
+	uid := (*tags)[uidTag]
+	if uid == "" {
+		return nil, nil, fmt.Errorf("Snapshot not found, no %s tag", uidTag)
+	}
+	rows, err := list(z.runner, "-t", "snapshot", "-o", "name,"+uidProperty+","+localProperty)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, row := range rows {
+		if len(row) != 3 || row[1] != uid {
+			continue
+		}
+		res := &crdv1.VolumeSnapshotDataSource{
+			ZFSSnapshot: &crdv1.ZFSVolumeSnapshotSource{
+				Snapshot: row[0],
+				NodeName: z.nodeName,
+				Local:    row[2] == "true",
+			},
+		}
+		return res, newConditions(crdv1.VolumeSnapshotConditionReady, "Snapshot created successfully"), nil
+	}
+	return nil, nil, fmt.Errorf("Snapshot not found")
+}
+
+// SnapshotRestore clones the snapshot into a new dataset named after the PV.
+// A clone that is not mounted is destroyed again.
+func (z *zfsPlugin) SnapshotRestore(snapshotData *crdv1.VolumeSnapshotData, _ *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
+	if snapshotData == nil || snapshotData.Spec.ZFSSnapshot == nil {
+		return nil, nil, fmt.Errorf("failed to retrieve Snapshot spec")
+	}
+	src := snapshotData.Spec.ZFSSnapshot
+	if src.NodeName != "" && src.NodeName != z.nodeName {
+		return nil, nil, fmt.Errorf("snapshot %s is stored on node %s, it can not be restored on node %q", src.Snapshot, src.NodeName, z.nodeName)
+	}
+	dataset, _, err := splitSnapshot(src.Snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pvName == "" {
+		return nil, nil, fmt.Errorf("failed to restore %s, no volume name", src.Snapshot)
+	}
+	parent := parameters[ParentDatasetParameter]
+	if parent == "" {
+		parent = dataset
+		if i := strings.LastIndex(dataset, "/"); i > 0 {
+			parent = dataset[:i]
+		}
+	}
+	clone := parent + "/" + pvName
+
+	if _, err := run(z.runner, "clone", "-o", cloneOfProperty+"="+src.Snapshot, src.Snapshot, clone); err != nil {
+		glog.Errorf("snapshot :%v restore failed, err:%v", src.Snapshot, err)
+		return nil, nil, fmt.Errorf("failed to clone %s to %s: %v", src.Snapshot, clone, err)
+	}
+	mountpoint, err := getProperty(z.runner, clone, "mountpoint")
+	if err == nil && !strings.HasPrefix(mountpoint, "/") {
+		err = fmt.Errorf("clone is not mounted, mountpoint is %s", mountpoint)
+	}
+	if err != nil {
+		if _, destroyErr := run(z.runner, "destroy", clone); destroyErr != nil {
+			glog.Errorf("failed to destroy clone %v after a failed restore, err: %v", clone, destroyErr)
+		}
+		return nil, nil, fmt.Errorf("failed to restore %s to %s: %v", src.Snapshot, clone, err)
+	}
+	glog.V(1).Infof("snapshot %v restored successfully to dataset %v at %v", src.Snapshot, clone, mountpoint)
+
+	pv := &v1.PersistentVolumeSource{}
+	if src.Local {
+		pv.Local = &v1.LocalVolumeSource{Path: mountpoint}
+	} else {
+		pv.HostPath = &v1.HostPathVolumeSource{Path: mountpoint}
+	}
+	labels := map[string]string{
+		crdv1.ZFSVolumeLabel: "true",
+	}
+	return pv, labels, nil
+}
+
+// RestoreNodeAffinity pins the restored volumes to the node the pool of the
+// snapshot is imported on
+func (z *zfsPlugin) RestoreNodeAffinity(ctx context.Context, snapshotData *crdv1.VolumeSnapshotData) (*v1.VolumeNodeAffinity, error) {
+	if snapshotData == nil || snapshotData.Spec.ZFSSnapshot == nil || snapshotData.Spec.ZFSSnapshot.NodeName == "" {
+		return nil, nil
+	}
+	return volume.HostnameNodeAffinity(ctx, z.kubeClient, snapshotData.Spec.ZFSSnapshot.NodeName)
+}
+
+// VolumeDelete destroys a clone created by SnapshotRestore
+func (z *zfsPlugin) VolumeDelete(pv *v1.PersistentVolume) error {
+	if pv == nil || (pv.Spec.Local == nil && pv.Spec.HostPath == nil) {
+		return fmt.Errorf("invalid PV: %v", pv)
+	}
+	dataset, err := z.dataset(pv)
+	if err == errNotFound {
+		glog.V(1).Infof("dataset of PV %v is already destroyed", pv.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	origin, err := getProperty(z.runner, dataset, cloneOfProperty)
+	if err == errNotFound {
+		glog.V(1).Infof("dataset %v is already destroyed", dataset)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if origin == unsetValue {
+		glog.Warningf("Not destroying dataset %v of PV %v, it is not a clone restored from a snapshot", dataset, pv.Name)
+		return nil
+	}
+	if _, err := run(z.runner, "destroy", dataset); err != nil && err != errNotFound {
+		return fmt.Errorf("failed to destroy dataset %s: %v", dataset, err)
+	}
+	glog.V(1).Infof("dataset %v cloned from %v destroyed successfully", dataset, origin)
+	return nil
+}
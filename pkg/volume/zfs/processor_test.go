@@ -0,0 +1,259 @@
+package zfs
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	crdv1 "github.com/openebs/openebs-k8s-provisioner/pkg/apis/crd/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeRunner returns the output registered for the command line, or fails
+// with the registered error message, and records the commands it runs
+type fakeRunner struct {
+	outputs map[string]string
+	errors  map[string]string
+	calls   []string
+}
+
+func (f *fakeRunner) Run(args ...string) ([]byte, error) {
+	cmd := strings.Join(args, " ")
+	f.calls = append(f.calls, cmd)
+	if message, ok := f.errors[cmd]; ok {
+		return nil, fmt.Errorf("zfs %s failed: exit status 1, %s", cmd, message)
+	}
+	return []byte(f.outputs[cmd]), nil
+}
+
+const (
+	listFilesystems = "list -H -t filesystem -o name,mountpoint"
+	filesystems     = "tank\t/tank\ntank/data\t/tank/data\ntank/data/pv1\t/mnt/pv1\n"
+)
+
+func localPV(path string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				Local: &v1.LocalVolumeSource{Path: path},
+			},
+		},
+	}
+}
+
+func hostPathPV(path string, annotations map[string]string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv1", Annotations: annotations},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: path},
+			},
+		},
+	}
+}
+
+func TestSnapshotCreate(t *testing.T) {
+	tests := map[string]struct {
+		pv      *v1.PersistentVolume
+		dataset string
+		local   bool
+		calls   int
+	}{
+		"mountpoint": {
+			pv:      hostPathPV("/mnt/pv1/", nil),
+			dataset: "tank/data/pv1",
+			calls:   2,
+		},
+		"local": {
+			pv:      localPV("/mnt/pv1"),
+			dataset: "tank/data/pv1",
+			local:   true,
+			calls:   2,
+		},
+		"annotation": {
+			pv:      hostPathPV("/somewhere", map[string]string{crdv1.ZFSDatasetAnnotation: "tank/other"}),
+			dataset: "tank/other",
+			calls:   1,
+		},
+		"no dataset": {
+			pv:    hostPathPV("/mnt/pv2", nil),
+			calls: 1,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{outputs: map[string]string{listFilesystems: filesystems}}
+			plugin := &zfsPlugin{runner: runner, nodeName: "node1"}
+			tags := map[string]string{uidTag: "uid-1"}
+			src, conditions, err := plugin.SnapshotCreate(&crdv1.VolumeSnapshot{}, test.pv, &tags)
+			if len(runner.calls) != test.calls {
+				t.Errorf("Expected %d commands, got %q", test.calls, runner.calls)
+			}
+			if test.dataset == "" {
+				if err == nil {
+					t.Errorf("Expected snapshot to fail without a dataset")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			snapshot := src.ZFSSnapshot.Snapshot
+			if !strings.HasPrefix(snapshot, test.dataset+"@"+snapshotPrefix) || src.ZFSSnapshot.NodeName != "node1" {
+				t.Errorf("Unexpected snapshot %+v of dataset %s", src.ZFSSnapshot, test.dataset)
+			}
+			expected := fmt.Sprintf("snapshot -o %s=uid-1 %s", uidProperty, snapshot)
+			if test.local {
+				expected = fmt.Sprintf("snapshot -o %s=uid-1 -o %s=true %s", uidProperty, localProperty, snapshot)
+			}
+			if src.ZFSSnapshot.Local != test.local {
+				t.Errorf("Expected local %v, got %v", test.local, src.ZFSSnapshot.Local)
+			}
+			if runner.calls[len(runner.calls)-1] != expected {
+				t.Errorf("Expected command %q, got %q", expected, runner.calls[len(runner.calls)-1])
+			}
+			if (*conditions)[0].Type != crdv1.VolumeSnapshotConditionReady {
+				t.Errorf("Expected a ready snapshot, got %v", *conditions)
+			}
+		})
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"list -H -t snapshot -o name," + uidProperty + "," + localProperty: "tank/data/pv1@k8s-a\tuid-1\t-\n" +
+			"tank/data/pv1@manual\t-\t-\n" +
+			"tank/data/pv3@k8s-b\tuid-3\ttrue\n",
+	}}
+	plugin := &zfsPlugin{runner: runner, nodeName: "node1"}
+	tests := map[string]struct {
+		uid      string
+		expected *crdv1.ZFSVolumeSnapshotSource
+	}{
+		"hostPath":  {uid: "uid-1", expected: &crdv1.ZFSVolumeSnapshotSource{Snapshot: "tank/data/pv1@k8s-a", NodeName: "node1"}},
+		"local":     {uid: "uid-3", expected: &crdv1.ZFSVolumeSnapshotSource{Snapshot: "tank/data/pv3@k8s-b", NodeName: "node1", Local: true}},
+		"not found": {uid: "uid-2"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			src, _, err := plugin.FindSnapshot(&map[string]string{uidTag: test.uid})
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("Expected snapshot of %s not to be found", test.uid)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if *src.ZFSSnapshot != *test.expected {
+				t.Errorf("Expected %+v, got %+v", *test.expected, *src.ZFSSnapshot)
+			}
+		})
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	const snapshot = "tank/data/pv1@k8s-a"
+	tests := map[string]struct {
+		parameters map[string]string
+		local      bool
+		mountpoint string
+		clone      string
+		expected   *v1.PersistentVolumeSource
+	}{
+		"next to the dataset": {
+			mountpoint: "/tank/data/pv2",
+			clone:      "tank/data/pv2",
+			expected:   &v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/tank/data/pv2"}},
+		},
+		"parent dataset": {
+			parameters: map[string]string{ParentDatasetParameter: "tank/restored"},
+			local:      true,
+			mountpoint: "/tank/restored/pv2",
+			clone:      "tank/restored/pv2",
+			expected:   &v1.PersistentVolumeSource{Local: &v1.LocalVolumeSource{Path: "/tank/restored/pv2"}},
+		},
+		"not mounted": {
+			mountpoint: "none",
+			clone:      "tank/data/pv2",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			clone := fmt.Sprintf("clone -o %s=%s %s %s", cloneOfProperty, snapshot, snapshot, test.clone)
+			mountpoint := "get -H -o value mountpoint " + test.clone
+			runner := &fakeRunner{outputs: map[string]string{mountpoint: test.mountpoint + "\n"}}
+			plugin := &zfsPlugin{runner: runner, nodeName: "node1"}
+			data := &crdv1.VolumeSnapshotData{
+				Spec: crdv1.VolumeSnapshotDataSpec{
+					VolumeSnapshotDataSource: crdv1.VolumeSnapshotDataSource{
+						ZFSSnapshot: &crdv1.ZFSVolumeSnapshotSource{Snapshot: snapshot, NodeName: "node1", Local: test.local},
+					},
+				},
+			}
+			src, labels, err := plugin.SnapshotRestore(data, nil, "pv2", test.parameters)
+			calls := []string{clone, mountpoint}
+			if test.expected == nil {
+				calls = append(calls, "destroy "+test.clone)
+			}
+			if !reflect.DeepEqual(runner.calls, calls) {
+				t.Errorf("Expected commands %q, got %q", calls, runner.calls)
+			}
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("Expected restore to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(src, test.expected) || labels[crdv1.ZFSVolumeLabel] != "true" {
+				t.Errorf("Expected %+v, got %+v with labels %v", test.expected, src, labels)
+			}
+		})
+	}
+}
+
+func TestVolumeDelete(t *testing.T) {
+	const origin = "get -H -o value " + cloneOfProperty + " tank/data/pv1"
+	tests := map[string]struct {
+		outputs map[string]string
+		errors  map[string]string
+		calls   []string
+	}{
+		"clone": {
+			outputs: map[string]string{listFilesystems: filesystems, origin: "tank/data/pv0@k8s-a\n"},
+			calls:   []string{listFilesystems, origin, "destroy tank/data/pv1"},
+		},
+		"not a clone": {
+			outputs: map[string]string{listFilesystems: filesystems, origin: "-\n"},
+			calls:   []string{listFilesystems, origin},
+		},
+		"already destroyed": {
+			outputs: map[string]string{listFilesystems: "tank\t/tank\n"},
+			calls:   []string{listFilesystems},
+		},
+		"destroyed concurrently": {
+			outputs: map[string]string{listFilesystems: filesystems},
+			errors:  map[string]string{origin: "cannot open 'tank/data/pv1': dataset does not exist"},
+			calls:   []string{listFilesystems, origin},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{outputs: test.outputs, errors: test.errors}
+			plugin := &zfsPlugin{runner: runner}
+			if err := plugin.VolumeDelete(hostPathPV("/mnt/pv1", nil)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(runner.calls, test.calls) {
+				t.Errorf("Expected commands %q, got %q", test.calls, runner.calls)
+			}
+		})
+	}
+}
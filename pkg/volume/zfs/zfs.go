@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The OpenEBS Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	zfsBinary = "zfs"
+
+	// unsetValue is listed by zfs for unset properties
+	unsetValue = "-"
+)
+
+var errNotFound = errors.New("dataset does not exist")
+
+// commandRunner runs the zfs CLI, the tests replace it with a fake
+type commandRunner interface {
+	// Run runs the CLI with args and returns its standard output
+	Run(args ...string) ([]byte, error)
+}
+
+type execRunner struct {
+	binary string
+}
+
+func (r execRunner) Run(args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(r.binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("%s %s failed: %v, %s", r.binary, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// run runs zfs, it fails with errNotFound if the dataset of the command
+// does not exist
+func run(runner commandRunner, args ...string) ([]byte, error) {
+	out, err := runner.Run(args...)
+	if err != nil && strings.Contains(err.Error(), "dataset does not exist") {
+		return nil, errNotFound
+	}
+	return out, err
+}
+
+// list runs zfs list -H, it returns the tab separated fields of each line
+func list(runner commandRunner, args ...string) ([][]string, error) {
+	out, err := run(runner, append([]string{"list", "-H"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		rows = append(rows, strings.Split(line, "\t"))
+	}
+	return rows, nil
+}
+
+// datasetByMountpoint returns the filesystem mounted at path
+func datasetByMountpoint(runner commandRunner, path string) (string, error) {
+	rows, err := list(runner, "-t", "filesystem", "-o", "name,mountpoint")
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Clean(path)
+	for _, row := range rows {
+		if len(row) == 2 && row[1] == path {
+			return row[0], nil
+		}
+	}
+	return "", errNotFound
+}
+
+// getProperty returns the value of a property of the dataset, unsetValue if
+// the property is not set
+func getProperty(runner commandRunner, dataset, property string) (string, error) {
+	out, err := run(runner, "get", "-H", "-o", "value", property, dataset)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// splitSnapshot splits the full name of a snapshot into the dataset and the
+// name of the snapshot
+func splitSnapshot(snapshot string) (string, string, error) {
+	parts := strings.SplitN(snapshot, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid snapshot name %q", snapshot)
+	}
+	return parts[0], parts[1], nil
+}
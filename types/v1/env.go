@@ -13,6 +13,33 @@ const (
 
 	// K8sMasterENVK is the ENV key to fetch the K8s Master's Address
 	K8sMasterENVK ENVKey = "OPENEBS_IO_K8S_MASTER"
+
+	// MinVolumeSizeENVK is the ENV key to fetch the minimum size of a volume
+	// that can be provisioned e.g. 1Gi
+	MinVolumeSizeENVK ENVKey = "OPENEBS_IO_MIN_VOLUME_SIZE"
+
+	// MaxVolumeSizeENVK is the ENV key to fetch the maximum size of a volume
+	// that can be provisioned e.g. 10Ti
+	MaxVolumeSizeENVK ENVKey = "OPENEBS_IO_MAX_VOLUME_SIZE"
+
+	// FinalSnapshotRetentionENVK is the ENV key to fetch the default time the
+	// final snapshot of a deleted volume is kept e.g. 168h
+	FinalSnapshotRetentionENVK ENVKey = "OPENEBS_IO_FINAL_SNAPSHOT_RETENTION"
+
+	// HTTPAddressENVK is the ENV key to fetch the address to serve the health
+	// and debug endpoints on e.g. :9500
+	HTTPAddressENVK ENVKey = "OPENEBS_IO_HTTP_ADDRESS"
+
+	// EnablePprofENVK is the ENV key to enable the pprof endpoints e.g. true
+	EnablePprofENVK ENVKey = "OPENEBS_IO_ENABLE_PPROF"
+
+	// TracingExporterENVK is the ENV key to fetch the exporter of the traces
+	// i.e. otlp or stdout, nothing is traced if it is empty
+	TracingExporterENVK ENVKey = "OPENEBS_IO_TRACING_EXPORTER"
+
+	// TracingEndpointENVK is the ENV key to fetch the URL of the OTLP/HTTP
+	// collector of the traces e.g. http://otel-collector:4318
+	TracingEndpointENVK ENVKey = "OPENEBS_IO_TRACING_ENDPOINT"
 )
 
 func KubeConfigENV() string {
@@ -25,6 +52,41 @@ func K8sMasterENV() string {
 	return val
 }
 
+func MinVolumeSizeENV() string {
+	val := GetEnv(MinVolumeSizeENVK)
+	return val
+}
+
+func MaxVolumeSizeENV() string {
+	val := GetEnv(MaxVolumeSizeENVK)
+	return val
+}
+
+func FinalSnapshotRetentionENV() string {
+	val := GetEnv(FinalSnapshotRetentionENVK)
+	return val
+}
+
+func HTTPAddressENV() string {
+	val := GetEnv(HTTPAddressENVK)
+	return val
+}
+
+func EnablePprofENV() string {
+	val := GetEnv(EnablePprofENVK)
+	return val
+}
+
+func TracingExporterENV() string {
+	val := GetEnv(TracingExporterENVK)
+	return val
+}
+
+func TracingEndpointENV() string {
+	val := GetEnv(TracingEndpointENVK)
+	return val
+}
+
 // GetEnv fetches the environment variable value from the machine's
 // environment
 func GetEnv(envKey ENVKey) string {